@@ -0,0 +1,221 @@
+// Package client is the Go client of the esm.sh server APIs, it's shared by the Go build tools
+// and the `esmd` subcommands.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Client calls the APIs of an esm.sh server.
+type Client struct {
+	// the origin of the server, like "https://esm.sh"
+	BaseURL string
+	// the bearer token of the admin APIs like `Purge`
+	Token string
+	// the http client to send the requests, `http.DefaultClient` is used if it's nil
+	HTTPClient *http.Client
+}
+
+// New returns a client of the server.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Options defines the build options of the modules.
+type Options struct {
+	// the build target, like "es2020" or "deno", empty means the default target
+	Target string
+	// the pinned dependencies, like "react@17.0.2"
+	Deps []string
+}
+
+func (o *Options) query() url.Values {
+	query := url.Values{}
+	if o == nil {
+		return query
+	}
+	if o.Target != "" {
+		query.Set("target", o.Target)
+	}
+	if len(o.Deps) > 0 {
+		query.Set("deps", strings.Join(o.Deps, ","))
+	}
+	return query
+}
+
+// An Error is the non-2xx response of the server.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("esm.sh: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("esm.sh: %d %s", e.StatusCode, e.Message)
+}
+
+// A ResolveResult is the resolved module of a specifier.
+type ResolveResult struct {
+	Specifier string `json:"specifier"`
+	Name      string `json:"name,omitempty"`
+	Version   string `json:"version,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Dts       string `json:"dts,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Resolve resolves the bare specifiers like `react-dom@17/server` to the module URLs, the
+// results are in the order of the specifiers. The specifiers that can't be resolved have the
+// `Error` field.
+func (c *Client) Resolve(ctx context.Context, specifiers []string, opts *Options) (results []ResolveResult, err error) {
+	body := strings.NewReader(strings.Join(specifiers, "\n"))
+	resp, err := c.do(ctx, "POST", "/-/resolve", opts.query(), body)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var r ResolveResult
+		err = json.Unmarshal(line, &r)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	err = scanner.Err()
+	if err == nil && len(results) != len(specifiers) {
+		err = fmt.Errorf("esm.sh: %d of %d specifiers resolved, the response is incomplete", len(results), len(specifiers))
+	}
+	return
+}
+
+// A ModuleMeta is the build meta of a module.
+type ModuleMeta struct {
+	Name                string            `json:"name"`
+	Version             string            `json:"version"`
+	Dependencies        map[string]string `json:"dependencies,omitempty"`
+	PeerDependencies    map[string]string `json:"peerDependencies,omitempty"`
+	Exports             []string          `json:"exports"`
+	Dts                 string            `json:"dts"`
+	TypesSource         string            `json:"typesSource,omitempty"`
+	TopLevelSideEffects []string          `json:"topLevelSideEffects,omitempty"`
+	NodeEnvFree         bool              `json:"nodeEnvFree,omitempty"`
+	Contributors        []struct {
+		Name  string `json:"name"`
+		Bytes int    `json:"bytes"`
+	} `json:"contributors,omitempty"`
+}
+
+// An ImportMeta is the response of the `?meta` query.
+type ImportMeta struct {
+	// the build ID, like "v36/react@17.0.2/es2020/react"
+	ID        string     `json:"id"`
+	Meta      ModuleMeta `json:"meta"`
+	MinTarget string     `json:"minTarget,omitempty"`
+}
+
+// ImportMeta returns the build meta of the module like `react@17.0.2` or `react-dom@17/server`,
+// the module is built if it's not in the cache.
+func (c *Client) ImportMeta(ctx context.Context, module string, opts *Options) (meta *ImportMeta, err error) {
+	query := opts.query()
+	query.Set("meta", "")
+	meta = &ImportMeta{}
+	err = c.getJSON(ctx, "/"+strings.TrimPrefix(module, "/"), query, meta)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// A BuildStatus is the status of the build queue.
+type BuildStatus struct {
+	Version int    `json:"version"`
+	Uptime  string `json:"uptime"`
+	Queue   struct {
+		Queued     int    `json:"queued"`
+		Processing int    `json:"processing"`
+		Throttled  bool   `json:"throttled"`
+		Coalesced  uint64 `json:"coalesced"`
+	} `json:"queue"`
+	TarballChanges uint64 `json:"tarballChanges"`
+}
+
+// BuildStatus returns the status of the server.
+func (c *Client) BuildStatus(ctx context.Context) (status *BuildStatus, err error) {
+	status = &BuildStatus{}
+	err = c.getJSON(ctx, "/-/status", nil, status)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Purge removes the builds of the package version like `react@17.0.2`, returns the number of
+// the purged builds. It requires the admin token.
+func (c *Client) Purge(ctx context.Context, pkg string) (n int, err error) {
+	resp, err := c.do(ctx, "POST", "/-/purge", url.Values{"pkg": {pkg}}, nil)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var ret struct {
+		Purged int `json:"purged"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&ret)
+	return ret.Purged, err
+}
+
+func (c *Client) getJSON(ctx context.Context, pathname string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, "GET", pathname, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do sends the request, the non-2xx responses are returned as `*Error`.
+func (c *Client) do(ctx context.Context, method string, pathname string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := strings.TrimSuffix(c.BaseURL, "/") + pathname
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &Error{resp.StatusCode, strings.TrimSpace(string(data))}
+	}
+	return resp, nil
+}
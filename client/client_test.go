@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/resolve", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || string(body) != "react@17\nreact-dom@17/server" || r.URL.Query().Get("target") != "deno" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"specifier":"react@17","name":"react","version":"17.0.2","url":"https://esm.sh/react@17.0.2?target=deno"}` + "\n"))
+		w.Write([]byte(`{"specifier":"react-dom@17/server","error":"not found"}` + "\n"))
+	})
+	mux.HandleFunc("/react@17.0.2", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["meta"]; !ok || r.URL.Query().Get("deps") != "object-assign@4.1.1" {
+			http.Error(w, "bad request", 400)
+			return
+		}
+		w.Write([]byte(`{"id":"v36/react@17.0.2/deps=object-assign@4.1.1/es2020/react","meta":{"name":"react","version":"17.0.2","exports":["Children","default"],"dts":"/react@17.0.2/index.d.ts"}}`))
+	})
+	mux.HandleFunc("/-/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":36,"uptime":"1h0m0s","queue":{"queued":2,"processing":1,"throttled":true}}`))
+	})
+	mux.HandleFunc("/-/purge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", 401)
+			return
+		}
+		w.Write([]byte(`{"pkg":"` + r.URL.Query().Get("pkg") + `","purged":3}`))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return New(ts.URL + "/")
+}
+
+func TestResolve(t *testing.T) {
+	c := newTestServer(t)
+	results, err := c.Resolve(context.Background(), []string{"react@17", "react-dom@17/server"}, &Options{Target: "deno"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Version != "17.0.2" || results[0].URL != "https://esm.sh/react@17.0.2?target=deno" || results[1].Error != "not found" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	_, err = c.Resolve(context.Background(), []string{"react@17"}, nil)
+	if e, ok := err.(*Error); !ok || e.StatusCode != 400 || e.Message != "bad request" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestImportMeta(t *testing.T) {
+	c := newTestServer(t)
+	meta, err := c.ImportMeta(context.Background(), "react@17.0.2", &Options{Deps: []string{"object-assign@4.1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(meta.ID, "/es2020/react") || meta.Meta.Version != "17.0.2" || len(meta.Meta.Exports) != 2 || meta.Meta.Dts == "" {
+		t.Fatalf("unexpected meta %+v", meta)
+	}
+}
+
+func TestBuildStatus(t *testing.T) {
+	c := newTestServer(t)
+	status, err := c.BuildStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != 36 || status.Queue.Queued != 2 || status.Queue.Processing != 1 || !status.Queue.Throttled {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	c := newTestServer(t)
+	if _, err := c.Purge(context.Background(), "react@17.0.2"); err == nil || err.(*Error).StatusCode != 401 {
+		t.Fatalf("the purge without the admin token should be rejected, got %v", err)
+	}
+	c.Token = "secret"
+	n, err := c.Purge(context.Background(), "react@17.0.2")
+	if err != nil || n != 3 {
+		t.Fatalf("unexpected purge result %d, %v", n, err)
+	}
+}
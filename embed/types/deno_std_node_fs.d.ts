@@ -0,0 +1,153 @@
+// the declarations of the `/v{VERSION}/_deno_std_node_fs.js` polyfill that is bundled from
+// https://deno.land/std@0.91.0/node/fs.ts
+
+type PathLike = string | URL;
+type Encodings = "ascii" | "utf8" | "utf-8" | "utf16le" | "ucs2" | "ucs-2" | "base64" | "latin1" | "binary" | "hex";
+type Callback = (err: Error | null) => void;
+type ValueCallback<T> = (err: Error | null, value: T) => void;
+type FileOptions = Encodings | { encoding?: Encodings | null; flag?: string; mode?: number } | null;
+
+export interface Stats {
+  dev: number | null;
+  ino: number | null;
+  mode: number | null;
+  nlink: number | null;
+  uid: number | null;
+  gid: number | null;
+  rdev: number | null;
+  size: number;
+  blksize: number | null;
+  blocks: number | null;
+  mtime: Date | null;
+  atime: Date | null;
+  birthtime: Date | null;
+  isFile(): boolean;
+  isDirectory(): boolean;
+  isSymbolicLink(): boolean;
+}
+
+export interface Dirent {
+  name: string;
+  isFile(): boolean;
+  isDirectory(): boolean;
+  isSymbolicLink(): boolean;
+}
+
+export interface FSWatcher {
+  close(): void;
+  on(event: string, listener: (...args: any[]) => void): FSWatcher;
+}
+
+export declare const constants: {
+  F_OK: number;
+  R_OK: number;
+  W_OK: number;
+  X_OK: number;
+  S_IRUSR: number;
+  S_IWUSR: number;
+  S_IXUSR: number;
+  S_IRGRP: number;
+  S_IWGRP: number;
+  S_IXGRP: number;
+  S_IROTH: number;
+  S_IWOTH: number;
+  S_IXOTH: number;
+};
+
+export declare const promises: {
+  readFile(path: PathLike | number, options?: FileOptions): Promise<string | Uint8Array>;
+  writeFile(path: PathLike | number, data: string | Uint8Array, options?: FileOptions): Promise<void>;
+};
+
+export declare function access(path: PathLike, modeOrCallback: number | Callback, callback?: Callback): void;
+export declare function accessSync(path: PathLike, mode?: number): void;
+export declare function appendFile(path: PathLike | number, data: string | Uint8Array, optionsOrCallback: FileOptions | Callback, callback?: Callback): void;
+export declare function appendFileSync(path: PathLike | number, data: string | Uint8Array, options?: FileOptions): void;
+export declare function chmod(path: PathLike, mode: string | number, callback: Callback): void;
+export declare function chmodSync(path: PathLike, mode: string | number): void;
+export declare function chown(path: PathLike, uid: number, gid: number, callback: Callback): void;
+export declare function chownSync(path: PathLike, uid: number, gid: number): void;
+export declare function close(fd: number, callback: Callback): void;
+export declare function closeSync(fd: number): void;
+export declare function copyFile(source: PathLike, destination: PathLike, callback: Callback): void;
+export declare function copyFileSync(source: PathLike, destination: PathLike): void;
+export declare function exists(path: PathLike, callback: (exists: boolean) => void): void;
+export declare function existsSync(path: PathLike): boolean;
+export declare function lstat(path: PathLike, optionsOrCallback: any, callback?: ValueCallback<Stats>): void;
+export declare function lstatSync(path: PathLike, options?: any): Stats;
+export declare function mkdir(path: PathLike, optionsOrCallback: any, callback?: Callback): void;
+export declare function mkdirSync(path: PathLike, options?: any): void;
+export declare function mkdtemp(prefix: string, optionsOrCallback: any, callback?: ValueCallback<string>): void;
+export declare function mkdtempSync(prefix: string, options?: any): string;
+export declare function open(path: PathLike, flagsOrCallback: any, modeOrCallback?: any, callback?: ValueCallback<number>): void;
+export declare function openSync(path: PathLike, flags?: string, mode?: number): number;
+export declare function readFile(path: PathLike | number, optionsOrCallback: FileOptions | ValueCallback<string | Uint8Array>, callback?: ValueCallback<string | Uint8Array>): void;
+export declare function readFileSync(path: PathLike | number, options?: FileOptions): string | Uint8Array;
+export declare function readdir(path: PathLike, optionsOrCallback: any, callback?: ValueCallback<string[] | Dirent[]>): void;
+export declare function readdirSync(path: PathLike, options?: any): string[] | Dirent[];
+export declare function readlink(path: PathLike, optionsOrCallback: any, callback?: ValueCallback<string | Uint8Array>): void;
+export declare function readlinkSync(path: PathLike, options?: any): string | Uint8Array;
+export declare function realpath(path: PathLike, optionsOrCallback: any, callback?: ValueCallback<string>): void;
+export declare function realpathSync(path: PathLike): string;
+export declare function rename(oldPath: PathLike, newPath: PathLike, callback: Callback): void;
+export declare function renameSync(oldPath: PathLike, newPath: PathLike): void;
+export declare function rmdir(path: PathLike, optionsOrCallback: any, callback?: Callback): void;
+export declare function rmdirSync(path: PathLike, options?: any): void;
+export declare function stat(path: PathLike, optionsOrCallback: any, callback?: ValueCallback<Stats>): void;
+export declare function statSync(path: PathLike, options?: any): Stats;
+export declare function truncate(path: PathLike, lenOrCallback: number | Callback, callback?: Callback): void;
+export declare function truncateSync(path: PathLike, len?: number): void;
+export declare function unlink(path: PathLike, callback: Callback): void;
+export declare function unlinkSync(path: PathLike): void;
+export declare function watch(filename: PathLike, optionsOrListener?: any, listener?: (event: string, filename: string) => void): FSWatcher;
+export declare function writeFile(path: PathLike | number, data: string | Uint8Array, optionsOrCallback: FileOptions | Callback, callback?: Callback): void;
+export declare function writeFileSync(path: PathLike | number, data: string | Uint8Array, options?: FileOptions): void;
+
+declare const _default: {
+  access: typeof access;
+  accessSync: typeof accessSync;
+  appendFile: typeof appendFile;
+  appendFileSync: typeof appendFileSync;
+  chmod: typeof chmod;
+  chmodSync: typeof chmodSync;
+  chown: typeof chown;
+  chownSync: typeof chownSync;
+  close: typeof close;
+  closeSync: typeof closeSync;
+  constants: typeof constants;
+  copyFile: typeof copyFile;
+  copyFileSync: typeof copyFileSync;
+  exists: typeof exists;
+  existsSync: typeof existsSync;
+  lstat: typeof lstat;
+  lstatSync: typeof lstatSync;
+  mkdir: typeof mkdir;
+  mkdirSync: typeof mkdirSync;
+  mkdtemp: typeof mkdtemp;
+  mkdtempSync: typeof mkdtempSync;
+  open: typeof open;
+  openSync: typeof openSync;
+  promises: typeof promises;
+  readdir: typeof readdir;
+  readdirSync: typeof readdirSync;
+  readFile: typeof readFile;
+  readFileSync: typeof readFileSync;
+  readlink: typeof readlink;
+  readlinkSync: typeof readlinkSync;
+  realpath: typeof realpath;
+  realpathSync: typeof realpathSync;
+  rename: typeof rename;
+  renameSync: typeof renameSync;
+  rmdir: typeof rmdir;
+  rmdirSync: typeof rmdirSync;
+  stat: typeof stat;
+  statSync: typeof statSync;
+  unlink: typeof unlink;
+  unlinkSync: typeof unlinkSync;
+  watch: typeof watch;
+  writeFile: typeof writeFile;
+  writeFileSync: typeof writeFileSync;
+  truncate: typeof truncate;
+  truncateSync: typeof truncateSync;
+};
+export default _default;
@@ -0,0 +1,37 @@
+/*-----------------------------------------------*
+ *                                               *
+ *     DOM SHIM (for the Deno type checker)      *
+ *                                               *
+ ------------------------------------------------*/
+
+interface Node { [key: string]: any }
+interface ChildNode extends Node { }
+interface ParentNode extends Node { }
+interface Element extends Node { }
+interface HTMLElement extends Element { }
+interface HTMLInputElement extends HTMLElement { }
+interface HTMLCanvasElement extends HTMLElement { }
+interface HTMLImageElement extends HTMLElement { }
+interface HTMLVideoElement extends HTMLElement { }
+interface SVGElement extends Element { }
+interface Text extends Node { }
+interface Document extends Node { }
+interface DocumentFragment extends Node { }
+interface ShadowRoot extends DocumentFragment { }
+interface NodeList { [index: number]: Node; length: number }
+interface NodeListOf<TNode extends Node> extends NodeList { [index: number]: TNode }
+interface HTMLCollection { [index: number]: Element; length: number }
+interface HTMLCollectionOf<T extends Element> extends HTMLCollection { [index: number]: T }
+interface CSSStyleDeclaration { [key: string]: any }
+interface DOMRect { x: number; y: number; width: number; height: number; top: number; right: number; bottom: number; left: number }
+interface UIEvent extends Event { }
+interface MouseEvent extends UIEvent { [key: string]: any }
+interface PointerEvent extends MouseEvent { }
+interface KeyboardEvent extends UIEvent { [key: string]: any }
+interface FocusEvent extends UIEvent { }
+interface TouchEvent extends UIEvent { [key: string]: any }
+interface WheelEvent extends MouseEvent { }
+interface DragEvent extends MouseEvent { }
+interface MutationObserver { [key: string]: any }
+interface ResizeObserver { [key: string]: any }
+interface IntersectionObserver { [key: string]: any }
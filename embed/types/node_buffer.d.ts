@@ -0,0 +1,13 @@
+/// <reference path="./_node.ns.d.ts" />
+
+// the declarations of the `/v{VERSION}/_node_buffer.js` polyfill
+
+declare const _Buffer: typeof globalThis.Buffer;
+type _Buffer = globalThis.Buffer;
+
+export { _Buffer as Buffer };
+
+declare const _default: {
+  Buffer: typeof _Buffer;
+};
+export default _default;
@@ -0,0 +1,30 @@
+// the declarations of the `/v{VERSION}/_node_process.js` polyfill
+
+type Listener = (...args: any[]) => void;
+
+interface Process {
+  title: string;
+  browser: boolean;
+  env: Record<string, string | undefined>;
+  argv: string[];
+  version: string;
+  versions: Record<string, string>;
+  nextTick(callback: (...args: any[]) => void, ...args: any[]): void;
+  on(event: string, listener: Listener): Process;
+  addListener(event: string, listener: Listener): Process;
+  once(event: string, listener: Listener): Process;
+  off(event: string, listener: Listener): Process;
+  removeListener(event: string, listener: Listener): Process;
+  removeAllListeners(event?: string): Process;
+  emit(event: string, ...args: any[]): boolean;
+  prependListener(event: string, listener: Listener): Process;
+  prependOnceListener(event: string, listener: Listener): Process;
+  listeners(event: string): Listener[];
+  binding(name: string): never;
+  cwd(): string;
+  chdir(directory: string): never;
+  umask(): number;
+}
+
+declare const process: Process;
+export default process;
@@ -0,0 +1,33 @@
+// the declarations of the `/v{VERSION}/_node_readline.js` polyfill
+
+export declare class Interface {
+  line: string;
+  cursor: number;
+  close(): void;
+  pause(): void;
+  prompt(preserveCursor?: boolean): void;
+  question(query: string, callback?: (answer: string) => void): void;
+  resume(): void;
+  setPrompt(prompt: string): void;
+  getPrompt(): string;
+  write(data: any, key?: any): void;
+  getCursorPos(): { rows: number; cols: number };
+}
+
+export declare function clearLine(...args: any[]): void;
+export declare function clearScreenDown(...args: any[]): void;
+export declare function createInterface(...args: any[]): Interface;
+export declare function cursorTo(...args: any[]): void;
+export declare function emitKeypressEvents(...args: any[]): void;
+export declare function moveCursor(...args: any[]): void;
+
+declare const _default: {
+  Interface: typeof Interface;
+  clearLine: typeof clearLine;
+  clearScreenDown: typeof clearScreenDown;
+  createInterface: typeof createInterface;
+  cursorTo: typeof cursorTo;
+  emitKeypressEvents: typeof emitKeypressEvents;
+  moveCursor: typeof moveCursor;
+};
+export default _default;
@@ -10,4 +10,5 @@ require (
 	github.com/mssola/user_agent v0.5.2
 	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/postui/postdb v0.5.0
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 )
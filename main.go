@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"os"
 
 	"esm.sh/server"
 )
@@ -11,5 +12,21 @@ import (
 var fs embed.FS
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "init":
+			server.Init(os.Args[2:])
+			return
+		case "status":
+			server.Status(os.Args[2:])
+			return
+		case "purge":
+			server.Purge(os.Args[2:])
+			return
+		case "doctor":
+			server.Doctor(&fs, os.Args[2:])
+			return
+		}
+	}
 	server.Serve(&fs)
 }
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+const abuseMaxTarpit = 5 * time.Second
+
+// the max number of the requests that are tarpitted at the same time, the others are responded
+// without the delay to not hold a goroutine for each request of the flood
+const abuseMaxTarpitted = 256
+
+var tarpitSlots = make(chan struct{}, abuseMaxTarpitted)
+
+// An errorModule is the module that throws the error of resolving or building.
+type errorModule struct {
+	*bytes.Buffer
+}
+
+type abuseRecord struct {
+	window       time.Time
+	failures     int
+	blockedUntil time.Time
+}
+
+// An abuseGuard tracks the failed requests(404s, error modules) of the clients in the window
+// of one minute: the clients are tarpitted after half of the `abuse-threshold` failures, and
+// blocked for the `abuse-block` duration after the threshold.
+type abuseGuard struct {
+	lock         sync.Mutex
+	clients      map[string]*abuseRecord
+	tarpitted    uint64
+	blockedTotal uint64
+}
+
+var abuse = &abuseGuard{clients: map[string]*abuseRecord{}}
+
+// Blocked reports whether the client is blocked.
+func (g *abuseGuard) Blocked(client string, now time.Time) (until time.Time, blocked bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	r, ok := g.clients[client]
+	if ok && now.Before(r.blockedUntil) {
+		return r.blockedUntil, true
+	}
+	return
+}
+
+// Fail records a failed request of the client, returns the tarpit delay, and whether the client
+// is blocked by the failure.
+func (g *abuseGuard) Fail(client string, threshold int, blockDuration time.Duration, now time.Time) (delay time.Duration, blocked bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	window := now.Truncate(time.Minute)
+	r, ok := g.clients[client]
+	if !ok {
+		if len(g.clients) >= 10000 {
+			g.prune(now)
+		}
+		r = &abuseRecord{window: window}
+		g.clients[client] = r
+	}
+	if !r.window.Equal(window) {
+		r.window = window
+		r.failures = 0
+	}
+	r.failures++
+
+	if r.failures >= threshold {
+		if now.After(r.blockedUntil) {
+			r.blockedUntil = now.Add(blockDuration)
+			g.blockedTotal++
+			blocked = true
+		}
+		return
+	}
+	if over := r.failures - threshold/2; over > 0 {
+		g.tarpitted++
+		delay = time.Duration(over) * 100 * time.Millisecond
+		if delay > abuseMaxTarpit {
+			delay = abuseMaxTarpit
+		}
+	}
+	return
+}
+
+// Unblock unblocks the client, or all the clients if the client is empty.
+func (g *abuseGuard) Unblock(client string) (n int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for c, r := range g.clients {
+		if (client == "" || c == client) && !r.blockedUntil.IsZero() {
+			delete(g.clients, c)
+			n++
+		}
+	}
+	return
+}
+
+// BlockedClients returns the clients that are blocked.
+func (g *abuseGuard) BlockedClients(now time.Time) []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	clients := []string{}
+	for c, r := range g.clients {
+		if now.Before(r.blockedUntil) {
+			clients = append(clients, c)
+		}
+	}
+	sort.Strings(clients)
+	return clients
+}
+
+// Stats returns the number of the tarpitted requests and the blocked clients.
+func (g *abuseGuard) Stats() (tarpitted uint64, blockedTotal uint64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.tarpitted, g.blockedTotal
+}
+
+func (g *abuseGuard) prune(now time.Time) {
+	window := now.Truncate(time.Minute)
+	for c, r := range g.clients {
+		if r.window.Before(window) && now.After(r.blockedUntil) {
+			delete(g.clients, c)
+		}
+	}
+}
+
+// isFailedResponse reports whether the response is a failure caused by the client: the 404s and
+// the error modules. The server errors are not counted against the client.
+func isFailedResponse(ret interface{}) bool {
+	switch v := ret.(type) {
+	case errorModule:
+		return true
+	case *rex.Error:
+		return v.Status == 404
+	}
+	return false
+}
+
+// tarpit delays the response unless there are too many requests tarpitted already.
+func tarpit(delay time.Duration) {
+	select {
+	case tarpitSlots <- struct{}{}:
+		time.Sleep(delay)
+		<-tarpitSlots
+	default:
+	}
+}
+
+// guardAbuse wraps the handle to tarpit or block the clients that send floods of invalid
+// module paths. The clients are identified by `clientIP`, behind a CDN the `trusted-proxies`
+// config should be set, otherwise all the users of the CDN share the IP of it.
+func (s *Server) guardAbuse(handle rex.Handle) rex.Handle {
+	return func(ctx *rex.Context) interface{} {
+		if s.config.abuseThreshold <= 0 || strings.HasPrefix(ctx.Path.String(), "/-/") {
+			return handle(ctx)
+		}
+
+		client := s.clientIP(ctx.R)
+		if until, blocked := abuse.Blocked(client, time.Now()); blocked {
+			ctx.SetHeader("Retry-After", fmt.Sprintf("%d", int(time.Until(until).Seconds())+1))
+			ctx.SetHeader("Cache-Control", "private, no-store")
+			return rex.Status(http.StatusTooManyRequests, "too many invalid requests, please try again later")
+		}
+		ret := handle(ctx)
+		if isFailedResponse(ret) {
+			delay, blocked := abuse.Fail(client, s.config.abuseThreshold, s.config.abuseBlock, time.Now())
+			if blocked {
+				s.log.Warnf("abuse: %s blocked for %v (%d failed requests in a minute)", client, s.config.abuseBlock, s.config.abuseThreshold)
+			}
+			if delay > 0 {
+				tarpit(delay)
+			}
+		}
+		return ret
+	}
+}
+
+// isAdminRequest reports whether the request has the admin token by the `Authorization: Bearer
+// TOKEN` header, or by the basic auth with the token as the password(that the browsers prompt
+// for) if the basicAuth is true. The token is compared in constant time.
+func (s *Server) isAdminRequest(ctx *rex.Context, basicAuth bool) bool {
+	if s.config.adminToken == "" {
+		return false
+	}
+	token := []byte(s.config.adminToken)
+	if auth := ctx.R.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), token) == 1 {
+			return true
+		}
+	}
+	if basicAuth {
+		if _, password, ok := ctx.R.BasicAuth(); ok {
+			return subtle.ConstantTimeCompare([]byte(password), token) == 1
+		}
+	}
+	return false
+}
+
+// unblock handles the `/-/unblock` requests of the admin: `GET` lists the blocked clients,
+// `POST /-/unblock?ip=IP` unblocks the client(or all the clients without the `ip`).
+func (s *Server) unblock(ctx *rex.Context) interface{} {
+	if s.config.adminToken == "" {
+		return rex.Err(404)
+	}
+	if !s.isAdminRequest(ctx, false) {
+		return rex.Err(401)
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	switch ctx.R.Method {
+	case "GET":
+		return map[string]interface{}{
+			"blocked": abuse.BlockedClients(time.Now()),
+		}
+	case "POST":
+		n := abuse.Unblock(ctx.Form.Value("ip"))
+		s.log.Infof("abuse: %d clients unblocked by admin", n)
+		return map[string]interface{}{
+			"unblocked": n,
+		}
+	default:
+		ctx.SetHeader("Allow", "GET, POST")
+		return rex.Status(405, "method not allowed")
+	}
+}
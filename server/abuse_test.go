@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+func TestAbuseGuard(t *testing.T) {
+	g := &abuseGuard{clients: map[string]*abuseRecord{}}
+	now := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 1; i < 10; i++ {
+		delay, _ := g.Fail("1.1.1.1", 10, time.Minute, now)
+		if i <= 5 && delay != 0 {
+			t.Fatalf("failure %d should not be tarpitted", i)
+		}
+		if i > 5 && delay != time.Duration(i-5)*100*time.Millisecond {
+			t.Fatalf("failure %d: unexpected tarpit delay %v", i, delay)
+		}
+	}
+	if _, blocked := g.Blocked("1.1.1.1", now); blocked {
+		t.Fatal("the client should not be blocked before the threshold")
+	}
+	if _, blocked := g.Fail("1.1.1.1", 10, time.Minute, now); !blocked {
+		t.Fatal("the failure should block the client")
+	}
+	if _, blocked := g.Blocked("1.1.1.1", now); !blocked {
+		t.Fatal("the client should be blocked")
+	}
+	if _, blocked := g.Blocked("2.2.2.2", now); blocked {
+		t.Fatal("other clients should not be blocked")
+	}
+	if _, blocked := g.Blocked("1.1.1.1", now.Add(2*time.Minute)); blocked {
+		t.Fatal("the block should expire")
+	}
+	if clients := g.BlockedClients(now); len(clients) != 1 || clients[0] != "1.1.1.1" {
+		t.Fatalf("unexpected blocked clients %v", clients)
+	}
+	if n := g.Unblock("1.1.1.1"); n != 1 {
+		t.Fatalf("unexpected unblocked clients %d", n)
+	}
+	if _, blocked := g.Blocked("1.1.1.1", now); blocked {
+		t.Fatal("the client should be unblocked")
+	}
+
+	// the failures are counted in the window of one minute
+	for i := 0; i < 9; i++ {
+		g.Fail("3.3.3.3", 10, time.Minute, now)
+	}
+	g.Fail("3.3.3.3", 10, time.Minute, now.Add(time.Minute))
+	if _, blocked := g.Blocked("3.3.3.3", now.Add(time.Minute)); blocked {
+		t.Fatal("the failures of the last window should not be counted")
+	}
+	if tarpitted, blockedTotal := g.Stats(); tarpitted != 8 || blockedTotal != 1 {
+		t.Fatalf("unexpected stats %d %d", tarpitted, blockedTotal)
+	}
+}
+
+func TestIsFailedResponse(t *testing.T) {
+	for _, ret := range []interface{}{errorModule{bytes.NewBuffer(nil)}, rex.Err(404)} {
+		if !isFailedResponse(ret) {
+			t.Fatalf("%T should be a failed response", ret)
+		}
+	}
+	// the server errors are not the failures of the client
+	for _, ret := range []interface{}{bytes.NewBuffer(nil), rex.Err(401), rex.Err(500), errors.New("oops"), "ok", nil} {
+		if isFailedResponse(ret) {
+			t.Fatalf("%T should not be a failed response", ret)
+		}
+	}
+}
+
+func TestTarpitLimit(t *testing.T) {
+	for i := 0; i < abuseMaxTarpitted; i++ {
+		tarpitSlots <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < abuseMaxTarpitted; i++ {
+			<-tarpitSlots
+		}
+	}()
+	start := time.Now()
+	tarpit(time.Hour)
+	if time.Since(start) > time.Second {
+		t.Fatal("the request should not be tarpitted when the slots are full")
+	}
+}
+
+func TestIsAdminRequest(t *testing.T) {
+	s := &Server{config: &Config{adminToken: "secret"}}
+	for _, c := range []struct {
+		auth      string
+		basicAuth bool
+		ok        bool
+	}{
+		{"Bearer secret", false, true},
+		{"Bearer secrets", false, false},
+		{"Bearer ", false, false},
+		{"", false, false},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")), false, false},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")), true, true},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:oops")), true, false},
+	} {
+		req := httptest.NewRequest("GET", "/-/purge", nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		if ok := s.isAdminRequest(&rex.Context{R: req}, c.basicAuth); ok != c.ok {
+			t.Fatalf("'%s'(basic auth: %v): expect %v", c.auth, c.basicAuth, c.ok)
+		}
+	}
+	s.config.adminToken = ""
+	req := httptest.NewRequest("GET", "/-/purge", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	if s.isAdminRequest(&rex.Context{R: req}, false) {
+		t.Fatal("the admin APIs should be disabled without the token")
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// An aliasRoute redirects a friendly URL to a package path.
+type aliasRoute struct {
+	To     string `json:"to"`
+	Status int    `json:"status,omitempty"`
+}
+
+// aliasRoutes is the `aliases` config, it can be set by a JSON object like
+// `{"/jquery": "/jquery@3/dist/jquery.module.js", "/ui": {"to": "/@corp/ui@2", "status": 301}}`
+// or a list like `/jquery=/jquery@3/dist/jquery.module.js,/ui=/@corp/ui@2`.
+type aliasRoutes map[string]aliasRoute
+
+func (r aliasRoutes) String() string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	a := make([]string, len(names))
+	for i, name := range names {
+		a[i] = name + "=" + r[name].To
+	}
+	return strings.Join(a, ",")
+}
+
+func (r aliasRoutes) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") {
+		var values map[string]json.RawMessage
+		err := json.Unmarshal([]byte(s), &values)
+		if err != nil {
+			return err
+		}
+		for from, value := range values {
+			var route aliasRoute
+			if json.Unmarshal(value, &route.To) != nil {
+				err = json.Unmarshal(value, &route)
+				if err != nil {
+					return fmt.Errorf("invalid alias '%s'", from)
+				}
+			}
+			err = r.add(from, route)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		a := strings.SplitN(part, "=", 2)
+		if len(a) != 2 {
+			return fmt.Errorf("invalid alias '%s', should be 'from=to'", part)
+		}
+		err := r.add(a[0], aliasRoute{To: a[1]})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r aliasRoutes) add(from string, route aliasRoute) error {
+	from = strings.TrimSuffix(strings.TrimSpace(from), "/")
+	route.To = strings.TrimSuffix(strings.TrimSpace(route.To), "/")
+	if !strings.HasPrefix(from, "/") || !strings.HasPrefix(route.To, "/") {
+		return fmt.Errorf("invalid alias '%s', the paths should start with '/'", from)
+	}
+	if strings.HasPrefix(from, "/-/") || regBuildVersionPath.MatchString(from+"/") {
+		return fmt.Errorf("invalid alias '%s', the path is reserved", from)
+	}
+	switch route.Status {
+	case 0:
+		route.Status = http.StatusFound
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("invalid alias '%s', bad redirect status %d", from, route.Status)
+	}
+	r[from] = route
+	return nil
+}
+
+// Match returns the redirect URL of the pathname, the sub-paths of the alias are redirected
+// as well, e.g. `/ui/button` -> `/@corp/ui@2/button`.
+func (r aliasRoutes) Match(pathname string) (to string, status int, ok bool) {
+	for from := pathname; from != ""; from = from[:strings.LastIndexByte(from, '/')] {
+		if route, ok := r[from]; ok {
+			return route.To + pathname[len(from):], route.Status, true
+		}
+	}
+	return
+}
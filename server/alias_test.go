@@ -0,0 +1,41 @@
+package server
+
+import "testing"
+
+func TestAliasRoutes(t *testing.T) {
+	r := aliasRoutes{}
+	err := r.Set(`{"/jquery": "/jquery@3/dist/jquery.module.js", "/ui/": {"to": "/@corp/ui@2", "status": 301}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Set("/lodash=/lodash-es@4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for pathname, expected := range map[string]struct {
+		to     string
+		status int
+	}{
+		"/jquery":          {"/jquery@3/dist/jquery.module.js", 302},
+		"/ui":              {"/@corp/ui@2", 301},
+		"/ui/button":       {"/@corp/ui@2/button", 301},
+		"/lodash/debounce": {"/lodash-es@4/debounce", 302},
+	} {
+		to, status, ok := r.Match(pathname)
+		if !ok || to != expected.to || status != expected.status {
+			t.Fatalf("Match(%s): unexpected %s %d", pathname, to, status)
+		}
+	}
+	for _, pathname := range []string{"/", "/jquery-ui", "/uikit/button", "/react"} {
+		if to, _, ok := r.Match(pathname); ok {
+			t.Fatalf("%s should not be redirected, got %s", pathname, to)
+		}
+	}
+
+	for _, s := range []string{"jquery=/jquery@3", "/-/status=/react", "/v36/react=/react", `{"/a": {"to": "/b", "status": 200}}`, "/a"} {
+		if err := (aliasRoutes{}).Set(s); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+}
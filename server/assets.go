@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/gox/utils"
+)
+
+const assetNamespace = "esm-sh-asset"
+
+// the static assets that are copied into the storage instead of being bundled, the imports of
+// them are replaced by the URLs
+var assetExts = map[string]bool{
+	".apng":  true,
+	".avif":  true,
+	".bmp":   true,
+	".gif":   true,
+	".ico":   true,
+	".jpeg":  true,
+	".jpg":   true,
+	".png":   true,
+	".svg":   true,
+	".webp":  true,
+	".eot":   true,
+	".otf":   true,
+	".ttf":   true,
+	".woff":  true,
+	".woff2": true,
+}
+
+// isStaticAsset reports whether the import is an image or a font file of the packages, the
+// URLs are kept as they are.
+func isStaticAsset(specifier string) bool {
+	if strings.HasPrefix(specifier, "data:") || strings.Contains(specifier, "://") {
+		return false
+	}
+	filename, _ := splitAssetSuffix(specifier)
+	return assetExts[strings.ToLower(path.Ext(filename))]
+}
+
+// splitAssetSuffix splits the query and the hash of the css URLs like `font.woff2?v=4.7.0#iefix`.
+func splitAssetSuffix(specifier string) (filename string, suffix string) {
+	if i := strings.IndexAny(specifier, "?#"); i >= 0 {
+		return specifier[:i], specifier[i:]
+	}
+	return specifier, ""
+}
+
+// resolveAssetImport resolves the import of an asset to the file of the package, the file
+// imports and the relative URLs of the css are resolved by the importer dir and the others by
+// the `node_modules` dir.
+func (task *buildTask) resolveAssetImport(args api.OnResolveArgs, specifier string) (filename string, err error) {
+	if filepath.IsAbs(specifier) {
+		filename = specifier
+	} else if isFileImportPath(specifier) || args.Kind == api.ResolveCSSURLToken || args.Kind == api.ResolveCSSImportRule {
+		filename = filepath.Join(args.ResolveDir, specifier)
+	} else {
+		filename = filepath.Join(task.wd, "node_modules", specifier)
+	}
+	if !fileExists(filename) {
+		err = fmt.Errorf("could not resolve \"%s\"", specifier)
+	}
+	return
+}
+
+// resolveAsset copies the asset into the storage: the `url()` and `@import` of the css files
+// are rewritten to the absolute URL of the asset, and the asset imported by the javascript
+// modules is loaded as a module that exports the URL by default.
+func (task *buildTask) resolveAsset(args api.OnResolveArgs, specifier string) (api.OnResolveResult, error) {
+	isCSS := args.Kind == api.ResolveCSSURLToken || args.Kind == api.ResolveCSSImportRule
+	specifier, suffix := splitAssetSuffix(specifier)
+	filename, err := task.resolveAssetImport(args, specifier)
+	if err != nil {
+		// the missing files of the css are kept as they are like the browsers do
+		if isCSS {
+			return api.OnResolveResult{Path: specifier + suffix, External: true}, nil
+		}
+		return api.OnResolveResult{}, err
+	}
+	if isCSS {
+		url, err := task.emitAsset(filename)
+		if err != nil {
+			return api.OnResolveResult{}, fmt.Errorf("could not load \"%s\": %v", specifier, err)
+		}
+		return api.OnResolveResult{Path: task.assetOrigin() + url + suffix, External: true}, nil
+	}
+	return api.OnResolveResult{Path: filename, Namespace: assetNamespace}, nil
+}
+
+// loadAsset copies the asset into the storage and returns the module that exports the URL.
+func (task *buildTask) loadAsset(args api.OnLoadArgs) (api.OnLoadResult, error) {
+	url, err := task.emitAsset(args.Path)
+	if err != nil {
+		return api.OnLoadResult{}, fmt.Errorf("could not load \"%s\": %v", args.Path, err)
+	}
+	code := fmt.Sprintf("export default %s;", strings.TrimSpace(string(utils.MustEncodeJSON(task.assetOrigin()+url))))
+	return api.OnLoadResult{Contents: &code, Loader: api.LoaderJS}, nil
+}
+
+// assetOrigin returns the origin of the asset and the file URLs of builds, the CDN domain is preferred except for the
+// scratch builds that are only stored in this server.
+func (task *buildTask) assetOrigin() string {
+	if task.config.cdnDomain != "" && !task.scratch {
+		return "https://" + task.config.cdnDomain
+	}
+	if task.config.domain == "localhost" {
+		return "http://localhost"
+	}
+	return "https://" + task.config.domain
+}
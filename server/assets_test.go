@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaticAssets(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-assets@1.0.0"), cjsExports: "auto", target: "es2020"}
+	js := buildFixture(t, task)
+
+	assetsURL := fmt.Sprintf("%s/v%d/esm-fixture-assets@1.0.0/_assets/", task.assetOrigin(), VERSION)
+	for _, name := range []string{"logo.png", "fonts/fixture.woff2", "fonts/fixture.woff"} {
+		if !fileExists(filepath.Join(s.config.storageDir, "builds", fmt.Sprintf("v%d/esm-fixture-assets@1.0.0/_assets", VERSION), name)) {
+			t.Fatalf("the asset %s should be copied into the storage", name)
+		}
+	}
+	if !strings.Contains(js, `"`+assetsURL+`logo.png"`) {
+		t.Fatalf("build %s: the image import is not rewritten:\n%s", task.ID(), js)
+	}
+	// the package css is stored along with the js
+	css := readBuild(t, s, task.ID()+".css")
+	for _, url := range []string{assetsURL + "fonts/fixture.woff2", assetsURL + "fonts/fixture.woff?v=1#iefix", assetsURL + "logo.png", "missing.png"} {
+		if !strings.Contains(css, url) {
+			t.Fatalf("build %s: missing the url '%s' in the css:\n%s", task.ID(), url, css)
+		}
+	}
+}
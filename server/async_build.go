@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+// the prefix of the status URLs of the async builds, like `/build-status/v36/react@17.0.2/es2020/react`
+const buildStatusPrefix = "/build-status/"
+
+// the time to keep the state of the finished async builds
+const asyncBuildStateTTL = 10 * time.Minute
+
+// An asyncBuild is the state of a build that is started by the `?async` query.
+type asyncBuild struct {
+	done    bool
+	err     error
+	expires time.Time
+}
+
+// An asyncBuildTracker tracks the async builds until the clients poll the final states.
+type asyncBuildTracker struct {
+	lock   sync.Mutex
+	builds map[string]*asyncBuild
+}
+
+var asyncBuilds = &asyncBuildTracker{builds: map[string]*asyncBuild{}}
+
+// Start adds the task to the queue without waiting for the output, the task in process is not
+// added again.
+func (t *asyncBuildTracker) Start(queue *buildQueue, task *buildTask) {
+	id := task.ID()
+	now := time.Now()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for key, b := range t.builds {
+		if b.done && now.After(b.expires) {
+			delete(t.builds, key)
+		}
+	}
+	if b, ok := t.builds[id]; ok && !b.done {
+		return
+	}
+	b := &asyncBuild{}
+	t.builds[id] = b
+	c := queue.Add(task)
+	go func() {
+		output := <-c
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		b.done = true
+		b.err = output.err
+		b.expires = time.Now().Add(asyncBuildStateTTL)
+	}()
+}
+
+// State returns the state of the tracked build: `queued`, `building`, `done` or `error`, the
+// state is empty if the build is not tracked.
+func (t *asyncBuildTracker) State(queue *buildQueue, id string) (state string, err error) {
+	var done bool
+	t.lock.Lock()
+	b, tracked := t.builds[id]
+	if tracked {
+		done, err = b.done, b.err
+	}
+	t.lock.Unlock()
+
+	if tracked && !done {
+		if inProcess, queued := queue.State(id); queued && !inProcess {
+			return "queued", nil
+		}
+		return "building", nil
+	}
+	if err != nil {
+		return "error", err
+	}
+	if tracked {
+		return "done", nil
+	}
+	return "", nil
+}
+
+// asyncBuildAccepted returns the `202 Accepted` response of the async build.
+func asyncBuildAccepted(ctx *rex.Context, id string) interface{} {
+	statusURL := buildStatusPrefix + id
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	ctx.SetHeader("Location", statusURL)
+	ctx.SetHeader("Retry-After", "1")
+	return rex.Status(202, map[string]interface{}{
+		"id":        id,
+		"status":    "queued",
+		"statusURL": statusURL,
+		"logURL":    buildLogPrefix + id,
+	})
+}
+
+// buildStatus handles the `/build-status/{buildID}` requests of the async builds, the `url` of
+// the artifact is reported when the build is done.
+func (s *Server) buildStatus(ctx *rex.Context, queue *buildQueue, pathname string) interface{} {
+	id := strings.TrimSuffix(strings.TrimPrefix(pathname, buildStatusPrefix), ".js")
+	if !strings.HasPrefix(id, fmt.Sprintf("v%d/", VERSION)) {
+		return rex.Status(400, "invalid build id")
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	state, err := asyncBuilds.State(queue, id)
+	if state == "" {
+		// the builds in the storage are done even if they are not started by the `?async` query
+		if _, _, ok := s.findESM(id); !ok {
+			return rex.Status(404, "build not found")
+		}
+		state = "done"
+	}
+	ret := map[string]interface{}{
+		"id":     id,
+		"status": state,
+	}
+	switch state {
+	case "done":
+		ret["url"] = fmt.Sprintf("/%s.js", id)
+	case "error":
+		ret["error"] = err.Error()
+	default:
+		ctx.SetHeader("Retry-After", "1")
+	}
+	return ret
+}
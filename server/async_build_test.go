@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ije/rex"
+	"github.com/postui/postdb/q"
+)
+
+func TestAsyncBuild(t *testing.T) {
+	s := setupTestEnv(t)
+	queue := newBuildQueue(1, 0)
+	tracker := &asyncBuildTracker{builds: map[string]*asyncBuild{}}
+
+	// occupy the only process
+	p := &task{buildTask: &buildTask{Server: s, id: "p"}, inProcess: true}
+	p.el = queue.queue.PushBack(p)
+	queue.current = []*task{p}
+
+	id := fmt.Sprintf("v%d/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm", VERSION)
+	tracker.Start(queue, &buildTask{Server: s, id: id})
+	tracker.Start(queue, &buildTask{Server: s, id: id})
+	if n := len(queue.tasks[id].consumers); n != 1 {
+		t.Fatalf("the async build in process should not be added again, got %d consumers", n)
+	}
+	if state, _ := tracker.State(queue, id); state != "queued" {
+		t.Fatalf("unexpected state %s", state)
+	}
+	queue.tasks[id].inProcess = true
+	if state, _ := tracker.State(queue, id); state != "building" {
+		t.Fatalf("unexpected state %s", state)
+	}
+
+	queue.tasks[id].consumers[0] <- &buildOutput{err: errors.New("unexpected token")}
+	for i := 0; i < 100; i++ {
+		if state, _ := tracker.State(queue, id); state != "building" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state, err := tracker.State(queue, id); state != "error" || err == nil || err.Error() != "unexpected token" {
+		t.Fatalf("unexpected state %s, %v", state, err)
+	}
+
+	// the builds in the storage are done
+	built := fmt.Sprintf("v%d/esm-fixture-cjs@1.0.0/es2020/esm-fixture-cjs", VERSION)
+	if _, err := s.db.Put(q.Alias(built), q.KV{"esmeta": []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(s.config.storageDir, "builds", built+".js")
+	if err := ensureDir(filepath.Dir(filename)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("export default 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	asyncBuilds = tracker
+	call := func(id string) interface{} {
+		req := httptest.NewRequest("GET", "http://esm.sh"+buildStatusPrefix+id, nil)
+		return s.buildStatus(&rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}, queue, buildStatusPrefix+id)
+	}
+	ret, ok := call(built).(map[string]interface{})
+	if !ok || ret["status"] != "done" || ret["url"] != "/"+built+".js" {
+		t.Fatalf("unexpected build status %v", ret)
+	}
+	ret, ok = call(id).(map[string]interface{})
+	if !ok || ret["status"] != "error" || ret["error"] != "unexpected token" {
+		t.Fatalf("unexpected build status %v", ret)
+	}
+	if _, ok := call(fmt.Sprintf("v%d/esm-fixture-dep@1.0.0/es2020/esm-fixture-dep", VERSION)).(map[string]interface{}); ok {
+		t.Fatal("the unknown build should not be found")
+	}
+}
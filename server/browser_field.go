@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the namespace of the modules that are replaced with the empty module by the `browser` field
+const browserEmptyNamespace = "esm-sh-browser-empty"
+
+// A browserField is the `browser` field of a package.json, the string form swaps the main
+// entry, the object form replaces the files and the modules of the package, `false` means
+// the empty module.
+type browserField struct {
+	main    string
+	replace map[string]interface{}
+}
+
+func readBrowserField(pkgDir string) (field browserField, ok bool) {
+	data, err := ioutil.ReadFile(filepath.Join(pkgDir, "package.json"))
+	if err != nil {
+		return
+	}
+	var p struct {
+		Browser interface{} `json:"browser"`
+	}
+	if json.Unmarshal(data, &p) != nil {
+		return
+	}
+	return parseBrowserField(p.Browser)
+}
+
+func parseBrowserField(v interface{}) (field browserField, ok bool) {
+	switch v := v.(type) {
+	case string:
+		field.main = v
+	case map[string]interface{}:
+		field.replace = v
+	default:
+		return
+	}
+	return field, true
+}
+
+// Entry returns the browser entry of the package that has the main entry `main`, the file
+// is relative to the package directory.
+func (f browserField) Entry(pkgDir string, main string) (entry string, ok bool) {
+	if f.main != "" {
+		entry = f.main
+	} else {
+		if main == "" {
+			main = "index.js"
+		}
+		for _, key := range []string{main, ensureSuffix(main, ".js")} {
+			if s, ok := f.replace["./"+strings.TrimPrefix(path.Clean(key), "./")].(string); ok {
+				entry = s
+				break
+			}
+		}
+	}
+	if entry == "" {
+		return
+	}
+	return resolvePackageFile(pkgDir, entry)
+}
+
+// resolvePackageFile resolves the file of the package like `./lib/browser` to `lib/browser.js`.
+func resolvePackageFile(pkgDir string, filename string) (string, bool) {
+	filename = strings.TrimPrefix(path.Clean(filename), "./")
+	if filename == ".." || strings.HasPrefix(filename, "../") {
+		return "", false
+	}
+	for _, name := range []string{filename, filename + ".js", filename + ".mjs", filename + ".cjs", path.Join(filename, "index.js")} {
+		if fileExists(filepath.Join(pkgDir, name)) {
+			return name, true
+		}
+	}
+	return "", false
+}
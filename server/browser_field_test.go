@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBrowserFieldResolver(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-browser-field-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	pkgDir := filepath.Join(wd, "node_modules", "@scope", "pkg")
+	for name, content := range map[string]string{
+		"package.json":    `{"browser": {"fs": false, "path": "path-browserify", "crypto": "./shims/crypto", "os": true}}`,
+		"shims/crypto.js": "",
+		"lib/index.js":    "",
+	} {
+		ensureDir(filepath.Dir(filepath.Join(pkgDir, name)))
+		ioutil.WriteFile(filepath.Join(pkgDir, name), []byte(content), 0644)
+	}
+
+	r := newPackageScopeResolver(wd)
+	importer := filepath.Join(pkgDir, "lib", "index.js")
+	for _, c := range []struct {
+		specifier string
+		to        string
+		ok        bool
+	}{
+		{"fs", "", true},
+		{"path", "path-browserify", true},
+		{"crypto", filepath.Join(pkgDir, "shims", "crypto.js"), true},
+		{"os", "", false},
+		{"util", "", false},
+	} {
+		to, ok := r.ResolveBrowser(importer, c.specifier)
+		if to != c.to || ok != c.ok {
+			t.Fatalf("resolve %s: unexpected result '%s' %v", c.specifier, to, ok)
+		}
+	}
+	// the files out of the node_modules are ignored
+	if _, ok := r.ResolveBrowser(filepath.Join(wd, "index.js"), "fs"); ok {
+		t.Fatal("the browser field should only apply to the packages")
+	}
+}
+
+func TestBrowserFieldBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	p := fixturePkg(t, s, "esm-fixture-browser@1.0.0")
+	task := &buildTask{Server: s, pkg: p, cjsExports: "auto", target: "es2020"}
+	esm, _, err := task.buildESM(context.Background())
+	if err != nil {
+		t.Fatalf("build %s: %v", task.ID(), err)
+	}
+	if esm.Module != "lib/browser.mjs" || !includes(esm.Exports, "platform") {
+		t.Fatalf("build %s: unexpected module '%s' %v", task.ID(), esm.Module, esm.Exports)
+	}
+	code := readBuild(t, s, task.ID()+".js")
+	if strings.Contains(code, "/node/fs") || strings.Contains(code, "esm-fixture-cjs") || !strings.Contains(code, "esm-fixture-dep@1.0.0") || !strings.Contains(code, `"browser"`) {
+		t.Fatalf("build %s: the browser field is not applied:\n%s", task.ID(), code)
+	}
+
+	// the node builds ignore the browser field
+	esmeta, err := s.initBuild(context.Background(), task.wd, p, "node", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esmeta.Main != "lib/node.js" || esmeta.Module != "" {
+		t.Fatalf("unexpected node entry '%s'", esmeta.Main)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the max number of the contributors recorded for a build
+const maxBundleContributors = 10
+
+var regByteSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*(b|kb|mb)?$`)
+
+// A BundleContributor is a package bundled in the build with its bytes in the output.
+type BundleContributor struct {
+	Name  string `json:"name"`
+	Bytes int    `json:"bytes"`
+}
+
+// bundleContributors returns the largest packages in the outputs by the esbuild metafile.
+func bundleContributors(metafile string) (contributors []BundleContributor, err error) {
+	var meta struct {
+		Outputs map[string]struct {
+			Inputs map[string]struct {
+				BytesInOutput int `json:"bytesInOutput"`
+			} `json:"inputs"`
+		} `json:"outputs"`
+	}
+	err = json.Unmarshal([]byte(metafile), &meta)
+	if err != nil {
+		return
+	}
+
+	sizes := map[string]int{}
+	for _, output := range meta.Outputs {
+		for input, v := range output.Inputs {
+			i := strings.LastIndex(input, "node_modules/")
+			if i < 0 || v.BytesInOutput == 0 {
+				continue
+			}
+			a := strings.Split(input[i+len("node_modules/"):], "/")
+			name := a[0]
+			if strings.HasPrefix(name, "@") && len(a) > 1 {
+				name += "/" + a[1]
+			}
+			sizes[name] += v.BytesInOutput
+		}
+	}
+	contributors = []BundleContributor{}
+	for name, bytes := range sizes {
+		contributors = append(contributors, BundleContributor{name, bytes})
+	}
+	sort.Slice(contributors, func(i, j int) bool {
+		if contributors[i].Bytes == contributors[j].Bytes {
+			return contributors[i].Name < contributors[j].Name
+		}
+		return contributors[i].Bytes > contributors[j].Bytes
+	})
+	if len(contributors) > maxBundleContributors {
+		contributors = contributors[:maxBundleContributors]
+	}
+	return
+}
+
+// parseByteSize parses the size like `150kb`, `1.5mb` or `2048`.
+func parseByteSize(s string) (int64, error) {
+	m := regByteSize.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	switch m[2] {
+	case "kb":
+		n *= 1024
+	case "mb":
+		n *= 1024 * 1024
+	}
+	return int64(n), nil
+}
+
+// formatByteSize formats the size like `150.0kb`.
+func formatByteSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%db", n)
+	}
+	if n < 1024*1024 {
+		return fmt.Sprintf("%.1fkb", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1fmb", float64(n)/1024/1024)
+}
+
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// gzipSize returns the size of the file after gzip.
+func gzipSize(filename string) (int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var n byteCounter
+	gw := gzip.NewWriter(&n)
+	_, err = io.Copy(gw, file)
+	if err == nil {
+		err = gw.Close()
+	}
+	return int64(n), err
+}
+
+// A budgetItem is the gzip size of a build in the budget report.
+type budgetItem struct {
+	Name         string              `json:"name"`
+	URL          string              `json:"url"`
+	Size         int64               `json:"size"`
+	Contributors []BundleContributor `json:"contributors,omitempty"`
+}
+
+// measureBudget returns the gzip sizes of the builds(name -> build id) in descending order and
+// the total size.
+func (s *Server) measureBudget(ids map[string]string, origin string) (items []budgetItem, total int64, err error) {
+	items = []budgetItem{}
+	for name, id := range ids {
+		var size int64
+		size, err = gzipSize(filepath.Join(s.config.storageDir, "builds", id+".js"))
+		if err != nil {
+			return
+		}
+		item := budgetItem{Name: name, URL: fmt.Sprintf("%s/%s.js", origin, id), Size: size}
+		if esm, _, ok := s.findESM(id); ok {
+			item.Contributors = esm.Contributors
+		}
+		items = append(items, item)
+		total += size
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Size == items[j].Size {
+			return items[i].Name < items[j].Name
+		}
+		return items[i].Size > items[j].Size
+	})
+	return
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/rex"
+	"github.com/postui/postdb/q"
+)
+
+func TestBundleContributors(t *testing.T) {
+	result := api.Build(api.BuildOptions{
+		Stdin: &api.StdinOptions{
+			Contents:   `import a from "a"; import b from "@s/b"; console.log(a, b);`,
+			ResolveDir: "/",
+		},
+		Bundle:   true,
+		Write:    false,
+		Metafile: true,
+		Plugins: []api.Plugin{{
+			Name: "fixtures",
+			Setup: func(build api.PluginBuild) {
+				build.OnResolve(api.OnResolveOptions{Filter: ".*"}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					return api.OnResolveResult{Path: "/node_modules/" + args.Path + "/index.js", Namespace: "fixture"}, nil
+				})
+				build.OnLoad(api.OnLoadOptions{Filter: ".*", Namespace: "fixture"}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					code := "export default 1;"
+					if strings.Contains(args.Path, "/a/") {
+						code = "export default " + strings.Repeat("1 + ", 100) + "1;"
+					}
+					return api.OnLoadResult{Contents: &code}, nil
+				})
+			},
+		}},
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors[0].Text)
+	}
+	contributors, err := bundleContributors(result.Metafile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contributors) != 2 || contributors[0].Name != "a" || contributors[1].Name != "@s/b" || contributors[0].Bytes <= contributors[1].Bytes {
+		t.Fatalf("unexpected contributors %v", contributors)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, n := range map[string]int64{"2048": 2048, "150kb": 150 * 1024, "1.5MB": 1536 * 1024, "10 b": 10} {
+		if v, err := parseByteSize(s); err != nil || v != n {
+			t.Fatalf("unexpected size of %s: %d, %v", s, v, err)
+		}
+	}
+	for _, s := range []string{"", "kb", "-1kb", "1gb"} {
+		if _, err := parseByteSize(s); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+}
+
+func TestPrebuildBudget(t *testing.T) {
+	s := setupTestEnv(t)
+	queue := newBuildQueue(1, 0)
+
+	id := fmt.Sprintf("v%d/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm", VERSION)
+	code := strings.Repeat("export const x = Math.random();\n", 100)
+	err := writeFileAtomic(filepath.Join(s.config.storageDir, "builds", id+".js"), bytes.NewReader([]byte(code)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.db.Put(q.Alias(id), q.KV{"esmeta": []byte(`{"module":"index.mjs","contributors":[{"name":"esm-fixture-esm","bytes":3200}]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := func(budget string) interface{} {
+		req := httptest.NewRequest("POST", "http://esm.sh/-/build?budget="+budget, strings.NewReader(`{"dependencies":{"esm-fixture-esm":"^1.0.0"}}`))
+		ctx := &rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}
+		return s.prebuild(ctx, queue)
+	}
+	if ret, ok := build("1mb").(map[string]interface{}); !ok || ret["budget"] != int64(1024*1024) || ret["size"].(int64) <= 0 {
+		t.Fatalf("the builds should be within the budget: %v", ret)
+	}
+	if _, ok := build("10b").(map[string]interface{}); ok {
+		t.Fatal("the builds should exceed the budget")
+	}
+	if _, ok := build("abc").(map[string]interface{}); ok {
+		t.Fatal("the invalid budget should be rejected")
+	}
+
+	items, total, err := s.measureBudget(map[string]string{"esm-fixture-esm": id}, "https://esm.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Size != total || items[0].Size >= int64(len(code)) || len(items[0].Contributors) != 1 {
+		t.Fatalf("unexpected budget items %v", items)
+	}
+}
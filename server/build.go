@@ -2,13 +2,16 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -20,12 +23,48 @@ import (
 )
 
 type buildTask struct {
-	id     string
-	wd     string
-	pkg    pkg
-	deps   pkgSlice
-	target string
-	isDev  bool
+	// the server of the build
+	*Server
+	id    string
+	wd    string
+	pkg   pkg
+	alias importAlias
+	deps  pkgSlice
+	// the packages that are kept as the bare imports, like `react` resolved by the import maps
+	external   []string
+	cjsExports string
+	exports    []string
+	// the defines of the `?env` and `?define` queries
+	define map[string]string
+	// the statements dropped from the build, like `console` and `debugger`
+	drop       []string
+	bundle     bool
+	standalone bool
+	split      bool
+	// keep the `name` of the functions and classes in the minified builds
+	keepNames bool
+	// the mode of the legal comments, empty means the `legal-comments` config of the server
+	legalComments string
+	sourcemap     string
+	target        string
+	isDev         bool
+	// the build of the dev routes, it's stored in the scratch area without the db record
+	scratch bool
+	// the artifacts written by the build for the replication feed
+	artifacts *artifactSet
+}
+
+var (
+	regImportMetaURL = regexp.MustCompile(`\bimport\.meta\.url\b`)
+	regNewWorkerExpr = regexp.MustCompile(`new\s+(Worker|SharedWorker)\(\s*new\s+URL\(\s*("|')(\.{1,2}/[^"']+)("|')\s*,\s*import\.meta\.url\s*\)`)
+	regNewURLExpr    = regexp.MustCompile(`new\s+URL\(\s*("|')(\.{1,2}/[^"']+)("|')\s*,\s*import\.meta\.url\s*\)`)
+)
+
+// the modes of the named exports synthesis of commonjs modules
+var cjsExportsModes = map[string]bool{
+	"strict": true, // only the default export
+	"auto":   true, // the named exports found by cjs-module-lexer
+	"all":    true, // the lexer exports and the keys of the evaluated module.exports, see `-eval-cjs-exports`
 }
 
 func (task *buildTask) ID() string {
@@ -34,7 +73,18 @@ func (task *buildTask) ID() string {
 	}
 
 	pkg := task.pkg
+	alias := ""
 	deps := ""
+	external := externalSegment(task.external, pkg.name)
+	cjsExports := ""
+	exports := ""
+	define := ""
+	drop := ""
+	bundle := ""
+	split := ""
+	keepNames := ""
+	legalComments := ""
+	sourcemap := ""
 	target := task.target
 	name := path.Base(pkg.name)
 	if pkg.submodule != "" {
@@ -43,73 +93,214 @@ func (task *buildTask) ID() string {
 	if task.isDev {
 		name += ".development"
 	}
+	if len(task.alias) > 0 {
+		alias = fmt.Sprintf("alias=%s/", strings.ReplaceAll(task.alias.String(), "/", "_"))
+	}
 	if len(task.deps) > 0 {
 		sort.Sort(task.deps)
 		deps = fmt.Sprintf("deps=%s/", strings.ReplaceAll(task.deps.String(), "/", "_"))
 	}
+	if task.cjsExports != "" && task.cjsExports != "auto" {
+		cjsExports = fmt.Sprintf("cjs-exports=%s/", task.cjsExports)
+	}
+	if len(task.exports) > 0 {
+		sort.Strings(task.exports)
+		exports = fmt.Sprintf("exports=%s/", strings.Join(task.exports, ","))
+	}
+	if len(task.define) > 0 {
+		define = fmt.Sprintf("define=%s/", defineHash(task.define))
+	}
+	if len(task.drop) > 0 {
+		drop = fmt.Sprintf("drop=%s/", strings.Join(task.drop, ","))
+	}
+	if task.standalone {
+		bundle = "standalone/"
+	} else if task.bundle {
+		bundle = "bundle/"
+	}
+	if task.split {
+		split = "split/"
+	}
+	if task.keepNames {
+		keepNames = "keep-names/"
+	}
+	if task.legalComments != "" {
+		legalComments = fmt.Sprintf("legal-comments=%s/", task.legalComments)
+	}
+	switch task.sourcemap {
+	case "external":
+		sourcemap = "sourcemap/"
+	case "inline":
+		sourcemap = "sourcemap=inline/"
+	}
 	task.id = fmt.Sprintf(
-		"v%d/%s@%s/%s%s/%s",
+		"v%d/%s@%s/%s%s%s%s%s%s%s%s%s%s%s%s%s/%s",
 		VERSION,
 		pkg.name,
 		pkg.version,
+		alias,
 		deps,
+		external,
+		cjsExports,
+		exports,
+		define,
+		drop,
+		bundle,
+		split,
+		keepNames,
+		legalComments,
+		sourcemap,
 		target,
 		name,
 	)
 	return task.id
 }
 
-func (task *buildTask) buildESM() (esm *ESMeta, pkgCSS bool, err error) {
+func (task *buildTask) buildESM(ctx context.Context) (esm *ESMeta, pkgCSS bool, err error) {
 	hasher := sha1.New()
 	hasher.Write([]byte(task.ID()))
-	task.wd = path.Join(os.TempDir(), "esm-build-"+hex.EncodeToString(hasher.Sum(nil)))
+	task.wd = filepath.Join(os.TempDir(), "esm-build-"+hex.EncodeToString(hasher.Sum(nil)))
 	ensureDir(task.wd)
 	defer os.RemoveAll(task.wd)
+	buildLogs.Start(task.ID(), task.wd)
+	defer func() {
+		buildLogs.Finish(task.wd, err)
+	}()
+	// the errors of the killed subprocesses are reported as the timeout
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = &buildTimeoutError{task.ID(), task.config.buildTimeout}
+		}
+	}()
+	task.logf("build %s (target: %s)", task.ID(), task.target)
+	task.artifacts = &artifactSet{server: task.Server}
+
+	// the build path of the defines is rebuilt by the stored defines
+	if len(task.define) > 0 {
+		err = task.saveDefines(defineHash(task.define), task.define)
+		if err != nil {
+			return
+		}
+	}
 
-	esmeta, err := initBuild(task.wd, task.pkg, true)
+	esmeta, err := task.initBuild(ctx, task.wd, task.pkg, task.target, true)
 	if err != nil {
 		return
 	}
 
-	start := time.Now()
-	buf := bytes.NewBuffer(nil)
-	exports := newStringSet()
-	hasDefaultExport := false
-	importPath := task.pkg.ImportPath()
-	env := "production"
-	if task.isDev {
-		env = "development"
+	// the native addons fail before bundling with the targeted error, instead of the confusing
+	// errors of the `.node` binaries
+	if reason, ok := detectNativeAddon(filepath.Join(task.wd, "node_modules", task.pkg.name)); ok {
+		err = &nativeAddonError{task.pkg.name, task.pkg.version, reason, task.config.nativeSubstitutes[task.pkg.name]}
+		return
 	}
-	for _, name := range esmeta.Exports {
-		if name == "default" {
-			hasDefaultExport = true
-		} else if name != "import" {
-			exports.Add(name)
+
+	// the pinned deps are bundled as well in the bundle mode
+	if (task.bundle || task.standalone) && len(task.deps) > 0 {
+		specs := make([]string, len(task.deps))
+		for i, dep := range task.deps {
+			specs[i] = fmt.Sprintf("%s@%s", dep.name, dep.version)
 		}
-	}
-	if esmeta.Module != "" {
-		if exports.Size() > 0 {
-			fmt.Fprintf(buf, `export {%s} from "%s";%s`, strings.Join(exports.Values(), ","), importPath, "\n")
+		err = task.installPackages(ctx, task.wd, specs...)
+		if err != nil {
+			return
 		}
-		if hasDefaultExport {
-			fmt.Fprintf(buf, `export {default} from "%s";`, importPath)
+	}
+
+	if esmeta.Module == "" {
+		switch task.cjsExports {
+		case "strict":
+			esmeta.Exports = nil
+		case "all":
+			if !task.config.evalCJSExports {
+				err = fmt.Errorf("the cjs-exports mode 'all' is disabled by the server")
+				return
+			}
+			names, e := task.evalCJSModuleExports(ctx, task.wd, task.pkg.ImportPath())
+			if e != nil {
+				task.log.Warn(e)
+			}
+			for _, name := range names {
+				if !includes(esmeta.Exports, name) {
+					esmeta.Exports = append(esmeta.Exports, name)
+				}
+			}
 		}
-	} else {
-		if exports.Size() > 0 {
-			fmt.Fprintf(buf, `export {%s,default} from "%s";%s`, strings.Join(exports.Values(), ","), importPath, "\n")
-		} else {
-			fmt.Fprintf(buf, `export {default} from "%s";`, importPath)
+		esmeta.CJSExports = task.cjsExports
+	}
+
+	// alias the development build of a pure ESM package to the production build
+	// if the NODE_ENV has no effect on it
+	// the standalone builds inline the process shim that sets the NODE_ENV
+	// the development build is built as usual if the production build doesn't exist, the
+	// production build is not started here to not take a second slot of the build queue
+	dedupable := esmeta.Module != "" && !task.split && !task.standalone && !task.scratch
+	if dedupable && task.isDev {
+		prodTask := *task
+		prodTask.id = ""
+		prodTask.isDev = false
+		prodESM, prodCSS, ok := task.findESM(prodTask.ID())
+		if ok && prodESM.NodeEnvFree {
+			err = task.aliasBuild(prodTask.ID(), task.ID(), prodCSS)
+			if err != nil {
+				return
+			}
+			cssMark := []byte{0}
+			if prodCSS {
+				cssMark = []byte{1}
+			}
+			_, err = task.db.Put(
+				q.Alias(task.ID()),
+				q.KV{
+					"esmeta": utils.MustEncodeJSON(prodESM),
+					"css":    cssMark,
+				},
+			)
+			if err != nil && err == postdb.ErrDuplicateAlias {
+				err = nil
+			}
+			if err != nil {
+				return
+			}
+			files := []string{filepath.Join(task.buildsDir(), task.ID()+".js")}
+			if prodCSS {
+				files = append(files, filepath.Join(task.buildsDir(), task.ID()+".css"))
+			}
+			task.publishBuild(task.ID(), prodESM, prodCSS, files)
+			task.log.Debugf("esbuild %s %s development aliased to production", task.pkg.String(), task.target)
+			esm = prodESM
+			pkgCSS = prodCSS
+			return
 		}
 	}
+
+	start := time.Now()
+	importPath := task.pkg.ImportPath()
+	env := "production"
+	if task.isDev {
+		env = "development"
+	}
 	input := &api.StdinOptions{
-		Contents:   buf.String(),
+		Contents:   buildEntryStub(importPath, esmeta, task.exports),
 		ResolveDir: task.wd,
 		Sourcefile: "export.js",
 	}
+	shims, err := writeInjectableNodeShims(task.wd, task.target)
+	if err != nil {
+		return
+	}
+	if task.standalone {
+		var standaloneShims []string
+		standaloneShims, err = task.writeStandaloneNodeShims(task.wd, env)
+		if err != nil {
+			return
+		}
+		shims = append(shims, standaloneShims...)
+	}
 	minify := !task.isDev
 	define := map[string]string{
-		"__filename":                  fmt.Sprintf(`"https://%s/%s.js"`, config.domain, task.ID()),
-		"__dirname":                   fmt.Sprintf(`"https://%s/%s"`, config.domain, path.Dir(task.ID())),
+		"__filename":                  fmt.Sprintf(`"https://%s/%s.js"`, task.config.domain, task.ID()),
+		"__dirname":                   fmt.Sprintf(`"https://%s/%s"`, task.config.domain, path.Dir(task.ID())),
 		"process":                     "__process$",
 		"Buffer":                      "__Buffer$",
 		"setImmediate":                "__setImmediate$",
@@ -124,7 +315,24 @@ func (task *buildTask) buildESM() (esm *ESMeta, pkgCSS bool, err error) {
 		"global.require.resolve":      "__rResolve$",
 		"global.process.env.NODE_ENV": fmt.Sprintf(`"%s"`, env),
 	}
+	// the node globals are available in Node.js and Bun, only the `NODE_ENV` is replaced
+	if isNodeRuntimeTarget(task.target) {
+		for _, name := range []string{"process", "Buffer", "setImmediate", "clearImmediate", "global", "global.process", "global.Buffer", "global.setImmediate", "global.clearImmediate"} {
+			delete(define, name)
+		}
+	}
+	if !task.isDev {
+		for key, value := range task.config.define {
+			define[key] = value
+		}
+	}
+	for key, value := range task.define {
+		define[key] = value
+	}
 	external := newStringSet()
+	externals := newExternalResolver(ctx, task, esmeta)
+	polyfills := newNodePolyfillLoader(ctx, task)
+	scopes := newPackageScopeResolver(task.wd)
 	esmResolverPlugin := api.Plugin{
 		Name: "esm-resolver",
 		Setup: func(plugin api.PluginBuild) {
@@ -132,10 +340,103 @@ func (task *buildTask) buildESM() (esm *ESMeta, pkgCSS bool, err error) {
 				api.OnResolveOptions{Filter: ".*"},
 				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
 					p := args.Path
+					// the internal imports like `#internal/utils` are resolved by the `imports` field
+					// of the importer package
+					if strings.HasPrefix(p, "#") && args.Kind != api.ResolveCSSURLToken {
+						to, ok := scopes.ResolveImports(args.Importer, p, task.importsConditions(args.Kind))
+						if !ok {
+							return api.OnResolveResult{}, fmt.Errorf("could not resolve \"%s\" by the imports field of the package", p)
+						}
+						if filepath.IsAbs(to) {
+							return relayFile(args, to), nil
+						}
+						p = to
+					}
+					// the `browser` field of the importer package replaces the node-only modules with
+					// the browser shims or the empty module
+					if !isNodeRuntimeTarget(task.target) && !isFileImportPath(p) {
+						if to, ok := scopes.ResolveBrowser(args.Importer, p); ok {
+							if to == "" {
+								return api.OnResolveResult{Path: p, Namespace: browserEmptyNamespace}, nil
+							}
+							if filepath.IsAbs(to) {
+								return relayFile(args, to), nil
+							}
+							p = to
+						}
+					}
+					// the wasm files are copied into the storage and imported by the loader module
+					if strings.HasSuffix(p, ".wasm") && !task.isExternal(p) {
+						filename, err := task.resolveAssetImport(args, p)
+						if err != nil {
+							return api.OnResolveResult{}, err
+						}
+						return api.OnResolveResult{Path: filename, Namespace: wasmNamespace}, nil
+					}
+					// the images and fonts are copied into the storage and imported by the URLs
+					if isStaticAsset(p) && !task.isExternal(p) {
+						return task.resolveAsset(args, p)
+					}
+					// the `.node` binaries of the native addons can't be bundled
+					if strings.HasSuffix(p, ".node") && strings.Contains(p, "/") {
+						name, version := task.pkg.name, task.pkg.version
+						if n, v, _, ok := task.lookupPackageFile(args.Importer); ok {
+							name, version = n, v
+						}
+						return api.OnResolveResult{}, &nativeAddonError{name, version, path.Base(p), task.config.nativeSubstitutes[name]}
+					}
+					// keep the dynamic imports of the package files as on-demand built submodules
+					if args.Kind == api.ResolveJSDynamicImport && !task.split && isFileImportPath(p) {
+						if url, ok := task.dynamicImportURL(args); ok {
+							return api.OnResolveResult{Path: url, External: true}, nil
+						}
+					}
 					importName := task.pkg.name
 					if smod := task.pkg.submodule; smod != "" {
 						importName += "/" + smod
 					}
+					// the aliased packages are always external, like `react` -> `preact/compat`
+					if to, ok := task.alias.Resolve(p); ok && p != importName {
+						if args.Kind == api.ResolveJSRequireCall {
+							return api.OnResolveResult{Path: to, Namespace: "esm-sh-cjs-external"}, nil
+						}
+						importPath, err := externals.Resolve(to)
+						if err != nil {
+							return api.OnResolveResult{}, err
+						}
+						return api.OnResolveResult{Path: importPath, External: true}, nil
+					}
+					// the external packages(and their submodules) are never bundled
+					if task.isExternal(p) {
+						if args.Kind == api.ResolveJSRequireCall {
+							return api.OnResolveResult{Path: p, Namespace: "esm-sh-cjs-external"}, nil
+						}
+						return api.OnResolveResult{Path: p, External: true}, nil
+					}
+					// the native addons are replaced by the substitutes of the config, like
+					// `bcrypt` -> `bcryptjs`
+					if to, ok := task.nativeSubstitute(p); ok && !isFileImportPath(p) && p != importName {
+						if args.Kind == api.ResolveJSRequireCall {
+							return api.OnResolveResult{Path: to, Namespace: "esm-sh-cjs-external"}, nil
+						}
+						importPath, err := externals.Resolve(to)
+						if err != nil {
+							return api.OnResolveResult{}, err
+						}
+						return api.OnResolveResult{Path: importPath, External: true}, nil
+					}
+					// the node builtin modules are imported by the `node:` scheme in the node and bun
+					// targets, and the bun native modules like `bun:sqlite` are kept as they are
+					if (isNodeRuntimeTarget(task.target) && isNodeBuiltInModule(p)) || (task.target == "bun" && isBunBuiltInModule(p)) {
+						if args.Kind == api.ResolveJSRequireCall {
+							return api.OnResolveResult{Path: p, Namespace: "esm-sh-cjs-external"}, nil
+						}
+						importPath, err := externals.Resolve(p)
+						if err != nil {
+							return api.OnResolveResult{}, err
+						}
+						return api.OnResolveResult{Path: importPath, External: true}, nil
+					}
 					// bundling modules:
 					// 1. the package itself
 					// 2. submodules of the package
@@ -146,10 +447,63 @@ func (task *buildTask) buildESM() (esm *ESMeta, pkgCSS bool, err error) {
 						(strings.HasPrefix(p, "@") && len(strings.Split(p, "/")) > 2) {
 						return api.OnResolveResult{}, nil
 					}
+					// bundle all the dependencies except the node builtin modules in the bundle mode
+					if (task.bundle || task.standalone) && !builtInNodeModules[p] {
+						return api.OnResolveResult{}, nil
+					}
+					// inline the polyfills of the node builtin modules in the standalone mode
+					if task.standalone && builtInNodeModules[p] && task.target != "deno" && !isNodeRuntimeTarget(task.target) {
+						return api.OnResolveResult{Path: p, Namespace: "esm-sh-node-polyfill"}, nil
+					}
+					// the required modules are wrapped by a commonjs shim module
+					if args.Kind == api.ResolveJSRequireCall {
+						return api.OnResolveResult{Path: p, Namespace: "esm-sh-cjs-external"}, nil
+					}
+					importPath, err := externals.Resolve(p)
+					if err != nil {
+						return api.OnResolveResult{}, err
+					}
 					external.Add(p)
-					return api.OnResolveResult{Path: "esm_sh_external://" + p, External: true}, nil
+					return api.OnResolveResult{Path: importPath, External: true}, nil
 				},
 			)
+			plugin.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: "esm-sh-cjs-external"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					code, err := externals.CJSShim(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					return api.OnLoadResult{Contents: &code, Loader: api.LoaderJS}, nil
+				},
+			)
+			plugin.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: browserEmptyNamespace},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					code := "module.exports = {};"
+					return api.OnLoadResult{Contents: &code, Loader: api.LoaderJS}, nil
+				},
+			)
+			plugin.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: relayNamespace},
+				task.loadRelay,
+			)
+			plugin.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: wasmNamespace},
+				task.loadWasm,
+			)
+			plugin.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: assetNamespace},
+				task.loadAsset,
+			)
+			plugin.OnLoad(
+				api.OnLoadOptions{Filter: ".*", Namespace: "esm-sh-node-polyfill"},
+				polyfills.Load,
+			)
+			plugin.OnLoad(
+				api.OnLoadOptions{Filter: `\.m?js$`, Namespace: "file"},
+				task.loadJSFile,
+			)
 		},
 	}
 	for name := range builtInNodeModules {
@@ -157,267 +511,178 @@ func (task *buildTask) buildESM() (esm *ESMeta, pkgCSS bool, err error) {
 			external.Add(name)
 		}
 	}
-	result := api.Build(api.BuildOptions{
+	platform := api.PlatformBrowser
+	if isNodeRuntimeTarget(task.target) {
+		// ignore the `browser` field and honor the `node` condition of the `exports` field
+		platform = api.PlatformNode
+	}
+	options := api.BuildOptions{
 		Stdin:             input,
 		Outdir:            "/esbuild",
 		Write:             false,
 		Bundle:            true,
 		Target:            targets[task.target],
 		Format:            api.FormatESModule,
-		Platform:          api.PlatformBrowser,
+		Platform:          platform,
 		MinifyWhitespace:  minify,
 		MinifyIdentifiers: minify,
 		MinifySyntax:      minify,
 		External:          external.Values(),
 		Define:            define,
 		Plugins:           []api.Plugin{esmResolverPlugin},
-	})
+		Splitting:         task.split,
+		KeepNames:         task.keepNames,
+		Metafile:          true,
+		AbsWorkingDir:     task.wd,
+		Inject:            shims,
+	}
+	switch task.target {
+	case "workers":
+		options.Conditions = []string{"workerd", "worker"}
+	case "bun":
+		// the `bun` condition takes precedence over the `node` condition
+		options.Conditions = []string{"bun"}
+	}
+	if task.sourcemap != "" {
+		options.Sourcemap = api.SourceMapExternal
+	}
+	if task.drops("console") {
+		options.Pure = pureConsoleCalls()
+	}
+	if err = task.injectFault("esbuild"); err != nil {
+		return
+	}
+	result, err := runESBuild(ctx, options)
+	if err != nil {
+		return
+	}
 	if len(result.Errors) > 0 {
+		for _, e := range result.Errors {
+			task.logf("esbuild error: %s", formatESBuildMessage(e))
+		}
+		if text := result.Errors[0].Text; isUnsupportedSyntaxError(text) {
+			minTarget := findMinViableTarget(options, task.target)
+			if minTarget != "" {
+				task.recordMinTarget(task.pkg, minTarget)
+			}
+			err = &targetError{task.pkg, task.target, minTarget, text}
+			return
+		}
 		err = errors.New("esbuild: " + result.Errors[0].Text)
 		return
 	}
 	for _, w := range result.Warnings {
-		log.Warn(w.Text)
+		task.log.Warn(w.Text)
+		task.logf("esbuild warning: %s", formatESBuildMessage(w))
+	}
+
+	if len(task.exports) > 0 {
+		esmeta.Treeshake, err = reportTreeshake(options, buildEntryStub(importPath, esmeta, nil), result)
+		if err != nil {
+			task.log.Warnf("reportTreeshake(%s): %v", task.pkg.String(), err)
+			err = nil
+		}
+	}
+
+	if task.config.analyzeSideEffects {
+		esmeta.TopLevelSideEffects, err = analyzeSideEffects(task.wd, importPath, esmResolverPlugin, define)
+		if err != nil {
+			task.log.Warnf("analyzeSideEffects(%s): %v", task.pkg.String(), err)
+			err = nil
+		}
+	}
+
+	sourceMaps := map[string][]byte{}
+	for _, file := range result.OutputFiles {
+		if strings.HasSuffix(file.Path, ".js.map") {
+			sourceMaps[strings.TrimSuffix(file.Path, ".map")] = file.Contents
+		}
 	}
 
 	cssMark := []byte{0}
+	usesProcess := false
 	for _, file := range result.OutputFiles {
 		outputContent := file.Contents
 		if strings.HasSuffix(file.Path, ".js") {
-			jsHeader := bytes.NewBufferString(fmt.Sprintf(
-				"/* esm.sh - esbuild bundle(%s) %s %s */\n",
+			// the chunks of the `?split` build are stored under the directory of the build
+			saveFilePath := filepath.Join(task.buildsDir(), task.ID()+".js")
+			isEntry := file.Path == path.Join(options.Outdir, "stdin.js")
+			if !isEntry {
+				saveFilePath = filepath.Join(task.buildsDir(), path.Dir(task.ID()), path.Base(file.Path))
+			}
+
+			jsHeader := newJSWriter(task.isDev, task.config.devLineWidth)
+			jsHeader.Line(
+				"/* esm.sh - esbuild bundle(%s) %s %s */",
 				task.pkg.String(),
 				strings.ToLower(task.target),
 				env,
-			))
-			eol := "\n"
-			if !task.isDev {
-				eol = ""
-			}
+			)
 
-			// replace external imports/requires
-			for _, name := range external.Values() {
-				var importPath string
-				if task.target == "deno" {
-					_, yes := denoStdNodeModules[name]
-					if yes {
-						importPath = fmt.Sprintf("/v%d/_deno_std_node_%s.js", VERSION, name)
+			// move legal comments into the `.LEGAL.txt` artifact
+			if mode := task.legalCommentsMode(); mode == "none" || mode == "linked" {
+				var comments [][]byte
+				outputContent, comments = splitLegalComments(outputContent)
+				if mode == "linked" && isEntry {
+					var legal []byte
+					legal, err = extractLegalComments(
+						task.wd,
+						result.Metafile,
+						fmt.Sprintf("esm.sh - legal comments of %s (%s)", task.pkg.String(), task.target),
+						comments,
+					)
+					if err != nil {
+						return
 					}
-				}
-				if name == "buffer" {
-					importPath = fmt.Sprintf("/v%d/_node_buffer.js", VERSION)
-				}
-				if importPath == "" && builtInNodeModules[name] {
-					polyfill, ok := polyfilledBuiltInNodeModules[name]
-					if ok {
-						p, submodule, e := node.getPackageInfo(polyfill, "latest")
-						if e == nil {
-							filename := path.Base(p.Name)
-							if submodule != "" {
-								filename = submodule
-							}
-							if task.isDev {
-								filename += ".development"
-							}
-							importPath = fmt.Sprintf(
-								"/v%d/%s@%s/%s/%s.js",
-								VERSION,
-								p.Name,
-								p.Version,
-								task.target,
-								filename,
-							)
-						} else {
-							err = e
-							return
-						}
-					} else {
-						_, err := embedFS.Open(fmt.Sprintf("polyfills/node_%s.js", name))
-						if err == nil {
-							importPath = fmt.Sprintf("/v%d/_node_%s.js", VERSION, name)
-						} else {
-							importPath = fmt.Sprintf("/_error.js?type=unsupported-nodejs-builtin-module&name=%s", name)
-						}
-					}
-				}
-				if importPath == "" {
-					packageFile := path.Join(task.wd, "node_modules", name, "package.json")
-					if fileExists(packageFile) {
-						var p NpmPackage
-						if utils.ParseJSONFile(packageFile, &p) == nil {
-							suffix := ".js"
-							if task.isDev {
-								suffix = ".development.js"
-							}
-							importPath = fmt.Sprintf(
-								"/v%d/%s@%s/%s/%s%s",
-								VERSION,
-								p.Name,
-								p.Version,
-								task.target,
-								path.Base(p.Name),
-								suffix,
-							)
-						}
-					}
-				}
-				if importPath == "" {
-					version := "latest"
-					for _, dep := range task.deps {
-						if name == dep.name {
-							version = dep.version
-							break
-						}
-					}
-					if version == "latest" {
-						for n, v := range esmeta.Dependencies {
-							if name == n {
-								version = v
-								break
-							}
-						}
-					}
-					if version == "latest" {
-						for n, v := range esmeta.PeerDependencies {
-							if name == n {
-								version = v
-								break
-							}
-						}
-					}
-					p, submodule, e := node.getPackageInfo(name, version)
-					if e == nil {
-						filename := path.Base(p.Name)
-						if submodule != "" {
-							filename = submodule
-						}
-						if task.isDev {
-							filename += ".development"
-						}
-						importPath = fmt.Sprintf(
-							"/v%d/%s@%s/%s/%s.js",
-							VERSION,
-							p.Name,
-							p.Version,
-							task.target,
-							filename,
-						)
-					}
-				}
-				if importPath == "" {
-					importPath = fmt.Sprintf("/_error.js?type=resolve&name=%s", name)
-				}
-				buf := bytes.NewBuffer(nil)
-				identifier := identify(name)
-				slice := bytes.Split(outputContent, []byte(fmt.Sprintf("\"esm_sh_external://%s\"", name)))
-				commonjs := false
-				commonjsImported := false
-				for i, p := range slice {
-					if commonjs {
-						p = bytes.TrimPrefix(p, []byte{')'})
-					}
-					commonjs = bytes.HasSuffix(p, []byte("require("))
-					if commonjs {
-						p = bytes.TrimSuffix(p, []byte("require("))
-						if !commonjsImported {
-							wrote := false
-							versionPrefx := fmt.Sprintf("/v%d/", VERSION)
-							if strings.HasPrefix(importPath, versionPrefx) {
-								pkg, err := parsePkg(strings.TrimPrefix(importPath, versionPrefx))
-								if err == nil {
-									// here the submodule should be always empty
-									pkg.submodule = ""
-									_, installed := esmeta.Dependencies[name]
-									if !installed {
-										_, installed = esmeta.PeerDependencies[name]
-									}
-									meta, err := initBuild(task.wd, *pkg, !installed)
-									if err == nil {
-										hasDefaultExport := false
-										if len(meta.Exports) > 0 {
-											for _, name := range meta.Exports {
-												if name == "default" || name == "__esModule" {
-													hasDefaultExport = true
-													break
-												}
-											}
-										} else {
-											hasDefaultExport = true
-										}
-										if hasDefaultExport {
-											fmt.Fprintf(jsHeader, `import __%s$ from "%s";%s`, identifier, importPath, eol)
-										} else {
-											fmt.Fprintf(jsHeader, `import * as __%s$ from "%s";%s`, identifier, importPath, eol)
-										}
-										wrote = true
-									}
-								}
-							}
-							if !wrote {
-								fmt.Fprintf(jsHeader, `import __%s$ from "%s";%s`, identifier, importPath, eol)
-							}
-							commonjsImported = true
-						}
-					}
-					buf.Write(p)
-					if i < len(slice)-1 {
-						if commonjs {
-							buf.WriteString(fmt.Sprintf("__%s$", identifier))
-						} else {
-							buf.WriteString(fmt.Sprintf("\"%s\"", importPath))
-						}
+					err = task.artifacts.Write(filepath.Join(task.buildsDir(), task.ID()+".LEGAL.txt"), bytes.NewReader(legal))
+					if err != nil {
+						return
 					}
+					jsHeader.Line("/*! For license information please see /%s.LEGAL.txt */", task.ID())
 				}
-				outputContent = buf.Bytes()
 			}
 
-			// add nodejs/deno compatibility
-			if bytes.Contains(outputContent, []byte("__process$")) {
-				fmt.Fprintf(jsHeader, `import __process$ from "/v%d/_node_process.js";%s__process$.env.NODE_ENV="%s";%s`, VERSION, eol, env, eol)
-			}
-			if bytes.Contains(outputContent, []byte("__Buffer$")) {
-				fmt.Fprintf(jsHeader, `import { Buffer as __Buffer$ } from "/v%d/_node_buffer.js";%s`, VERSION, eol)
-			}
-			if bytes.Contains(outputContent, []byte("__global$")) {
-				fmt.Fprintf(jsHeader, `var __global$ = window;%s`, eol)
-			}
-			if bytes.Contains(outputContent, []byte("__setImmediate$")) {
-				fmt.Fprintf(jsHeader, `var __setImmediate$ = (cb, args) => setTimeout(cb, 0, ...args);%s`, eol)
-			}
-			if bytes.Contains(outputContent, []byte("__rResolve$")) {
-				fmt.Fprintf(jsHeader, `var __rResolve$ = p => p;%s`, eol)
+			globals, ok := unboundIdentifiers(outputContent)
+			if !ok {
+				err = fmt.Errorf("esbuild: invalid output %s", path.Base(file.Path))
+				return
 			}
 
-			saveFilePath := path.Join(config.storageDir, "builds", task.ID()+".js")
-			ensureDir(path.Dir(saveFilePath))
+			// import the external modules that are required by the commonjs code
+			externals.WriteCJSImports(jsHeader, globals)
 
-			var file *os.File
-			file, err = os.Create(saveFilePath)
-			if err != nil {
-				return
-			}
-			defer file.Close()
+			// add nodejs/deno compatibility
+			writeNodeShims(jsHeader, globals, env)
+			usesProcess = usesProcess || globals.Has("__process$")
 
-			_, err = io.Copy(file, jsHeader)
-			if err != nil {
-				return
+			outputContent = normalizeEOL(outputContent)
+			if sourceMap, ok := sourceMaps[file.Path]; ok {
+				sourceMap, err = fixSourceMap(sourceMap, bytes.Count(jsHeader.Bytes(), []byte{'\n'}), options.Outdir, task.wd)
+				if err != nil {
+					return
+				}
+				if task.sourcemap == "external" {
+					err = task.artifacts.Write(saveFilePath+".map", bytes.NewReader(sourceMap))
+					if err != nil {
+						return
+					}
+				}
+				if !bytes.HasSuffix(outputContent, []byte{'\n'}) {
+					outputContent = append(outputContent, '\n')
+				}
+				outputContent = append(outputContent, sourceMappingURL(task.sourcemap, filepath.Base(saveFilePath)+".map", sourceMap)...)
 			}
 
-			_, err = io.Copy(file, bytes.NewReader(outputContent))
+			err = task.artifacts.Write(
+				saveFilePath,
+				bytes.NewReader(jsHeader.Bytes()),
+				bytes.NewReader(outputContent),
+			)
 			if err != nil {
 				return
 			}
 		} else if strings.HasSuffix(file.Path, ".css") {
-			saveFilePath := path.Join(config.storageDir, "builds", task.ID()+".css")
-			ensureDir(path.Dir(saveFilePath))
-			file, e := os.Create(saveFilePath)
-			if e != nil {
-				err = e
-				return
-			}
-			defer file.Close()
-
-			_, err = io.Copy(file, bytes.NewReader(outputContent))
+			err = task.artifacts.Write(filepath.Join(task.buildsDir(), task.ID()+".css"), bytes.NewReader(outputContent))
 			if err != nil {
 				return
 			}
@@ -425,22 +690,56 @@ func (task *buildTask) buildESM() (esm *ESMeta, pkgCSS bool, err error) {
 		}
 	}
 
-	log.Debugf("esbuild %s %s %s in %v", task.pkg.String(), task.target, env, time.Now().Sub(start))
+	task.log.Debugf("esbuild %s %s %s in %v", task.pkg.String(), task.target, env, time.Now().Sub(start))
 
-	err = task.handleDTS(esmeta)
+	// the builds that fail the verification are removed instead of being served
+	if task.config.verifyBuilds {
+		err = task.verifyBuild(ctx, esmeta, filepath.Join(task.buildsDir(), task.ID()+".js"))
+		if err != nil {
+			for _, filename := range task.artifacts.Files() {
+				os.Remove(filename)
+				os.Remove(filename + ".sig")
+			}
+			task.log.Warn(err)
+			task.logf("%v", err)
+			return
+		}
+	}
+
+	esmeta.Contributors, err = bundleContributors(result.Metafile)
 	if err != nil {
 		return
 	}
 
-	_, err = db.Put(
-		q.Alias(task.ID()),
-		q.KV{
-			"esmeta": utils.MustEncodeJSON(esmeta),
-			"css":    cssMark,
-		},
-	)
-	if err != nil && err == postdb.ErrDuplicateAlias {
-		err = nil
+	if dedupable && !task.isDev {
+		esmeta.NodeEnvFree, err = isNodeEnvFree(task.wd, result.Metafile, defineMarkers(task.config.define)...)
+		if err != nil {
+			return
+		}
+		esmeta.NodeEnvFree = esmeta.NodeEnvFree && !usesProcess && !externals.HasPackageDeps()
+	}
+
+	// the scratch builds are served once, the types and the db record are not needed
+	if task.scratch {
+		esm = esmeta
+		pkgCSS = cssMark[0] == 1
+		return
+	}
+
+	err = task.handleDTS(ctx, esmeta)
+	if err != nil {
+		return
+	}
+
+	esmeta.PolyfillsHash = task.polyfillsHash()
+	kv := q.KV{
+		"esmeta": utils.MustEncodeJSON(esmeta),
+		"css":    cssMark,
+	}
+	_, err = task.db.Put(q.Alias(task.ID()), kv)
+	if err == postdb.ErrDuplicateAlias {
+		// the stale build is rebuilt
+		err = task.db.Update(q.Alias(task.ID()), kv)
 	}
 	if err != nil {
 		return
@@ -448,44 +747,218 @@ func (task *buildTask) buildESM() (esm *ESMeta, pkgCSS bool, err error) {
 
 	esm = esmeta
 	pkgCSS = cssMark[0] == 1
+	task.publishBuild(task.ID(), esm, pkgCSS, task.artifacts.Files())
 	return
 }
 
-func (task *buildTask) handleDTS(esmeta *ESMeta) (err error) {
+// lookupPackageFile returns the package name, version and the subpath of a file in the node_modules
+func (task *buildTask) lookupPackageFile(filename string) (name string, version string, subpath string, ok bool) {
+	// the esbuild paths use the OS separator
+	filename = filepath.ToSlash(filename)
+	nmDir := filepath.ToSlash(filepath.Join(task.wd, "node_modules")) + "/"
+	if !strings.HasPrefix(filename, nmDir) {
+		return
+	}
+
+	a := strings.Split(strings.TrimPrefix(filename, nmDir), "/")
+	name = a[0]
+	subpath = strings.Join(a[1:], "/")
+	if strings.HasPrefix(name, "@") && len(a) > 1 {
+		name = a[0] + "/" + a[1]
+		subpath = strings.Join(a[2:], "/")
+	}
+	if strings.Contains(subpath, "node_modules/") || subpath == "" {
+		return
+	}
+
+	version = task.pkg.version
+	if name != task.pkg.name {
+		var p NpmPackage
+		if utils.ParseJSONFile(filepath.Join(nmDir, name, "package.json"), &p) != nil {
+			return
+		}
+		version = p.Version
+	}
+	ok = true
+	return
+}
+
+// dynamicImportURL returns the build URL of the file that is imported by the dynamic `import()`
+func (task *buildTask) dynamicImportURL(args api.OnResolveArgs) (url string, ok bool) {
+	return task.submoduleURL(resolveJSFile(path.Join(args.ResolveDir, args.Path)))
+}
+
+// submoduleURL returns the build URL of a package file with the same build options, except the
+// `exports` that are picked for the entry only
+func (task *buildTask) submoduleURL(filename string) (url string, ok bool) {
+	if filename == "" {
+		return
+	}
+	name, version, subpath, ok := task.lookupPackageFile(filename)
+	if !ok {
+		return
+	}
+
+	sub := &buildTask{
+		Server: task.Server,
+		pkg: pkg{
+			name:      name,
+			version:   version,
+			submodule: strings.TrimSuffix(subpath, ".js"),
+		},
+		alias:         task.alias,
+		deps:          task.deps,
+		external:      task.external,
+		cjsExports:    task.cjsExports,
+		define:        task.define,
+		drop:          task.drop,
+		bundle:        task.bundle,
+		standalone:    task.standalone,
+		split:         task.split,
+		keepNames:     task.keepNames,
+		legalComments: task.legalComments,
+		sourcemap:     task.sourcemap,
+		target:        task.target,
+		isDev:         task.isDev,
+		scratch:       task.scratch,
+	}
+	return sub.routePrefix() + "/" + escapePath(sub.ID()) + ".js", true
+}
+
+// loadJSFile rewrites the `import.meta.url` of a bundled file, drops its `debugger` statements
+// and inlines its published source map, the file is loaded by esbuild as it is if nothing is
+// changed.
+func (task *buildTask) loadJSFile(args api.OnLoadArgs) (api.OnLoadResult, error) {
+	data, err := ioutil.ReadFile(args.Path)
+	if err != nil {
+		return api.OnLoadResult{}, nil
+	}
+	data, rewritten := task.rewriteImportMetaURL(args.Path, data)
+	dropped := false
+	if task.drops("debugger") {
+		if code, ok := dropDebugger(string(data)); ok {
+			data, dropped = []byte(code), true
+		}
+	}
+	inlined := false
+	if task.sourcemap != "" {
+		data, inlined = inlineSourceMap(args.Path, data, task.wd)
+	}
+	if !rewritten && !dropped && !inlined {
+		return api.OnLoadResult{}, nil
+	}
+	contents := string(data)
+	return api.OnLoadResult{
+		Contents:   &contents,
+		ResolveDir: path.Dir(args.Path),
+		Loader:     api.LoaderJS,
+	}, nil
+}
+
+// rewriteImportMetaURL replaces the `import.meta.url` of a bundled file with the raw file URL
+func (task *buildTask) rewriteImportMetaURL(filename string, data []byte) ([]byte, bool) {
+	if !regImportMetaURL.Match(data) {
+		return data, false
+	}
+	name, version, subpath, ok := task.lookupPackageFile(filename)
+	if !ok {
+		return data, false
+	}
+
+	origin := task.assetOrigin()
+
+	// the `new Worker(new URL("./worker.js", import.meta.url))` pattern: build the worker as a
+	// submodule and start it by a same-origin blob that imports the submodule
+	data = regNewWorkerExpr.ReplaceAllFunc(data, func(expr []byte) []byte {
+		m := regNewWorkerExpr.FindSubmatch(expr)
+		workerURL, ok := task.submoduleURL(resolveJSFile(path.Join(path.Dir(filename), string(m[3]))))
+		if !ok {
+			return expr
+		}
+		return []byte(fmt.Sprintf(`new %s(%s`, m[1], workerBlobURL(origin+workerURL)))
+	})
+
+	// the `new URL("./asset", import.meta.url)` pattern: copy the asset into the storage
+	data = regNewURLExpr.ReplaceAllFunc(data, func(expr []byte) []byte {
+		assetURL, err := task.emitAsset(path.Join(path.Dir(filename), string(regNewURLExpr.FindSubmatch(expr)[2])))
+		if err != nil {
+			task.log.Warnf("emitAsset(%s): %v", expr, err)
+			return expr
+		}
+		return []byte(fmt.Sprintf(`new URL("%s%s")`, origin, assetURL))
+	})
+
+	url := fmt.Sprintf(`"%s/%s@%s/%s"`, origin, name, version, escapePath(subpath))
+	return regImportMetaURL.ReplaceAll(data, []byte(url)), true
+}
+
+// emitAsset copies the asset file referenced by `new URL("./asset", import.meta.url)`
+// into the storage, and returns its URL path.
+func (task *buildTask) emitAsset(filename string) (url string, err error) {
+	if !fileExists(filename) {
+		err = fmt.Errorf("asset not found")
+		return
+	}
+	name, version, subpath, ok := task.lookupPackageFile(filename)
+	if !ok {
+		err = fmt.Errorf("asset out of packages")
+		return
+	}
+
+	url = fmt.Sprintf("/v%d/%s@%s/_assets/%s", VERSION, name, version, subpath)
+	saveFilePath := filepath.Join(task.buildsDir(), url)
+	if !fileExists(saveFilePath) {
+		var file *os.File
+		file, err = os.Open(filename)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		err = task.artifacts.Write(saveFilePath, file)
+	}
+	url = task.routePrefix() + escapePath(url)
+	return
+}
+
+func (task *buildTask) handleDTS(ctx context.Context, esmeta *ESMeta) (err error) {
 	start := time.Now()
 	pkg := task.pkg
-	nodeModulesDir := path.Join(task.wd, "node_modules")
+	nodeModulesDir := filepath.Join(task.wd, "node_modules")
 	versionedName := fmt.Sprintf("%s@%s", esmeta.Name, esmeta.Version)
 
 	var types string
-	if esmeta.Types != "" || esmeta.Typings != "" {
+	if s, ok := resolveExportsTypes(filepath.Join(nodeModulesDir, esmeta.Name), pkg.submodule); ok {
+		types = fmt.Sprintf("%s/%s", versionedName, s)
+	} else if esmeta.Types != "" || esmeta.Typings != "" {
 		types = getTypesPath(nodeModulesDir, *esmeta.NpmPackage, "")
 	} else if pkg.submodule == "" {
-		if fileExists(path.Join(nodeModulesDir, pkg.name, "index.d.ts")) {
+		if fileExists(filepath.Join(nodeModulesDir, pkg.name, "index.d.ts")) {
 			types = fmt.Sprintf("%s/%s", versionedName, "index.d.ts")
 		} else if !strings.HasPrefix(pkg.name, "@") {
-			packageFile := path.Join(nodeModulesDir, "@types", pkg.name, "package.json")
+			packageFile := filepath.Join(nodeModulesDir, "@types", pkg.name, "package.json")
 			if fileExists(packageFile) {
 				var p NpmPackage
-				err := utils.ParseJSONFile(path.Join(nodeModulesDir, "@types", pkg.name, "package.json"), &p)
+				err := utils.ParseJSONFile(filepath.Join(nodeModulesDir, "@types", pkg.name, "package.json"), &p)
 				if err == nil {
 					types = getTypesPath(nodeModulesDir, p, "")
 				}
 			}
 		}
 	} else {
-		if fileExists(path.Join(nodeModulesDir, pkg.name, pkg.submodule, "index.d.ts")) {
+		if fileExists(filepath.Join(nodeModulesDir, pkg.name, pkg.submodule, "index.d.ts")) {
 			types = fmt.Sprintf("%s/%s", versionedName, path.Join(pkg.submodule, "index.d.ts"))
-		} else if fileExists(path.Join(nodeModulesDir, pkg.name, ensureSuffix(pkg.submodule, ".d.ts"))) {
+		} else if fileExists(filepath.Join(nodeModulesDir, pkg.name, ensureSuffix(pkg.submodule, ".d.ts"))) {
 			types = fmt.Sprintf("%s/%s", versionedName, ensureSuffix(pkg.submodule, ".d.ts"))
-		} else if fileExists(path.Join(nodeModulesDir, "@types", pkg.name, pkg.submodule, "index.d.ts")) {
+		} else if fileExists(filepath.Join(nodeModulesDir, "@types", pkg.name, pkg.submodule, "index.d.ts")) {
 			types = fmt.Sprintf("@types/%s/%s", versionedName, path.Join(pkg.submodule, "index.d.ts"))
-		} else if fileExists(path.Join(nodeModulesDir, "@types", pkg.name, ensureSuffix(pkg.submodule, ".d.ts"))) {
+		} else if fileExists(filepath.Join(nodeModulesDir, "@types", pkg.name, ensureSuffix(pkg.submodule, ".d.ts"))) {
 			types = fmt.Sprintf("@types/%s/%s", versionedName, ensureSuffix(pkg.submodule, ".d.ts"))
 		}
 	}
 	if types != "" {
-		err = copyDTS(
+		task.logf("dts: copying the types of %s", types)
+		err = task.copyDTS(
+			ctx,
 			nodeModulesDir,
 			types,
 		)
@@ -494,15 +967,22 @@ func (task *buildTask) handleDTS(esmeta *ESMeta) (err error) {
 			return
 		}
 		esmeta.Dts = "/" + types
-		log.Debug("copy dts in", time.Now().Sub(start))
+		// record the DefinitelyTyped package that the types come from
+		if strings.HasPrefix(types, "@types/") {
+			var p NpmPackage
+			if utils.ParseJSONFile(filepath.Join(nodeModulesDir, "@types", pkg.name, "package.json"), &p) == nil {
+				esmeta.TypesSource = fmt.Sprintf("%s@%s", p.Name, p.Version)
+			}
+		}
+		task.log.Debug("copy dts in", time.Now().Sub(start))
 	}
 
 	return
 }
 
-func initBuild(buildDir string, pkg pkg, install bool) (esmeta *ESMeta, err error) {
+func (s *Server) initBuild(ctx context.Context, buildDir string, pkg pkg, target string, install bool) (esmeta *ESMeta, err error) {
 	var p NpmPackage
-	p, _, err = node.getPackageInfo(pkg.name, pkg.version)
+	p, _, err = s.getPackageInfo(pkg.name, pkg.version)
 	if err != nil {
 		return
 	}
@@ -513,10 +993,10 @@ func initBuild(buildDir string, pkg pkg, install bool) (esmeta *ESMeta, err erro
 	installList := []string{
 		fmt.Sprintf("%s@%s", pkg.name, pkg.version),
 	}
-	pkgDir := path.Join(buildDir, "node_modules", esmeta.Name)
+	pkgDir := filepath.Join(buildDir, "node_modules", esmeta.Name)
 	if esmeta.Types == "" && esmeta.Typings == "" && !strings.HasPrefix(pkg.name, "@") {
 		var info NpmPackage
-		info, _, err = node.getPackageInfo("@types/"+pkg.name, "latest")
+		info, _, err = s.getPackageInfo("@types/"+pkg.name, "latest")
 		if err == nil {
 			if info.Types != "" || info.Typings != "" || info.Main != "" {
 				installList = append(installList, fmt.Sprintf("%s@%s", info.Name, info.Version))
@@ -528,18 +1008,6 @@ func initBuild(buildDir string, pkg pkg, install bool) (esmeta *ESMeta, err erro
 	if esmeta.Module == "" && esmeta.Type == "module" {
 		esmeta.Module = esmeta.Main
 	}
-	if esmeta.Module == "" && esmeta.DefinedExports != nil {
-		v, ok := esmeta.DefinedExports.(map[string]interface{})
-		if ok {
-			m, ok := v["import"]
-			if ok {
-				s, ok := m.(string)
-				if ok && s != "" {
-					esmeta.Module = s
-				}
-			}
-		}
-	}
 	if pkg.submodule != "" {
 		esmeta.Main = pkg.submodule
 		esmeta.Module = ""
@@ -551,14 +1019,41 @@ func initBuild(buildDir string, pkg pkg, install bool) (esmeta *ESMeta, err erro
 		for n, v := range esmeta.PeerDependencies {
 			installList = append(installList, fmt.Sprintf("%s@%s", n, v))
 		}
-		err = yarnAdd(buildDir, installList...)
+		err = s.installPackages(ctx, buildDir, installList...)
 		if err != nil {
 			return
 		}
 	}
 
-	if pkg.submodule != "" {
-		packageFile := path.Join(pkgDir, pkg.submodule, "package.json")
+	// the `exports` field takes precedence over the `main` and `module` fields, the entry is
+	// checked whether it's an ES module below
+	entry := ""
+	if exports, ok := readExportsField(pkgDir); ok {
+		subpath := "."
+		if pkg.submodule != "" {
+			subpath = "./" + pkg.submodule
+		}
+		if s, ok := resolveExports(exports, subpath, entryConditions); ok && fileExists(filepath.Join(pkgDir, s)) {
+			entry = s
+			esmeta.Main = s
+			esmeta.Module = s
+		}
+	}
+
+	// the `browser` field swaps the main entry in the browser builds, like esbuild does with the
+	// `browser` main field
+	if entry == "" && pkg.submodule == "" && !isNodeRuntimeTarget(target) {
+		if field, ok := readBrowserField(pkgDir); ok {
+			if s, ok := field.Entry(pkgDir, esmeta.Main); ok {
+				entry = s
+				esmeta.Main = s
+				esmeta.Module = s
+			}
+		}
+	}
+
+	if pkg.submodule != "" && entry == "" {
+		packageFile := filepath.Join(pkgDir, pkg.submodule, "package.json")
 		if fileExists(packageFile) {
 			var p NpmPackage
 			err = utils.ParseJSONFile(packageFile, &p)
@@ -580,7 +1075,7 @@ func initBuild(buildDir string, pkg pkg, install bool) (esmeta *ESMeta, err erro
 				esmeta.Typings = path.Join(pkg.submodule, p.Typings)
 			}
 		} else {
-			exports, esm, e := parseESModuleExports(buildDir, path.Join(esmeta.Name, pkg.submodule))
+			exports, esm, e := s.parseESModuleExports(ctx, buildDir, path.Join(esmeta.Name, pkg.submodule))
 			if e != nil {
 				err = e
 				return
@@ -593,7 +1088,7 @@ func initBuild(buildDir string, pkg pkg, install bool) (esmeta *ESMeta, err erro
 	}
 
 	if esmeta.Module != "" {
-		exports, esm, e := parseESModuleExports(buildDir, path.Join(esmeta.Name, esmeta.Module))
+		exports, esm, e := s.parseESModuleExports(ctx, buildDir, path.Join(esmeta.Name, esmeta.Module))
 		if e != nil {
 			err = e
 			return
@@ -607,9 +1102,19 @@ func initBuild(buildDir string, pkg pkg, install bool) (esmeta *ESMeta, err erro
 	}
 
 	if esmeta.Module == "" {
-		ret, err := parseCJSModuleExports(buildDir, pkg.ImportPath())
-		if err != nil {
-			log.Warn(err)
+		importPath := pkg.ImportPath()
+		if entry != "" {
+			// the files that are not exported can't be resolved by the import path
+			importPath = filepath.Join(pkgDir, entry)
+		}
+		ret, e := s.parseCJSModuleExports(ctx, buildDir, importPath)
+		if e != nil {
+			// the canceled build fails, the other lexer errors only lose the named exports
+			if ctx.Err() != nil {
+				err = e
+				return
+			}
+			s.log.Warn(e)
 		}
 		esmeta.Exports = ret.Exports
 	}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/postui/postdb"
+	"github.com/postui/postdb/q"
+)
+
+// A buildFailure is the error of a failed build, it's cached for the `build-failure-ttl` to
+// avoid re-running the failing build on every request.
+type buildFailure struct {
+	message string
+	expires time.Time
+}
+
+func (e *buildFailure) Error() string {
+	return e.message
+}
+
+func buildFailureKey(id string) string {
+	return "failure:" + id
+}
+
+// isCacheableBuildError reports whether the error of the build is reproducible: the esbuild
+// errors, the failed verifications, the native addons and the rejected installs, except the
+// timeouts. The yarn errors are not cached as they are mostly of the registry and the network.
+func isCacheableBuildError(err error) bool {
+	switch e := err.(type) {
+	case *installError:
+		return !e.timeout
+	case *buildVerifyError, *nativeAddonError:
+		return true
+	case *targetError, *buildFailure, *buildTimeoutError:
+		return false
+	}
+	return strings.HasPrefix(err.Error(), "esbuild: ")
+}
+
+// findBuildFailure returns the cached failure of the build, the expired one is removed.
+func (s *Server) findBuildFailure(id string, now time.Time) (*buildFailure, bool) {
+	if s.config.buildFailureTTL <= 0 {
+		return nil, false
+	}
+	post, err := s.db.Get(q.Alias(buildFailureKey(id)), q.K("error", "expires"))
+	if err != nil {
+		return nil, false
+	}
+	expires, _ := strconv.ParseInt(string(post.KV.Get("expires")), 10, 64)
+	if now.Unix() >= expires {
+		s.db.Delete(q.Alias(buildFailureKey(id)))
+		return nil, false
+	}
+	return &buildFailure{string(post.KV.Get("error")), time.Unix(expires, 0)}, true
+}
+
+// recordBuildFailure caches the error of the build for the `build-failure-ttl`.
+func (s *Server) recordBuildFailure(id string, err error, now time.Time) (*buildFailure, error) {
+	f := &buildFailure{err.Error(), now.Add(s.config.buildFailureTTL)}
+	kv := q.KV{
+		"error":   []byte(f.message),
+		"expires": []byte(strconv.FormatInt(f.expires.Unix(), 10)),
+	}
+	_, e := s.db.Put(q.Alias(buildFailureKey(id)), kv)
+	if e == postdb.ErrDuplicateAlias {
+		e = s.db.Update(q.Alias(buildFailureKey(id)), kv)
+	}
+	return f, e
+}
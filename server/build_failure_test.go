@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBuildFailure(t *testing.T) {
+	s := setupTestEnv(t)
+	s.config.buildFailureTTL = time.Minute
+
+	for _, c := range []struct {
+		err       error
+		cacheable bool
+	}{
+		{errors.New("esbuild: Could not resolve \"fs\""), true},
+		{errors.New("yarn add esm-fixture-esm@1.0.0: error An unexpected error occurred"), false},
+		{&installError{specs: []string{"esm-fixture-esm@1.0.0"}, message: "denied"}, true},
+		{&installError{specs: []string{"esm-fixture-esm@1.0.0"}, message: "timeout", timeout: true}, false},
+		{&targetError{minTarget: "es2020"}, false},
+		{errBuildQueueFull, false},
+		{errors.New("npm: package 'esm-fixture-esm' not found"), false},
+	} {
+		if isCacheableBuildError(c.err) != c.cacheable {
+			t.Fatalf("unexpected cacheable of %v", c.err)
+		}
+	}
+
+	task := &buildTask{Server: s, pkg: pkg{name: "esm-fixture-esm", version: "1.0.0"}, target: "es2020"}
+	now := time.Now()
+	if _, ok := s.findBuildFailure(task.ID(), now); ok {
+		t.Fatal("the failure should not be found")
+	}
+	if _, err := s.recordBuildFailure(task.ID(), errors.New("esbuild: unexpected token"), now); err != nil {
+		t.Fatal(err)
+	}
+	// the failure is overwritten by the later one
+	if _, err := s.recordBuildFailure(task.ID(), errors.New("esbuild: unexpected end of file"), now); err != nil {
+		t.Fatal(err)
+	}
+	f, ok := s.findBuildFailure(task.ID(), now)
+	if !ok || f.Error() != "esbuild: unexpected end of file" || f.expires.Unix() != now.Add(time.Minute).Unix() {
+		t.Fatalf("unexpected failure %v", f)
+	}
+
+	// the cached failure is returned without building
+	_, _, err := task.build()
+	if _, ok := err.(*buildFailure); !ok || err.Error() != "esbuild: unexpected end of file" {
+		t.Fatalf("unexpected build error %v", err)
+	}
+
+	if _, ok := s.findBuildFailure(task.ID(), now.Add(time.Minute)); ok {
+		t.Fatal("the expired failure should not be found")
+	}
+	if _, ok := s.findBuildFailure(task.ID(), now); ok {
+		t.Fatal("the expired failure should be removed")
+	}
+}
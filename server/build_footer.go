@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+var regFooterPlaceholder = regexp.MustCompile(`\{\{\s*([a-zA-Z]+)\s*\}\}`)
+
+// the placeholders of the `build-footer` config
+var footerPlaceholders = map[string]bool{
+	"buildID": true,
+	"package": true,
+	"version": true,
+}
+
+// parseBuildFooter checks the placeholders of the `build-footer` config, like
+// `reportErrors({{buildID}})`.
+func parseBuildFooter(s string) (string, error) {
+	for _, m := range regFooterPlaceholder.FindAllStringSubmatch(s, -1) {
+		if !footerPlaceholders[m[1]] {
+			return "", fmt.Errorf("unknown placeholder '%s' in the build footer, available placeholders: {{buildID}}, {{package}}, {{version}}", m[0])
+		}
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// renderBuildFooter renders the footer of the build file like `/react@17.0.2/es2020/react.js`,
+// the placeholders are replaced with the JS string literals so they are safe in the code.
+func renderBuildFooter(footer string, pathname string) string {
+	values := map[string]string{
+		"buildID": fmt.Sprintf("v%d%s", VERSION, strings.TrimSuffix(pathname, ".js")),
+	}
+	// the polyfills like `/_node_process.js` have no package
+	a := strings.Split(strings.TrimPrefix(pathname, "/"), "/")
+	pkgPath := a[0]
+	if strings.HasPrefix(pkgPath, "@") && len(a) > 1 {
+		pkgPath += "/" + a[1]
+	}
+	if name, version := splitPkgVersion(pkgPath); version != "" {
+		values["package"] = name
+		values["version"] = version
+	}
+	return regFooterPlaceholder.ReplaceAllStringFunc(footer, func(s string) string {
+		name := regFooterPlaceholder.FindStringSubmatch(s)[1]
+		return strings.TrimSpace(string(utils.MustEncodeJSON(values[name])))
+	})
+}
+
+// appendBuildFooter reads the build file and appends the footer, the stored file is not changed.
+func (s *Server) appendBuildFooter(filename string, pathname string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, renderBuildFooter(s.config.buildFooter, pathname)...)
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildFooter(t *testing.T) {
+	_, err := parseBuildFooter("report({{ nonce }})")
+	if err == nil {
+		t.Fatal("the unknown placeholder should be rejected")
+	}
+	footer, err := parseBuildFooter("  if (window.__report) { __report({{buildID}}, {{ package }}, {{version}}) }\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf(`if (window.__report) { __report("v%d/react@17.0.2/es2020/react", "react", "17.0.2") }`, VERSION)
+	if s := renderBuildFooter(footer, "/react@17.0.2/es2020/react.js"); s != expected {
+		t.Fatalf("unexpected footer %s", s)
+	}
+	expected = fmt.Sprintf(`if (window.__report) { __report("v%d/@babel/core@7.13.0/es2020/core", "@babel/core", "7.13.0") }`, VERSION)
+	if s := renderBuildFooter(footer, "/@babel/core@7.13.0/es2020/core.js"); s != expected {
+		t.Fatalf("unexpected footer of the scoped package %s", s)
+	}
+	expected = fmt.Sprintf(`if (window.__report) { __report("v%d/_node_process", "", "") }`, VERSION)
+	if s := renderBuildFooter(footer, "/_node_process.js"); s != expected {
+		t.Fatalf("unexpected footer of the polyfill %s", s)
+	}
+
+	dir, err := ioutil.TempDir("", "esm-footer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "react.js")
+	err = ioutil.WriteFile(filename, []byte("export default 1"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{config: &Config{buildFooter: "/* {{package}}@{{version}} */"}}
+	data, err := s.appendBuildFooter(filename, "/react@17.0.2/es2020/react.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "export default 1\n/* \"react\"@\"17.0.2\" */\n" {
+		t.Fatalf("unexpected build %q", data)
+	}
+	// the stored build is not changed
+	if data, _ := ioutil.ReadFile(filename); string(data) != "export default 1" {
+		t.Fatalf("the stored build is changed: %q", data)
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// the prefix of the build log streams, like `/build-log/v36/react@17.0.2/es2020/react`
+const buildLogPrefix = "/build-log/"
+
+const (
+	// the time to keep the logs of the finished builds
+	buildLogTTL = 10 * time.Minute
+	// the max lines of a build log, the later lines are dropped
+	buildLogMaxLines = 5000
+	// the interval of the keep-alive comments of the log streams
+	buildLogHeartbeat = 15 * time.Second
+)
+
+// A buildLog records the steps of a build: the output of the installs, the esbuild warnings
+// and the progress of the dts copy.
+type buildLog struct {
+	lock    sync.Mutex
+	lines   []string
+	partial []byte
+	done    bool
+	err     error
+	expires time.Time
+	// closed when the log is changed
+	changed chan struct{}
+}
+
+func newBuildLog() *buildLog {
+	return &buildLog{changed: make(chan struct{})}
+}
+
+// Printf appends a line to the log, it's safe to call on a nil log.
+func (l *buildLog) Printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.appendLines(strings.Split(strings.TrimRight(fmt.Sprintf(format, args...), "\n"), "\n")...)
+}
+
+// Write appends the output of the subprocesses to the log by lines.
+func (l *buildLog) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.partial = append(l.partial, p...)
+	if i := bytes.LastIndexByte(l.partial, '\n'); i >= 0 {
+		l.appendLines(strings.Split(string(l.partial[:i]), "\n")...)
+		l.partial = append([]byte{}, l.partial[i+1:]...)
+	}
+	return len(p), nil
+}
+
+func (l *buildLog) appendLines(lines ...string) {
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || len(l.lines) > buildLogMaxLines {
+			continue
+		}
+		if len(l.lines) == buildLogMaxLines {
+			line = fmt.Sprintf("(the log is truncated, the max is %d lines)", buildLogMaxLines)
+		}
+		l.lines = append(l.lines, line)
+	}
+	l.notify()
+}
+
+func (l *buildLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *buildLog) finish(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.partial) > 0 {
+		l.appendLines(string(l.partial))
+		l.partial = nil
+	}
+	l.done = true
+	l.err = err
+	l.expires = time.Now().Add(buildLogTTL)
+	l.notify()
+}
+
+// Wait returns the lines since the index, it waits for the new lines until the timeout if the
+// build is in process.
+func (l *buildLog) Wait(from int, timeout time.Duration) (lines []string, done bool, err error) {
+	l.lock.Lock()
+	if from >= len(l.lines) && !l.done {
+		changed := l.changed
+		l.lock.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(timeout):
+		}
+		l.lock.Lock()
+	}
+	defer l.lock.Unlock()
+
+	if from < len(l.lines) {
+		lines = append(lines, l.lines[from:]...)
+	}
+	return lines, l.done, l.err
+}
+
+// A buildLogRegistry indexes the logs by the build ID and by the working directory of the
+// build, the installs and the dts copy only know the directory.
+type buildLogRegistry struct {
+	lock sync.Mutex
+	logs map[string]*buildLog
+	dirs map[string]*buildLog
+}
+
+var buildLogs = &buildLogRegistry{logs: map[string]*buildLog{}, dirs: map[string]*buildLog{}}
+
+// Start creates the log of the build, it replaces the log of the last build of the ID.
+func (r *buildLogRegistry) Start(id string, wd string) *buildLog {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for key, l := range r.logs {
+		l.lock.Lock()
+		expired := l.done && now.After(l.expires)
+		l.lock.Unlock()
+		if expired {
+			delete(r.logs, key)
+		}
+	}
+	l := newBuildLog()
+	r.logs[id] = l
+	r.dirs[wd] = l
+	return l
+}
+
+// Finish marks the log of the build done.
+func (r *buildLogRegistry) Finish(wd string, err error) {
+	r.lock.Lock()
+	l, ok := r.dirs[wd]
+	delete(r.dirs, wd)
+	r.lock.Unlock()
+
+	if ok {
+		l.finish(err)
+	}
+}
+
+// Get returns the log of the build ID.
+func (r *buildLogRegistry) Get(id string) *buildLog {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.logs[id]
+}
+
+// Dir returns the log of the build in process in the directory, or nil.
+func (r *buildLogRegistry) Dir(wd string) *buildLog {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.dirs[wd]
+}
+
+// logf appends a line to the log of the build.
+func (task *buildTask) logf(format string, args ...interface{}) {
+	buildLogs.Dir(task.wd).Printf(format, args...)
+}
+
+// formatESBuildMessage formats the esbuild message with the location like `index.js:3:10: text`.
+func formatESBuildMessage(m api.Message) string {
+	if m.Location == nil {
+		return m.Text
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", m.Location.File, m.Location.Line, m.Location.Column, m.Text)
+}
+
+// writeBuildLogEvents writes the lines as the server-sent events, the event id is the index of
+// the line to resume the stream by the `Last-Event-ID` header.
+func writeBuildLogEvents(w io.Writer, lines []string, from int, done bool, err error) {
+	for i, line := range lines {
+		fmt.Fprintf(w, "id: %d\ndata: %s\n\n", from+i, line)
+	}
+	if done {
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strings.TrimSpace(string(utils.MustEncodeJSON(map[string]string{"error": err.Error()}))))
+		} else {
+			fmt.Fprint(w, "event: done\ndata: {}\n\n")
+		}
+	}
+}
+
+// streamBuildLog handles the `/build-log/{buildID}` requests, the log of the build is streamed
+// as the server-sent events until the build is done.
+func streamBuildLog(ctx *rex.Context, pathname string) interface{} {
+	id := strings.TrimSuffix(strings.TrimPrefix(pathname, buildLogPrefix), ".js")
+	l := buildLogs.Get(id)
+	if l == nil {
+		return rex.Status(404, "build log not found")
+	}
+	from := 0
+	if v := ctx.R.Header.Get("Last-Event-ID"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil && i >= 0 {
+			from = i + 1
+		}
+	}
+
+	// the rex response writer can't be flushed, take over the connection to stream the events
+	var conn io.Closer
+	var rw *bufio.ReadWriter
+	var err error
+	if hj, ok := ctx.W.(http.Hijacker); ok {
+		conn, rw, err = hj.Hijack()
+	}
+	if conn == nil || err != nil {
+		// e.g. http/2, respond the current log and let the client reconnect
+		buf := bytes.NewBufferString("retry: 1000\n\n")
+		lines, done, err := l.Wait(from, 0)
+		writeBuildLogEvents(buf, lines, from, done, err)
+		ctx.SetHeader("Cache-Control", "private, no-store")
+		ctx.SetHeader("Content-Type", "text/event-stream; charset=utf-8")
+		return buf.String()
+	}
+	defer conn.Close()
+
+	fmt.Fprint(rw, "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream; charset=utf-8\r\nCache-Control: private, no-store\r\nAccess-Control-Allow-Origin: *\r\nConnection: close\r\n\r\n")
+	for {
+		lines, done, err := l.Wait(from, buildLogHeartbeat)
+		if len(lines) == 0 && !done {
+			fmt.Fprint(rw, ": keep-alive\n\n")
+		}
+		writeBuildLogEvents(rw, lines, from, done, err)
+		// stop when the client is gone
+		if rw.Flush() != nil || done {
+			break
+		}
+		from += len(lines)
+	}
+	// the response is written to the connection, an empty body doesn't touch the hijacked
+	// response writer
+	return []byte{}
+}
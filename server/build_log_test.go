@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+func TestBuildLog(t *testing.T) {
+	l := newBuildLog()
+	l.Printf("$ yarn add %s", "react@17.0.2")
+	l.Write([]byte("info No lockfile found.\nwarning react"))
+	l.Write([]byte("@17.0.2: deprecated\r\n\n"))
+	lines, done, _ := l.Wait(0, 0)
+	if strings.Join(lines, "|") != "$ yarn add react@17.0.2|info No lockfile found.|warning react@17.0.2: deprecated" || done {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.Printf("esbuild warning: %s", "index.js:1:0: unused")
+	}()
+	lines, _, _ = l.Wait(3, time.Second)
+	if len(lines) != 1 || lines[0] != "esbuild warning: index.js:1:0: unused" {
+		t.Fatalf("the new lines should be waited, got %q", lines)
+	}
+
+	l.Write([]byte("no newline"))
+	l.finish(errors.New("esbuild: unexpected token"))
+	lines, done, err := l.Wait(4, time.Second)
+	if len(lines) != 1 || lines[0] != "no newline" || !done || err == nil {
+		t.Fatalf("unexpected log end %q, %v, %v", lines, done, err)
+	}
+
+	var nilLog *buildLog
+	nilLog.Printf("the nil log should be ignored")
+}
+
+func TestStreamBuildLog(t *testing.T) {
+	id := "v36/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm"
+	wd := t.TempDir()
+	l := buildLogs.Start(id, wd)
+	l.Printf("build %s", id)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := &rex.Context{W: w, R: r, Form: &rex.Form{R: r}}
+		if ret, ok := streamBuildLog(ctx, r.URL.Path).(string); ok {
+			w.Write([]byte(ret))
+		}
+	}))
+	defer ts.Close()
+
+	unknown := httptest.NewRequest("GET", ts.URL+buildLogPrefix+"v36/unknown@1.0.0/es2020/unknown", nil)
+	switch streamBuildLog(&rex.Context{W: httptest.NewRecorder(), R: unknown, Form: &rex.Form{R: unknown}}, unknown.URL.Path).(type) {
+	case string, []byte:
+		t.Fatal("the unknown build log should not be streamed")
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+buildLogPrefix+id, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		buildLogs.Dir(wd).Printf("dts: esm-fixture-esm@1.0.0/index.d.ts copied")
+		buildLogs.Finish(wd, nil)
+	}()
+	events := []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			events = append(events, line)
+		}
+	}
+	expected := []string{
+		"id: 0", "data: build " + id,
+		"id: 1", "data: dts: esm-fixture-esm@1.0.0/index.d.ts copied",
+		"event: done", "data: {}",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected events %q", events)
+	}
+
+	// resume by the `Last-Event-ID` header
+	req.Header.Set("Last-Event-ID", "0")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner = bufio.NewScanner(resp.Body)
+	scanner.Scan()
+	if line := scanner.Text(); line != "id: 1" {
+		t.Fatalf("the stream should be resumed after the last event, got %q", line)
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSubmoduleURL(t *testing.T) {
+	wd := filepath.Join("testdata", "wd")
+	task := &buildTask{
+		pkg:       pkg{name: "a", version: "1.0.0"},
+		wd:        wd,
+		alias:     importAlias{"react": "preact/compat"},
+		exports:   []string{"load"},
+		bundle:    true,
+		sourcemap: "external",
+		target:    "es2020",
+		isDev:     true,
+	}
+	url, ok := task.submoduleURL(filepath.Join(wd, "node_modules", "a", "lib", "chunk.js"))
+	// the submodule is built with the options of the entry except the exports
+	if expected := fmt.Sprintf("/v%d/a@1.0.0/alias=react:preact_compat/bundle/sourcemap/es2020/lib/chunk.development.js", VERSION); !ok || url != expected {
+		t.Fatalf("unexpected submodule url '%s', should be '%s'", url, expected)
+	}
+
+	if _, ok := task.submoduleURL(filepath.Join(wd, "src", "chunk.js")); ok {
+		t.Fatal("the files out of the node_modules are not submodules")
+	}
+}
+
+func TestCJSExportsQuery(t *testing.T) {
+	_, s := newTestServer(t)
+	handler := s.Handler()
+
+	// the `all` mode of the build paths is ignored if the eval is disabled
+	allID := fmt.Sprintf("v%d/esm-fixture-cjs@1.0.0/cjs-exports=all/es2020/esm-fixture-cjs", VERSION)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+allID+".js", nil))
+	if _, _, ok := s.findESM(allID); ok {
+		t.Fatal("the disabled mode should not be built")
+	}
+
+	for _, c := range []struct {
+		query    string
+		eval     bool
+		segment  string
+		errorMsg string
+	}{
+		{"", false, "/es2020/", ""},
+		{"cjs-exports=strict&", false, "/cjs-exports=strict/es2020/", ""},
+		{"cjs-exports=all&", true, "/cjs-exports=all/es2020/", ""},
+		{"cjs-exports=all&", false, "", "the cjs-exports mode 'all' is disabled by the server"},
+		{"cjs-exports=none&", true, "", "invalid cjs-exports mode 'none'"},
+	} {
+		s.config.evalCJSExports = c.eval
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/esm-fixture-cjs@1.0.0?"+c.query+"target=es2020", nil))
+		body := rec.Body.String()
+		if c.errorMsg != "" {
+			if !strings.Contains(body, c.errorMsg) {
+				t.Fatalf("'%s' should be rejected by '%s':\n%s", c.query, c.errorMsg, body)
+			}
+			continue
+		}
+		// the default `auto` mode is not in the build ID
+		id := fmt.Sprintf("/v%d/esm-fixture-cjs@1.0.0%sesm-fixture-cjs.js", VERSION, c.segment)
+		if rec.Code != 200 || !strings.Contains(body, id) {
+			t.Fatalf("the build of '%s' should be %s:\n%s", c.query, id, body)
+		}
+	}
+
+}
+
+func TestCJSExportsModes(t *testing.T) {
+	s := setupTestEnv(t)
+	s.config.evalCJSExports = true
+	_, lexerErr := exec.LookPath("yarn")
+
+	for _, c := range []struct {
+		mode    string
+		exports []string
+	}{
+		// the lexer exports, the cjs-module-lexer is installed by yarn
+		{"auto", []string{"cjs", "env", "text"}},
+		{"strict", nil},
+		// the keys of the evaluated module.exports and the lexer exports
+		{"all", []string{"cjs", "env", "text"}},
+	} {
+		task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-cjs@1.0.0"), cjsExports: c.mode, target: "es2020"}
+		esm, _, err := task.buildESM(context.Background())
+		if err != nil {
+			t.Fatalf("build %s: %v", task.ID(), err)
+		}
+		if esm.CJSExports != c.mode {
+			t.Fatalf("build %s: the mode should be recorded, got '%s'", task.ID(), esm.CJSExports)
+		}
+		if c.mode == "auto" && lexerErr != nil {
+			continue
+		}
+		sort.Strings(esm.Exports)
+		if strings.Join(esm.Exports, ",") != strings.Join(c.exports, ",") {
+			t.Fatalf("build %s: unexpected exports %v", task.ID(), esm.Exports)
+		}
+		code := readBuild(t, s, task.ID()+".js")
+		for _, name := range c.exports {
+			if !regexp.MustCompile(`\b` + name + `\b`).MatchString(code) {
+				t.Fatalf("build %s: missing the named export %s:\n%s", task.ID(), name, code)
+			}
+		}
+	}
+}
+
+func TestSplitBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-split@1.0.0"), cjsExports: "auto", target: "es2020", split: true}
+	if !strings.Contains(task.ID(), "/split/es2020/") {
+		t.Fatalf("the split mode should be in the build ID: %s", task.ID())
+	}
+	code := buildFixture(t, task)
+	if strings.Contains(code, "shared-module") {
+		t.Fatalf("the lazily imported parts should not be bundled in the entry:\n%s", code)
+	}
+
+	// the two lazily imported parts share the chunk of the shared module, the chunks are
+	// stored under the directory of the build
+	dir := path.Dir(task.ID())
+	parts := regexp.MustCompile(`import\("\./([\w-]+\.js)"\)`).FindAllStringSubmatch(code, -1)
+	if len(parts) != 2 {
+		t.Fatalf("the entry should import two parts lazily:\n%s", code)
+	}
+	chunks := map[string]int{}
+	for _, part := range parts {
+		partCode := readBuild(t, s, path.Join(dir, part[1]))
+		for _, m := range regexp.MustCompile(`from"\./(chunk-[\w-]+\.js)"`).FindAllStringSubmatch(partCode, -1) {
+			if strings.Contains(readBuild(t, s, path.Join(dir, m[1])), "shared-module") {
+				chunks[m[1]]++
+			}
+		}
+	}
+	if len(chunks) != 1 {
+		t.Fatalf("the shared module should be emitted as one chunk, got %v", chunks)
+	}
+	for chunk, n := range chunks {
+		if n != 2 {
+			t.Fatalf("the chunk %s should be imported by both parts, got %d", chunk, n)
+		}
+	}
+}
+
+func TestDynamicImportBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s,
+		pkg:        fixturePkg(t, s, "esm-fixture-dynamic@1.0.0"),
+		deps:       pkgSlice{fixturePkg(t, s, "esm-fixture-dep@1.0.0")},
+		cjsExports: "auto",
+		target:     "es2017",
+		isDev:      true,
+	}
+	code := buildFixture(t, task)
+	// the dynamically imported file is built on demand with the options of the entry
+	url := fmt.Sprintf("/v%d/esm-fixture-dynamic@1.0.0/deps=esm-fixture-dep@1.0.0/es2017/locale/en.mjs.development.js", VERSION)
+	if !strings.Contains(code, `import("`+url+`")`) || strings.Contains(code, `"en:"`) {
+		t.Fatalf("the dynamic import should be rewritten to %s:\n%s", url, code)
+	}
+}
+
+func TestImportMetaURLBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-metaurl@1.0.0"), cjsExports: "auto", target: "es2020"}
+	code := buildFixture(t, task)
+	// the `import.meta.url` of the bundled file is the raw URL of the file
+	fileURL := fmt.Sprintf(`"%s/esm-fixture-metaurl@1.0.0/lib/util.mjs"`, task.assetOrigin())
+	if !strings.Contains(code, fileURL) || strings.Contains(code, "import.meta.url") {
+		t.Fatalf("the import.meta.url should be rewritten to %s:\n%s", fileURL, code)
+	}
+	// the asset referenced by `new URL("../icon.svg", import.meta.url)` is copied into the storage
+	assetURL := fmt.Sprintf(`new URL("%s/v%d/esm-fixture-metaurl@1.0.0/_assets/icon.svg")`, task.assetOrigin(), VERSION)
+	if !strings.Contains(code, assetURL) {
+		t.Fatalf("the asset URL should be rewritten to %s:\n%s", assetURL, code)
+	}
+	if svg := readBuild(t, s, fmt.Sprintf("v%d/esm-fixture-metaurl@1.0.0/_assets/icon.svg", VERSION)); !strings.HasPrefix(svg, "<svg") {
+		t.Fatalf("unexpected asset content: %s", svg)
+	}
+}
+
+func TestTypesSource(t *testing.T) {
+	_, s := newTestServer(t)
+	handler := s.Handler()
+
+	for _, c := range []struct {
+		pkg         string
+		types       string
+		typesSource string
+	}{
+		// the types of DefinitelyTyped
+		{"esm-fixture-untyped@1.0.0", "/v%d/@types/esm-fixture-untyped@1.2.3/index.d.ts", "@types/esm-fixture-untyped@1.2.3"},
+		// the types shipped by the package
+		{"esm-fixture-exports@1.0.0", "/v%d/esm-fixture-exports@1.0.0/types/index.d.ts", ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/"+c.pkg+"?target=es2020", nil))
+		if rec.Code != 200 {
+			t.Fatalf("build %s: unexpected status %d:\n%s", c.pkg, rec.Code, rec.Body.String())
+		}
+		if types := rec.Header().Get("X-TypeScript-Types"); !strings.HasSuffix(types, fmt.Sprintf(c.types, VERSION)) {
+			t.Fatalf("build %s: unexpected types '%s'", c.pkg, types)
+		}
+		if source := rec.Header().Get("X-Esm-Types-Source"); source != c.typesSource {
+			t.Fatalf("build %s: unexpected types source '%s', should be '%s'", c.pkg, source, c.typesSource)
+		}
+		name := strings.Split(c.pkg, "@")[0]
+		esm, _, ok := s.findESM(fmt.Sprintf("v%d/%s/es2020/%s", VERSION, c.pkg, name))
+		if !ok || esm.TypesSource != c.typesSource {
+			t.Fatalf("build %s: the types source should be recorded in the meta", c.pkg)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// A buildTimeoutError is the error of a build that exceeds the `build-timeout` config, the
+// subprocesses of the build are killed.
+type buildTimeoutError struct {
+	id      string
+	timeout time.Duration
+}
+
+func (e *buildTimeoutError) Error() string {
+	return fmt.Sprintf("build %s: exceeds the limit(%v) of the 'build-timeout' config", e.id, e.timeout)
+}
+
+// buildContext returns the context of a build with the deadline of the `build-timeout` config.
+func (s *Server) buildContext() (context.Context, context.CancelFunc) {
+	if s.config.buildTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.config.buildTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// runESBuild runs the esbuild in a goroutine since it can't be canceled, the result is dropped
+// if the context is done first. Note the abandoned esbuild keeps running until it finishes after
+// the build slot is released, so the timeout doesn't bound the CPU usage of esbuild.
+func runESBuild(ctx context.Context, options api.BuildOptions) (result api.BuildResult, err error) {
+	ch := make(chan api.BuildResult, 1)
+	go func() {
+		ch <- api.Build(options)
+	}()
+	select {
+	case result = <-ch:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// build builds the task with the deadline of the `build-timeout` config, the failures are cached
+// by the `build-failure-ttl` config, except the scratch builds. The concurrent requests of the
+// same build are merged by the build queue, which is the only caller.
+func (task *buildTask) build() (esm *ESMeta, pkgCSS bool, err error) {
+	cacheFailure := !task.scratch && task.config.buildFailureTTL > 0
+	if cacheFailure {
+		if f, ok := task.findBuildFailure(task.ID(), time.Now()); ok {
+			return nil, false, f
+		}
+	}
+
+	ctx, cancel := task.buildContext()
+	defer cancel()
+	start := time.Now()
+	esm, pkgCSS, err = task.buildESM(ctx)
+	if err != nil {
+		recordRecentFailure(task.ID(), err, start, time.Now())
+		if cacheFailure && isCacheableBuildError(err) {
+			f, e := task.recordBuildFailure(task.ID(), err, time.Now())
+			if e != nil {
+				task.log.Warnf("record the failure of %s: %v", task.ID(), e)
+				return nil, false, err
+			}
+			return nil, false, f
+		}
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBuildTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake yarn is a shell script")
+	}
+	s := setupTestEnv(t)
+	s.config.buildTimeout = 200 * time.Millisecond
+	s.config.installer = "yarn"
+
+	// a hung yarn
+	binDir, err := ioutil.TempDir("", "esm-fake-yarn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(binDir)
+	err = ioutil.WriteFile(filepath.Join(binDir, "yarn"), []byte("#!/bin/sh\nsleep 10\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	task := &buildTask{Server: s, pkg: pkg{name: "esm-fixture-esm", version: "1.0.0"}, target: "es2020"}
+	ctx, cancel := s.buildContext()
+	defer cancel()
+	start := time.Now()
+	_, _, err = task.buildESM(ctx)
+	if _, ok := err.(*buildTimeoutError); !ok {
+		t.Fatalf("expected the timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("the hung yarn is not killed, the build takes %v", d)
+	}
+	if isCacheableBuildError(err) {
+		t.Fatal("the timeout error should not be cached")
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// the query params that have no value, like `?dev`
+var queryFlags = map[string]bool{
+	"async":      true,
+	"bundle":     true,
+	"css":        true,
+	"dev":        true,
+	"keep-names": true,
+	"meta":       true,
+	"module":     true,
+	"no-check":   true,
+	"raw":        true,
+	"report":     true,
+	"split":      true,
+	"standalone": true,
+	"worker":     true,
+}
+
+// the query params that are the comma separated lists, the order of the items doesn't matter
+var queryLists = map[string]bool{
+	"alias":    true,
+	"define":   true,
+	"deps":     true,
+	"drop":     true,
+	"env":      true,
+	"exports":  true,
+	"external": true,
+}
+
+// canonicalQuery returns the canonical form of the query of the module requests, so the
+// equivalent URLs like `?dev&target=es2020` and `?Target=ES2020&dev=` share a single CDN
+// cache entry: the known params are lowercased and sorted, the lists are sorted, and the
+// empty values and the default values are removed. the unknown params are kept as they are.
+func (s *Server) canonicalQuery(query url.Values) string {
+	// the keys are sorted so the exact lowercase key wins over the case variants, like
+	// `?Target=es2015&target=es2020`
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := map[string]string{}
+	for _, key := range keys {
+		raw := query.Get(key)
+		name := strings.ToLower(strings.TrimSpace(key))
+		value := strings.TrimSpace(raw)
+		known := true
+		switch {
+		case queryFlags[name]:
+			value = ""
+		case name == "target":
+			value = strings.ToLower(value)
+		case name == "legal-comments":
+			value = strings.ToLower(value)
+			if s.config != nil && value == s.config.legalComments {
+				value = ""
+			}
+		case name == "cjs-exports":
+			value = strings.ToLower(value)
+			if value == "auto" {
+				value = ""
+			}
+		case name == "sourcemap":
+			// `?sourcemap` means the external source map
+			if strings.ToLower(value) == "inline" {
+				value = "inline"
+			} else {
+				value = ""
+			}
+		case name == "fallback":
+			fallback := value != "0" && value != "false"
+			if s.config != nil && fallback == s.config.targetFallback {
+				delete(params, name)
+				continue
+			}
+			value = ""
+			if !fallback {
+				value = "0"
+			}
+		case queryLists[name]:
+			value = canonicalList(name, value)
+		default:
+			// keep the unknown params as they are
+			name, value = key, raw
+			known = false
+		}
+		// the empty values of the params are the defaults
+		if known && value == "" && !queryFlags[name] && name != "sourcemap" && name != "fallback" {
+			delete(params, name)
+			continue
+		}
+		params[name] = value
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := strings.Builder{}
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(escapeQueryValue(name))
+		if value := params[name]; value != "" {
+			buf.WriteByte('=')
+			buf.WriteString(escapeQueryValue(value))
+		}
+	}
+	return buf.String()
+}
+
+// canonicalList sorts the items of the list param and removes the duplicates, the first
+// pinned version of a package in the `deps` wins, and the last alias of a package(or the last
+// value of a define) wins.
+func canonicalList(name string, s string) string {
+	items := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key := item
+		switch name {
+		case "deps":
+			key, _ = splitPkgVersion(item)
+			if _, ok := items[key]; ok {
+				continue
+			}
+		case "alias", "define", "env":
+			from, to := item, ""
+			if i := strings.IndexByte(item, ':'); i >= 0 {
+				from, to = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+			}
+			key = from
+			item = from + ":" + to
+		}
+		items[key] = item
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		list = append(list, item)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// escapeQueryValue escapes the query value but keeps the characters that are common in the
+// package specifiers, like `react@18,@emotion/react`.
+func escapeQueryValue(s string) string {
+	return strings.NewReplacer("%2C", ",", "%2F", "/", "%3A", ":", "%40", "@").Replace(url.QueryEscape(s))
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalQuery(t *testing.T) {
+	s := &Server{config: &Config{targetFallback: true, legalComments: "eof"}}
+
+	for _, c := range []struct {
+		query     string
+		canonical string
+	}{
+		{"", ""},
+		{"dev&target=es2020", "dev&target=es2020"},
+		{"target=es2020&dev", "dev&target=es2020"},
+		{"Target=ES2020&dev=1&BUNDLE", "bundle&dev&target=es2020"},
+		{"target=&cjs-exports=auto&deps=&fallback=1", ""},
+		{"fallback=false&cjs-exports=Strict", "cjs-exports=strict&fallback=0"},
+		{"sourcemap=external", "sourcemap"},
+		{"sourcemap=INLINE", "sourcemap=inline"},
+		{"deps=react@17, preact@10,react@18", "deps=preact@10,react@17"},
+		{"external=react-dom,react&exports=useState,default", "exports=default,useState&external=react,react-dom"},
+		{"alias=react:preact/compat, react-dom : preact/compat", "alias=react-dom:preact/compat,react:preact/compat"},
+		{"env=B:2,A:1,B:3&define=__DEV__:false", "define=__DEV__:false&env=A:1,B:3"},
+		{"drop=debugger, console", "drop=console,debugger"},
+		{"keep-names=1&dev=true", "dev&keep-names"},
+		{"legal-comments=eof", ""},
+		{"legal-comments=Linked", "legal-comments=linked"},
+		{"Target=es2015&target=es2020", "target=es2020"},
+		{"v=1&Token=a%20b", "Token=a+b&v=1"},
+	} {
+		query, err := url.ParseQuery(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		canonical := s.canonicalQuery(query)
+		if canonical != c.canonical {
+			t.Fatalf("canonical query of '%s': expected '%s', got '%s'", c.query, c.canonical, canonical)
+		}
+		// the canonical query is stable, or the requests are redirected forever
+		query, _ = url.ParseQuery(canonical)
+		if s.canonicalQuery(query) != canonical {
+			t.Fatalf("canonical query '%s' is not stable", canonical)
+		}
+	}
+
+	s.config.targetFallback = false
+	query, _ := url.ParseQuery("fallback=0")
+	if s.canonicalQuery(query) != "" {
+		t.Fatal("the default value of the fallback should be removed")
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ije/rex"
+	"github.com/postui/postdb/q"
+)
+
+const (
+	catalogDefaultLimit = 1000
+	catalogMaxLimit     = 10000
+)
+
+// A CatalogItem specifies a cached build of the catalog.
+type CatalogItem struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+	Target  string `json:"target"`
+	Dev     bool   `json:"dev,omitempty"`
+	Path    string `json:"path"`
+}
+
+// parseCatalogItem parses the build ID like `v36/@scope/name@1.0.0/deps=.../es2020/name.development`.
+func parseCatalogItem(id string) (item CatalogItem, ok bool) {
+	a := strings.Split(id, "/")
+	if len(a) < 4 || a[0] != fmt.Sprintf("v%d", VERSION) {
+		return
+	}
+	nameAndVersion := a[1]
+	if strings.HasPrefix(nameAndVersion, "@") {
+		if len(a) < 5 {
+			return
+		}
+		nameAndVersion = a[1] + "/" + a[2]
+	}
+	i := strings.LastIndex(nameAndVersion, "@")
+	if i <= 0 {
+		return
+	}
+	item.Package = nameAndVersion[:i]
+	item.Version = nameAndVersion[i+1:]
+	item.Target = a[len(a)-2]
+	item.Dev = strings.HasSuffix(a[len(a)-1], ".development")
+	item.Path = "/" + id + ".js"
+	return item, true
+}
+
+// listCatalog returns the sorted IDs of the builds that are cached on this instance.
+func (s *Server) listCatalog() (ids []string, err error) {
+	prefix := fmt.Sprintf("v%d/", VERSION)
+	posts, err := s.db.List(q.Filter(func(p q.Post) bool {
+		return strings.HasPrefix(p.Alias, prefix)
+	}))
+	if err != nil {
+		return
+	}
+	ids = make([]string, len(posts))
+	for i, p := range posts {
+		ids[i] = p.Alias
+	}
+	sort.Strings(ids)
+	return
+}
+
+// catalog handles the `/-/catalog?format=json|txt&page=1&limit=1000` requests.
+func (s *Server) catalog(ctx *rex.Context) interface{} {
+	format := ctx.Form.Value("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "txt" {
+		return rex.Status(400, fmt.Sprintf("invalid format '%s'", format))
+	}
+	page, err := strconv.Atoi(ctx.Form.Value("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(ctx.Form.Value("limit"))
+	if err != nil || limit < 1 {
+		limit = catalogDefaultLimit
+	}
+	if limit > catalogMaxLimit {
+		limit = catalogMaxLimit
+	}
+
+	ids, err := s.listCatalog()
+	if err != nil {
+		return err
+	}
+	items := []CatalogItem{}
+	for _, id := range ids {
+		if item, ok := parseCatalogItem(id); ok {
+			items = append(items, item)
+		}
+	}
+	total := len(items)
+	start := (page - 1) * limit
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+	items = items[start:end]
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	if format == "txt" {
+		buf := bytes.NewBuffer(nil)
+		for _, item := range items {
+			buf.WriteString(item.Path)
+			buf.WriteByte('\n')
+		}
+		ctx.SetHeader("X-Total-Count", strconv.Itoa(total))
+		return buf.String()
+	}
+	return map[string]interface{}{
+		"total": total,
+		"page":  page,
+		"limit": limit,
+		"items": items,
+	}
+}
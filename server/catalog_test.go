@@ -0,0 +1,23 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseCatalogItem(t *testing.T) {
+	for id, expected := range map[string]CatalogItem{
+		"v%d/react@17.0.2/es2020/react":                                  {"react", "17.0.2", "es2020", false, "/v%d/react@17.0.2/es2020/react.js"},
+		"v%d/@babel/core@7.14.0/deps=react@17.0.2/deno/core.development": {"@babel/core", "7.14.0", "deno", true, "/v%d/@babel/core@7.14.0/deps=react@17.0.2/deno/core.development.js"},
+	} {
+		id = fmt.Sprintf(id, VERSION)
+		expected.Path = fmt.Sprintf(expected.Path, VERSION)
+		item, ok := parseCatalogItem(id)
+		if !ok || item != expected {
+			t.Fatalf("parseCatalogItem(%s): unexpected %v", id, item)
+		}
+	}
+	if _, ok := parseCatalogItem("v1/react@17.0.2/es2020/react"); ok {
+		t.Fatal("the builds of other versions should be ignored")
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the faults that can be injected by the `chaos` config
+var chaosFaults = map[string]string{
+	"registry":     "the npm registry responds 500",
+	"slow-install": "yarn installs slowly",
+	"esbuild":      "esbuild fails",
+	"disk-full":    "writing build artifacts fails with ENOSPC",
+}
+
+// parseChaosConfig parses the `chaos` config like `registry=0.1,esbuild=0.05`, the value
+// is the rate of the fault in the range [0, 1].
+func parseChaosConfig(s string) (rates map[string]float64, err error) {
+	rates = map[string]float64{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		a := strings.SplitN(part, "=", 2)
+		if _, ok := chaosFaults[a[0]]; !ok {
+			names := make([]string, 0, len(chaosFaults))
+			for name := range chaosFaults {
+				names = append(names, name)
+			}
+			return nil, fmt.Errorf("unknown chaos fault '%s', available faults: %s", a[0], strings.Join(names, ", "))
+		}
+		rate := 1.0
+		if len(a) == 2 {
+			rate, err = strconv.ParseFloat(a[1], 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid chaos rate '%s'", part)
+			}
+		}
+		rates[a[0]] = rate
+	}
+	return
+}
+
+// injectFault returns an error if the fault is triggered in the chaos mode.
+func (s *Server) injectFault(fault string) error {
+	if s.config == nil || len(s.config.chaos) == 0 {
+		return nil
+	}
+	rate := s.config.chaos[fault]
+	if rate <= 0 || rand.Float64() >= rate {
+		return nil
+	}
+	s.log.Warnf("chaos: inject fault '%s'", fault)
+	switch fault {
+	case "registry":
+		return fmt.Errorf("500 Internal Server Error (chaos)")
+	case "slow-install":
+		time.Sleep(s.config.chaosDelay)
+		return nil
+	case "disk-full":
+		return fmt.Errorf("chaos: %w", syscall.ENOSPC)
+	default:
+		return fmt.Errorf("chaos: %s", chaosFaults[fault])
+	}
+}
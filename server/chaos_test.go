@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	logx "github.com/ije/gox/log"
+)
+
+func TestParseChaosConfig(t *testing.T) {
+	rates, err := parseChaosConfig("registry=0.1, esbuild")
+	if err != nil || rates["registry"] != 0.1 || rates["esbuild"] != 1 {
+		t.Fatalf("unexpected rates %v: %v", rates, err)
+	}
+	for _, s := range []string{"network=0.1", "registry=2", "registry=x"} {
+		if _, err := parseChaosConfig(s); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	s := &Server{config: &Config{chaos: map[string]float64{"disk-full": 1}}, log: &logx.Logger{}}
+
+	err := s.writeStorageFile("/dev/null/esm")
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("should be disk full: %v", err)
+	}
+	if s.injectFault("esbuild") != nil {
+		t.Fatal("the esbuild fault is not enabled")
+	}
+}
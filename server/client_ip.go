@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies parses the `trusted-proxies` config, the IPs or the CIDRs separated by
+// commas, like '10.0.0.0/8,192.168.1.1'.
+func parseTrustedProxies(s string) (proxies []*net.IPNet, err error) {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			if ip := net.ParseIP(part); ip != nil && ip.To4() != nil {
+				part += "/32"
+			} else {
+				part += "/128"
+			}
+		}
+		_, ipnet, e := net.ParseCIDR(part)
+		if e != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s'", part)
+		}
+		proxies = append(proxies, ipnet)
+	}
+	return
+}
+
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range s.config.trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client that sends the request. The `X-Forwarded-For` and
+// `X-Real-IP` headers are only honored when the peer is a trusted proxy, the forwarded IPs are
+// checked from the nearest one, the first IP that is not a trusted proxy is the client.
+func (s *Server) clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !s.isTrustedProxy(net.ParseIP(peer)) {
+		return peer
+	}
+
+	var forwarded []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				forwarded = append(forwarded, ip)
+			}
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwarded[i])
+		if ip == nil {
+			// the invalid IP is added by the client
+			return peer
+		}
+		if !s.isTrustedProxy(ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Fatal("'10.0.0.0/33' should be invalid")
+	}
+	s := &Server{config: &Config{trustedProxies: proxies}}
+
+	for _, c := range []struct {
+		peer      string
+		forwarded string
+		realIP    string
+		ip        string
+	}{
+		{"1.1.1.1:80", "", "", "1.1.1.1"},
+		// the headers of the untrusted peers are ignored
+		{"1.1.1.1:80", "2.2.2.2", "3.3.3.3", "1.1.1.1"},
+		{"10.0.0.1:80", "2.2.2.2", "", "2.2.2.2"},
+		{"10.0.0.1:80", "", "3.3.3.3", "3.3.3.3"},
+		// the spoofed IPs before the nearest untrusted one are ignored
+		{"10.0.0.1:80", "4.4.4.4, 2.2.2.2, 192.168.1.1", "", "2.2.2.2"},
+		{"10.0.0.1:80", "10.0.0.2, 10.0.0.3", "", "10.0.0.2"},
+		{"10.0.0.1:80", "2.2.2.2, oops", "", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.peer
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if ip := s.clientIP(r); ip != c.ip {
+			t.Fatalf("%s(%s, %s): expect %s, got %s", c.peer, c.forwarded, c.realIP, c.ip, ip)
+		}
+	}
+}
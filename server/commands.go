@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"esm.sh/client"
+)
+
+// newCommandClient parses the common flags of the subcommands that call a running server.
+func newCommandClient(name string, args []string) (*client.Client, []string) {
+	var server string
+	var adminToken string
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&server, "server", "http://localhost", "the origin of the esm.sh server")
+	fs.StringVar(&adminToken, "admin-token", os.Getenv("ESMD_ADMIN_TOKEN"), "the bearer token of the admin APIs, defaults to the ESMD_ADMIN_TOKEN env")
+	fs.Parse(args)
+
+	c := client.New(server)
+	c.Token = adminToken
+	return c, fs.Args()
+}
+
+// Status prints the status of a running server, it's the `esmd status` command.
+func Status(args []string) {
+	c, _ := newCommandClient("status", args)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	status, err := c.BuildStatus(ctx)
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("version: v%d\nuptime: %s\n", status.Version, status.Uptime)
+	fmt.Printf("queue: %d queued, %d processing", status.Queue.Queued, status.Queue.Processing)
+	if status.Queue.Throttled {
+		fmt.Print(", throttled")
+	}
+	fmt.Println()
+}
+
+// Purge removes the builds of the package versions on a running server, it's the
+// `esmd purge react@17.0.2` command.
+func Purge(args []string) {
+	c, pkgs := newCommandClient("purge", args)
+	if len(pkgs) == 0 {
+		fmt.Println("usage: esmd purge [-server URL] [-admin-token TOKEN] <pkg@version>...")
+		os.Exit(2)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	ok := true
+	for _, pkg := range pkgs {
+		n, err := c.Purge(ctx, pkg)
+		if err != nil {
+			ok = false
+			fmt.Printf("✗ purge %s: %v\n", pkg, err)
+			continue
+		}
+		fmt.Printf("✓ %s: %d builds purged\n", pkg, n)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
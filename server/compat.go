@@ -18,6 +18,17 @@ var targets = map[string]api.Target{
 	"es2018": api.ES2018,
 	"es2019": api.ES2019,
 	"es2020": api.ES2020,
+	// esbuild(0.11) doesn't know the ES2021+ features yet: the es2021 builds are lowered like
+	// es2020, and nothing in es2022 needs to be lowered
+	"es2021": api.ES2020,
+	"es2022": api.ESNext,
+	"esnext": api.ESNext,
+	// the node target is for Node.js 14+ that supports ES2020
+	"node": api.ES2020,
+	// the bun target is for Bun that runs the latest JavaScriptCore
+	"bun": api.ESNext,
+	// the workers target is for Cloudflare Workers(workerd) that runs the latest V8
+	"workers": api.ESNext,
 }
 
 var engines = map[string]api.EngineName{
@@ -94,6 +105,18 @@ func validateESMAFeatures(target api.Target) int {
 }
 
 func validateEngineFeatures(engine api.Engine) int {
+	return countFeatures(compat.UnsupportedJSFeatures(engineConstraints(engine)))
+}
+
+// supportsES2020Lowered reports whether the engine supports all the features that are lowered
+// for es2020, like the class fields, then it can run the code without lowering.
+func supportsES2020Lowered(engine api.Engine) bool {
+	// the top-level await can't be lowered by esbuild anyway
+	lowered := compat.UnsupportedJSFeatures(map[compat.Engine][]int{compat.ES: {2020}}) &^ compat.TopLevelAwait
+	return compat.UnsupportedJSFeatures(engineConstraints(engine))&lowered == 0
+}
+
+func engineConstraints(engine api.Engine) map[compat.Engine][]int {
 	constraints := make(map[compat.Engine][]int)
 
 	if match := regBrowserVersion.FindStringSubmatch(engine.Version); match != nil {
@@ -121,8 +144,7 @@ func validateEngineFeatures(engine api.Engine) int {
 			}
 		}
 	}
-
-	return countFeatures(compat.UnsupportedJSFeatures(constraints))
+	return constraints
 }
 
 func countFeatures(feature compat.JSFeature) int {
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// the first path segments that are probed by vulnerability scanners
+var botPathSegments = map[string]bool{
+	"wp-admin":    true,
+	"wp-content":  true,
+	"wp-includes": true,
+	"cgi-bin":     true,
+	"phpmyadmin":  true,
+	"boaform":     true,
+	"hnap1":       true,
+}
+
+// the file extensions that are never used by module paths
+var botPathExts = map[string]bool{
+	".php":    true,
+	".asp":    true,
+	".aspx":   true,
+	".jsp":    true,
+	".cgi":    true,
+	".env":    true,
+	".ini":    true,
+	".sql":    true,
+	".bak":    true,
+	".zip":    true,
+	".gz":     true,
+	".tar":    true,
+	".rar":    true,
+	".exe":    true,
+	".dll":    true,
+	".action": true,
+}
+
+// isBotPath reports whether the path is obviously generated by crawlers or scanners,
+// these paths are rejected with 404 before they trigger any package lookup or build.
+func isBotPath(pathname string) bool {
+	lower := strings.ToLower(pathname)
+	first := strings.Split(strings.TrimPrefix(lower, "/"), "/")[0]
+	if botPathSegments[first] {
+		return true
+	}
+	if botPathExts[path.Ext(lower)] {
+		return true
+	}
+	// the npm package names can't start with `.` or `_`, and the dot files are never served
+	for _, segment := range strings.Split(lower, "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." && segment != ".." {
+			return true
+		}
+	}
+	return strings.HasPrefix(first, "_")
+}
+
+// robotsTxt returns the content of the `robots.txt`, the `robots-txt` config overrides
+// the default content. if the `robots-disallow-builds` config is enabled, all paths except
+// the homepage are disallowed since any other path may trigger a build.
+func (s *Server) robotsTxt() ([]byte, error) {
+	if s.config.robotsTxt != "" {
+		return ioutil.ReadFile(s.config.robotsTxt)
+	}
+	if s.config.robotsDisallowBuilds {
+		return []byte("User-agent: *\nAllow: /$\nAllow: /embed/\nDisallow: /\n"), nil
+	}
+	return []byte("User-agent: *\nDisallow: /_error.js\n"), nil
+}
@@ -0,0 +1,27 @@
+package server
+
+import "testing"
+
+func TestIsBotPath(t *testing.T) {
+	for pathname, expected := range map[string]bool{
+		"/react":                        false,
+		"/react@17.0.2/es2020/react.js": false,
+		"/@babel/core":                  false,
+		"/console-browserify":           false,
+		"/v36/_node_buffer.js":          false,
+		"/wp-admin/setup-config.php":    true,
+		"/wp-login.php":                 true,
+		"/index.php":                    true,
+		"/.env":                         true,
+		"/.git/config":                  true,
+		"/react@17.0.2/.npmrc":          true,
+		"/_ignition/execute-solution":   true,
+		"/cgi-bin/luci":                 true,
+		"/admin/config.action":          true,
+		"/backup.sql":                   true,
+	} {
+		if isBotPath(pathname) != expected {
+			t.Fatalf("isBotPath(%s) should be %v", pathname, expected)
+		}
+	}
+}
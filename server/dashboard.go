@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+// the number of the recent build failures that the dashboard shows
+const recentFailuresLimit = 20
+
+// the number of the build requests that are served from the cached builds, and the ones that
+// need a new build
+var (
+	cacheHits   uint64
+	cacheMisses uint64
+)
+
+// A failedBuild is a recent build attempt that failed.
+type failedBuild struct {
+	ID    string    `json:"id"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+	// the seconds that the build took
+	Duration float64 `json:"duration"`
+}
+
+// the recent build failures in the order of the time, the newest last
+var recentFailures struct {
+	lock     sync.Mutex
+	failures []failedBuild
+}
+
+// recordRecentFailure adds the failure of the build attempt to the dashboard.
+func recordRecentFailure(id string, err error, start time.Time, now time.Time) {
+	recentFailures.lock.Lock()
+	defer recentFailures.lock.Unlock()
+
+	recentFailures.failures = append(recentFailures.failures, failedBuild{
+		ID:       id,
+		Error:    err.Error(),
+		Time:     now,
+		Duration: now.Sub(start).Seconds(),
+	})
+	if n := len(recentFailures.failures); n > recentFailuresLimit {
+		recentFailures.failures = append([]failedBuild{}, recentFailures.failures[n-recentFailuresLimit:]...)
+	}
+}
+
+// listRecentFailures returns the recent build failures, the newest first.
+func listRecentFailures() []failedBuild {
+	recentFailures.lock.Lock()
+	defer recentFailures.lock.Unlock()
+
+	n := len(recentFailures.failures)
+	failures := make([]failedBuild, n)
+	for i, f := range recentFailures.failures {
+		failures[n-1-i] = f
+	}
+	return failures
+}
+
+// cacheHitRate returns the rate of the build requests that are served from the cached builds,
+// 0 if there is no request yet.
+func cacheHitRate(hits uint64, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// dashboard handles the `/-/dashboard` requests of the admin, the page polls the
+// `/-/dashboard.json` for the live queue, the active builds, the recent failures and the cache
+// hit rate.
+func (s *Server) dashboard(ctx *rex.Context, queue *buildQueue, startTime time.Time, data bool) interface{} {
+	if s.config.adminToken == "" {
+		return rex.Err(404)
+	}
+	if !s.isAdminRequest(ctx, true) {
+		ctx.SetHeader("WWW-Authenticate", `Basic realm="esm.sh admin", charset="UTF-8"`)
+		return rex.Err(401)
+	}
+	if ctx.R.Method != "GET" {
+		ctx.SetHeader("Allow", "GET")
+		return rex.Status(405, "method not allowed")
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	if !data {
+		html, err := s.embedFS.ReadFile("embed/dashboard.html")
+		if err != nil {
+			return err
+		}
+		return rex.Content("dashboard.html", startTime, bytes.NewReader(html))
+	}
+
+	now := time.Now()
+	queued, processing := queue.Stats()
+	hits := atomic.LoadUint64(&cacheHits)
+	misses := atomic.LoadUint64(&cacheMisses)
+	return map[string]interface{}{
+		"version": VERSION,
+		"uptime":  int64(now.Sub(startTime).Seconds()),
+		"queue": map[string]interface{}{
+			"queued":      queued,
+			"processing":  processing,
+			"concurrency": s.config.buildConcurrency,
+			"throttled":   queue.Throttled(),
+			"coalesced":   atomic.LoadUint64(&coalescedBuilds),
+			"shed":        atomic.LoadUint64(&shedBuilds),
+		},
+		"active":   queue.Active(now),
+		"failures": listRecentFailures(),
+		"cache": map[string]interface{}{
+			"hits":    hits,
+			"misses":  misses,
+			"hitRate": cacheHitRate(hits, misses),
+		},
+	}
+}
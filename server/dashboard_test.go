@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+func TestRecentFailures(t *testing.T) {
+	recentFailures.failures = nil
+	t.Cleanup(func() { recentFailures.failures = nil })
+
+	now := time.Now()
+	for i := 0; i < recentFailuresLimit+5; i++ {
+		recordRecentFailure(fmt.Sprintf("v%d/pkg@1.0.%d/es2020/pkg.js", VERSION, i), errors.New("esbuild: failed"), now.Add(-2*time.Second), now)
+	}
+	failures := listRecentFailures()
+	if len(failures) != recentFailuresLimit {
+		t.Fatalf("expected %d failures, got %d", recentFailuresLimit, len(failures))
+	}
+	if failures[0].ID != fmt.Sprintf("v%d/pkg@1.0.%d/es2020/pkg.js", VERSION, recentFailuresLimit+4) || failures[0].Duration != 2 {
+		t.Fatalf("unexpected newest failure %v", failures[0])
+	}
+	if failures[recentFailuresLimit-1].ID != fmt.Sprintf("v%d/pkg@1.0.5/es2020/pkg.js", VERSION) {
+		t.Fatalf("unexpected oldest failure %v", failures[recentFailuresLimit-1])
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	s := setupTestEnv(t)
+	atomic.StoreUint64(&cacheHits, 3)
+	atomic.StoreUint64(&cacheMisses, 1)
+	recentFailures.failures = nil
+	t.Cleanup(func() {
+		atomic.StoreUint64(&cacheHits, 0)
+		atomic.StoreUint64(&cacheMisses, 0)
+		recentFailures.failures = nil
+	})
+
+	now := time.Now()
+	queue := newBuildQueue(2, 0)
+	for _, elapsed := range []time.Duration{time.Second, 5 * time.Second} {
+		queue.current = append(queue.current, &task{
+			buildTask: &buildTask{Server: s, id: fmt.Sprintf("v%d/pkg@1.0.%d/es2020/pkg.js", VERSION, elapsed/time.Second), target: "es2020"},
+			inProcess: true,
+			startTime: now.Add(-elapsed),
+			consumers: make([]chan *buildOutput, 2),
+		})
+	}
+	recordRecentFailure("v1/broken@1.0.0/es2020/broken.js", errors.New("esbuild: failed"), now, now)
+
+	call := func(header string, value string, data bool) (interface{}, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest("GET", "http://esm.sh/-/dashboard.json", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		return s.dashboard(&rex.Context{W: w, R: req, Form: &rex.Form{R: req}}, queue, now, data), w
+	}
+
+	if ret, _ := call("", "", true); !isErrStatus(ret, 404) {
+		t.Fatalf("the dashboard should be disabled without the admin token, got %v", ret)
+	}
+	s.config.adminToken = "secret"
+	ret, w := call("Authorization", "Bearer wrong", true)
+	if !isErrStatus(ret, 401) || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("the request without the admin token should be challenged, got %v", ret)
+	}
+	req := httptest.NewRequest("GET", "http://esm.sh/-/dashboard", nil)
+	req.SetBasicAuth("admin", "secret")
+	ret = s.dashboard(&rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}, queue, now, true)
+	if _, ok := ret.(map[string]interface{}); !ok {
+		t.Fatalf("the basic auth with the admin token should be accepted, got %v", ret)
+	}
+
+	ret, _ = call("Authorization", "Bearer secret", true)
+	data, ok := ret.(map[string]interface{})
+	if !ok {
+		t.Fatalf("unexpected response %v", ret)
+	}
+	active := data["active"].([]activeBuild)
+	if len(active) != 2 || active[0].ID != fmt.Sprintf("v%d/pkg@1.0.5/es2020/pkg.js", VERSION) || active[0].Waiters != 2 || active[0].Elapsed < 5 {
+		t.Fatalf("unexpected active builds %v", active)
+	}
+	if failures := data["failures"].([]failedBuild); len(failures) != 1 || failures[0].ID != "v1/broken@1.0.0/es2020/broken.js" {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+	if cache := data["cache"].(map[string]interface{}); cache["hitRate"] != 0.75 {
+		t.Fatalf("unexpected cache %v", cache)
+	}
+	if queued := data["queue"].(map[string]interface{}); queued["processing"] != 2 {
+		t.Fatalf("unexpected queue %v", queued)
+	}
+}
+
+func isErrStatus(ret interface{}, status int) bool {
+	e, ok := ret.(*rex.Error)
+	return ok && e.Status == status
+}
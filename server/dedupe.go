@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isNodeEnvFree reports whether none of the bundled files reads the `NODE_ENV`(or the other
+// names that are only defined for the production builds), the check is conservative: a false
+// positive only makes the development build not deduplicated.
+func isNodeEnvFree(wd string, metafile string, prodDefines ...string) (bool, error) {
+	var meta esbuildMetafile
+	err := json.Unmarshal([]byte(metafile), &meta)
+	if err != nil {
+		return false, err
+	}
+	for input := range meta.Inputs {
+		if strings.HasPrefix(input, "esm_sh_shims/") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(wd, input))
+		if err != nil {
+			// the virtual modules like `<stdin>`
+			continue
+		}
+		if bytes.Contains(data, []byte("NODE_ENV")) {
+			return false, nil
+		}
+		for _, name := range prodDefines {
+			if bytes.Contains(data, []byte(name)) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// HasPackageDeps reports whether the build imports other package builds, which are different
+// between the development and production modes.
+func (r *externalResolver) HasPackageDeps() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	versionPrefx := fmt.Sprintf("/v%d/", VERSION)
+	for _, importPath := range r.paths {
+		if strings.HasPrefix(importPath, versionPrefx) && !strings.HasPrefix(importPath, versionPrefx+"_") {
+			return true
+		}
+	}
+	return len(r.cjsDeps) > 0
+}
+
+// aliasBuild links the artifacts of the build `id` to the build `src`, the linked `.LEGAL.txt`
+// is referenced by the source build. The `.sig` sidecars are linked too, the alias is signed
+// by the signatures of the source build.
+func (s *Server) aliasBuild(src string, id string, pkgCSS bool) (err error) {
+	exts := []string{".js"}
+	if pkgCSS {
+		exts = append(exts, ".css")
+	}
+	for _, ext := range exts {
+		if fileExists(filepath.Join(s.config.storageDir, "builds", src+ext+".sig")) {
+			exts = append(exts, ext+".sig")
+		}
+	}
+	for _, ext := range exts {
+		srcFile := filepath.Join(s.config.storageDir, "builds", src+ext)
+		dstFile := filepath.Join(s.config.storageDir, "builds", id+ext)
+		err = ensureDir(path.Dir(dstFile))
+		if err != nil {
+			return
+		}
+		os.Remove(dstFile)
+		rel, e := filepath.Rel(filepath.Dir(dstFile), srcFile)
+		if e == nil && os.Symlink(rel, dstFile) == nil {
+			continue
+		}
+		// fallback to copy if the file system doesn't support symlinks
+		var data []byte
+		data, err = ioutil.ReadFile(srcFile)
+		if err != nil {
+			return
+		}
+		err = s.writeStorageFile(dstFile, bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
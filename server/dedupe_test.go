@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestIsNodeEnvFree(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-dedupe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	ensureDir(path.Join(wd, "node_modules/a"))
+	ioutil.WriteFile(path.Join(wd, "node_modules/a/index.js"), []byte(`export const a = 1`), 0644)
+	ioutil.WriteFile(path.Join(wd, "node_modules/a/dev.js"), []byte(`export const dev = process.env.NODE_ENV !== "production"`), 0644)
+
+	ok, err := isNodeEnvFree(wd, `{"inputs":{"<stdin>":{"bytes":1},"node_modules/a/index.js":{"bytes":18}}}`)
+	if err != nil || !ok {
+		t.Fatalf("should be node-env free: %v", err)
+	}
+	ok, err = isNodeEnvFree(wd, `{"inputs":{"node_modules/a/index.js":{"bytes":18},"node_modules/a/dev.js":{"bytes":56}}}`)
+	if err != nil || ok {
+		t.Fatalf("should not be node-env free: %v", err)
+	}
+	ok, err = isNodeEnvFree(wd, `{"inputs":{"node_modules/a/index.js":{"bytes":18}}}`, "a")
+	if err != nil || ok {
+		t.Fatalf("should not be node-env free with the production defines: %v", err)
+	}
+}
+
+func TestAliasBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-dedupe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{config: &Config{storageDir: dir}}
+	src := "v1/a@1.0.0/es2020/a"
+	err = writeFileAtomic(path.Join(dir, "builds", src+".js"), bytes.NewReader([]byte("export const a = 1;")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.aliasBuild(src, src+".development", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path.Join(dir, "builds", src+".development.js"))
+	if err != nil || string(data) != "export const a = 1;" {
+		t.Fatalf("unexpected alias content %q: %v", data, err)
+	}
+	if fileExists(path.Join(dir, "builds", src+".development.js.sig")) {
+		t.Fatal("the signature of the unsigned build should not be aliased")
+	}
+
+	// the alias is signed by the signature of the source build
+	s.config.signingKey, err = loadSigningKey(path.Join(dir, "signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.writeArtifact(path.Join(dir, "builds", src+".js"), bytes.NewReader([]byte("export const a = 2;")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.aliasBuild(src, src+".development", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, ok := readArtifactSignature(path.Join(dir, "builds", src+".development.js"))
+	if !ok || !verifyContent(s.config.signingKey.Public().(ed25519.PublicKey), []byte("export const a = 2;"), sig) {
+		t.Fatal("the alias should be signed by the signature of the source build")
+	}
+}
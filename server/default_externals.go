@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// parseExternals parses the `external` query like `react,react-dom`, the packages are kept as
+// the bare imports in the builds, resolved by the import maps.
+func parseExternals(s string) (external []string, err error) {
+	set := newStringSet()
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !regPkgName.MatchString(name) {
+			return nil, fmt.Errorf("invalid external package name '%s'", name)
+		}
+		set.Add(name)
+	}
+	external = set.Values()
+	sort.Strings(external)
+	return
+}
+
+// mergeExternals merges the external packages, the package itself is never external.
+func mergeExternals(pkgName string, lists ...[]string) []string {
+	set := newStringSet()
+	for _, list := range lists {
+		for _, name := range list {
+			if name != pkgName {
+				set.Add(name)
+			}
+		}
+	}
+	external := set.Values()
+	sort.Strings(external)
+	return external
+}
+
+// externalSegment returns the segment of the external packages in the build path like
+// `external=react,@emotion_react/`, the package itself is excluded.
+func externalSegment(external []string, pkgName string) string {
+	names := mergeExternals(pkgName, external)
+	if len(names) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("external=%s/", strings.ReplaceAll(strings.Join(names, ","), "/", "_"))
+}
+
+// parseExternalSegment parses the segment of the build path like `external=react,@emotion_react`.
+func parseExternalSegment(s string) []string {
+	var external []string
+	for _, name := range strings.Split(strings.TrimPrefix(s, "external="), ",") {
+		if strings.HasPrefix(name, "@") {
+			scope, n := utils.SplitByFirstByte(name, '_')
+			name = scope + "/" + n
+		}
+		if regPkgName.MatchString(name) {
+			external = append(external, name)
+		}
+	}
+	return external
+}
+
+// An externalRule of the `default-external` config adds the external packages to the builds
+// of the target or the packages that match the pattern, the rule without a selector applies
+// to all the builds.
+type externalRule struct {
+	target  string
+	pattern string
+	names   []string
+}
+
+// defaultExternals is the `default-external` config like `react,react-dom;deno=preact;@corp/*=vue`,
+// the rules are separated by `;` and the selector is a target or a package pattern.
+type defaultExternals []externalRule
+
+func parseDefaultExternals(s string) (rules defaultExternals, err error) {
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		selector, list := "", rule
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			selector, list = strings.TrimSpace(rule[:i]), rule[i+1:]
+		}
+		var r externalRule
+		r.names, err = parseExternals(list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default external rule '%s': %v", rule, err)
+		}
+		if _, ok := targets[selector]; ok {
+			r.target = selector
+		} else if selector != "" {
+			if _, e := path.Match(selector, ""); e != nil || !regPkgName.MatchString(strings.ReplaceAll(selector, "*", "x")) {
+				return nil, fmt.Errorf("invalid default external rule '%s': bad selector '%s'", rule, selector)
+			}
+			r.pattern = selector
+		}
+		rules = append(rules, r)
+	}
+	return
+}
+
+// Match returns the default external packages of the build.
+func (rules defaultExternals) Match(pkgName string, target string) (external []string) {
+	for _, r := range rules {
+		if r.target != "" && r.target != target {
+			continue
+		}
+		if r.pattern != "" {
+			if ok, _ := path.Match(r.pattern, pkgName); !ok {
+				continue
+			}
+		}
+		external = append(external, r.names...)
+	}
+	return mergeExternals(pkgName, external)
+}
+
+// isExternal reports whether the import is kept as the bare import in the build.
+func (task *buildTask) isExternal(specifier string) bool {
+	if len(task.external) == 0 || isFileImportPath(specifier) || strings.Contains(specifier, ":") {
+		return false
+	}
+	name, _ := splitPkgPath(specifier)
+	return name != task.pkg.name && includes(task.external, name)
+}
+
+// applyDefaultExternals merges the `default-external` config into the external packages.
+func (task *buildTask) applyDefaultExternals() {
+	if task.config != nil && len(task.config.defaultExternals) > 0 {
+		task.external = mergeExternals(task.pkg.name, task.external, task.config.defaultExternals.Match(task.pkg.name, task.target))
+	}
+}
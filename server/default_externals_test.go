@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDefaultExternals(t *testing.T) {
+	rules, err := parseDefaultExternals("react, react-dom; deno=preact; @corp/*=vue,@emotion/react")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		pkg      string
+		target   string
+		external []string
+	}{
+		{"lodash", "es2020", []string{"react", "react-dom"}},
+		{"lodash", "deno", []string{"preact", "react", "react-dom"}},
+		{"@corp/ui", "es2020", []string{"@emotion/react", "react", "react-dom", "vue"}},
+		// the package itself is never external
+		{"react", "es2020", []string{"react-dom"}},
+	} {
+		if external := rules.Match(c.pkg, c.target); !reflect.DeepEqual(external, c.external) {
+			t.Fatalf("match %s(%s): unexpected external %v", c.pkg, c.target, external)
+		}
+	}
+	for _, s := range []string{"es2020=React", "@corp/[=vue", "bad name=vue"} {
+		if _, err := parseDefaultExternals(s); err == nil {
+			t.Fatalf("the rule '%s' should be rejected", s)
+		}
+	}
+
+	s := &Server{config: &Config{defaultExternals: rules}}
+	task := &buildTask{Server: s, pkg: pkg{name: "@corp/ui", version: "1.0.0"}, external: []string{"react"}, target: "es2020"}
+	task.applyDefaultExternals()
+	id := fmt.Sprintf("v%d/@corp/ui@1.0.0/external=@emotion_react,react,react-dom,vue/es2020/ui", VERSION)
+	if task.ID() != id {
+		t.Fatalf("unexpected build id %s", task.ID())
+	}
+	if external := parseExternalSegment("external=@emotion_react,react,react-dom,vue"); !reflect.DeepEqual(external, task.external) {
+		t.Fatalf("unexpected external %v", external)
+	}
+	for specifier, external := range map[string]bool{"react": true, "react/jsx-runtime": true, "@emotion/react": true, "preact": false, "./react": false, "node:fs": false} {
+		if task.isExternal(specifier) != external {
+			t.Fatalf("unexpected external of %s", specifier)
+		}
+	}
+}
+
+func TestExternalBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	for _, name := range []string{"esm-fixture-esm", "esm-fixture-cjs"} {
+		task := &buildTask{Server: s, pkg: fixturePkg(t, s, name+"@1.0.0"), external: []string{"esm-fixture-dep"}, cjsExports: "auto", target: "es2020"}
+		code := buildFixture(t, task)
+		if !regexp.MustCompile(`from ?"esm-fixture-dep"`).MatchString(code) || strings.Contains(code, "esm-fixture-dep@") {
+			t.Fatalf("build %s: the external package should be kept as the bare import:\n%s", task.ID(), code)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ije/gox/utils"
+	"github.com/postui/postdb"
+	"github.com/postui/postdb/q"
+)
+
+// the presets of the `define` config, they strip the development-only code of frameworks
+// from the production builds
+var definePresets = map[string]map[string]string{
+	"angular": {
+		"ngDevMode":         "false",
+		"ngI18nClosureMode": "false",
+	},
+	"dev": {
+		"__DEV__": "false",
+	},
+	"node-debug": {
+		"process.env.NODE_DEBUG":        "false",
+		"global.process.env.NODE_DEBUG": "false",
+	},
+}
+
+// parseDefineConfig parses the `define` config like `angular,dev,__TEST__=false`, the items
+// are the presets or the `key=value` pairs that the value is a JSON literal or an identifier.
+func parseDefineConfig(s string) (define map[string]string, err error) {
+	define = map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		a := strings.SplitN(part, "=", 2)
+		if len(a) == 1 {
+			preset, ok := definePresets[part]
+			if !ok {
+				names := make([]string, 0, len(definePresets))
+				for name := range definePresets {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				return nil, fmt.Errorf("unknown define preset '%s', available presets: %s", part, strings.Join(names, ", "))
+			}
+			for key, value := range preset {
+				define[key] = value
+			}
+			continue
+		}
+		key, value := strings.TrimSpace(a[0]), strings.TrimSpace(a[1])
+		if !isDottedIdentifier(key) {
+			return nil, fmt.Errorf("invalid define key '%s'", key)
+		}
+		if !isDottedIdentifier(value) && !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("invalid define value '%s' of '%s', should be a JSON literal or an identifier", value, key)
+		}
+		define[key] = value
+	}
+	return
+}
+
+// parseQueryDefines parses the `?env` and `?define` queries of the requests, like
+// `?env=NODE_ENV:test,API_URL:https://x` and `?define=__DEV__:false`. The env vars are
+// defined as the strings of the `process.env`, and the defines are the JSON literals or the
+// identifiers like the `define` config.
+func parseQueryDefines(env string, define string) (defines map[string]string, err error) {
+	defines = map[string]string{}
+	for _, item := range strings.Split(env, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value := utils.SplitByFirstByte(item, ':')
+		name = strings.TrimSpace(name)
+		if !regJSIdentifier.MatchString(name) {
+			return nil, fmt.Errorf("invalid env name '%s'", name)
+		}
+		value = strings.TrimSpace(string(utils.MustEncodeJSON(value)))
+		defines["process.env."+name] = value
+		defines["global.process.env."+name] = value
+	}
+	for _, item := range strings.Split(define, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value := utils.SplitByFirstByte(item, ':')
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !isDottedIdentifier(key) {
+			return nil, fmt.Errorf("invalid define key '%s'", key)
+		}
+		if !isDottedIdentifier(value) && !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("invalid define value '%s' of '%s', should be a JSON literal or an identifier", value, key)
+		}
+		defines[key] = value
+	}
+	if len(defines) == 0 {
+		return nil, nil
+	}
+	return
+}
+
+// defineHash returns the hash of the defines of the request that is a segment of the build ID,
+// the defines are stored by the hash to rebuild the build path.
+func defineHash(defines map[string]string) string {
+	keys := make([]string, 0, len(defines))
+	for key := range defines {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha1.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%s\n", key, defines[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// saveDefines stores the defines of the request by the hash.
+func (s *Server) saveDefines(hash string, defines map[string]string) error {
+	kv := q.KV{"define": utils.MustEncodeJSON(defines)}
+	_, err := s.db.Put(q.Alias("define:"+hash), kv)
+	if err == postdb.ErrDuplicateAlias {
+		return nil
+	}
+	return err
+}
+
+// loadDefines returns the defines of the hash in the build path like
+// `/v36/pkg@1.0.0/define=2fd4e1c67a2d/es2020/pkg.js`.
+func (s *Server) loadDefines(hash string) (defines map[string]string, ok bool) {
+	post, err := s.db.Get(q.Alias("define:"+hash), q.K("define"))
+	if err != nil {
+		return
+	}
+	ok = json.Unmarshal(post.KV.Get("define"), &defines) == nil && len(defines) > 0
+	return
+}
+
+func isDottedIdentifier(s string) bool {
+	for _, name := range strings.Split(s, ".") {
+		if !regJSIdentifier.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// defineMarkers returns the names of the `define` config that the bundled files are checked
+// for, like `ngDevMode` and `NODE_DEBUG`.
+func defineMarkers(define map[string]string) []string {
+	markers := []string{}
+	for key := range define {
+		markers = append(markers, key[strings.LastIndexByte(key, '.')+1:])
+	}
+	sort.Strings(markers)
+	return markers
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseDefineConfig(t *testing.T) {
+	define, err := parseDefineConfig("angular, dev,__TEST__=false,VERSION=\"1.0\",global.__STAGE__=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{
+		"ngDevMode":        "false",
+		"__DEV__":          "false",
+		"__TEST__":         "false",
+		"VERSION":          `"1.0"`,
+		"global.__STAGE__": "prod",
+	} {
+		if define[key] != value {
+			t.Fatalf("unexpected define %s=%s", key, define[key])
+		}
+	}
+	if markers := strings.Join(defineMarkers(define), ","); markers != "VERSION,__DEV__,__STAGE__,__TEST__,ngDevMode,ngI18nClosureMode" {
+		t.Fatalf("unexpected markers %s", markers)
+	}
+
+	for _, s := range []string{"vue", "a-b=1", "__DEV__=", "__DEV__={", "__DEV__=a-b"} {
+		if _, err := parseDefineConfig(s); err == nil {
+			t.Fatalf("define '%s' should be invalid", s)
+		}
+	}
+}
+
+func TestParseQueryDefines(t *testing.T) {
+	define, err := parseQueryDefines("NODE_ENV:test, API_URL:https://x.com/api", "__DEBUG__:true,config.stage:\"beta\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{
+		"process.env.NODE_ENV":        `"test"`,
+		"global.process.env.NODE_ENV": `"test"`,
+		"process.env.API_URL":         `"https://x.com/api"`,
+		"__DEBUG__":                   "true",
+		"config.stage":                `"beta"`,
+	} {
+		if define[key] != value {
+			t.Fatalf("unexpected define %s=%s", key, define[key])
+		}
+	}
+	if len(define) != 6 {
+		t.Fatalf("unexpected defines %v", define)
+	}
+	if define, err := parseQueryDefines("", ""); err != nil || define != nil {
+		t.Fatalf("unexpected defines %v, %v", define, err)
+	}
+
+	a, _ := parseQueryDefines("A:1,B:2", "")
+	b, _ := parseQueryDefines("B:2,A:1", "")
+	c, _ := parseQueryDefines("A:1,B:3", "")
+	if defineHash(a) != defineHash(b) || defineHash(a) == defineHash(c) || len(defineHash(a)) != 12 {
+		t.Fatalf("unexpected hashes %s, %s, %s", defineHash(a), defineHash(b), defineHash(c))
+	}
+
+	for _, c := range [][2]string{{"a-b:1", ""}, {"", "__DEV__"}, {"", "__DEV__:"}, {"", "__DEV__:a-b"}, {"", "a b:1"}} {
+		if _, err := parseQueryDefines(c[0], c[1]); err == nil {
+			t.Fatalf("'?env=%s&define=%s' should be invalid", c[0], c[1])
+		}
+	}
+}
+
+func TestQueryDefinesBuild(t *testing.T) {
+	_, s := newTestServer(t)
+	handler := s.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/esm-fixture-env@1.0.0?define=__DEBUG__:true&env=API_URL:https://api.example.com&target=es2020", nil))
+	if rec.Code != 200 {
+		t.Fatalf("unexpected status %d:\n%s", rec.Code, rec.Body.String())
+	}
+	m := regexp.MustCompile(`/v\d+/esm-fixture-env@1\.0\.0/define=([0-9a-f]{12})/es2020/esm-fixture-env\.js`).FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("the build path should contain the define hash:\n%s", rec.Body.String())
+	}
+	code := readBuild(t, s, strings.TrimPrefix(m[0], "/"))
+	if !strings.Contains(code, "https://api.example.com") || strings.Contains(code, "https://default.example.com") || strings.Contains(code, "__DEBUG__") {
+		t.Fatalf("the defines should be applied:\n%s", code)
+	}
+
+	// the build path is rebuilt by the stored defines
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", m[0], nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "https://api.example.com") {
+		t.Fatalf("unexpected response %d:\n%s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/v%d/esm-fixture-env@1.0.0/define=000000000000/es2020/esm-fixture-env.js", VERSION), nil))
+	if rec.Code != 404 {
+		t.Fatalf("the unknown defines should be 404: %d", rec.Code)
+	}
+}
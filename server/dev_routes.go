@@ -0,0 +1,84 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the prefix of the dev routes, like `/dev/react@17.0.2`
+const devRoutePrefix = "/dev"
+
+// scratchBuildsDir returns the directory of the builds of the dev routes, they are never
+// mixed with the builds storage.
+func (s *Server) scratchBuildsDir() string {
+	return filepath.Join(s.config.storageDir, "scratch", "builds")
+}
+
+// buildsDir returns the directory that stores the artifacts of the build.
+func (task *buildTask) buildsDir() string {
+	if task.scratch {
+		return task.scratchBuildsDir()
+	}
+	return filepath.Join(task.config.storageDir, "builds")
+}
+
+// routePrefix returns the prefix of the URLs of the artifacts.
+func (task *buildTask) routePrefix() string {
+	if task.scratch {
+		return devRoutePrefix
+	}
+	return ""
+}
+
+// queueKey returns the key of the build in the queue, the scratch builds don't share the
+// builds in process with the production ones.
+func (task *buildTask) queueKey() string {
+	if task.scratch {
+		return "scratch:" + task.ID()
+	}
+	return task.ID()
+}
+
+// startScratchGC removes the expired scratch builds when the `dev-routes` config is set.
+func (s *Server) startScratchGC() {
+	if !s.config.devRoutes {
+		return
+	}
+
+	interval := s.config.devRoutesTTL / 2
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			n, err := s.gcScratchBuilds(time.Now())
+			if err != nil {
+				s.log.Errorf("gc scratch builds: %v", err)
+			} else if n > 0 {
+				s.log.Debugf("gc scratch builds: %d files removed", n)
+			}
+		}
+	}()
+}
+
+// gcScratchBuilds removes the scratch files that are not rewritten in the `dev-routes-ttl`.
+func (s *Server) gcScratchBuilds(now time.Time) (removed int, err error) {
+	dir := s.scratchBuildsDir()
+	if !dirExists(dir) {
+		return
+	}
+	err = filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && now.Sub(info.ModTime()) >= s.config.devRoutesTTL {
+			if os.Remove(filename) == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScratchBuilds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-scratch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{config: &Config{storageDir: dir, devRoutes: true, devRoutesTTL: 10 * time.Minute}}
+	task := &buildTask{Server: s, pkg: pkg{name: "react", version: "17.0.2"}, target: "es2020"}
+	scratchTask := *task
+	scratchTask.scratch = true
+	if task.ID() != scratchTask.ID() || task.queueKey() == scratchTask.queueKey() {
+		t.Fatal("the scratch build should have the same id but not share the queue with the production build")
+	}
+	if task.buildsDir() != filepath.Join(dir, "builds") || scratchTask.buildsDir() != filepath.Join(dir, "scratch", "builds") {
+		t.Fatalf("unexpected builds dirs %s, %s", task.buildsDir(), scratchTask.buildsDir())
+	}
+	if scratchTask.routePrefix() != "/dev" || task.routePrefix() != "" {
+		t.Fatal("the scratch builds should be served by the dev routes")
+	}
+
+	filename := filepath.Join(scratchTask.buildsDir(), scratchTask.ID()+".js")
+	if err = writeFileAtomic(filename); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.gcScratchBuilds(time.Now()); err != nil || n != 0 {
+		t.Fatalf("the fresh scratch build should be kept: %d, %v", n, err)
+	}
+	if n, err := s.gcScratchBuilds(time.Now().Add(time.Hour)); err != nil || n != 1 || fileExists(filename) {
+		t.Fatalf("the expired scratch build should be removed: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+// the package that `esmd doctor` builds by default, it's small and ships the types
+const doctorPackage = "preact"
+
+// A doctorStep is a step of the self-test, the hint explains how to fix the failure.
+type doctorStep struct {
+	name string
+	hint string
+	run  func() (string, error)
+}
+
+// runDoctor runs the steps in order and prints the results, the steps after a failure are
+// skipped since they depend on the previous ones.
+func runDoctor(w io.Writer, steps []doctorStep) bool {
+	for i, step := range steps {
+		start := time.Now()
+		message, err := step.run()
+		if err != nil {
+			fmt.Fprintf(w, "✗ %s: %v\n  hint: %s\n", step.name, err, step.hint)
+			for _, s := range steps[i+1:] {
+				fmt.Fprintf(w, "- %s: skipped\n", s.name)
+			}
+			return false
+		}
+		fmt.Fprintf(w, "✓ %s: %s (%v)\n", step.name, message, time.Since(start).Round(time.Millisecond))
+	}
+	return true
+}
+
+// doctorSteps returns the steps that resolve, install, build and serve the package(like
+// `preact` or `preact@10`) with the live config.
+func (s *Server) doctorSteps(spec string, logFile string) []doctorStep {
+	if spec == "" {
+		spec = doctorPackage
+	}
+	name, version := splitPkgVersion(spec)
+	if version == "" {
+		version = "latest"
+	}
+
+	var info NpmPackage
+	var task *buildTask
+	var esm *ESMeta
+	return []doctorStep{
+		{
+			name: "storage",
+			hint: "check the permissions of the 'etc-dir'(or the 'data-dir')",
+			run: func() (string, error) {
+				dir := filepath.Join(s.config.storageDir, fmt.Sprintf("builds/v%d", VERSION))
+				err := ensureDir(dir)
+				if err != nil {
+					return "", err
+				}
+				f, err := ioutil.TempFile(dir, ".doctor-")
+				if err != nil {
+					return "", err
+				}
+				f.Close()
+				return s.config.storageDir + " is writable", os.Remove(f.Name())
+			},
+		},
+		{
+			name: "resolve",
+			hint: "check the npm registry(`npm config get registry`) and the 'http-proxy', 'https-proxy' and 'no-proxy' configs",
+			run: func() (message string, err error) {
+				info, _, err = s.getPackageInfo(name, version)
+				if err != nil {
+					return
+				}
+				return fmt.Sprintf("%s@%s from %s", info.Name, info.Version, s.node.npmRegistry), nil
+			},
+		},
+		{
+			name: "install",
+			hint: "check the registry, the 'installer'(`yarn -v` for the yarn installer), 'install-timeout' and 'install-deny' configs",
+			run: func() (string, error) {
+				wd, err := ioutil.TempDir("", "esm-doctor-")
+				if err != nil {
+					return "", err
+				}
+				defer os.RemoveAll(wd)
+
+				ctx, cancel := s.buildContext()
+				defer cancel()
+				err = s.installPackages(ctx, wd, fmt.Sprintf("%s@%s", info.Name, info.Version))
+				if err != nil {
+					return "", err
+				}
+				packages, err := listInstalledPackages(wd)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d packages installed", len(packages)), nil
+			},
+		},
+		{
+			name: "build",
+			hint: fmt.Sprintf("check the esbuild errors in %s and the 'build-timeout' config", logFile),
+			run: func() (string, error) {
+				task = &buildTask{Server: s, pkg: pkg{name: info.Name, version: info.Version}, target: "es2020"}
+				ctx, cancel := s.buildContext()
+				defer cancel()
+				var err error
+				esm, _, err = task.buildESM(ctx)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%s.js with %d exports", task.ID(), len(esm.Exports)), nil
+			},
+		},
+		{
+			name: "types",
+			hint: fmt.Sprintf("check the types of the package, or try a package that ships the types like `esmd doctor %s`", doctorPackage),
+			run: func() (string, error) {
+				if esm.Dts == "" {
+					return "", fmt.Errorf("no types found in %s@%s", info.Name, info.Version)
+				}
+				if !fileExists(filepath.Join(s.config.storageDir, fmt.Sprintf("types/v%d", VERSION), esm.Dts)) {
+					return "", fmt.Errorf("%s is not copied", esm.Dts)
+				}
+				return esm.Dts + " copied", nil
+			},
+		},
+		{
+			name: "serve",
+			hint: fmt.Sprintf("check the 'domain' and 'cdn-domain' configs and the errors in %s", logFile),
+			run: func() (string, error) {
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					return "", err
+				}
+				handler := &rex.APIHandler{}
+				handler.Use(s.guardAbuse(s.query()))
+				server := &http.Server{Handler: handler}
+				go server.Serve(ln)
+				defer server.Close()
+
+				origin := "http://" + ln.Addr().String()
+				body, err := doctorGet(fmt.Sprintf("%s/%s@%s?target=es2020", origin, info.Name, info.Version))
+				if err != nil {
+					return "", err
+				}
+				if !strings.Contains(body, task.ID()) {
+					return "", fmt.Errorf("the module doesn't import the build %s", task.ID())
+				}
+				_, err = doctorGet(fmt.Sprintf("%s/%s.js", origin, task.ID()))
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%s/%s@%s served", origin, info.Name, info.Version), nil
+			},
+		},
+	}
+}
+
+// doctorGet fetches the url, returns an error if the response is not a javascript module.
+func doctorGet(url string) (string, error) {
+	client := &http.Client{Timeout: time.Minute}
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	// the stubs are `application/javascript`, the build files are served by the mime type
+	ct := res.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/javascript") && !strings.HasPrefix(ct, "text/javascript") {
+		return "", fmt.Errorf("GET %s: unexpected content type '%s'", url, ct)
+	}
+	return string(data), nil
+}
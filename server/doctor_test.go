@@ -0,0 +1,44 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunDoctor(t *testing.T) {
+	var buf bytes.Buffer
+	ok := runDoctor(&buf, []doctorStep{
+		{name: "a", run: func() (string, error) { return "done", nil }},
+		{name: "b", hint: "fix b", run: func() (string, error) { return "", errors.New("broken") }},
+		{name: "c", run: func() (string, error) { t.Fatal("c should be skipped"); return "", nil }},
+	})
+	if ok {
+		t.Fatal("the doctor should fail")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "✓ a: done (") || lines[1] != "✗ b: broken" || lines[2] != "  hint: fix b" || lines[3] != "- c: skipped" {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestDoctorSteps(t *testing.T) {
+	s := setupTestEnv(t)
+
+	steps := s.doctorSteps("esm-fixture-esm@1", "main.log")
+	names := []string{}
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	if strings.Join(names, ",") != "storage,resolve,install,build,types,serve" {
+		t.Fatalf("unexpected steps %v", names)
+	}
+	var buf bytes.Buffer
+	if !runDoctor(&buf, steps[:2]) {
+		t.Fatalf("unexpected failure:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "✓ resolve: esm-fixture-esm@1.0.0 from ") {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ije/esbuild-internal/js_lexer"
+	"github.com/ije/esbuild-internal/logger"
+	"github.com/ije/esbuild-internal/test"
+)
+
+// the statements that can be dropped from the production builds by the `?drop` query
+var dropKinds = map[string]bool{
+	"console":  true,
+	"debugger": true,
+}
+
+// the methods of the `console` that are marked as pure for the `?drop=console` query, the
+// minifier removes the calls of them
+var consoleMethods = []string{
+	"assert",
+	"count",
+	"countReset",
+	"debug",
+	"dir",
+	"dirxml",
+	"error",
+	"group",
+	"groupCollapsed",
+	"groupEnd",
+	"info",
+	"log",
+	"profile",
+	"profileEnd",
+	"table",
+	"time",
+	"timeEnd",
+	"timeLog",
+	"timeStamp",
+	"trace",
+	"warn",
+}
+
+// parseDropQuery parses the `?drop` query like `console,debugger`.
+func parseDropQuery(s string) (drop []string, err error) {
+	set := newStringSet()
+	for _, kind := range strings.Split(s, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		if !dropKinds[kind] {
+			return nil, fmt.Errorf("invalid drop '%s', available drops: console, debugger", kind)
+		}
+		set.Add(kind)
+	}
+	drop = set.Values()
+	sort.Strings(drop)
+	return
+}
+
+// pureConsoleCalls returns the `console` methods for the `Pure` option of esbuild.
+func pureConsoleCalls() []string {
+	calls := make([]string, len(consoleMethods))
+	for i, method := range consoleMethods {
+		calls[i] = "console." + method
+	}
+	return calls
+}
+
+// drops reports whether the statements of the kind are dropped from the build.
+func (task *buildTask) drops(kind string) bool {
+	for _, k := range task.drop {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// dropDebugger replaces the `debugger` statements of the code with the empty statements, the
+// positions of the code are kept for the source maps. The code is returned as it is if it
+// can't be tokenized.
+func dropDebugger(code string) (ret string, ok bool) {
+	if !strings.Contains(code, "debugger") {
+		return code, false
+	}
+	defer func() {
+		if recover() != nil {
+			ret, ok = code, false
+		}
+	}()
+
+	buf := []byte(code)
+	lexer := js_lexer.NewLexer(logger.NewDeferLog(), test.SourceForTest(code))
+	// the braces of the blocks(false) and the template literals(true)
+	braces := []bool{}
+	prev := js_lexer.TEndOfFile
+	for lexer.Token != js_lexer.TEndOfFile {
+		token := lexer.Token
+		switch token {
+		case js_lexer.TSlash, js_lexer.TSlashEquals:
+			if isRegExpStart(prev) {
+				lexer.ScanRegExp()
+				token = js_lexer.TStringLiteral
+			}
+		case js_lexer.TTemplateHead:
+			braces = append(braces, true)
+		case js_lexer.TOpenBrace:
+			braces = append(braces, false)
+		case js_lexer.TCloseBrace:
+			if n := len(braces); n > 0 {
+				isTemplate := braces[n-1]
+				braces = braces[:n-1]
+				if isTemplate {
+					lexer.RescanCloseBraceAsTemplateToken()
+					token = lexer.Token
+					if token == js_lexer.TTemplateMiddle {
+						braces = append(braces, true)
+					}
+				}
+			}
+		case js_lexer.TDebugger:
+			// not the property names like `obj.debugger` and `{ debugger: 1 }`
+			if prev != js_lexer.TDot && prev != js_lexer.TQuestionDot {
+				r := lexer.Range()
+				lexer.Next()
+				if lexer.Token != js_lexer.TColon && lexer.Token != js_lexer.TOpenParen {
+					copy(buf[r.Loc.Start:r.End()], ";"+strings.Repeat(" ", int(r.Len)-1))
+					ok = true
+				}
+				prev = token
+				continue
+			}
+		}
+		prev = token
+		lexer.Next()
+	}
+	if !ok {
+		return code, false
+	}
+	return string(buf), true
+}
+
+// isRegExpStart reports whether the `/` after the token starts a regular expression instead of
+// the division.
+func isRegExpStart(prev js_lexer.T) bool {
+	switch prev {
+	case js_lexer.TIdentifier, js_lexer.TNumericLiteral, js_lexer.TBigIntegerLiteral, js_lexer.TStringLiteral,
+		js_lexer.TNoSubstitutionTemplateLiteral, js_lexer.TTemplateTail, js_lexer.TCloseParen, js_lexer.TCloseBracket,
+		js_lexer.TPlusPlus, js_lexer.TMinusMinus, js_lexer.TThis, js_lexer.TSuper, js_lexer.TNull, js_lexer.TTrue,
+		js_lexer.TFalse, js_lexer.TPrivateIdentifier:
+		return false
+	}
+	return true
+}
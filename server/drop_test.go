@@ -0,0 +1,60 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseDropQuery(t *testing.T) {
+	drop, err := parseDropQuery("debugger, console,debugger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(drop, ",") != "console,debugger" {
+		t.Fatalf("unexpected drop %v", drop)
+	}
+	if _, err := parseDropQuery("console,alert"); err == nil {
+		t.Fatal("'alert' should be invalid")
+	}
+}
+
+func TestDropDebugger(t *testing.T) {
+	for _, c := range []struct {
+		code   string
+		expect string
+	}{
+		{"debugger;", ";       ;"},
+		{"function f() { debugger }", "function f() { ;        }"},
+		{"if (x) debugger\nfoo()", "if (x) ;       \nfoo()"},
+		{"a = b / c; debugger", "a = b / c; ;       "},
+		{"x = `${`${a / b}`}` / 2; debugger", "x = `${`${a / b}`}` / 2; ;       "},
+		{"o.debugger(); o?.debugger; ({ debugger: 1, debugger() {} })", ""},
+		{`s = "debugger"; r = /debugger/g; // debugger`, ""},
+		{"let a = <div>debugger</div>", ""},
+	} {
+		code, ok := dropDebugger(c.code)
+		if c.expect == "" {
+			if ok || code != c.code {
+				t.Fatalf("'%s' should be kept, got '%s'", c.code, code)
+			}
+			continue
+		}
+		if !ok || code != c.expect {
+			t.Fatalf("unexpected output of '%s': '%s'", c.code, code)
+		}
+	}
+}
+
+func TestDropBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-drop@1.0.0"), cjsExports: "auto", target: "es2020", drop: []string{"console", "debugger"}}
+	if !strings.Contains(task.ID(), "/drop=console,debugger/es2020/") {
+		t.Fatalf("the drop should be in the build ID: %s", task.ID())
+	}
+	code := buildFixture(t, task)
+	if strings.Contains(code, "console") || !regexp.MustCompile(`function \w+\(\w+,\w+\)\{return \w+\+\w+\}`).MatchString(code) || !strings.Contains(code, "kept") {
+		t.Fatalf("the console and debugger statements should be dropped:\n%s", code)
+	}
+}
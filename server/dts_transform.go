@@ -3,10 +3,11 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
-	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -20,25 +21,45 @@ var (
 	regImportCallExpr = regexp.MustCompile(`import\((('[^']+')|("[^"]+"))\)`)
 	regReferenceTag   = regexp.MustCompile(`^<reference\s+(path|types)\s*=\s*('|")([^'"]+)("|')\s*/>$`)
 	regDeclareModule  = regexp.MustCompile(`^declare\s+module\s*('|")([^'"]+)("|')`)
+	regLibReference   = regexp.MustCompile(`(?m)^\s*///\s*<reference\s+lib\s*=\s*('|")([^'"]+)("|')\s*/>[ \t]*$`)
 )
 
-func copyDTS(nodeModulesDir string, dts string) (err error) {
-	dtsFilePath := path.Join(nodeModulesDir, regVersionPath.ReplaceAllString(dts, "$1/"))
+// the libs that are declared by the deno runtime
+var denoLibs = map[string]bool{
+	"deno.ns":       true,
+	"deno.window":   true,
+	"deno.worker":   true,
+	"deno.unstable": true,
+}
+
+// the dom libs that are missing in the deno runtime
+var domLibs = map[string]bool{
+	"dom":          true,
+	"dom.iterable": true,
+	"webworker":    true,
+}
+
+func (s *Server) copyDTS(ctx context.Context, nodeModulesDir string, dts string) (err error) {
+	// stop copying the deep types tree if the build is timed out
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	dtsFilePath := filepath.Join(nodeModulesDir, regVersionPath.ReplaceAllString(dts, "$1/"))
 	dtsDir := path.Dir(dtsFilePath)
 	dtsFile, err := os.Open(dtsFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			log.Warnf("copyDTS(%s): %v", dts, err)
+			s.log.Warnf("copyDTS(%s): %v", dts, err)
 			err = nil
 		} else if strings.HasSuffix(err.Error(), "is a directory") {
-			log.Warnf("copyDTS(%s): %v", dts, err)
+			s.log.Warnf("copyDTS(%s): %v", dts, err)
 			err = nil
 		}
 		return
 	}
 	defer dtsFile.Close()
 
-	saveFilePath := path.Join(config.storageDir, fmt.Sprintf("types/v%d", VERSION), dts)
+	saveFilePath := filepath.Join(s.config.storageDir, fmt.Sprintf("types/v%d", VERSION), dts)
 	fi, err := os.Lstat(saveFilePath)
 	if err == nil {
 		if fi.IsDir() {
@@ -93,12 +114,12 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 				subpath = s
 			}
 			var p NpmPackage
-			packageJSONFile := path.Join(nodeModulesDir, pkgName, "package.json")
+			packageJSONFile := filepath.Join(nodeModulesDir, pkgName, "package.json")
 			if fileExists(packageJSONFile) {
 				utils.ParseJSONFile(packageJSONFile, &p)
 			}
 			if p.Name == "" || (p.Types == "" && p.Typings == "") {
-				packageJSONFile = path.Join(nodeModulesDir, "@types", pkgName, "package.json")
+				packageJSONFile = filepath.Join(nodeModulesDir, "@types", pkgName, "package.json")
 				if fileExists(packageJSONFile) {
 					utils.ParseJSONFile(packageJSONFile, &p)
 				}
@@ -106,12 +127,12 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 			if p.Name != "" {
 				importPath = getTypesPath(nodeModulesDir, p, subpath)
 			} else {
-				p, _, err := node.getPackageInfo("@types/"+pkgName, "latest")
+				p, _, err := s.getPackageInfo("@types/"+pkgName, "latest")
 				if err != nil && err.Error() == fmt.Sprintf("npm: package '%s' not found", pkgName) {
-					p, _, err = node.getPackageInfo(pkgName, "latest")
+					p, _, err = s.getPackageInfo(pkgName, "latest")
 				}
 				if err == nil {
-					err = yarnAdd(fmt.Sprintf("%s@%s", p.Name, p.Version))
+					err = s.installPackages(ctx, fmt.Sprintf("%s@%s", p.Name, p.Version))
 					if err == nil {
 						importPath = getTypesPath(nodeModulesDir, p, subpath)
 					}
@@ -125,7 +146,7 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 		if strings.HasPrefix(importPath, "/") {
 			importPath = fmt.Sprintf("/v%d%s", VERSION, importPath)
 		}
-		return importPath
+		return escapePath(importPath)
 	}
 
 	buf := bytes.NewBuffer(nil)
@@ -162,9 +183,9 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 			pure = pure[i:]
 			goto Re
 		} else if strings.HasPrefix(pure, "///") {
-			s := strings.TrimSpace(strings.TrimPrefix(pure, "///"))
-			if regReferenceTag.MatchString(s) {
-				a := regReferenceTag.FindAllStringSubmatch(s, 1)
+			ref := strings.TrimSpace(strings.TrimPrefix(pure, "///"))
+			if regReferenceTag.MatchString(ref) {
+				a := regReferenceTag.FindAllStringSubmatch(ref, 1)
 				format := a[0][1]
 				path := a[0][3]
 				if format == "path" {
@@ -179,10 +200,10 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 						path = rewriteFn(path)
 					}
 					protocol := "https:"
-					if config.domain == "localhost" {
+					if s.config.domain == "localhost" {
 						protocol = "http:"
 					}
-					fmt.Fprintf(buf, `/// <reference path="%s//%s%s" />`, protocol, config.domain, path)
+					fmt.Fprintf(buf, `/// <reference path="%s//%s%s" />`, protocol, s.config.domain, path)
 				} else {
 					fmt.Fprintf(buf, `/// <reference path="%s" />`, rewriteFn(path))
 				}
@@ -201,8 +222,8 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 			if len(a) == 3 && strings.HasPrefix(dts, a[1]) {
 				buf.WriteString(a[0])
 				buf.WriteString(q)
-				newname := fmt.Sprintf("https://%s/%s", config.domain, a[1])
-				if config.domain == "localhost" {
+				newname := fmt.Sprintf("https://%s/%s", s.config.domain, a[1])
+				if s.config.domain == "localhost" {
 					newname = fmt.Sprintf("http://localhost/%s", a[1])
 				}
 				buf.WriteString(newname)
@@ -340,17 +361,11 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 		}
 	}
 
-	ensureDir(path.Dir(saveFilePath))
-	saveFile, err := os.Create(saveFilePath)
-	if err != nil {
-		return
-	}
-
-	_, err = io.Copy(saveFile, buf)
-	saveFile.Close()
+	err = s.writeStorageFile(saveFilePath, buf)
 	if err != nil {
 		return
 	}
+	buildLogs.Dir(filepath.Dir(nodeModulesDir)).Printf("dts: %s copied", dts)
 
 	for _, dep := range deps.Values() {
 		if isFileImportPath(dep) {
@@ -360,12 +375,12 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 					n, _ := utils.SplitByFirstByte(subpath, '/')
 					pkg = fmt.Sprintf("%s/%s", pkg, n)
 				}
-				err = copyDTS(nodeModulesDir, path.Join(pkg, dep))
+				err = s.copyDTS(ctx, nodeModulesDir, path.Join(pkg, dep))
 			} else {
-				err = copyDTS(nodeModulesDir, path.Join(path.Dir(dts), dep))
+				err = s.copyDTS(ctx, nodeModulesDir, path.Join(path.Dir(dts), dep))
 			}
 		} else {
-			err = copyDTS(nodeModulesDir, dep)
+			err = s.copyDTS(ctx, nodeModulesDir, dep)
 		}
 		if err != nil {
 			os.Remove(saveFilePath)
@@ -376,12 +391,37 @@ func copyDTS(nodeModulesDir string, dts string) (err error) {
 	return
 }
 
+// rewriteLibReferences rewrites the `/// <reference lib="..." />` tags of a copied dts file
+// for the target: deno gets the dom shim instead of the dom libs, others drop the deno libs.
+func (s *Server) rewriteLibReferences(data []byte, target string) []byte {
+	shimAdded := false
+	return regLibReference.ReplaceAllFunc(data, func(tag []byte) []byte {
+		lib := strings.ToLower(string(regLibReference.FindSubmatch(tag)[2]))
+		if target == "deno" {
+			if domLibs[lib] {
+				if shimAdded {
+					return []byte{}
+				}
+				shimAdded = true
+				protocol := "https:"
+				if s.config.domain == "localhost" {
+					protocol = "http:"
+				}
+				return []byte(fmt.Sprintf(`/// <reference path="%s//%s/v%d/_dom.shim.d.ts" />`, protocol, s.config.domain, VERSION))
+			}
+		} else if denoLibs[lib] {
+			return []byte{}
+		}
+		return tag
+	})
+}
+
 func getTypesPath(nodeModulesDir string, p NpmPackage, subpath string) string {
 	var types string
 	if subpath != "" {
 		var subpkg NpmPackage
 		var subtypes string
-		subpkgJSONFile := path.Join(nodeModulesDir, p.Name, subpath, "package.json")
+		subpkgJSONFile := filepath.Join(nodeModulesDir, p.Name, subpath, "package.json")
 		if fileExists(subpkgJSONFile) && utils.ParseJSONFile(subpkgJSONFile, &subpkg) == nil {
 			if subpkg.Types != "" {
 				subtypes = subpkg.Types
@@ -422,3 +462,40 @@ func onSemicolon(data []byte, atEOF bool) (advance int, token []byte, err error)
 	// but does not trigger an error to be returned from Scan itself.
 	return 0, data, bufio.ErrFinalToken
 }
+
+// packageHasTypes checks whether the package has the types or the `@types` package.
+func (s *Server) packageHasTypes(name string, version string) (bool, error) {
+	info, _, err := s.getPackageInfo(name, version)
+	if err != nil {
+		return false, err
+	}
+	if info.Types != "" || info.Typings != "" {
+		return true, nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return false, nil
+	}
+	_, _, err = s.getPackageInfo("@types/"+name, "latest")
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// synthesizeDTS creates an any-typed declaration for the package without types.
+func synthesizeDTS(pkg pkg) []byte {
+	name := pkg.name
+	if pkg.submodule != "" {
+		name += "/" + strings.TrimSuffix(strings.TrimSuffix(pkg.submodule, ".d.ts"), "/index")
+	}
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "/* esm.sh - synthesized types of %s@%s (no types found) */\n", pkg.name, pkg.version)
+	fmt.Fprintf(buf, "declare module \"%s\" {\n", name)
+	buf.WriteString("  const mod: any;\n")
+	buf.WriteString("  export = mod;\n")
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
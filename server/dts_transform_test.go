@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,12 +11,14 @@ import (
 )
 
 func TestCopyDTS(t *testing.T) {
+	s := setupNpmTestEnv(t)
+
 	testDir := path.Join(os.TempDir(), "testcopydts")
 	nmDir := path.Join(testDir, "node_modules")
 	os.RemoveAll(testDir)
 	ensureDir(testDir)
 
-	err := yarnAdd(testDir, "@types/react@17.0.0")
+	err := s.installPackages(context.Background(), testDir, "@types/react@17.0.0")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,11 +93,11 @@ func TestCopyDTS(t *testing.T) {
 		}
 	}
 
-	config = &Config{
+	s.config = &Config{
 		storageDir: testDir,
 		domain:     "cdn.esm.sh",
 	}
-	err = copyDTS(nmDir, "test/index.d.ts")
+	err = s.copyDTS(context.Background(), nmDir, "test/index.d.ts")
 	if err != nil && os.IsExist(err) {
 		t.Fatal(err)
 	}
@@ -108,3 +111,49 @@ func TestCopyDTS(t *testing.T) {
 		t.Fatal("unexpected index.d.ts", string(data))
 	}
 }
+
+func TestRewriteLibReferences(t *testing.T) {
+	s := &Server{config: &Config{domain: "cdn.esm.sh"}}
+	dts := strings.Join([]string{
+		`/// <reference lib="dom" />`,
+		`/// <reference lib="dom.iterable" />`,
+		`/// <reference lib="deno.ns" />`,
+		`/// <reference lib="es2017" />`,
+		`export declare function render(el: HTMLElement): void;`,
+	}, "\n")
+
+	denoExcept := strings.Join([]string{
+		fmt.Sprintf(`/// <reference path="https://cdn.esm.sh/v%d/_dom.shim.d.ts" />`, VERSION),
+		``,
+		`/// <reference lib="deno.ns" />`,
+		`/// <reference lib="es2017" />`,
+		`export declare function render(el: HTMLElement): void;`,
+	}, "\n")
+	if ret := string(s.rewriteLibReferences([]byte(dts), "deno")); ret != denoExcept {
+		t.Fatalf("unexpected deno dts:\n%s", ret)
+	}
+
+	browserExcept := strings.Join([]string{
+		`/// <reference lib="dom" />`,
+		`/// <reference lib="dom.iterable" />`,
+		``,
+		`/// <reference lib="es2017" />`,
+		`export declare function render(el: HTMLElement): void;`,
+	}, "\n")
+	if ret := string(s.rewriteLibReferences([]byte(dts), "es2020")); ret != browserExcept {
+		t.Fatalf("unexpected browser dts:\n%s", ret)
+	}
+}
+
+func TestSynthesizeDTS(t *testing.T) {
+	s := setupTestEnv(t)
+
+	hasTypes, err := s.packageHasTypes("esm-fixture-esm", "1.0.0")
+	if err != nil || hasTypes {
+		t.Fatalf("esm-fixture-esm has no types: %v", err)
+	}
+	dts := string(synthesizeDTS(pkg{name: "esm-fixture-esm", version: "1.0.0", submodule: "lib/index.d.ts"}))
+	if !strings.HasPrefix(dts, "/* esm.sh - synthesized types of esm-fixture-esm@1.0.0") || !strings.Contains(dts, `declare module "esm-fixture-esm/lib" {`) {
+		t.Fatalf("unexpected types:\n%s", dts)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	logx "github.com/ije/gox/log"
+	"github.com/postui/postdb"
+)
+
+// setupTestEnv returns a server with a temporary storage and the fixture registry.
+func setupTestEnv(t *testing.T) *Server {
+	_, s := newTestServer(t)
+	return s
+}
+
+// newTestServer returns a server of the fixture registry, the server is closed after the test.
+func newTestServer(t *testing.T) (*fixtureRegistry, *Server) {
+	dir, err := ioutil.TempDir("", "esm-e2e-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	registry := newFixtureRegistry(t)
+	config := &Config{storageDir: filepath.Join(dir, "storage"), domain: "esm.sh", legalComments: "eof"}
+	s := &Server{
+		config:     config,
+		log:        &logx.Logger{},
+		node:       &NodeEnv{npmRegistry: registry.URL + "/"},
+		embedFS:    &embed.FS{},
+		httpClient: newHTTPClient(config),
+	}
+	s.purgeJobs = newPurgeJobTracker(s)
+	s.db, err = postdb.Open(filepath.Join(dir, "esm.db"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return registry, s
+}
+
+// setupNpmTestEnv returns a server with a temporary storage and the public npm registry.
+func setupNpmTestEnv(t *testing.T) *Server {
+	s := setupTestEnv(t)
+	s.node.npmRegistry = "https://registry.npmjs.org/"
+	return s
+}
+
+// fixturePkg parses the package of the fixture registry.
+func fixturePkg(t *testing.T, s *Server, spec string) pkg {
+	t.Helper()
+	p, err := s.parsePkg(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *p
+}
+
+// buildFixture builds the task and returns the JS of the build.
+func buildFixture(t *testing.T, task *buildTask) string {
+	t.Helper()
+	_, _, err := task.buildESM(context.Background())
+	if err != nil {
+		t.Fatalf("build %s: %v", task.ID(), err)
+	}
+	return readBuild(t, task.Server, task.ID()+".js")
+}
+
+// readBuild reads the build artifact in the storage.
+func readBuild(t *testing.T, s *Server, name string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(s.config.storageDir, "builds", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFixtureRegistry(t *testing.T) {
+	s := setupTestEnv(t)
+
+	info, _, err := s.getPackageInfo("esm-fixture-esm", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.0.0" || info.Type != "module" || info.Dependencies["esm-fixture-dep"] != "^1.0.0" {
+		t.Fatalf("unexpected package info: %+v", info)
+	}
+	_, _, err = s.getPackageInfo("@types/esm-fixture-esm", "latest")
+	if err == nil || !strings.HasSuffix(err.Error(), "not found") {
+		t.Fatalf("unknown packages should not be found: %v", err)
+	}
+}
+
+// TestE2EBuild builds the fixture packages, the test requires yarn(and the network to install
+// the cjs-module-lexer).
+func TestE2EBuild(t *testing.T) {
+	if _, err := exec.LookPath("yarn"); err != nil {
+		t.Skip("yarn not found")
+	}
+	s := setupTestEnv(t)
+
+	depURL := fmt.Sprintf("/v%d/esm-fixture-dep@1.0.0/es2020/esm-fixture-dep.js", VERSION)
+	for _, c := range []struct {
+		pkg      string
+		isDev    bool
+		bundle   bool
+		exports  []string
+		contains []string
+		excludes []string
+	}{
+		{
+			pkg:      "esm-fixture-esm@1.0.0",
+			exports:  []string{"esm", "default"},
+			contains: []string{`from"` + depURL + `"`},
+		},
+		{
+			pkg:      "esm-fixture-cjs@1.0.0",
+			contains: []string{`import __esm_fixture_dep$ from "` + depURL + `"`, `"production"`, `'require("esm-fixture-dep")'`},
+		},
+		{
+			pkg:      "esm-fixture-cjs@1.0.0",
+			isDev:    true,
+			contains: []string{`"development"`},
+		},
+		{
+			pkg:      "esm-fixture-esm@1.0.0",
+			bundle:   true,
+			contains: []string{`"dep"`},
+			excludes: []string{depURL},
+		},
+	} {
+		task := &buildTask{Server: s, pkg: fixturePkg(t, s, c.pkg), cjsExports: "auto", target: "es2020", isDev: c.isDev, bundle: c.bundle}
+		esm, _, err := task.buildESM(context.Background())
+		if err != nil {
+			t.Fatalf("build %s: %v", task.ID(), err)
+		}
+		for _, name := range c.exports {
+			if !includes(esm.Exports, name) {
+				t.Fatalf("build %s: missing export %s in %v", task.ID(), name, esm.Exports)
+			}
+		}
+		code := readBuild(t, s, task.ID()+".js")
+		if strings.Contains(code, "esm_sh_external") {
+			t.Fatalf("build %s: unresolved external imports:\n%s", task.ID(), code)
+		}
+		for _, text := range c.contains {
+			if !strings.Contains(code, text) {
+				t.Fatalf("build %s: missing %s in:\n%s", task.ID(), text, code)
+			}
+		}
+		for _, text := range c.excludes {
+			if strings.Contains(code, text) {
+				t.Fatalf("build %s: unexpected %s in:\n%s", task.ID(), text, code)
+			}
+		}
+	}
+}
@@ -2,7 +2,7 @@ package server
 
 import (
 	"encoding/json"
-	"path"
+	"path/filepath"
 
 	"github.com/postui/postdb/q"
 )
@@ -10,26 +10,47 @@ import (
 // ESMeta defines the ES Module meta
 type ESMeta struct {
 	*NpmPackage
-	Exports []string `json:"exports"`
-	Dts     string   `json:"dts"`
+	Exports    []string `json:"exports"`
+	CJSExports string   `json:"cjsExports,omitempty"`
+	Dts        string   `json:"dts"`
+	// the `@types/*` package that the types come from, like "@types/react@17.0.3"
+	TypesSource string `json:"typesSource,omitempty"`
+	// the kinds of the top-level side effects, like "network" and "dom"
+	TopLevelSideEffects []string `json:"topLevelSideEffects,omitempty"`
+	// the report of the `?exports=` build
+	Treeshake *TreeshakeReport `json:"treeshake,omitempty"`
+	// the NODE_ENV has no effect on the build, the development build is aliased to the production build
+	NodeEnvFree bool `json:"nodeEnvFree,omitempty"`
+	// the largest packages bundled in the build
+	Contributors []BundleContributor `json:"contributors,omitempty"`
+	// the hash of the polyfill set that the build is built with
+	PolyfillsHash string `json:"polyfillsHash,omitempty"`
 }
 
-func findESM(id string) (esm *ESMeta, pkgCSS bool, ok bool) {
-	post, err := db.Get(q.Alias(id), q.K("esmeta", "css"))
+func (s *Server) findESM(id string) (esm *ESMeta, pkgCSS bool, ok bool) {
+	post, err := s.db.Get(q.Alias(id), q.K("esmeta", "css"))
 	if err == nil {
 		err = json.Unmarshal(post.KV.Get("esmeta"), &esm)
 		if err != nil {
-			db.Delete(q.Alias(id))
+			s.db.Delete(q.Alias(id))
 			return
 		}
 
-		if !fileExists(path.Join(config.storageDir, "builds", id+".js")) {
-			db.Delete(q.Alias(id))
+		// the development alias of a production build is dangling after the production
+		// build is evicted
+		if !targetExists(filepath.Join(s.config.storageDir, "builds", id+".js")) {
+			s.db.Delete(q.Alias(id))
+			return
+		}
+
+		// the stale build is rebuilt transparently, the record is updated by the rebuild
+		if s.polyfillsChanged(esm) {
+			s.log.Debugf("the polyfills of %s are changed, rebuild it", id)
 			return
 		}
 
 		if val := post.KV.Get("css"); len(val) == 1 && val[0] == 1 {
-			pkgCSS = fileExists(path.Join(config.storageDir, "builds", id+".css"))
+			pkgCSS = targetExists(filepath.Join(s.config.storageDir, "builds", id+".css"))
 		}
 		ok = true
 	}
@@ -2,12 +2,16 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ije/esbuild-internal/js_ast"
@@ -17,114 +21,146 @@ import (
 	"github.com/ije/gox/utils"
 )
 
-var cjsModuleLexerAppDir string
+// the cjs-module-lexer app dir is shared by the servers of the process
+var cjsModuleLexerDirLock sync.Mutex
+
+var regJSIdentifier = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$]*$`)
+
+// copy from https://developer.mozilla.org/en-US/docs/Web/JavaScript/Reference/Lexical_grammar#keywords
+var jsReservedWords = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "enum": true,
+	"export": true, "extends": true, "false": true, "finally": true, "for": true, "function": true,
+	"if": true, "import": true, "in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true, "throw": true, "true": true,
+	"try": true, "typeof": true, "var": true, "void": true, "while": true, "with": true,
+	"yield": true, "let": true, "static": true, "implements": true, "interface": true,
+	"package": true, "private": true, "protected": true, "public": true, "await": true,
+}
 
 type cjsModuleLexerResult struct {
 	Exports []string `json:"exports"`
 	Error   string   `json:"error"`
 }
 
-func parseCJSModuleExports(buildDir string, importPath string) (ret cjsModuleLexerResult, err error) {
-	if cjsModuleLexerAppDir == "" {
-		cjsModuleLexerAppDir = path.Join(os.TempDir(), "esmd-cjs-module-lexer")
-		ensureDir(cjsModuleLexerAppDir)
-		cmd := exec.Command("yarn", "add", "cjs-module-lexer", "enhanced-resolve")
-		cmd.Dir = cjsModuleLexerAppDir
-		var output []byte
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			err = fmt.Errorf("yarn: %s", string(output))
-			return
-		}
+// installCJSModuleLexer installs the cjs-module-lexer app once and creates the pool of its
+// workers, the concurrent builds wait for the installation, and a failed installation is
+// retried by the next build.
+func (s *Server) installCJSModuleLexer() (pool *nodeWorkerPool, err error) {
+	s.cjsModuleLexer.Lock()
+	defer s.cjsModuleLexer.Unlock()
+
+	if s.cjsModuleLexer.pool != nil {
+		return s.cjsModuleLexer.pool, nil
+	}
+	cjsModuleLexerDirLock.Lock()
+	defer cjsModuleLexerDirLock.Unlock()
+
+	dir := filepath.Join(os.TempDir(), "esmd-cjs-module-lexer")
+	err = ensureDir(dir)
+	if err != nil {
+		return
+	}
+	_, output, err := s.runProc(context.Background(), procOptions{Dir: dir}, "yarn", "add", "cjs-module-lexer", "enhanced-resolve")
+	if err != nil {
+		err = fmt.Errorf("yarn: %s", string(output))
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "worker.js"), []byte(cjsModuleLexerWorkerScript), 0644)
+	if err != nil {
+		return
+	}
+	s.cjsModuleLexer.pool = newNodeWorkerPool(s.config, s.config.cjsLexerWorkers, dir, time.Minute, "node", "worker.js")
+	return s.cjsModuleLexer.pool, nil
+}
+
+// stopCJSModuleLexer kills the idle workers of the cjs-module-lexer.
+func (s *Server) stopCJSModuleLexer() {
+	s.cjsModuleLexer.Lock()
+	defer s.cjsModuleLexer.Unlock()
+
+	if s.cjsModuleLexer.pool != nil {
+		s.cjsModuleLexer.pool.close()
+	}
+}
+
+// parseCJSModuleExports parses the exports of the commonjs module by the pooled cjs-module-lexer
+// workers, the re-exports are followed.
+func (s *Server) parseCJSModuleExports(ctx context.Context, buildDir string, importPath string) (ret cjsModuleLexerResult, err error) {
+	pool, err := s.installCJSModuleLexer()
+	if err != nil {
+		return
 	}
 
 	start := time.Now()
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(fmt.Sprintf(`
-		const fs = require('fs')
-		const { dirname, join } = require('path')
-		const { promisify } = require('util')
-		const moduleLexer = require('cjs-module-lexer')
-		const enhancedResolve = require('enhanced-resolve')
-
-		const resolve = promisify(enhancedResolve.create({
-			mainFields: ['browser', 'module', 'main']
-		}))
-
-		// the function 'getExports' is copied from https://github.com/evanw/esbuild/issues/442#issuecomment-739340295
-		async function getExports () {
-			await moduleLexer.init()
-
-			const exports = []
-			const paths = []
-
-			try {
-				paths.push(await resolve('%s', '%s')) 
-				while (paths.length > 0) {
-					const currentPath = paths.pop()
-					const code = fs.readFileSync(currentPath).toString()
-					const results = moduleLexer.parse(code)
-					exports.push(...results.exports)
-					for (const reexport of results.reexports) {
-						paths.push(await resolve(dirname(currentPath), reexport))
-					}
-				}
-				return { exports }
-			} catch(e) {
-				return { error: e.message }
-			}
+	err = pool.call(ctx, map[string]string{"buildDir": buildDir, "importPath": importPath}, &ret)
+	if err != nil {
+		return
+	}
+
+	s.log.Debug("run cjs-module-lexer in", time.Now().Sub(start))
+	return
+}
+
+// evalCJSModuleExports evaluates the commonjs module in nodejs to get the keys of `module.exports`,
+// the module is evaluated in a subprocess that will be killed after 10 seconds. The package code
+// runs with the access of the server, it's only used with the `-eval-cjs-exports` flag.
+func (s *Server) evalCJSModuleExports(ctx context.Context, buildDir string, importPath string) (exports []string, err error) {
+	start := time.Now()
+	script := fmt.Sprintf(`
+		const m = require(require.resolve(%s, { paths: [%s] }))
+		if (m !== null && (typeof m === 'object' || typeof m === 'function')) {
+			console.log(JSON.stringify(Object.keys(m)))
+		} else {
+			console.log('[]')
 		}
+		process.exit(0)
+	`, utils.MustEncodeJSON(importPath), utils.MustEncodeJSON(buildDir))
 
-		getExports().then(exports => {
-			const saveDir = join('%s', '%s')
-			if (!fs.existsSync(saveDir)){
-				fs.mkdirSync(saveDir, {recursive: true});
-			}
-			fs.writeFileSync(join(saveDir, '__exports.json'), JSON.stringify(exports))
-			process.exit(0)
-		})
-	`, buildDir, importPath, buildDir, importPath))
-
-	cmd := exec.Command("node")
-	cmd.Stdin = buf
-	cmd.Dir = cjsModuleLexerAppDir
-	output, e := cmd.CombinedOutput()
+	output, _, e := s.runProc(ctx, procOptions{Dir: buildDir, Timeout: 10 * time.Second}, "node", "-e", script)
 	if e != nil {
-		err = fmt.Errorf("nodejs: %s", string(output))
+		err = fmt.Errorf("evalCJSModuleExports(%s): %v", importPath, e)
 		return
 	}
 
-	err = utils.ParseJSONFile(path.Join(buildDir, importPath, "__exports.json"), &ret)
+	var keys []string
+	lines := bytes.Split(bytes.TrimSpace(output), []byte{'\n'})
+	err = json.Unmarshal(lines[len(lines)-1], &keys)
 	if err != nil {
+		err = fmt.Errorf("evalCJSModuleExports(%s): %v", importPath, err)
 		return
 	}
-
-	log.Debug("run cjs-module-lexer in", time.Now().Sub(start))
+	for _, key := range keys {
+		if regJSIdentifier.MatchString(key) && !jsReservedWords[key] && key != "__esModule" {
+			exports = append(exports, key)
+		}
+	}
+	s.log.Debug("eval cjs module exports in", time.Now().Sub(start))
 	return
 }
 
-func parseESModuleExports(buildDir string, importPath string) (exports []string, esm bool, err error) {
-	var filepath string
+func (s *Server) parseESModuleExports(ctx context.Context, buildDir string, importPath string) (exports []string, esm bool, err error) {
+	var filename string
 	var isImportDir bool
-	nmDir := path.Join(buildDir, "node_modules")
+	nmDir := filepath.Join(buildDir, "node_modules")
 	if path.IsAbs(importPath) {
-		filepath = importPath
+		filename = importPath
 	} else {
-		fi, e := os.Lstat(path.Join(nmDir, importPath))
+		fi, e := os.Lstat(filepath.Join(nmDir, importPath))
 		isImportDir = e == nil && fi.IsDir()
 		if isImportDir {
-			filepath = path.Join(nmDir, importPath, "index.mjs")
-			if !fileExists(filepath) {
-				filepath = path.Join(nmDir, importPath, "index.js")
+			filename = filepath.Join(nmDir, importPath, "index.mjs")
+			if !fileExists(filename) {
+				filename = filepath.Join(nmDir, importPath, "index.js")
 			}
 		} else {
-			filepath = path.Join(nmDir, importPath)
-			if !strings.HasSuffix(filepath, ".js") && !strings.HasSuffix(filepath, ".mjs") {
-				filepath = filepath + ".js"
+			filename = filepath.Join(nmDir, importPath)
+			if !strings.HasSuffix(filename, ".js") && !strings.HasSuffix(filename, ".mjs") {
+				filename = filename + ".js"
 			}
 		}
 	}
-	data, err := ioutil.ReadFile(filepath)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return
 	}
@@ -142,20 +178,18 @@ func parseESModuleExports(buildDir string, importPath string) (exports []string,
 					} else {
 						p = path.Join(path.Dir(importPath), src)
 					}
-					a, ok, e := parseESModuleExports(buildDir, p)
+					a, ok, e := s.parseESModuleExports(ctx, buildDir, p)
 					if e != nil {
 						err = e
 						return
 					}
-					if ok {
-						for _, name := range a {
-							if name != "default" {
-								exports = append(exports, name)
-							}
-						}
+					if !ok && !path.IsAbs(p) {
+						// export * from a commonjs file
+						a = s.parseReexportedCJSModuleExports(ctx, buildDir, p)
 					}
+					exports = appendStarExports(exports, a)
 				} else {
-					pkgFile := path.Join(nmDir, src, "package.json")
+					pkgFile := filepath.Join(nmDir, src, "package.json")
 					if fileExists(pkgFile) {
 						var p NpmPackage
 						err = utils.ParseJSONFile(pkgFile, &p)
@@ -165,39 +199,60 @@ func parseESModuleExports(buildDir string, importPath string) (exports []string,
 						if p.Module == "" && p.Type == "module" {
 							p.Module = p.Main
 						}
-						if p.Module == "" && p.DefinedExports != nil {
-							v, ok := p.DefinedExports.(map[string]interface{})
-							if ok {
-								m, ok := v["import"]
-								if ok {
-									s, ok := m.(string)
-									if ok && s != "" {
-										p.Module = s
-									}
-								}
+						if exports, ok := readExportsField(filepath.Dir(pkgFile)); ok {
+							if s, ok := resolveExports(exports, ".", entryConditions); ok {
+								p.Module = s
 							}
 						}
+						var a []string
+						var ok bool
 						if p.Module != "" {
-							a, ok, e := parseESModuleExports(buildDir, path.Join(src, p.Module))
+							var e error
+							a, ok, e = s.parseESModuleExports(ctx, buildDir, path.Join(src, p.Module))
 							if e != nil {
 								err = e
 								return
 							}
-							if ok {
-								for _, name := range a {
-									if name != "default" {
-										exports = append(exports, name)
-									}
-								}
-							}
 						}
+						if !ok {
+							// export * from a commonjs package, the cjs-module-lexer follows
+							// the re-export chains (`module.exports = require(...)`) across packages
+							a = s.parseReexportedCJSModuleExports(ctx, buildDir, src)
+						}
+						exports = appendStarExports(exports, a)
 					}
 				}
 			}
 			for name := range ast.NamedExports {
-				exports = append(exports, name)
+				if !includes(exports, name) {
+					exports = append(exports, name)
+				}
 			}
 		}
 	}
 	return
 }
+
+func (s *Server) parseReexportedCJSModuleExports(ctx context.Context, buildDir string, importPath string) []string {
+	ret, err := s.parseCJSModuleExports(ctx, buildDir, importPath)
+	if err != nil {
+		s.log.Warnf("parseCJSModuleExports(%s): %v", importPath, err)
+		return nil
+	}
+	if ret.Error != "" {
+		s.log.Warnf("parseCJSModuleExports(%s): %s", importPath, ret.Error)
+		return nil
+	}
+	return ret.Exports
+}
+
+// appendStarExports appends the names of an `export * from` statement, the default export is not
+// included and the names that are exported already are skipped.
+func appendStarExports(exports []string, names []string) []string {
+	for _, name := range names {
+		if name != "default" && name != "__esModule" && !includes(exports, name) {
+			exports = append(exports, name)
+		}
+	}
+	return exports
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the conditions to resolve the entries of the builds, esbuild resolves the imports with the
+// conditions of the target at build time.
+var entryConditions = []string{"browser", "import", "module", "production", "default"}
+
+// the conditions to resolve the types, the javascript entry is used to find the sibling
+// `.d.ts` file if there is no `types` condition.
+var typesConditions = []string{"types", "typings", "browser", "import", "module", "default"}
+
+// An exportsObject is an object of the `exports` field, the keys are in the order of the
+// package.json since the first matched condition wins.
+type exportsObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// parseExportsField parses the `exports` field of the package.json, the objects are parsed as
+// `*exportsObject` to keep the order of the keys.
+func parseExportsField(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeExportsValue(dec)
+}
+
+func decodeExportsValue(dec *json.Decoder) (v interface{}, err error) {
+	t, err := dec.Token()
+	if err != nil {
+		return
+	}
+	switch t {
+	case json.Delim('{'):
+		obj := &exportsObject{values: map[string]interface{}{}}
+		for dec.More() {
+			t, err = dec.Token()
+			if err != nil {
+				return
+			}
+			key, ok := t.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid exports key %v", t)
+			}
+			var value interface{}
+			value, err = decodeExportsValue(dec)
+			if err != nil {
+				return
+			}
+			if _, ok := obj.values[key]; !ok {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = value
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		a := []interface{}{}
+		for dec.More() {
+			var value interface{}
+			value, err = decodeExportsValue(dec)
+			if err != nil {
+				return
+			}
+			a = append(a, value)
+		}
+		_, err = dec.Token()
+		return a, err
+	default:
+		return t, nil
+	}
+}
+
+// readExportsField reads the `exports` field of the package in the directory.
+func readExportsField(pkgDir string) (exports interface{}, ok bool) {
+	data, err := ioutil.ReadFile(filepath.Join(pkgDir, "package.json"))
+	if err != nil {
+		return
+	}
+	var p struct {
+		Exports json.RawMessage `json:"exports"`
+	}
+	if json.Unmarshal(data, &p) != nil || len(p.Exports) == 0 || string(p.Exports) == "null" {
+		return
+	}
+	exports, err = parseExportsField(p.Exports)
+	return exports, err == nil
+}
+
+// resolveExports resolves the subpath like `.` or `./feature` by the `exports` field with the
+// conditions, returns the path relative to the package directory like `dist/feature.mjs`. the
+// subpath patterns like `./*` and the legacy folder mappings like `./lib/` are supported.
+func resolveExports(exports interface{}, subpath string, conditions []string) (string, bool) {
+	obj, ok := exports.(*exportsObject)
+	if !ok || len(obj.keys) == 0 || !strings.HasPrefix(obj.keys[0], ".") {
+		// the sugar of the main entry, like `"exports": "./index.js"`
+		if subpath != "." {
+			return "", false
+		}
+		return resolveExportsTarget(exports, "", conditions)
+	}
+
+	value, match, rest, ok := matchSubpath(obj, subpath)
+	if !ok {
+		return "", false
+	}
+	target, ok := resolveExportsTarget(value, match, conditions)
+	if !ok || (rest != "" && !strings.HasSuffix(target, "/")) {
+		return "", false
+	}
+	return target + rest, true
+}
+
+// matchSubpath finds the value of the subpath in the map of the `exports` or `imports` field,
+// returns the matched part of the pattern key like `./utils/*`, or the rest of the subpath of
+// the folder key like `./lib/`.
+func matchSubpath(obj *exportsObject, subpath string) (value interface{}, match string, rest string, ok bool) {
+	if value, ok := obj.values[subpath]; ok && !strings.Contains(subpath, "*") {
+		return value, "", "", true
+	}
+
+	// the pattern with the longest prefix wins
+	keys := make([]string, 0, len(obj.keys))
+	for _, key := range obj.keys {
+		if strings.Count(key, "*") == 1 || strings.HasSuffix(key, "/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := patternPrefix(keys[i]), patternPrefix(keys[j])
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return len(keys[i]) > len(keys[j])
+	})
+	for _, key := range keys {
+		if i := strings.IndexByte(key, '*'); i >= 0 {
+			prefix, suffix := key[:i], key[i+1:]
+			if len(subpath) >= len(key) && strings.HasPrefix(subpath, prefix) && strings.HasSuffix(subpath, suffix) {
+				return obj.values[key], subpath[len(prefix) : len(subpath)-len(suffix)], "", true
+			}
+		} else if strings.HasPrefix(subpath, key) {
+			return obj.values[key], "", subpath[len(key):], true
+		}
+	}
+	return
+}
+
+func patternPrefix(key string) string {
+	if i := strings.IndexByte(key, '*'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// resolveExportsTarget resolves the target of the `exports` field, the `*` of the target is
+// replaced with the matched part of the subpath pattern.
+func resolveExportsTarget(target interface{}, match string, conditions []string) (string, bool) {
+	return resolveSubpathTarget(target, match, conditions, false)
+}
+
+// resolveSubpathTarget resolves the target of the `exports` or `imports` field, the targets of
+// the `imports` field can be the bare specifiers of the dependencies.
+func resolveSubpathTarget(target interface{}, match string, conditions []string, bare bool) (string, bool) {
+	switch v := target.(type) {
+	case string:
+		s := strings.ReplaceAll(v, "*", match)
+		if !strings.HasPrefix(v, "./") {
+			if bare && v != "" && !strings.HasPrefix(v, "/") && !strings.HasPrefix(v, "../") && !strings.HasPrefix(v, "#") {
+				return s, true
+			}
+			return "", false
+		}
+		// the targets out of the package are invalid
+		if p := path.Clean(s); p == ".." || strings.HasPrefix(p, "../") || strings.Contains(p, "/node_modules/") {
+			return "", false
+		}
+		if bare {
+			// keep the `./` prefix to tell the files from the bare specifiers
+			return s, true
+		}
+		return strings.TrimPrefix(s, "./"), true
+	case []interface{}:
+		for _, t := range v {
+			if s, ok := resolveSubpathTarget(t, match, conditions, bare); ok {
+				return s, true
+			}
+		}
+	case *exportsObject:
+		for _, key := range v.keys {
+			if key == "default" || includes(conditions, key) {
+				if s, ok := resolveSubpathTarget(v.values[key], match, conditions, bare); ok {
+					return s, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// resolveExportsTypes resolves the types of the subpath by the `exports` field of the package
+// in the directory, the sibling `.d.ts` file of the javascript entry is used if there is no
+// `types` condition.
+func resolveExportsTypes(pkgDir string, submodule string) (types string, ok bool) {
+	exports, ok := readExportsField(pkgDir)
+	if !ok {
+		return
+	}
+	subpath := "."
+	if submodule != "" {
+		subpath = "./" + submodule
+	}
+	entry, ok := resolveExports(exports, subpath, typesConditions)
+	if !ok {
+		return
+	}
+	// the dts transform only supports the `.d.ts` files
+	if !strings.HasSuffix(entry, ".d.ts") {
+		entry = strings.TrimSuffix(entry, path.Ext(entry)) + ".d.ts"
+	}
+	if !fileExists(filepath.Join(pkgDir, entry)) {
+		return "", false
+	}
+	return entry, true
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolveExports(t *testing.T) {
+	exports, err := parseExportsField([]byte(`{
+		".": {
+			"node": "./node.js",
+			"import": { "development": "./dev.mjs", "default": "./index.mjs" },
+			"require": "./index.cjs"
+		},
+		"./feature": [{ "worker": "./feature.worker.js" }, "./feature.js"],
+		"./utils/*": "./src/utils/*.js",
+		"./utils/internal/*": null,
+		"./lib/": "./dist/lib/",
+		"./*.json": "./*.json"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		subpath    string
+		conditions []string
+		target     string
+	}{
+		{".", entryConditions, "index.mjs"},
+		{".", []string{"import", "development"}, "dev.mjs"},
+		// the first matched condition in the order of the package.json wins
+		{".", []string{"import", "node"}, "node.js"},
+		{".", []string{"require"}, "index.cjs"},
+		{"./feature", entryConditions, "feature.js"},
+		{"./feature", []string{"worker"}, "feature.worker.js"},
+		{"./utils/format", entryConditions, "src/utils/format.js"},
+		{"./utils/internal/x", entryConditions, ""},
+		{"./lib/a/b.js", entryConditions, "dist/lib/a/b.js"},
+		{"./package.json", entryConditions, "package.json"},
+		{"./missing", entryConditions, ""},
+	} {
+		target, ok := resolveExports(exports, c.subpath, c.conditions)
+		if target != c.target || ok != (c.target != "") {
+			t.Fatalf("resolve %s %v: unexpected target '%s'", c.subpath, c.conditions, target)
+		}
+	}
+
+	for _, c := range []struct {
+		exports string
+		target  string
+	}{
+		{`"./index.js"`, "index.js"},
+		{`{ "import": "./index.mjs", "default": "./index.js" }`, "index.mjs"},
+		{`{ "require": "./index.cjs" }`, ""},
+		{`"../outside.js"`, ""},
+		{`"index.js"`, ""},
+	} {
+		exports, err := parseExportsField([]byte(c.exports))
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, _ := resolveExports(exports, ".", entryConditions)
+		if target != c.target {
+			t.Fatalf("resolve %s: unexpected target '%s'", c.exports, target)
+		}
+	}
+}
+
+func TestExportsMapBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	for _, c := range []struct {
+		pkg     string
+		module  string
+		exports []string
+	}{
+		{"esm-fixture-exports@1.0.0", "esm/index.mjs", []string{"name"}},
+		{"esm-fixture-exports@1.0.0/feature", "esm/feature.mjs", []string{"feature"}},
+		{"esm-fixture-exports@1.0.0/utils/format", "esm/utils/format.mjs", []string{"format"}},
+	} {
+		p := fixturePkg(t, s, c.pkg)
+		task := &buildTask{Server: s, pkg: p, cjsExports: "auto", target: "es2020"}
+		esm, _, err := task.buildESM(context.Background())
+		if err != nil {
+			t.Fatalf("build %s: %v", c.pkg, err)
+		}
+		if esm.Module != c.module {
+			t.Fatalf("build %s: unexpected module '%s'", c.pkg, esm.Module)
+		}
+		for _, name := range c.exports {
+			if !includes(esm.Exports, name) {
+				t.Fatalf("build %s: missing export %s in %v", c.pkg, name, esm.Exports)
+			}
+		}
+		if p.submodule == "" && esm.Dts != "/esm-fixture-exports@1.0.0/types/index.d.ts" {
+			t.Fatalf("build %s: unexpected types '%s'", c.pkg, esm.Dts)
+		}
+	}
+}
@@ -1,24 +1,35 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+
+	logx "github.com/ije/gox/log"
 )
 
 func TestParseCJSModuleExports(t *testing.T) {
+	s := setupNpmTestEnv(t)
+
 	testDir := path.Join(os.TempDir(), "test")
 	os.RemoveAll(testDir)
 	ensureDir(testDir)
 
-	err := yarnAdd(testDir, "react")
+	err := s.installPackages(context.Background(), testDir, "react")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	exports, err := parseCJSModuleExports(testDir, "react")
+	exports, err := s.parseCJSModuleExports(context.Background(), testDir, "react")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,6 +47,7 @@ func TestParseESModuleExports(t *testing.T) {
 		`} from 'react';`,
 	}
 
+	s := &Server{config: &Config{}, log: &logx.Logger{}}
 	tmpDir := os.TempDir()
 	ensureDir(path.Join(tmpDir, "node_modules"))
 	err := ioutil.WriteFile(path.Join(tmpDir, "node_modules", "react.js"), []byte(strings.Join(reactRaw, "\n")), 0644)
@@ -49,7 +61,7 @@ func TestParseESModuleExports(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	exports, _, err := parseESModuleExports(tmpDir, "exports")
+	exports, _, err := s.parseESModuleExports(context.Background(), tmpDir, "exports")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,3 +70,140 @@ func TestParseESModuleExports(t *testing.T) {
 		t.Fatalf("unexpected exports.js: %s", strings.Join(exports, ","))
 	}
 }
+
+func TestAppendStarExports(t *testing.T) {
+	exports := appendStarExports([]string{"a"}, []string{"default", "a", "b", "__esModule", "b", "c"})
+	if strings.Join(exports, ",") != "a,b,c" {
+		t.Fatalf("unexpected exports %v", exports)
+	}
+}
+
+// TestParseReexportedCJSModuleExports follows the `export * from` of an ES module into the
+// commonjs re-export chains, the test requires yarn(and the network to install the
+// cjs-module-lexer).
+func TestParseReexportedCJSModuleExports(t *testing.T) {
+	if _, err := exec.LookPath("yarn"); err != nil {
+		t.Skip("yarn not found")
+	}
+	s := &Server{config: &Config{}, log: &logx.Logger{}}
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "esm-reexports-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"wrapper/package.json": `{"name":"wrapper","module":"index.mjs"}`,
+		"wrapper/index.mjs":    "export * from 'cjs-a'\nexport * from 'cjs-b'\nexport const own = 1\n",
+		"cjs-a/package.json":   `{"name":"cjs-a","main":"index.js"}`,
+		"cjs-a/index.js":       "module.exports = require('./lib/index.js')\n",
+		"cjs-a/lib/index.js":   "module.exports = require('cjs-b')\n",
+		"cjs-b/package.json":   `{"name":"cjs-b","main":"index.js"}`,
+		"cjs-b/index.js":       "exports.foo = 1\nexports.bar = 2\nexports.default = 3\n",
+	} {
+		filename := path.Join(dir, "node_modules", name)
+		ensureDir(path.Dir(filename))
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exports, esm, err := s.parseESModuleExports(context.Background(), dir, "wrapper/index.mjs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(exports)
+	// the names of the two star exports are deduplicated, the default export is not re-exported
+	if !esm || strings.Join(exports, ",") != "bar,foo,own" {
+		t.Fatalf("unexpected exports %v", exports)
+	}
+}
+
+func TestInstallCJSModuleLexerConcurrently(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake yarn is a shell script")
+	}
+	s := &Server{config: &Config{}, log: &logx.Logger{}}
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "esm-lexer-install-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a yarn that fails at the first run and records the runs
+	runs := filepath.Join(dir, "runs.log")
+	script := fmt.Sprintf("#!/bin/sh\necho $PWD >> %s\n[ $(wc -l < %s) -gt 1 ]\n", runs, runs)
+	binDir := filepath.Join(dir, "bin")
+	ensureDir(binDir)
+	err = ioutil.WriteFile(filepath.Join(binDir, "yarn"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpDir := filepath.Join(dir, "tmp")
+	ensureDir(tmpDir)
+	for key, value := range map[string]string{
+		"PATH":   binDir + string(os.PathListSeparator) + os.Getenv("PATH"),
+		"TMPDIR": tmpDir,
+	} {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+	cwd, _ := os.Getwd()
+
+	if _, err := s.installCJSModuleLexer(); err == nil {
+		t.Fatal("the failed installation should return the error")
+	}
+
+	// the concurrent builds wait for one installation
+	pools := make(chan *nodeWorkerPool, 8)
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool, err := s.installCJSModuleLexer()
+			if err != nil {
+				errs <- err
+				return
+			}
+			pools <- pool
+		}()
+	}
+	wg.Wait()
+	close(pools)
+	close(errs)
+	for err := range errs {
+		t.Fatalf("the failed installation should be retried: %v", err)
+	}
+	var pool *nodeWorkerPool
+	for p := range pools {
+		if pool != nil && p != pool {
+			t.Fatal("the concurrent builds should share the workers")
+		}
+		pool = p
+	}
+
+	data, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("yarn should run twice(one failure and one retry), got %d runs", len(lines))
+	}
+	// yarn runs in the app dir without changing the working directory of the process
+	appDir, _ := filepath.EvalSymlinks(filepath.Join(tmpDir, "esmd-cjs-module-lexer"))
+	for _, line := range lines {
+		if dir, _ := filepath.EvalSymlinks(line); dir != appDir {
+			t.Fatalf("yarn should run in %s, got %s", appDir, line)
+		}
+	}
+	if wd, _ := os.Getwd(); wd != cwd {
+		t.Fatalf("the working directory should not be changed, got %s", wd)
+	}
+}
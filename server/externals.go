@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ije/gox/utils"
+)
+
+// An externalResolver resolves the external modules of a build to the esm.sh URLs in the
+// `onResolve` hook of esbuild, so the output doesn't need any textual patching. The results
+// are cached and guarded by a lock since esbuild calls the plugin hooks concurrently.
+type externalResolver struct {
+	ctx     context.Context
+	task    *buildTask
+	esmeta  *ESMeta
+	lock    sync.Mutex
+	paths   map[string]string
+	cjsDeps map[string]string // identifier -> import statement
+}
+
+func newExternalResolver(ctx context.Context, task *buildTask, esmeta *ESMeta) *externalResolver {
+	return &externalResolver{
+		ctx:     ctx,
+		task:    task,
+		esmeta:  esmeta,
+		paths:   map[string]string{},
+		cjsDeps: map[string]string{},
+	}
+}
+
+// Resolve returns the import URL of the external module.
+func (r *externalResolver) Resolve(name string) (importPath string, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.resolve(name)
+}
+
+func (r *externalResolver) resolve(name string) (importPath string, err error) {
+	importPath, ok := r.paths[name]
+	if ok {
+		return
+	}
+
+	task := r.task
+	// the external packages are kept as the bare imports
+	if task.isExternal(name) {
+		importPath = name
+	}
+	if importPath == "" && isNodeRuntimeTarget(task.target) && isNodeBuiltInModule(name) {
+		importPath = nodeBuiltInModuleURL(name)
+	}
+	if importPath == "" && task.target == "bun" && isBunBuiltInModule(name) {
+		importPath = name
+	}
+	if importPath == "" && task.target == "deno" {
+		_, yes := denoStdNodeModules[name]
+		if yes {
+			importPath = fmt.Sprintf("/v%d/_deno_std_node_%s.js", VERSION, name)
+		}
+	}
+	if name == "buffer" && importPath == "" {
+		importPath = fmt.Sprintf("/v%d/_node_buffer.js", VERSION)
+	}
+	if importPath == "" && builtInNodeModules[name] {
+		polyfill, ok := polyfilledBuiltInNodeModules[name]
+		if ok {
+			p, submodule, e := r.task.getPackageInfo(polyfill, "latest")
+			if e != nil {
+				err = e
+				return
+			}
+			filename := path.Base(p.Name)
+			if submodule != "" {
+				filename = submodule
+			}
+			if task.isDev {
+				filename += ".development"
+			}
+			importPath = fmt.Sprintf(
+				"/v%d/%s@%s/%s/%s.js",
+				VERSION,
+				p.Name,
+				p.Version,
+				task.target,
+				filename,
+			)
+		} else if r.task.hasEmbeddedPolyfill(name) {
+			importPath = fmt.Sprintf("/v%d/_node_%s.js", VERSION, name)
+		} else if task.target == "workers" {
+			// fail the build instead of throwing at runtime, the workers can't recover from it
+			err = fmt.Errorf("unsupported nodejs builtin module \"%s\" in the workers target", name)
+			return
+		} else {
+			importPath = fmt.Sprintf("/_error.js?type=unsupported-nodejs-builtin-module&name=%s", name)
+		}
+	}
+	// the aliased imports may be submodules of other packages, like `preact/compat`
+	pkgName, submodule := splitPkgPath(name)
+	if importPath == "" && submodule == "" {
+		packageFile := filepath.Join(task.wd, "node_modules", name, "package.json")
+		if fileExists(packageFile) {
+			var p NpmPackage
+			if utils.ParseJSONFile(packageFile, &p) == nil {
+				suffix := ".js"
+				if task.isDev {
+					suffix = ".development.js"
+				}
+				// the dependencies keep the same external packages to share them
+				importPath = fmt.Sprintf(
+					"/v%d/%s@%s/%s%s/%s%s",
+					VERSION,
+					p.Name,
+					p.Version,
+					externalSegment(task.external, p.Name),
+					task.target,
+					path.Base(p.Name),
+					suffix,
+				)
+			}
+		}
+	}
+	if importPath == "" {
+		version := "latest"
+		for _, dep := range task.deps {
+			if pkgName == dep.name {
+				version = dep.version
+				break
+			}
+		}
+		if version == "latest" {
+			for n, v := range r.esmeta.Dependencies {
+				if pkgName == n {
+					version = v
+					break
+				}
+			}
+		}
+		if version == "latest" {
+			for n, v := range r.esmeta.PeerDependencies {
+				if pkgName == n {
+					version = v
+					break
+				}
+			}
+		}
+		p, submodule, e := r.task.getPackageInfo(name, version)
+		if e == nil {
+			filename := path.Base(p.Name)
+			if submodule != "" {
+				filename = submodule
+			}
+			if task.isDev {
+				filename += ".development"
+			}
+			importPath = fmt.Sprintf(
+				"/v%d/%s@%s/%s%s/%s.js",
+				VERSION,
+				p.Name,
+				p.Version,
+				externalSegment(task.external, p.Name),
+				task.target,
+				escapePath(filename),
+			)
+		}
+	}
+	if importPath == "" {
+		importPath = fmt.Sprintf("/_error.js?type=resolve&name=%s", name)
+	}
+	r.paths[name] = importPath
+	return
+}
+
+// CJSShim returns a commonjs module that exports the external module for the `require`
+// calls, the module references a global identifier that is imported by the header of
+// the build output.
+func (r *externalResolver) CJSShim(name string) (code string, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	importPath, err := r.resolve(name)
+	if err != nil {
+		return
+	}
+
+	identifier := fmt.Sprintf("__%s$", identify(name))
+	if _, ok := r.cjsDeps[identifier]; !ok {
+		hasDefaultExport := true
+		versionPrefx := fmt.Sprintf("/v%d/", VERSION)
+		// the `_` prefixed paths are the built-in polyfills
+		if strings.HasPrefix(importPath, versionPrefx) && !strings.HasPrefix(importPath, versionPrefx+"_") {
+			pkg, err := r.task.parsePkg(strings.TrimPrefix(importPath, versionPrefx))
+			if err == nil {
+				// here the submodule should be always empty
+				pkg.submodule = ""
+				_, installed := r.esmeta.Dependencies[name]
+				if !installed {
+					_, installed = r.esmeta.PeerDependencies[name]
+				}
+				meta, err := r.task.initBuild(r.ctx, r.task.wd, *pkg, r.task.target, !installed)
+				if err == nil && len(meta.Exports) > 0 {
+					hasDefaultExport = includes(meta.Exports, "default") || includes(meta.Exports, "__esModule")
+				}
+			}
+		}
+		if hasDefaultExport {
+			r.cjsDeps[identifier] = fmt.Sprintf(`import %s from "%s";`, identifier, importPath)
+		} else {
+			r.cjsDeps[identifier] = fmt.Sprintf(`import * as %s from "%s";`, identifier, importPath)
+		}
+	}
+	code = fmt.Sprintf("module.exports = %s;", identifier)
+	return
+}
+
+// WriteCJSImports writes the imports of the external modules that are required by the code.
+func (r *externalResolver) WriteCJSImports(w *jsWriter, globals *stringSet) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	identifiers := make([]string, 0, len(r.cjsDeps))
+	for identifier := range r.cjsDeps {
+		if globals.Has(identifier) {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	sort.Strings(identifiers)
+	for _, identifier := range identifiers {
+		w.Stmt("%s", r.cjsDeps[identifier])
+	}
+}
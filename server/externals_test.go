@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestExternalResolverCJSShim(t *testing.T) {
+	s := &Server{config: &Config{}, embedFS: &embed.FS{}}
+	externals := newExternalResolver(context.Background(), &buildTask{Server: s, target: "es2020"}, &ESMeta{NpmPackage: &NpmPackage{}})
+	plugin := api.Plugin{
+		Name: "test",
+		Setup: func(plugin api.PluginBuild) {
+			plugin.OnResolve(api.OnResolveOptions{Filter: "^buffer$"}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if args.Kind == api.ResolveJSRequireCall {
+					return api.OnResolveResult{Path: args.Path, Namespace: "esm-sh-cjs-external"}, nil
+				}
+				importPath, err := externals.Resolve(args.Path)
+				return api.OnResolveResult{Path: importPath, External: true}, err
+			})
+			plugin.OnLoad(api.OnLoadOptions{Filter: ".*", Namespace: "esm-sh-cjs-external"}, func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+				code, err := externals.CJSShim(args.Path)
+				return api.OnLoadResult{Contents: &code, Loader: api.LoaderJS}, err
+			})
+		},
+	}
+	result := api.Build(api.BuildOptions{
+		Stdin: &api.StdinOptions{
+			Contents:   `const s = 'require("buffer")'; const { Buffer } = require ( "buffer" ); export { s, Buffer }; export * as b from "buffer";`,
+			Sourcefile: "export.js",
+		},
+		Outdir:   "/esbuild",
+		Bundle:   true,
+		Format:   api.FormatESModule,
+		External: []string{"buffer"},
+		Plugins:  []api.Plugin{plugin},
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors[0].Text)
+	}
+
+	code := result.OutputFiles[0].Contents
+	if !bytes.Contains(code, []byte(`'require("buffer")'`)) {
+		t.Fatalf("the string literal is changed:\n%s", code)
+	}
+	if !bytes.Contains(code, []byte(fmt.Sprintf(`from "/v%d/_node_buffer.js"`, VERSION))) {
+		t.Fatalf("the import is not resolved:\n%s", code)
+	}
+	globals, ok := unboundIdentifiers(code)
+	if !ok || !globals.Has("__buffer$") {
+		t.Fatalf("missing the required module:\n%s", code)
+	}
+	w := newJSWriter(false, 0)
+	externals.WriteCJSImports(w, globals)
+	if s := string(w.Bytes()); s != fmt.Sprintf(`import __buffer$ from "/v%d/_node_buffer.js";`, VERSION) {
+		t.Fatalf("unexpected imports: %s", s)
+	}
+}
+
+func TestExternalResolverNodeTarget(t *testing.T) {
+	s := &Server{config: &Config{}, embedFS: &embed.FS{}}
+	externals := newExternalResolver(context.Background(), &buildTask{Server: s, target: "node"}, &ESMeta{NpmPackage: &NpmPackage{}})
+	for name, expected := range map[string]string{
+		"fs":                  "node:fs",
+		"buffer":              "node:buffer",
+		"fs/promises":         "node:fs/promises",
+		"node:path":           "node:path",
+		"node:stream/web":     "node:stream/web",
+		"node:worker_threads": "node:worker_threads",
+	} {
+		importPath, err := externals.Resolve(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if importPath != expected {
+			t.Fatalf("%s should be resolved to %s, but got %s", name, expected, importPath)
+		}
+	}
+	if isNodeBuiltInModule("process/browser") {
+		t.Fatal("process/browser is not a node builtin module")
+	}
+
+	code, err := externals.CJSShim("node:fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "module.exports = __node_fs$;" {
+		t.Fatalf("unexpected cjs shim: %s", code)
+	}
+	globals := newStringSet()
+	globals.Add("__node_fs$")
+	w := newJSWriter(false, 0)
+	externals.WriteCJSImports(w, globals)
+	if s := string(w.Bytes()); s != `import __node_fs$ from "node:fs";` {
+		t.Fatalf("unexpected imports: %s", s)
+	}
+}
+
+func TestExternalResolverBunTarget(t *testing.T) {
+	s := &Server{config: &Config{}, embedFS: &embed.FS{}}
+	externals := newExternalResolver(context.Background(), &buildTask{Server: s, target: "bun"}, &ESMeta{NpmPackage: &NpmPackage{}})
+	for name, expected := range map[string]string{
+		"fs":         "node:fs",
+		"node:path":  "node:path",
+		"bun:sqlite": "bun:sqlite",
+		"bun:ffi":    "bun:ffi",
+	} {
+		importPath, err := externals.Resolve(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if importPath != expected {
+			t.Fatalf("%s should be resolved to %s, but got %s", name, expected, importPath)
+		}
+	}
+
+	code, err := externals.CJSShim("bun:sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "module.exports = __bun_sqlite$;" {
+		t.Fatalf("unexpected cjs shim: %s", code)
+	}
+}
+
+func TestExternalResolverWorkersTarget(t *testing.T) {
+	s := &Server{config: &Config{}, embedFS: &embed.FS{}}
+	externals := newExternalResolver(context.Background(), &buildTask{Server: s, target: "workers"}, &ESMeta{NpmPackage: &NpmPackage{}})
+	if importPath, err := externals.Resolve("buffer"); err != nil || importPath != fmt.Sprintf("/v%d/_node_buffer.js", VERSION) {
+		t.Fatalf("unexpected buffer polyfill: %s, %v", importPath, err)
+	}
+	_, err := externals.Resolve("fs")
+	if err == nil || err.Error() != `unsupported nodejs builtin module "fs" in the workers target` {
+		t.Fatalf("the unsupported builtin module should be rejected: %v", err)
+	}
+
+	externals = newExternalResolver(context.Background(), &buildTask{Server: s, target: "es2020"}, &ESMeta{NpmPackage: &NpmPackage{}})
+	if importPath, err := externals.Resolve("fs"); err != nil || !strings.HasPrefix(importPath, "/_error.js?type=unsupported-nodejs-builtin-module") {
+		t.Fatalf("unexpected import path of fs: %s, %v", importPath, err)
+	}
+}
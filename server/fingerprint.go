@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/postui/postdb"
+	"github.com/postui/postdb/q"
+)
+
+// the number of the tarballs that are changed by the registry for the same version
+var tarballChanges uint64
+
+// tarballFingerprint returns the integrity of the package tarball, the legacy registries only
+// have the sha1 shasum.
+func tarballFingerprint(p *NpmPackage) string {
+	if p == nil || p.Dist == nil {
+		return ""
+	}
+	if p.Dist.Integrity != "" {
+		return p.Dist.Integrity
+	}
+	if p.Dist.Shasum != "" {
+		return "sha1:" + p.Dist.Shasum
+	}
+	return ""
+}
+
+func tarballKey(name string, version string) string {
+	return fmt.Sprintf("tarball:%s@%s", name, version)
+}
+
+// recordTarballFingerprint records the fingerprint of the tarball that the registry serves for
+// the version, returns true if it's changed since the last resolve, like the version is
+// republished.
+func (s *Server) recordTarballFingerprint(name string, version string, fingerprint string, now time.Time) (changed bool, err error) {
+	if fingerprint == "" {
+		return
+	}
+	key := tarballKey(name, version)
+	post, err := s.db.Get(q.Alias(key), q.K("fingerprint"))
+	if err == postdb.ErrNotFound {
+		_, err = s.db.Put(q.Alias(key), q.KV{"fingerprint": []byte(fingerprint)})
+		return
+	}
+	if err != nil || string(post.KV.Get("fingerprint")) == fingerprint {
+		return
+	}
+	err = s.db.Update(q.Alias(key), q.KV{
+		"fingerprint": []byte(fingerprint),
+		"changed":     []byte(now.UTC().Format(time.RFC3339)),
+	})
+	if err != nil {
+		return
+	}
+	atomic.AddUint64(&tarballChanges, 1)
+	return true, nil
+}
+
+// tarballChanged checks the fingerprint in the build record against the latest resolve,
+// returns the latest fingerprint if the tarball is changed after the build.
+func (s *Server) tarballChanged(esm *ESMeta) (latest string, changed bool) {
+	built := tarballFingerprint(esm.NpmPackage)
+	if built == "" {
+		return
+	}
+	post, err := s.db.Get(q.Alias(tarballKey(esm.Name, esm.Version)), q.K("fingerprint"))
+	if err != nil {
+		return
+	}
+	latest = string(post.KV.Get("fingerprint"))
+	return latest, latest != built
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTarballFingerprint(t *testing.T) {
+	s := setupTestEnv(t)
+
+	p := &NpmPackage{Name: "esm-fixture-esm", Version: "1.0.0", Dist: &NpmPackageDist{Shasum: "a1b2"}}
+	if fp := tarballFingerprint(p); fp != "sha1:a1b2" {
+		t.Fatalf("unexpected fingerprint %s", fp)
+	}
+	p.Dist.Integrity = "sha512-abc"
+	if fp := tarballFingerprint(p); fp != "sha512-abc" {
+		t.Fatalf("the integrity should be preferred: %s", fp)
+	}
+	if fp := tarballFingerprint(&NpmPackage{}); fp != "" {
+		t.Fatalf("unexpected fingerprint %s", fp)
+	}
+
+	now := time.Now()
+	if changed, err := s.recordTarballFingerprint(p.Name, p.Version, "sha512-abc", now); err != nil || changed {
+		t.Fatalf("the first record should not be a change: %v", err)
+	}
+	if changed, err := s.recordTarballFingerprint(p.Name, p.Version, "sha512-abc", now); err != nil || changed {
+		t.Fatalf("the same tarball should not be a change: %v", err)
+	}
+	esm := &ESMeta{NpmPackage: p}
+	if _, changed := s.tarballChanged(esm); changed {
+		t.Fatal("the build should match the latest tarball")
+	}
+
+	before := tarballChanges
+	if changed, err := s.recordTarballFingerprint(p.Name, p.Version, "sha512-xyz", now); err != nil || !changed {
+		t.Fatalf("the republished tarball should be detected: %v", err)
+	}
+	if tarballChanges != before+1 {
+		t.Fatal("the change should be counted")
+	}
+	if latest, changed := s.tarballChanged(esm); !changed || latest != "sha512-xyz" {
+		t.Fatalf("the build should be flagged: %s", latest)
+	}
+}
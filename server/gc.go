@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postui/postdb/q"
+)
+
+// An accessCounter counts the requests of the builds since the last GC round.
+type accessCounter struct {
+	lock sync.Mutex
+	hits map[string]uint32
+}
+
+var buildAccess = &accessCounter{hits: map[string]uint32{}}
+
+// Touch records an access of the build.
+func (c *accessCounter) Touch(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.hits[id]++
+}
+
+// TakeAll returns the access counts of the builds and resets them.
+func (c *accessCounter) TakeAll() map[string]uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	hits := c.hits
+	c.hits = map[string]uint32{}
+	return hits
+}
+
+// startBuildGC checks the builds every hour when the `build-ttl` config is set: the builds
+// that are not refreshed in the TTL are evicted, unless they are accessed more than the
+// `warm-threshold` times since the last check, then they are retained for another TTL. The
+// development builds aliased to an evicted production build are rebuilt by `findESM`.
+func (s *Server) startBuildGC() {
+	if s.config.buildTTL <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Hour)
+			retained, evicted, err := s.gcBuilds(time.Now())
+			if err != nil {
+				s.log.Errorf("gc builds: %v", err)
+				continue
+			}
+			if retained > 0 || evicted > 0 {
+				s.log.Infof("gc builds: %d retained, %d evicted", retained, evicted)
+			}
+		}
+	}()
+}
+
+func (s *Server) gcBuilds(now time.Time) (retained int, evicted int, err error) {
+	prefix := fmt.Sprintf("v%d/", VERSION)
+	posts, err := s.db.List(q.K("atime"), q.Filter(func(p q.Post) bool {
+		return strings.HasPrefix(p.Alias, prefix)
+	}))
+	if err != nil {
+		return
+	}
+
+	// the hits are counted since the last check whether the builds are expired or not
+	hits := buildAccess.TakeAll()
+	for _, post := range posts {
+		atime := int64(post.Crtime)
+		if v := post.KV.Get("atime"); v != nil {
+			atime, _ = strconv.ParseInt(string(v), 10, 64)
+		}
+		if now.Sub(time.Unix(atime, 0)) < s.config.buildTTL {
+			continue
+		}
+
+		id := post.Alias
+		if hits := hits[id]; hits >= uint32(s.config.warmThreshold) {
+			err = s.db.Update(q.Alias(id), q.KV{"atime": []byte(strconv.FormatInt(now.Unix(), 10))})
+			if err != nil {
+				return
+			}
+			retained++
+			s.log.Debugf("gc builds: %s retained (%d hits)", id, hits)
+			continue
+		}
+
+		_, err = s.db.Delete(q.Alias(id))
+		if err != nil {
+			return
+		}
+		for _, ext := range buildArtifactExts {
+			os.Remove(filepath.Join(s.config.storageDir, "builds", id+ext))
+		}
+		evicted++
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	logx "github.com/ije/gox/log"
+	"github.com/postui/postdb"
+	"github.com/postui/postdb/q"
+)
+
+func TestGCBuilds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-gc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{config: &Config{storageDir: dir, buildTTL: time.Hour, warmThreshold: 2}, log: &logx.Logger{}}
+	s.db, err = postdb.Open(path.Join(dir, "esm.db"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	hot := fmt.Sprintf("v%d/hot@1.0.0/es2020/hot", VERSION)
+	cold := fmt.Sprintf("v%d/cold@1.0.0/es2020/cold", VERSION)
+	for _, id := range []string{hot, cold} {
+		if err = writeFileAtomic(path.Join(dir, "builds", id+".js")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = s.db.Put(q.Alias(id), q.KV{"esmeta": []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the hits before the last check are not counted
+	buildAccess.Touch(cold)
+	buildAccess.Touch(cold)
+	if retained, evicted, err := s.gcBuilds(time.Now()); err != nil || retained+evicted > 0 {
+		t.Fatalf("the fresh builds should be kept: %d, %d, %v", retained, evicted, err)
+	}
+	buildAccess.Touch(hot)
+	buildAccess.Touch(hot)
+	buildAccess.Touch(cold)
+
+	retained, evicted, err := s.gcBuilds(time.Now().Add(2 * time.Hour))
+	if err != nil || retained != 1 || evicted != 1 {
+		t.Fatalf("unexpected gc result: %d, %d, %v", retained, evicted, err)
+	}
+	if !fileExists(path.Join(dir, "builds", hot+".js")) || fileExists(path.Join(dir, "builds", cold+".js")) {
+		t.Fatal("the hot build should be retained and the cold one should be evicted")
+	}
+	if retained, evicted, err = s.gcBuilds(time.Now().Add(2 * time.Hour)); err != nil || retained+evicted > 0 {
+		t.Fatalf("the retained build should be kept for another TTL: %d, %d, %v", retained, evicted, err)
+	}
+}
+
+func TestFindESMOfEvictedAlias(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-gc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &Server{config: &Config{storageDir: dir}}
+	s.db, err = postdb.Open(path.Join(dir, "esm.db"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+
+	prod := fmt.Sprintf("v%d/a@1.0.0/es2020/a", VERSION)
+	dev := prod + ".development"
+	if err = writeFileAtomic(path.Join(dir, "builds", prod+".js")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.aliasBuild(prod, dev, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.db.Put(q.Alias(dev), q.KV{"esmeta": []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.findESM(dev); !ok {
+		t.Fatal("the alias should be found")
+	}
+	os.Remove(path.Join(dir, "builds", prod+".js"))
+	if _, _, ok := s.findESM(dev); ok {
+		t.Fatal("the alias of the evicted build should not be found")
+	}
+}
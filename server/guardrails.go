@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// An installError is the error of the install guardrails, it explains which dependency
+// trips the limit.
+type installError struct {
+	specs   []string
+	message string
+	// the install is timed out, it may succeed later
+	timeout bool
+}
+
+func (e *installError) Error() string {
+	return fmt.Sprintf("install %s: %s", strings.Join(e.specs, " "), e.message)
+}
+
+// An installedPackage is a package in the `node_modules` of the build directory.
+type installedPackage struct {
+	Name         string
+	Version      string
+	Dependencies map[string]string
+}
+
+// parseInstallDenyList parses the `install-deny` config like `left-pad,@corp/legacy`.
+func parseInstallDenyList(s string) (deny map[string]bool, err error) {
+	deny = map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !regPkgName.MatchString(name) {
+			return nil, fmt.Errorf("invalid package name '%s' in the install deny list", name)
+		}
+		deny[name] = true
+	}
+	return
+}
+
+// listInstalledPackages walks the `node_modules` of the directory, the nested `node_modules`
+// of the packages are included.
+func listInstalledPackages(wd string) (packages []installedPackage, err error) {
+	dir := filepath.Join(wd, "node_modules")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !entry.IsDir() {
+			continue
+		}
+		pkgDirs := []string{filepath.Join(dir, name)}
+		if strings.HasPrefix(name, "@") {
+			pkgDirs = nil
+			scoped, _ := ioutil.ReadDir(filepath.Join(dir, name))
+			for _, e := range scoped {
+				if e.IsDir() {
+					pkgDirs = append(pkgDirs, filepath.Join(dir, name, e.Name()))
+				}
+			}
+		}
+		for _, pkgDir := range pkgDirs {
+			var p NpmPackage
+			if utils.ParseJSONFile(filepath.Join(pkgDir, "package.json"), &p) != nil || p.Name == "" {
+				continue
+			}
+			packages = append(packages, installedPackage{p.Name, p.Version, p.Dependencies})
+			var nested []installedPackage
+			nested, err = listInstalledPackages(pkgDir)
+			if err != nil {
+				return
+			}
+			packages = append(packages, nested...)
+		}
+	}
+	return
+}
+
+// checkInstallGuardrails checks the installed packages against the `install-deny` and
+// `install-max-deps` configs.
+func (s *Server) checkInstallGuardrails(wd string, specs []string) error {
+	if s.config.installMaxDeps <= 0 && len(s.config.installDeny) == 0 {
+		return nil
+	}
+	packages, err := listInstalledPackages(wd)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range packages {
+		if !s.config.installDeny[p.Name] {
+			continue
+		}
+		dependents := []string{}
+		for _, d := range packages {
+			if _, ok := d.Dependencies[p.Name]; ok {
+				dependents = append(dependents, fmt.Sprintf("%s@%s", d.Name, d.Version))
+			}
+		}
+		message := fmt.Sprintf("the dependency %s@%s is denied by the 'install-deny' config", p.Name, p.Version)
+		if len(dependents) > 0 {
+			sort.Strings(dependents)
+			message += fmt.Sprintf(", it's required by %s", strings.Join(dependents, ", "))
+		}
+		return &installError{specs: specs, message: message}
+	}
+
+	if s.config.installMaxDeps > 0 && len(packages) > s.config.installMaxDeps {
+		message := fmt.Sprintf("%d packages are installed, exceeds the limit(%d) of the 'install-max-deps' config", len(packages), s.config.installMaxDeps)
+		if name, n := largestDependency(packages, specs); name != "" {
+			message += fmt.Sprintf(", the dependency %s pulls %d packages", name, n)
+		}
+		return &installError{specs: specs, message: message}
+	}
+	return nil
+}
+
+// largestDependency returns the direct dependency of the installed specs that pulls the most
+// packages, the versions are ignored in the dependency graph.
+func largestDependency(packages []installedPackage, specs []string) (name string, n int) {
+	graph := map[string][]string{}
+	for _, p := range packages {
+		for dep := range p.Dependencies {
+			graph[p.Name] = append(graph[p.Name], dep)
+		}
+	}
+	roots := []string{}
+	for _, spec := range specs {
+		pkgName, _ := splitPkgVersion(spec)
+		roots = append(roots, graph[pkgName]...)
+	}
+	sort.Strings(roots)
+	for _, root := range roots {
+		seen := map[string]bool{root: true}
+		queue := []string{root}
+		for len(queue) > 0 {
+			for _, dep := range graph[queue[0]] {
+				if !seen[dep] {
+					seen[dep] = true
+					queue = append(queue, dep)
+				}
+			}
+			queue = queue[1:]
+		}
+		if len(seen) > n {
+			name, n = root, len(seen)
+		}
+	}
+	return
+}
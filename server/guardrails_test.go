@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeInstalledPackage(t *testing.T, dir string, packageJSON string) {
+	err := ensureDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(packageJSON), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstallGuardrails(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-guardrails-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	nm := filepath.Join(wd, "node_modules")
+	writeInstalledPackage(t, filepath.Join(nm, "app"), `{"name":"app","version":"1.0.0","dependencies":{"small":"1","huge":"1"}}`)
+	writeInstalledPackage(t, filepath.Join(nm, "small"), `{"name":"small","version":"1.0.0"}`)
+	writeInstalledPackage(t, filepath.Join(nm, "huge"), `{"name":"huge","version":"1.0.0","dependencies":{"@huge/a":"1","@huge/b":"1"}}`)
+	writeInstalledPackage(t, filepath.Join(nm, "@huge", "a"), `{"name":"@huge/a","version":"1.0.0","dependencies":{"left-pad":"1"}}`)
+	writeInstalledPackage(t, filepath.Join(nm, "@huge", "b"), `{"name":"@huge/b","version":"1.0.0"}`)
+	writeInstalledPackage(t, filepath.Join(nm, "@huge", "a", "node_modules", "left-pad"), `{"name":"left-pad","version":"1.3.0"}`)
+
+	packages, err := listInstalledPackages(wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 6 {
+		t.Fatalf("expected 6 installed packages, but got %d", len(packages))
+	}
+
+	s := &Server{config: &Config{}}
+	if err := s.checkInstallGuardrails(wd, []string{"app@1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.config = &Config{installMaxDeps: 6}
+	if err := s.checkInstallGuardrails(wd, []string{"app@1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	s.config = &Config{installMaxDeps: 5}
+	err = s.checkInstallGuardrails(wd, []string{"app@1.0.0"})
+	if err == nil || !strings.HasSuffix(err.Error(), "6 packages are installed, exceeds the limit(5) of the 'install-max-deps' config, the dependency huge pulls 4 packages") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.config = &Config{}
+	s.config.installDeny, err = parseInstallDenyList("left-pad, @corp/legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.checkInstallGuardrails(wd, []string{"app@1.0.0"})
+	if err == nil || err.Error() != "install app@1.0.0: the dependency left-pad@1.3.0 is denied by the 'install-deny' config, it's required by @huge/a@1.0.0" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := parseInstallDenyList("left pad"); err == nil {
+		t.Fatal("the invalid package name should be rejected")
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const geoipDataURL = "https://github.com/alecthw/mmdb_china_ip_list/releases/download/20210322/china_ip_list.mmdb"
+
+// Init bootstraps a self-hosted esmd: `esmd init [-etc-dir dir] [-log-dir dir] [-domain domain] [-geoip]`
+func Init(args []string) {
+	var etcDir string
+	var logDir string
+	var dataDir string
+	var domain string
+	var geoip bool
+	var force bool
+
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.StringVar(&etcDir, "etc-dir", defaultEtcDir(runtime.GOOS), "etc dir")
+	fs.StringVar(&logDir, "log-dir", defaultLogDir(runtime.GOOS), "log dir")
+	fs.StringVar(&dataDir, "data-dir", "", "keep all the writable data in the dir, see `esmd -data-dir`")
+	fs.StringVar(&domain, "domain", "esm.sh", "main domain")
+	fs.BoolVar(&geoip, "geoip", false, "download the china_ip_list.mmdb to split China traffic")
+	fs.BoolVar(&force, "force", false, "overwrite the existing config file")
+	fs.Parse(args)
+
+	httpPort, httpsPort := 80, 443
+	if dataDir != "" {
+		etcDir = dataDir
+		logDir = filepath.Join(dataDir, "log")
+		httpPort, httpsPort = 8080, 0
+	}
+	etcDir, _ = filepath.Abs(etcDir)
+	logDir, _ = filepath.Abs(logDir)
+	for _, dir := range []string{
+		filepath.Join(etcDir, "storage", fmt.Sprintf("builds/v%d", VERSION)),
+		filepath.Join(etcDir, "storage", fmt.Sprintf("types/v%d", VERSION)),
+		filepath.Join(etcDir, "storage", "raw"),
+		logDir,
+	} {
+		err := ensureDir(dir)
+		if err != nil {
+			fmt.Printf("✗ create directory %s: %v\n", dir, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("✓ directories created in %s\n", etcDir)
+
+	configFile := filepath.Join(etcDir, "config.json")
+	if fileExists(configFile) && !force {
+		fmt.Printf("- %s exists, use -force to overwrite\n", configFile)
+	} else {
+		data, err := starterConfig(domain, logDir, httpPort, httpsPort)
+		if err == nil {
+			err = ioutil.WriteFile(configFile, data, 0644)
+		}
+		if err != nil {
+			fmt.Printf("✗ write %s: %v\n", configFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ %s created\n", configFile)
+	}
+
+	ok := true
+	version, major, err := getNodejsVersion()
+	if err != nil {
+		ok = false
+		fmt.Printf("✗ nodejs not found, esmd installs nodejs %s into /usr/local/nodejs at startup\n", nodejsLatestLTS)
+	} else if major < minNodejsVersion {
+		ok = false
+		fmt.Printf("✗ nodejs %s is too old, need %d+\n", version, minNodejsVersion)
+	} else {
+		fmt.Printf("✓ nodejs %s\n", version)
+	}
+	_, output, err := runProc(context.Background(), procOptions{Timeout: time.Minute}, "yarn", "-v")
+	if err != nil {
+		ok = false
+		fmt.Println("✗ yarn not found, run `npm install yarn -g` to install it")
+	} else {
+		fmt.Printf("✓ yarn %s\n", strings.TrimSpace(string(output)))
+	}
+
+	if geoip {
+		mmdbFile := filepath.Join(etcDir, "china_ip_list.mmdb")
+		err = downloadFile(geoipDataURL, mmdbFile)
+		if err != nil {
+			ok = false
+			fmt.Printf("✗ download china_ip_list.mmdb: %v\n", err)
+		} else {
+			fmt.Printf("✓ %s downloaded\n", mmdbFile)
+		}
+	}
+
+	binPath, err := os.Executable()
+	if err != nil {
+		binPath = "/usr/local/bin/esmd"
+	}
+	fmt.Printf("\n# /etc/systemd/system/esmd.service\n%s", systemdUnit(binPath, configFile))
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// starterConfig returns the config file with the common options, the keys are the flag names
+// of the `esmd` command.
+func starterConfig(domain string, logDir string, port int, httpsPort int) ([]byte, error) {
+	return json.MarshalIndent(map[string]interface{}{
+		"port":           port,
+		"https-port":     httpsPort,
+		"domain":         domain,
+		"cdn-domain":     "",
+		"log-dir":        logDir,
+		"log":            "info",
+		"legal-comments": "eof",
+		"build-ttl":      "0s",
+		"warm-threshold": 100,
+	}, "", "  ")
+}
+
+// applyConfigFile sets the flags by the config file, the flags of the command line take
+// precedence.
+func applyConfigFile(fs *flag.FlagSet, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("parse %s: %v", filename, err)
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown option '%s'", filename, name)
+		}
+		if set[name] {
+			continue
+		}
+		var value string
+		switch v := values[name].(type) {
+		case string:
+			value = v
+		case nil:
+			continue
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(v)
+			value = string(data)
+		default:
+			value = fmt.Sprint(v)
+		}
+		err = fs.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid option '%s': %v", filename, name, err)
+		}
+	}
+	return nil
+}
+
+func systemdUnit(binPath string, configFile string) string {
+	return fmt.Sprintf(`[Unit]
+Description=esm.sh service
+After=network.target
+
+[Service]
+Type=simple
+ExecStart=%s -config %s
+Restart=always
+RestartSec=5
+LimitNOFILE=65535
+
+[Install]
+WantedBy=multi-user.target
+`, binPath, configFile)
+}
+
+func downloadFile(url string, filename string) error {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, bytes.NewReader(data))
+}
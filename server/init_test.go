@@ -0,0 +1,62 @@
+package server
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-init-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := starterConfig("esm.example.com", "/var/log/esmd", 80, 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(configFile, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var port, httpsPort, warmThreshold int
+	var domain, cdnDomain, logDir, logLevel, legalComments string
+	var buildTTL time.Duration
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.IntVar(&port, "port", 8080, "")
+	fs.IntVar(&httpsPort, "https-port", 8443, "")
+	fs.IntVar(&warmThreshold, "warm-threshold", 0, "")
+	fs.StringVar(&domain, "domain", "", "")
+	fs.StringVar(&cdnDomain, "cdn-domain", "", "")
+	fs.StringVar(&logDir, "log-dir", "", "")
+	fs.StringVar(&logLevel, "log", "", "")
+	fs.StringVar(&legalComments, "legal-comments", "", "")
+	fs.DurationVar(&buildTTL, "build-ttl", time.Hour, "")
+	fs.Parse([]string{"-port", "8000"})
+
+	err = applyConfigFile(fs, configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 8000 {
+		t.Fatalf("the flags of the command line should take precedence, got port %d", port)
+	}
+	if httpsPort != 443 || warmThreshold != 100 || domain != "esm.example.com" || logDir != "/var/log/esmd" || buildTTL != 0 {
+		t.Fatalf("unexpected config: %d %d %s %s %v", httpsPort, warmThreshold, domain, logDir, buildTTL)
+	}
+
+	err = ioutil.WriteFile(configFile, []byte(`{"no-such-option": true}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applyConfigFile(fs, configFile) == nil {
+		t.Fatal("unknown options should be rejected")
+	}
+}
@@ -0,0 +1,497 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ije/gox/utils"
+)
+
+// the max number of the concurrent tarball downloads of an install
+const installConcurrency = 8
+
+// An unsupportedSpecError is returned by the native installer for the dependencies that are
+// not in the registry, like the git repositories, they are installed by yarn instead.
+type unsupportedSpecError struct {
+	name string
+	spec string
+}
+
+func (e *unsupportedSpecError) Error() string {
+	return fmt.Sprintf("unsupported dependency %s@%s", e.name, e.spec)
+}
+
+// An installNode is a package placed in the `node_modules` tree.
+type installNode struct {
+	info NpmPackage
+	// the directory relative to the working directory, like `node_modules/a/node_modules/b`
+	dir string
+	// the tarball of the linked package
+	tarball string
+	// the package is installed by a previous install
+	installed bool
+}
+
+// A nativeInstaller installs the packages by the tarballs of the registry instead of yarn,
+// the dependencies are hoisted to the top-level `node_modules` unless they conflict.
+type nativeInstaller struct {
+	server *Server
+	ctx    context.Context
+	wd     string
+	log    *buildLog
+	placed map[string]*installNode
+	// the metadata of the packages fetched in the install
+	records map[string]NpmPackageRecords
+}
+
+// nativeInstall installs the packages like `react@17.0.2` into the working directory.
+func (s *Server) nativeInstall(ctx context.Context, wd string, specs []string) error {
+	in := &nativeInstaller{
+		server:  s,
+		ctx:     ctx,
+		wd:      wd,
+		log:     buildLogs.Dir(wd),
+		placed:  map[string]*installNode{},
+		records: map[string]NpmPackageRecords{},
+	}
+	in.log.Printf("$ install %s", strings.Join(specs, " "))
+	err := in.scan("node_modules")
+	if err != nil {
+		return err
+	}
+	nodes, err := in.resolveTree(specs)
+	if err != nil {
+		return err
+	}
+	return in.fetch(nodes)
+}
+
+// scan records the packages installed in the `node_modules` directory.
+func (in *nativeInstaller) scan(dir string) error {
+	entries, err := ioutil.ReadDir(filepath.Join(in.wd, dir))
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !entry.IsDir() {
+			continue
+		}
+		pkgDirs := []string{path.Join(dir, name)}
+		if strings.HasPrefix(name, "@") {
+			pkgDirs = nil
+			scoped, _ := ioutil.ReadDir(filepath.Join(in.wd, dir, name))
+			for _, e := range scoped {
+				if e.IsDir() {
+					pkgDirs = append(pkgDirs, path.Join(dir, name, e.Name()))
+				}
+			}
+		}
+		for _, pkgDir := range pkgDirs {
+			var p NpmPackage
+			if utils.ParseJSONFile(filepath.Join(in.wd, pkgDir, "package.json"), &p) != nil || p.Name == "" {
+				continue
+			}
+			in.placed[pkgDir] = &installNode{info: p, dir: pkgDir, installed: true}
+			err = in.scan(path.Join(pkgDir, "node_modules"))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveTree places the packages and their dependencies in the tree, returns the packages
+// to fetch.
+func (in *nativeInstaller) resolveTree(specs []string) (nodes []*installNode, err error) {
+	queue := []*installNode{}
+	for _, spec := range specs {
+		name, version := splitPkgVersion(spec)
+		var node *installNode
+		node, err = in.resolve(name, version)
+		if err != nil {
+			return
+		}
+		node.dir = path.Join("node_modules", name)
+		// the installed version is replaced
+		for dir := range in.placed {
+			if dir == node.dir || strings.HasPrefix(dir, node.dir+"/") {
+				delete(in.placed, dir)
+			}
+		}
+		in.placed[node.dir] = node
+		queue = append(queue, node)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		nodes = append(nodes, node)
+
+		names := make([]string, 0, len(node.info.Dependencies))
+		for name := range node.info.Dependencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			spec := node.info.Dependencies[name]
+			dirs := lookupDirs(node.dir, name)
+			// the node resolution finds the nearest one
+			dir := dirs[len(dirs)-1]
+			satisfied := false
+			for _, d := range dirs {
+				if p, ok := in.placed[d]; ok {
+					satisfied, err = in.satisfies(name, spec, p.info.Version)
+					if err != nil {
+						return
+					}
+					if !satisfied {
+						dir = dirs[0]
+					}
+					break
+				}
+			}
+			if satisfied {
+				continue
+			}
+			var dep *installNode
+			dep, err = in.resolve(name, spec)
+			if err != nil {
+				return
+			}
+			dep.dir = dir
+			in.placed[dir] = dep
+			queue = append(queue, dep)
+		}
+	}
+	return
+}
+
+// lookupDirs returns the directories where the node resolution looks for the dependency of
+// the package directory, from the nearest to the top-level.
+func lookupDirs(pkgDir string, name string) (dirs []string) {
+	dir := pkgDir
+	for {
+		dirs = append(dirs, path.Join(dir, "node_modules", name))
+		i := strings.LastIndex(dir, "node_modules/")
+		if i < 0 {
+			return
+		}
+		dir = strings.TrimSuffix(dir[:i], "/")
+	}
+}
+
+// satisfies reports whether the version satisfies the spec of the dependency, the dist-tags
+// are resolved by the registry.
+func (in *nativeInstaller) satisfies(name string, spec string, version string) (bool, error) {
+	if strings.HasPrefix(spec, "npm:") {
+		_, spec = splitPkgVersion(spec[4:])
+	}
+	if r, err := parseVersionRange(spec); err == nil {
+		v, ok := parseSemver(version)
+		return ok && r.Match(v), nil
+	}
+	p, err := in.resolve(name, spec)
+	if err != nil {
+		return false, err
+	}
+	return p.info.Version == version, nil
+}
+
+// resolve resolves the spec of the package like `^1.2.0`, `latest` or `npm:other@^1.0.0`.
+func (in *nativeInstaller) resolve(name string, spec string) (ret *installNode, err error) {
+	if spec == "" {
+		spec = "latest"
+	}
+	if strings.HasPrefix(spec, "npm:") {
+		var alias *installNode
+		realName, realSpec := splitPkgVersion(spec[4:])
+		alias, err = in.resolve(realName, realSpec)
+		if err != nil {
+			return
+		}
+		// the alias is installed in the directory of its name
+		alias.info.Name = name
+		return alias, nil
+	}
+	if p, ok := links.Get(name, spec); ok {
+		return &installNode{info: p.info, tarball: p.tarball}, nil
+	}
+	if regFullVersion.MatchString(spec) || (regDistTag.MatchString(spec) && spec != "x" && spec != "X") {
+		var info NpmPackage
+		info, _, err = in.server.getPackageInfo(name, spec)
+		if err != nil {
+			return
+		}
+		return &installNode{info: info}, nil
+	}
+
+	r, e := parseVersionRange(spec)
+	if e != nil {
+		// the git repositories, the tarball URLs and the local paths
+		return nil, &unsupportedSpecError{name, spec}
+	}
+	h, ok := in.records[name]
+	if !ok {
+		h, err = in.server.fetchPackageRecords(name)
+		if err != nil {
+			return
+		}
+		in.records[name] = h
+	}
+	// npm prefers the `latest` tag if it satisfies the range
+	version := h.DistTags["latest"]
+	if v, ok := parseSemver(version); !ok || !r.Match(v) {
+		versions := make([]string, 0, len(h.Versions))
+		for v := range h.Versions {
+			versions = append(versions, v)
+		}
+		version, ok = maxSatisfying(versions, r)
+		if !ok {
+			return nil, &installError{specs: []string{fmt.Sprintf("%s@%s", name, spec)}, message: "no matching version in the registry"}
+		}
+	}
+	info := h.Versions[version]
+	if info.Name == "" {
+		info.Name = name
+	}
+	return &installNode{info: info}, nil
+}
+
+// fetch downloads and extracts the packages, the parent packages are extracted before the
+// nested ones since the extracting cleans the package directory.
+func (in *nativeInstaller) fetch(nodes []*installNode) error {
+	levels := map[int][]*installNode{}
+	maxDepth := 0
+	for _, node := range nodes {
+		if node.installed {
+			continue
+		}
+		depth := strings.Count(node.dir, "node_modules/")
+		levels[depth] = append(levels[depth], node)
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+	for depth := 1; depth <= maxDepth; depth++ {
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		var firstErr error
+		sem := make(chan struct{}, installConcurrency)
+		for _, node := range levels[depth] {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(node *installNode) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				err := in.extract(node)
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+				}
+			}(node)
+		}
+		wg.Wait()
+		if firstErr != nil {
+			return firstErr
+		}
+	}
+	return nil
+}
+
+func (in *nativeInstaller) extract(node *installNode) error {
+	if err := in.ctx.Err(); err != nil {
+		return err
+	}
+	tarball := node.tarball
+	cached := true
+	if tarball == "" {
+		var err error
+		tarball, cached, err = in.server.fetchTarball(in.ctx, node.info)
+		if err != nil {
+			return err
+		}
+	}
+	err := extractTarball(tarball, filepath.Join(in.wd, node.dir))
+	if err != nil {
+		return fmt.Errorf("extract %s@%s: %v", node.info.Name, node.info.Version, err)
+	}
+	if cached {
+		in.log.Printf("+ %s@%s (cached)", node.info.Name, node.info.Version)
+	} else {
+		in.log.Printf("+ %s@%s", node.info.Name, node.info.Version)
+	}
+	return nil
+}
+
+// tarballDigest returns the hash algorithm and the expected hex digest of the tarball, the
+// sha512 integrity is preferred to the legacy sha1 shasum.
+func tarballDigest(dist *NpmPackageDist) (algorithm string, digest string, ok bool) {
+	if dist == nil {
+		return
+	}
+	for _, s := range strings.Fields(dist.Integrity) {
+		if strings.HasPrefix(s, "sha512-") {
+			data, err := base64.StdEncoding.DecodeString(s[7:])
+			if err == nil {
+				return "sha512", hex.EncodeToString(data), true
+			}
+		}
+	}
+	if dist.Shasum != "" {
+		return "sha1", strings.ToLower(dist.Shasum), true
+	}
+	for _, s := range strings.Fields(dist.Integrity) {
+		if strings.HasPrefix(s, "sha1-") {
+			data, err := base64.StdEncoding.DecodeString(s[5:])
+			if err == nil {
+				return "sha1", hex.EncodeToString(data), true
+			}
+		}
+	}
+	return
+}
+
+// fetchTarball downloads the tarball of the package into the tarball cache, the cache is
+// addressed by the digest so it's shared by all the builds. returns true if the tarball is
+// cached before.
+func (s *Server) fetchTarball(ctx context.Context, info NpmPackage) (filename string, cached bool, err error) {
+	algorithm, digest, ok := tarballDigest(info.Dist)
+	if !ok || info.Dist.Tarball == "" {
+		err = fmt.Errorf("no tarball or shasum of %s@%s in the registry", info.Name, info.Version)
+		return
+	}
+	dir := filepath.Join(s.config.storageDir, "tarballs", algorithm, digest[:2])
+	filename = filepath.Join(dir, digest+".tgz")
+	if fileExists(filename) {
+		return filename, true, nil
+	}
+	err = ensureDir(dir)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", info.Dist.Tarball, nil)
+	if err != nil {
+		return
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("GET %s: %s", info.Dist.Tarball, resp.Status)
+		return
+	}
+
+	f, err := ioutil.TempFile(dir, ".download-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	var h hash.Hash = sha1.New()
+	if algorithm == "sha512" {
+		h = sha512.New()
+	}
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	f.Close()
+	if err != nil {
+		return
+	}
+	if hex.EncodeToString(h.Sum(nil)) != digest {
+		err = &installError{specs: []string{fmt.Sprintf("%s@%s", info.Name, info.Version)}, message: "the tarball doesn't match the shasum of the registry"}
+		return
+	}
+	// the concurrent downloads of the same tarball write the same content
+	err = os.Rename(f.Name(), filename)
+	return
+}
+
+// extractTarball extracts the npm tarball into the package directory, the root directory of
+// the tarball(usually `package/`) is stripped.
+func extractTarball(filename string, dir string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	err = ensureDir(dir)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, name := utils.SplitByFirstByte(path.Clean(strings.TrimPrefix(h.Name, "/")), '/')
+		if name == "" || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = ensureDir(target)
+		case tar.TypeReg, tar.TypeRegA:
+			err = ensureDir(filepath.Dir(target))
+			if err == nil {
+				err = writeTarEntry(tr, target, h.FileInfo().Mode())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeTarEntry(r io.Reader, filename string, mode os.FileMode) error {
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the files of the working directory that are stored in the install cache
+var installCacheFiles = []string{"node_modules", "package.json", "yarn.lock"}
+
+// installCacheKey returns the key of the install list, the order of the packages doesn't
+// matter.
+func (s *Server) installCacheKey(packages []string) string {
+	specs := make([]string, len(packages))
+	for i, spec := range packages {
+		specs[i] = links.InstallSpec(spec)
+	}
+	sort.Strings(specs)
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n", s.config.installer)
+	if s.node != nil {
+		fmt.Fprintf(h, "%s\n", s.node.npmRegistry)
+	}
+	for _, spec := range specs {
+		fmt.Fprintf(h, "%s\n", spec)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *Server) installCacheDir(key string) string {
+	return filepath.Join(s.config.storageDir, "installs", key[:2], key)
+}
+
+// restoreInstallCache restores the installed tree of the key into the working directory by
+// the hardlinks, the files are copied if the storage is on another device. returns false if
+// the tree is not cached.
+func (s *Server) restoreInstallCache(wd string, key string) bool {
+	dir := s.installCacheDir(key)
+	if !dirExists(dir) {
+		return false
+	}
+	for _, name := range installCacheFiles {
+		src := filepath.Join(dir, name)
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		// the top-level files may be rewritten by the later installs, they are not linked
+		err := cloneTree(src, filepath.Join(wd, name), name == "node_modules")
+		if err != nil {
+			s.log.Warnf("restore install cache %s: %v", key, err)
+			for _, name := range installCacheFiles {
+				os.RemoveAll(filepath.Join(wd, name))
+			}
+			return false
+		}
+	}
+	// the mtime of the cache is the last use for the gc
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return true
+}
+
+// saveInstallCache stores the installed tree of the working directory in the install cache,
+// the concurrent saves of the same key keep the first one.
+func (s *Server) saveInstallCache(wd string, key string) error {
+	dir := s.installCacheDir(key)
+	if dirExists(dir) {
+		return nil
+	}
+	err := ensureDir(filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+	tmpDir := dir + ".tmp" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	defer os.RemoveAll(tmpDir)
+	err = ensureDir(tmpDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range installCacheFiles {
+		src := filepath.Join(wd, name)
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		err = cloneTree(src, filepath.Join(tmpDir, name), name == "node_modules")
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(tmpDir, dir)
+	if err != nil && dirExists(dir) {
+		return nil
+	}
+	return err
+}
+
+// cloneTree clones the file or the directory, the regular files in the `node_modules` are
+// hardlinked if possible except the top-level ones like `.yarn-integrity`.
+func cloneTree(src string, dst string, link bool) error {
+	return filepath.Walk(src, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, filename)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			// the links of `.bin`
+			dest, err := os.Readlink(filename)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(dest, target)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		if link && strings.ContainsRune(rel, filepath.Separator) && os.Link(filename, target) == nil {
+			return nil
+		}
+		return copyFile(filename, target, info.Mode())
+	})
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if e := w.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// startInstallCacheGC removes the cached trees that are not used in the `install-cache-ttl`.
+func (s *Server) startInstallCacheGC() {
+	if s.config.installCacheTTL <= 0 {
+		return
+	}
+
+	interval := s.config.installCacheTTL / 2
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			n, err := s.gcInstallCache(time.Now())
+			if err != nil {
+				s.log.Errorf("gc install cache: %v", err)
+			} else if n > 0 {
+				s.log.Debugf("gc install cache: %d trees removed", n)
+			}
+		}
+	}()
+}
+
+// gcInstallCache removes the cached trees that are not used in the `install-cache-ttl`, the
+// leftovers of the failed saves are removed as well.
+func (s *Server) gcInstallCache(now time.Time) (removed int, err error) {
+	root := filepath.Join(s.config.storageDir, "installs")
+	shards, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(root, shard.Name()))
+		if err != nil {
+			return removed, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && now.Sub(entry.ModTime()) >= s.config.installCacheTTL {
+				if os.RemoveAll(filepath.Join(root, shard.Name(), entry.Name())) == nil {
+					removed++
+				}
+			}
+		}
+	}
+	return
+}
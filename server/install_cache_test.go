@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInstallCache(t *testing.T) {
+	registry, s := newTestServer(t)
+	s.config.installCacheTTL = time.Hour
+
+	if s.installCacheKey([]string{"a@1.0.0", "b@2.0.0"}) != s.installCacheKey([]string{"b@2.0.0", "a@1.0.0"}) {
+		t.Fatal("the key should not depend on the order of the packages")
+	}
+
+	wd, err := ioutil.TempDir("", "esm-install-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+	err = s.installPackages(context.Background(), wd, "esm-fixture-esm@1.0.0", "esm-fixture-cjs@1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := s.installCacheDir(s.installCacheKey([]string{"esm-fixture-cjs@1.0.0", "esm-fixture-esm@1.0.0"}))
+	if !fileExists(filepath.Join(cacheDir, "node_modules", "esm-fixture-dep", "index.mjs")) {
+		t.Fatal("the installed tree should be cached")
+	}
+
+	// the cached tree is restored without the registry
+	registry.Close()
+	wd2, err := ioutil.TempDir("", "esm-install-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd2)
+	err = s.installPackages(context.Background(), wd2, "esm-fixture-cjs@1.0.0", "esm-fixture-esm@1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"esm-fixture-esm/package.json", "esm-fixture-cjs/index.js", "esm-fixture-dep/index.mjs"} {
+		if !fileExists(filepath.Join(wd2, "node_modules", name)) {
+			t.Fatalf("%s is not restored", name)
+		}
+	}
+
+	n, err := s.gcInstallCache(time.Now())
+	if err != nil || n != 0 {
+		t.Fatalf("unexpected gc %d, %v", n, err)
+	}
+	n, err = s.gcInstallCache(time.Now().Add(2 * time.Hour))
+	if err != nil || n != 1 || dirExists(cacheDir) {
+		t.Fatalf("unexpected gc %d, %v", n, err)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNativeInstall(t *testing.T) {
+	registry, s := newTestServer(t)
+
+	wd, err := ioutil.TempDir("", "esm-install-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+	err = s.installPackages(context.Background(), wd, "esm-fixture-esm@1.0.0", "esm-fixture-cjs@1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"esm-fixture-esm/package.json", "esm-fixture-cjs/index.js", "esm-fixture-dep/index.mjs"} {
+		if !fileExists(filepath.Join(wd, "node_modules", name)) {
+			t.Fatalf("%s is not installed", name)
+		}
+	}
+	tarballs, _ := filepath.Glob(filepath.Join(s.config.storageDir, "tarballs", "sha1", "*", "*.tgz"))
+	if len(tarballs) != 3 {
+		t.Fatalf("unexpected cached tarballs %v", tarballs)
+	}
+
+	// the tarballs are extracted from the cache without the registry
+	wd2, err := ioutil.TempDir("", "esm-install-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd2)
+	registry.Close()
+	err = s.installPackages(context.Background(), wd2, "esm-fixture-esm@1.0.0")
+	if err == nil {
+		t.Fatal("the range of the dependency should be resolved by the registry")
+	}
+	err = s.installPackages(context.Background(), wd, "esm-fixture-esm@1.0.0")
+	if err != nil {
+		t.Fatalf("the installed dependency should be reused: %v", err)
+	}
+}
+
+func TestInstallTree(t *testing.T) {
+	s := setupTestEnv(t)
+
+	in := &nativeInstaller{
+		server: s,
+		placed: map[string]*installNode{},
+		records: map[string]NpmPackageRecords{
+			"a": {Versions: map[string]NpmPackage{"1.0.0": {Name: "a", Version: "1.0.0", Dependencies: map[string]string{"c": "^1.0.0"}}}},
+			"b": {Versions: map[string]NpmPackage{"1.0.0": {Name: "b", Version: "1.0.0", Dependencies: map[string]string{"c": "^2.0.0", "d": "npm:c@~1.0.0"}}}},
+			"c": {
+				DistTags: map[string]string{"latest": "2.0.0"},
+				Versions: map[string]NpmPackage{
+					"1.0.0": {Name: "c", Version: "1.0.0"},
+					"1.1.0": {Name: "c", Version: "1.1.0"},
+					"2.0.0": {Name: "c", Version: "2.0.0"},
+				},
+			},
+		},
+	}
+	nodes, err := in.resolveTree([]string{"a@^1.0.0", "b@1.x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := []string{}
+	for _, node := range nodes {
+		tree = append(tree, node.dir+"@"+node.info.Version)
+	}
+	expected := "node_modules/a@1.0.0,node_modules/b@1.0.0,node_modules/c@1.1.0,node_modules/b/node_modules/c@2.0.0,node_modules/d@1.0.0"
+	if strings.Join(tree, ",") != expected {
+		t.Fatalf("unexpected tree %v", tree)
+	}
+
+	// the local files are not read by the specs of the registry packages
+	for _, spec := range []string{"github:user/e", "file:/etc/passwd", "file:../e.tgz"} {
+		if _, err := in.resolve("e", spec); err == nil {
+			t.Fatalf("the dependency %s should be unsupported", spec)
+		} else if _, ok := err.(*unsupportedSpecError); !ok {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestExtractTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-extract-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range map[string]string{
+		"package/package.json":  `{"name":"a"}`,
+		"package/lib/index.js":  "module.exports = 1",
+		"package/../../evil.js": "evil",
+	} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gw.Close()
+	tarball := filepath.Join(dir, "a.tgz")
+	err = ioutil.WriteFile(tarball, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgDir := filepath.Join(dir, "node_modules", "a")
+	err = extractTarball(tarball, pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(pkgDir, "lib", "index.js")); string(data) != "module.exports = 1" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if fileExists(filepath.Join(dir, "evil.js")) || fileExists(filepath.Join(dir, "node_modules", "evil.js")) {
+		t.Fatal("the entry out of the package directory should be skipped")
+	}
+}
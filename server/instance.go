@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	logx "github.com/ije/gox/log"
+	"github.com/ije/rex"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/postui/postdb"
+)
+
+// A Server carries the dependencies of the esm.sh server: the config, the db, the nodejs env,
+// the logger and the embedded files. The handlers, the build tasks and the background jobs
+// read the dependencies of their server, so the servers of different configs can run in one
+// process, like in the tests.
+type Server struct {
+	config  *Config
+	db      *postdb.DB
+	node    *NodeEnv
+	log     *logx.Logger
+	embedFS *embed.FS
+	mmdbr   *maxminddb.Reader
+	// the client of the registry requests with the proxy configs
+	httpClient *http.Client
+	// the replication feed of the new builds, nil if it's disabled
+	feed *buildFeed
+	// refreshes the floating versions of the served builds, it's created by the handler
+	revalidator *versionRevalidator
+	// the purge jobs started by the admin
+	purgeJobs *purgeJobTracker
+	// the installed cjs-module-lexer app
+	cjsModuleLexer struct {
+		sync.Mutex
+		pool *nodeWorkerPool
+	}
+	// the hash of the embedded polyfills
+	polyfills struct {
+		sync.Once
+		hash string
+	}
+}
+
+// newServer initiates the nodejs env, the storage and the db of the config, and installs the
+// embedded polyfills and types into the storage.
+func newServer(config *Config, logger *logx.Logger, fs *embed.FS, etcDir string) (s *Server, err error) {
+	s = &Server{config: config, log: logger, embedFS: fs, httpClient: newHTTPClient(config)}
+	if fs == nil {
+		s.embedFS = &embed.FS{}
+	}
+	s.purgeJobs = newPurgeJobTracker(s)
+
+	s.node, err = s.checkNodeEnv()
+	if err != nil {
+		return nil, fmt.Errorf("check nodejs env: %v", err)
+	}
+	logger.Debugf("nodejs v%s installed", s.node.version)
+
+	ensureDir(filepath.Join(config.storageDir, fmt.Sprintf("builds/v%d", VERSION)))
+	ensureDir(filepath.Join(config.storageDir, fmt.Sprintf("types/v%d", VERSION)))
+	ensureDir(filepath.Join(config.storageDir, "raw"))
+
+	s.db, err = postdb.Open(filepath.Join(etcDir, "esm.db"), 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open esm.db: %v", err)
+	}
+
+	if fs != nil {
+		err = s.installEmbedFiles()
+		if err != nil {
+			s.db.Close()
+			return nil, err
+		}
+		// the china_ip_list.mmdb downloaded by `esmd init -geoip` takes precedence
+		mmdata, e := ioutil.ReadFile(filepath.Join(etcDir, "china_ip_list.mmdb"))
+		if e != nil {
+			mmdata, e = fs.ReadFile("embed/china_ip_list.mmdb")
+		}
+		if e == nil {
+			s.mmdbr, err = maxminddb.FromBytes(mmdata)
+			if err != nil {
+				s.db.Close()
+				return nil, err
+			}
+			logger.Debugf("china_ip_list.mmdb applied: %+v", s.mmdbr.Metadata)
+		}
+	}
+	return
+}
+
+// installEmbedFiles writes the embedded polyfills and types into the storage.
+func (s *Server) installEmbedFiles() error {
+	polyfills, err := s.embedFS.ReadDir("embed/polyfills")
+	if err != nil {
+		return err
+	}
+	for _, entry := range polyfills {
+		name := entry.Name()
+		filename := filepath.Join(s.config.storageDir, fmt.Sprintf("builds/v%d/_%s", VERSION, name))
+		data, err := s.embedFS.ReadFile(fmt.Sprintf("embed/polyfills/%s", name))
+		if err != nil {
+			return err
+		}
+		// the polyfills are updated with the server, the builds are rebuilt by the polyfills hash
+		if current, err := ioutil.ReadFile(filename); err != nil || !bytes.Equal(current, data) {
+			err = s.writeArtifact(filename, bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			s.log.Debugf("%s updated", name)
+		}
+		// re-sign the polyfills if the signing key is changed
+		if s.config.signingKey != nil {
+			if sig, ok := readArtifactSignature(filename); !ok || sig.KeyID != s.currentSigningKeyID() {
+				err = s.signArtifact(filename)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	types, err := s.embedFS.ReadDir("embed/types")
+	if err != nil {
+		return err
+	}
+	for _, entry := range types {
+		name := entry.Name()
+		filename := filepath.Join(s.config.storageDir, fmt.Sprintf("types/v%d/_%s", VERSION, name))
+		if !fileExists(filename) {
+			file, err := s.embedFS.Open(fmt.Sprintf("embed/types/%s", name))
+			if err != nil {
+				return err
+			}
+			f, err := os.Create(filename)
+			if err != nil {
+				file.Close()
+				return err
+			}
+			_, err = io.Copy(f, file)
+			f.Close()
+			file.Close()
+			if err != nil {
+				return err
+			}
+			s.log.Debugf("%s added", name)
+		}
+	}
+	return nil
+}
+
+// handle returns the handle of the requests with the abuse guard, each handle has its own
+// build queue.
+func (s *Server) handle() rex.Handle {
+	return s.guardAbuse(s.query())
+}
+
+// Handler returns the http handler of the server with the middlewares, it's used to serve the
+// server without the default rex handler, like in the tests.
+func (s *Server) Handler(middlewares ...rex.Handle) http.Handler {
+	h := &rex.APIHandler{}
+	h.Use(middlewares...)
+	h.Use(s.handle())
+	return h
+}
+
+// Close stops the cjs-module-lexer workers, flushes the logger and closes the db of the server.
+func (s *Server) Close() {
+	s.stopCJSModuleLexer()
+	s.log.FlushBuffer()
+	if s.db != nil {
+		s.db.Close()
+	}
+}
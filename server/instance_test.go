@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServerHandler(t *testing.T) {
+	_, s := newTestServer(t)
+	handler := s.Handler()
+
+	buildPath := fmt.Sprintf("/v%d/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm.js", VERSION)
+	for _, c := range []struct {
+		url      string
+		status   int
+		location string
+		contains string
+	}{
+		{"/esm-fixture-esm@1.0.0?target=es2020", 200, "", `export * from "` + buildPath + `";`},
+		{"/esm-fixture-esm@1.0.0?Target=ES2020", 301, "/esm-fixture-esm@1.0.0?target=es2020", ""},
+		{buildPath, 200, "", "esm.sh - esbuild bundle(esm-fixture-esm@1.0.0) es2020 production"},
+		{"/esm-fixture-esm@1.0.0?target=es3", 200, "", "unsupported target 'es3'"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", c.url, nil))
+		if rec.Code != c.status {
+			t.Fatalf("GET %s: unexpected status %d:\n%s", c.url, rec.Code, rec.Body.String())
+		}
+		if location := rec.Header().Get("Location"); location != c.location {
+			t.Fatalf("GET %s: unexpected location '%s'", c.url, location)
+		}
+		if !strings.Contains(rec.Body.String(), c.contains) {
+			t.Fatalf("GET %s: unexpected body:\n%s", c.url, rec.Body.String())
+		}
+	}
+}
+
+func TestServerInstances(t *testing.T) {
+	servers := map[string]*Server{}
+	for _, domain := range []string{"a.example", "b.example"} {
+		_, s := newTestServer(t)
+		s.config.domain = domain
+		servers[domain] = s
+	}
+
+	// the servers of different configs build the same package concurrently in one process
+	buildPath := fmt.Sprintf("/v%d/esm-fixture-metaurl@1.0.0/es2020/esm-fixture-metaurl.js", VERSION)
+	codes := map[string]string{}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for domain, s := range servers {
+		wg.Add(1)
+		go func(domain string, s *Server) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", buildPath, nil))
+			lock.Lock()
+			codes[domain] = rec.Body.String()
+			lock.Unlock()
+		}(domain, s)
+	}
+	wg.Wait()
+
+	for domain, code := range codes {
+		fileURL := fmt.Sprintf(`"https://%s/esm-fixture-metaurl@1.0.0/lib/util.mjs"`, domain)
+		if !strings.Contains(code, fileURL) {
+			t.Fatalf("the build of %s should use its own domain:\n%s", domain, code)
+		}
+		if _, _, ok := servers[domain].findESM(strings.TrimSuffix(buildPath[1:], ".js")); !ok {
+			t.Fatalf("the build should be recorded in the db of %s", domain)
+		}
+	}
+}
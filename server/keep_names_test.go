@@ -0,0 +1,22 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeepNamesBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	for _, keepNames := range []bool{false, true} {
+		task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-keepnames@1.0.0"), cjsExports: "auto", target: "es2020", keepNames: keepNames}
+		if strings.Contains(task.ID(), "/keep-names/es2020/") != keepNames {
+			t.Fatalf("unexpected build ID %s", task.ID())
+		}
+		// the minified class name is only kept as a string by the keep-names option
+		code := buildFixture(t, task)
+		if strings.Contains(code, `"Widget"`) != keepNames {
+			t.Fatalf("build %s: unexpected output:\n%s", task.ID(), code)
+		}
+	}
+}
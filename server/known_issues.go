@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// A knownIssue marks the versions of a package that are known to be broken on esm.sh, like the
+// bad `exports` maps or the native bindings, the requests of them fail fast with the suggestion
+// instead of burning the build attempts.
+type knownIssue struct {
+	Name string `json:"name"`
+	// the semver range of the broken versions, like `>=2.0.0 <2.1.3`, empty means all versions
+	Versions string `json:"versions,omitempty"`
+	Reason   string `json:"reason"`
+	// the working versions or the query flags, like `bcryptjs` or `?target=es2020`
+	Suggest string `json:"suggest,omitempty"`
+
+	versions versionRange
+	// the version of the request that matches the issue
+	version string
+}
+
+func (issue knownIssue) Error() string {
+	msg := fmt.Sprintf("%s@%s is known to be broken on esm.sh: %s", issue.Name, issue.version, issue.Reason)
+	if issue.Suggest != "" {
+		msg += fmt.Sprintf(", try '%s' instead", issue.Suggest)
+	}
+	return msg
+}
+
+// match reports whether the version of the package is broken.
+func (issue knownIssue) match(name string, version string) bool {
+	if issue.Name != name {
+		return false
+	}
+	if issue.Versions == "" {
+		return true
+	}
+	v, ok := parseSemver(version)
+	return ok && issue.versions.Match(v)
+}
+
+// parseKnownIssues parses the table of the known issues in JSON, like
+// `[{"name":"node-sass","reason":"native bindings","suggest":"sass"}]`.
+func parseKnownIssues(data []byte) (issues []knownIssue, err error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	err = json.Unmarshal(data, &issues)
+	if err != nil {
+		return nil, fmt.Errorf("invalid known issues: %v", err)
+	}
+	for i, issue := range issues {
+		if !regPkgName.MatchString(issue.Name) {
+			return nil, fmt.Errorf("invalid package name '%s' in the known issues", issue.Name)
+		}
+		if issue.Reason == "" {
+			return nil, fmt.Errorf("missing the reason of the known issue of '%s'", issue.Name)
+		}
+		if issue.Versions != "" {
+			issues[i].versions, err = parseVersionRange(issue.Versions)
+			if err != nil {
+				return nil, fmt.Errorf("invalid versions '%s' of the known issue of '%s': %v", issue.Versions, issue.Name, err)
+			}
+		}
+	}
+	return
+}
+
+// the curated known issues embedded in the server, they are replaced by the updates from the
+// `known-issues-url`
+var curatedKnownIssues struct {
+	lock   sync.RWMutex
+	issues []knownIssue
+}
+
+// lookupKnownIssue returns the known issue of the package version, the issues of the config
+// take precedence over the curated ones.
+func (s *Server) lookupKnownIssue(name string, version string) (knownIssue, bool) {
+	for _, issue := range s.config.knownIssues {
+		if issue.match(name, version) {
+			issue.version = version
+			return issue, true
+		}
+	}
+	curatedKnownIssues.lock.RLock()
+	defer curatedKnownIssues.lock.RUnlock()
+	for _, issue := range curatedKnownIssues.issues {
+		if issue.match(name, version) {
+			issue.version = version
+			return issue, true
+		}
+	}
+	return knownIssue{}, false
+}
+
+// updateKnownIssues fetches the known issues from the URL, the current issues are kept if the
+// update fails.
+func (s *Server) updateKnownIssues(url string) (n int, err error) {
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	issues, err := parseKnownIssues(data)
+	if err != nil {
+		return
+	}
+	setCuratedKnownIssues(issues)
+	return len(issues), nil
+}
+
+func setCuratedKnownIssues(issues []knownIssue) {
+	curatedKnownIssues.lock.Lock()
+	curatedKnownIssues.issues = issues
+	curatedKnownIssues.lock.Unlock()
+}
+
+func (s *Server) startKnownIssuesUpdater() {
+	if s.config.knownIssuesURL == "" {
+		return
+	}
+
+	go func() {
+		for {
+			n, err := s.updateKnownIssues(s.config.knownIssuesURL)
+			if err != nil {
+				s.log.Errorf("update known issues: %v", err)
+			} else {
+				s.log.Debugf("%d known issues updated from %s", n, s.config.knownIssuesURL)
+			}
+			time.Sleep(s.config.knownIssuesRefresh)
+		}
+	}()
+}
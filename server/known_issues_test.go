@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseKnownIssues(t *testing.T) {
+	issues, err := parseKnownIssues([]byte(`[{"name":"a","versions":">=2.0.0 <2.1.3","reason":"bad exports","suggest":"a@2.1.3"},{"name":"@b/c","reason":"native bindings"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name    string
+		version string
+		match   bool
+	}{
+		{"a", "2.0.0", true},
+		{"a", "2.1.2", true},
+		{"a", "2.1.3", false},
+		{"a", "1.9.9", false},
+		{"@b/c", "1.0.0", true},
+		{"b", "1.0.0", false},
+	} {
+		matched := false
+		for _, issue := range issues {
+			if issue.match(c.name, c.version) {
+				matched = true
+			}
+		}
+		if matched != c.match {
+			t.Fatalf("%s@%s: expected match=%v", c.name, c.version, c.match)
+		}
+	}
+
+	for _, s := range []string{
+		`{"name":"a"}`,
+		`[{"name":"A B","reason":"x"}]`,
+		`[{"name":"a"}]`,
+		`[{"name":"a","versions":"^abc","reason":"x"}]`,
+	} {
+		if _, err := parseKnownIssues([]byte(s)); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+}
+
+func TestKnownIssueFailFast(t *testing.T) {
+	_, s := newTestServer(t)
+	issues, err := parseKnownIssues([]byte(`[{"name":"esm-fixture-cjs","versions":"1.x","reason":"the exports are broken","suggest":"esm-fixture-esm"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.config.knownIssues = issues
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/esm-fixture-cjs@1.0.0?target=es2020", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, "esm-fixture-cjs@1.0.0 is known to be broken on esm.sh: the exports are broken, try 'esm-fixture-esm' instead") {
+		t.Fatalf("unexpected response %d:\n%s", rec.Code, body)
+	}
+	if _, _, ok := s.findESM(fmt.Sprintf("v%d/esm-fixture-cjs@1.0.0/es2020/esm-fixture-cjs", VERSION)); ok {
+		t.Fatal("the package should not be built")
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/esm-fixture-esm@1.0.0?target=es2020", nil))
+	if strings.Contains(rec.Body.String(), "known to be broken") {
+		t.Fatalf("unexpected response %d:\n%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpdateKnownIssues(t *testing.T) {
+	_, s := newTestServer(t)
+	defer setCuratedKnownIssues(nil)
+
+	status := 200
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`[{"name":"esm-fixture-dep","reason":"native bindings"}]`))
+	}))
+	defer ts.Close()
+
+	n, err := s.updateKnownIssues(ts.URL)
+	if err != nil || n != 1 {
+		t.Fatalf("unexpected update %d, %v", n, err)
+	}
+	if _, ok := s.lookupKnownIssue("esm-fixture-dep", "1.0.0"); !ok {
+		t.Fatal("the updated issue should be matched")
+	}
+
+	// the failed updates keep the current issues
+	status = 500
+	if _, err := s.updateKnownIssues(ts.URL); err == nil {
+		t.Fatal("the update should fail")
+	}
+	if issue, ok := s.lookupKnownIssue("esm-fixture-dep", "1.0.0"); !ok || !strings.Contains(issue.Error(), "esm-fixture-dep@1.0.0") {
+		t.Fatal("the issues should be kept")
+	}
+}
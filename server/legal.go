@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// the modes of the legal comments of builds
+var legalCommentsModes = map[string]bool{
+	"eof":    true, // keep the legal comments at the end of the build
+	"none":   true, // drop the legal comments
+	"linked": true, // move the legal comments and the licenses into the `.LEGAL.txt` artifact
+}
+
+var licenseFileNames = []string{
+	"LICENSE",
+	"LICENSE.md",
+	"LICENSE.txt",
+	"LICENCE",
+	"LICENCE.md",
+	"LICENCE.txt",
+	"license",
+	"license.md",
+	"license.txt",
+}
+
+type esbuildMetafile struct {
+	Inputs map[string]struct {
+		Bytes int `json:"bytes"`
+	} `json:"inputs"`
+}
+
+// legalCommentsMode returns the mode of the legal comments of the build, the `?legal-comments`
+// query overrides the config of the server.
+func (task *buildTask) legalCommentsMode() string {
+	if task.legalComments != "" {
+		return task.legalComments
+	}
+	return task.config.legalComments
+}
+
+// splitLegalComments cuts the legal comments(`/*! ... */`, `@license` and `@preserve`)
+// that esbuild moved to the end of the output.
+func splitLegalComments(code []byte) (rest []byte, comments [][]byte) {
+	rest = bytes.TrimRight(code, " \t\r\n")
+	for bytes.HasSuffix(rest, []byte("*/")) {
+		i := bytes.LastIndex(rest[:len(rest)-2], []byte("/*"))
+		if i < 0 {
+			break
+		}
+		comment := rest[i:]
+		if !bytes.HasPrefix(comment, []byte("/*!")) && !bytes.Contains(comment, []byte("@license")) && !bytes.Contains(comment, []byte("@preserve")) {
+			break
+		}
+		comments = append([][]byte{comment}, comments...)
+		rest = bytes.TrimRight(rest[:i], " \t\r\n")
+	}
+	if len(comments) == 0 {
+		rest = code
+	} else {
+		rest = append(rest, '\n')
+	}
+	return
+}
+
+// bundledPackages returns the directories of packages whose files are bundled
+// in the build by the esbuild metafile.
+func bundledPackages(metafile string) (pkgs []string, err error) {
+	var meta esbuildMetafile
+	err = json.Unmarshal([]byte(metafile), &meta)
+	if err != nil {
+		return
+	}
+
+	set := newStringSet()
+	for input := range meta.Inputs {
+		i := strings.LastIndex(input, "node_modules/")
+		if i < 0 {
+			continue
+		}
+		a := strings.Split(input[i+len("node_modules/"):], "/")
+		dir := input[:i+len("node_modules/")] + a[0]
+		if strings.HasPrefix(a[0], "@") && len(a) > 1 {
+			dir += "/" + a[1]
+		}
+		set.Add(dir)
+	}
+	pkgs = set.Values()
+	sort.Strings(pkgs)
+	return
+}
+
+// extractLegalComments creates the content of the `.LEGAL.txt` artifact that contains
+// the license of every bundled package and the legal comments.
+func extractLegalComments(wd string, metafile string, header string, comments [][]byte) ([]byte, error) {
+	pkgDirs, err := bundledPackages(metafile)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "%s\n", header)
+	for _, dir := range pkgDirs {
+		var p struct {
+			Name    string      `json:"name"`
+			Version string      `json:"version"`
+			License interface{} `json:"license"`
+		}
+		if utils.ParseJSONFile(filepath.Join(wd, dir, "package.json"), &p) != nil || p.Name == "" {
+			continue
+		}
+		license := ""
+		switch v := p.License.(type) {
+		case string:
+			license = v
+		case map[string]interface{}:
+			license, _ = v["type"].(string)
+		}
+		fmt.Fprintf(buf, "\n=== %s@%s", p.Name, p.Version)
+		if license != "" {
+			fmt.Fprintf(buf, " (%s)", license)
+		}
+		buf.WriteString(" ===\n")
+		for _, name := range licenseFileNames {
+			data, err := ioutil.ReadFile(filepath.Join(wd, dir, name))
+			if err == nil {
+				buf.WriteByte('\n')
+				buf.Write(bytes.TrimSpace(data))
+				buf.WriteByte('\n')
+				break
+			}
+		}
+	}
+	if len(comments) > 0 {
+		buf.WriteString("\n=== legal comments ===\n")
+		for _, comment := range comments {
+			buf.WriteByte('\n')
+			buf.Write(comment)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
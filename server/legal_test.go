@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitLegalComments(t *testing.T) {
+	code := strings.Join([]string{
+		`var a="/*! not a comment */";export{a};`,
+		`/*! lib v1.0.0 | MIT */`,
+		`/**`,
+		` * @license React`,
+		` */`,
+		``,
+	}, "\n")
+
+	rest, comments := splitLegalComments([]byte(code))
+	if string(rest) != `var a="/*! not a comment */";export{a};`+"\n" {
+		t.Fatalf("unexpected code: %s", rest)
+	}
+	if len(comments) != 2 || string(comments[0]) != `/*! lib v1.0.0 | MIT */` {
+		t.Fatalf("unexpected comments: %q", comments)
+	}
+
+	rest, comments = splitLegalComments([]byte(`export default 1;/* not legal */`))
+	if len(comments) != 0 || string(rest) != `export default 1;/* not legal */` {
+		t.Fatalf("unexpected split: %s %q", rest, comments)
+	}
+}
+
+func TestLegalCommentsQuery(t *testing.T) {
+	_, s := newTestServer(t)
+	handler := s.Handler()
+
+	get := func(url string) string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != 200 {
+			t.Fatalf("unexpected status %d of %s:\n%s", rec.Code, url, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	// the default mode of the server is not in the build path
+	for _, c := range []struct {
+		query   string
+		segment string
+	}{
+		{"target=es2020", "/es2020/"},
+		{"legal-comments=none&target=es2020", "/legal-comments=none/es2020/"},
+		{"legal-comments=linked&target=es2020", "/legal-comments=linked/es2020/"},
+	} {
+		id := fmt.Sprintf("v%d/esm-fixture-legal@1.0.0%sesm-fixture-legal", VERSION, c.segment)
+		if body := get("/esm-fixture-legal@1.0.0?" + c.query); !strings.Contains(body, "/"+id+".js") {
+			t.Fatalf("the build path of '%s' should be %s:\n%s", c.query, id, body)
+		}
+	}
+
+	id := fmt.Sprintf("v%d/esm-fixture-legal@1.0.0/es2020/esm-fixture-legal", VERSION)
+	if code := get("/" + id + ".js"); !strings.Contains(code, "/*! esm-fixture-legal v1.0.0 | MIT License */") {
+		t.Fatalf("the legal comments should be kept by default:\n%s", code)
+	}
+	id = fmt.Sprintf("v%d/esm-fixture-legal@1.0.0/legal-comments=none/es2020/esm-fixture-legal", VERSION)
+	if code := get("/" + id + ".js"); strings.Contains(code, "MIT License") || fileExists(filepath.Join(s.config.storageDir, "builds", id+".LEGAL.txt")) {
+		t.Fatalf("the legal comments should be dropped:\n%s", code)
+	}
+	id = fmt.Sprintf("v%d/esm-fixture-legal@1.0.0/legal-comments=linked/es2020/esm-fixture-legal", VERSION)
+	code := get("/" + id + ".js")
+	if strings.Contains(code, "| MIT License */") || !strings.Contains(code, fmt.Sprintf("/*! For license information please see /%s.LEGAL.txt */", id)) {
+		t.Fatalf("the legal comments should be linked:\n%s", code)
+	}
+	if legal := get("/" + id + ".LEGAL.txt"); !strings.Contains(legal, "=== esm-fixture-legal@1.0.0 (MIT) ===") || !strings.Contains(legal, "/*! esm-fixture-legal v1.0.0 | MIT License */") {
+		t.Fatalf("unexpected legal comments:\n%s", legal)
+	}
+
+	if body := get("/esm-fixture-legal@1.0.0?legal-comments=inline"); !strings.Contains(body, "invalid legal-comments mode 'inline'") {
+		t.Fatalf("the unknown mode should be rejected:\n%s", body)
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+const linkMaxTarballSize = 50 << 20
+
+// A linkedPackage is an unpublished package uploaded by `PUT /-/link`, it's versioned as
+// `{version}-link.{id}` and served like the published packages until it expires.
+type linkedPackage struct {
+	info    NpmPackage
+	tarball string
+	expires time.Time
+}
+
+type linkRegistry struct {
+	lock     sync.Mutex
+	packages map[string]*linkedPackage // name@version -> package
+}
+
+var links = &linkRegistry{packages: map[string]*linkedPackage{}}
+
+func isLinkVersion(version string) bool {
+	return strings.Contains(version, "-link.")
+}
+
+// Get returns the linked package that is not expired.
+func (r *linkRegistry) Get(name string, version string) (*linkedPackage, bool) {
+	if !isLinkVersion(version) {
+		return nil, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	p, ok := r.packages[name+"@"+version]
+	if !ok || time.Now().After(p.expires) {
+		return nil, false
+	}
+	return p, true
+}
+
+// Add links the package tarball, returns the linked package. The expire callback is called
+// after the package is unlinked.
+func (r *linkRegistry) Add(tarball []byte, ttl time.Duration, expire func(p *linkedPackage)) (p *linkedPackage, err error) {
+	info, err := readTarballPackage(bytes.NewReader(tarball))
+	if err != nil {
+		return
+	}
+	if info.Name == "" || !regFullVersion.MatchString(info.Version) {
+		return nil, errors.New("invalid package.json: missing name or version")
+	}
+
+	id := make([]byte, 4)
+	_, err = rand.Read(id)
+	if err != nil {
+		return
+	}
+	info.Version = fmt.Sprintf("%s-link.%s", info.Version, hex.EncodeToString(id))
+	filename := filepath.Join(os.TempDir(), "esmd-links", fmt.Sprintf("%s@%s.tgz", strings.ReplaceAll(info.Name, "/", "_"), info.Version))
+	err = writeFileAtomic(filename, bytes.NewReader(tarball))
+	if err != nil {
+		return
+	}
+
+	p = &linkedPackage{
+		info:    info,
+		tarball: filename,
+		expires: time.Now().Add(ttl),
+	}
+	r.lock.Lock()
+	r.packages[info.Name+"@"+info.Version] = p
+	r.lock.Unlock()
+
+	time.AfterFunc(ttl, func() {
+		r.lock.Lock()
+		delete(r.packages, info.Name+"@"+info.Version)
+		r.lock.Unlock()
+		if expire != nil {
+			expire(p)
+		}
+	})
+	return
+}
+
+// InstallSpec returns the yarn spec of the package, the linked packages are installed from
+// the tarball.
+func (r *linkRegistry) InstallSpec(spec string) string {
+	i := strings.LastIndexByte(spec, '@')
+	if i <= 0 {
+		return spec
+	}
+	if p, ok := r.Get(spec[:i], spec[i+1:]); ok {
+		return fmt.Sprintf("%s@file:%s", p.info.Name, p.tarball)
+	}
+	return spec
+}
+
+// readTarballPackage reads the `package.json` in the npm tarball.
+func readTarballPackage(r io.Reader) (info NpmPackage, err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return
+	}
+	tr := tar.NewReader(gr)
+	for {
+		var h *tar.Header
+		h, err = tr.Next()
+		if err == io.EOF {
+			err = errors.New("package.json not found in the tarball")
+			return
+		}
+		if err != nil {
+			return
+		}
+		// the root directory of npm tarballs is not always `package/`
+		a := strings.Split(h.Name, "/")
+		if h.Typeflag == tar.TypeReg && len(a) == 2 && a[1] == "package.json" {
+			var data []byte
+			data, err = ioutil.ReadAll(io.LimitReader(tr, 1<<20))
+			if err == nil {
+				err = json.Unmarshal(data, &info)
+			}
+			return
+		}
+	}
+}
+
+// purgeLinkedPackage removes the tarball and the builds of the expired linked package.
+func (s *Server) purgeLinkedPackage(p *linkedPackage) {
+	os.Remove(p.tarball)
+	n, err := s.purgeBuilds(p.info.Name, p.info.Version)
+	if err != nil {
+		s.log.Errorf("purge linked package %s@%s: %v", p.info.Name, p.info.Version, err)
+		return
+	}
+	s.log.Debugf("linked package %s@%s expired, %d builds purged", p.info.Name, p.info.Version, n)
+}
+
+// linkPackage handles the `PUT /-/link` requests, the body is the tarball created by `npm pack`.
+func (s *Server) linkPackage(ctx *rex.Context) interface{} {
+	if s.config.linkTTL <= 0 {
+		return rex.Err(404)
+	}
+	if ctx.R.Method != "PUT" {
+		ctx.SetHeader("Allow", "PUT")
+		return rex.Status(405, "method not allowed")
+	}
+
+	tarball, err := ioutil.ReadAll(io.LimitReader(ctx.R.Body, linkMaxTarballSize+1))
+	if err != nil {
+		return rex.Status(400, err.Error())
+	}
+	if len(tarball) > linkMaxTarballSize {
+		return rex.Status(413, "the tarball is too large")
+	}
+	p, err := links.Add(tarball, s.config.linkTTL, s.purgeLinkedPackage)
+	if err != nil {
+		return rex.Status(400, fmt.Sprintf("invalid tarball: %v", err))
+	}
+
+	proto := "http"
+	if ctx.R.TLS != nil {
+		proto = "https"
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	return map[string]interface{}{
+		"name":    p.info.Name,
+		"version": p.info.Version,
+		"url":     fmt.Sprintf("%s://%s/%s@%s", proto, ctx.R.Host, p.info.Name, p.info.Version),
+		"expires": p.expires.UTC().Format(time.RFC3339),
+	}
+}
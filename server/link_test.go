@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinkPackage(t *testing.T) {
+	s := setupTestEnv(t)
+
+	tarball, err := packFixture(fixturesDir + "/esm-fixture-esm@1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := links.Add(tarball, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(p.tarball)
+	if p.info.Name != "esm-fixture-esm" || !strings.HasPrefix(p.info.Version, "1.0.0-link.") || !regFullVersion.MatchString(p.info.Version) {
+		t.Fatalf("unexpected linked package %s@%s", p.info.Name, p.info.Version)
+	}
+
+	info, _, err := s.getPackageInfo("esm-fixture-esm", p.info.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != p.info.Version || info.Module == "" && info.Type != "module" {
+		t.Fatalf("unexpected package info %+v", info)
+	}
+	spec := links.InstallSpec("esm-fixture-esm@" + p.info.Version)
+	if spec != fmt.Sprintf("esm-fixture-esm@file:%s", p.tarball) {
+		t.Fatalf("unexpected install spec %s", spec)
+	}
+	if spec := links.InstallSpec("esm-fixture-esm@1.0.0"); spec != "esm-fixture-esm@1.0.0" {
+		t.Fatalf("the published packages should be installed from the registry, got %s", spec)
+	}
+
+	p.expires = time.Now().Add(-time.Second)
+	if _, ok := links.Get("esm-fixture-esm", p.info.Version); ok {
+		t.Fatal("the expired package should not be found")
+	}
+
+	if _, err = links.Add([]byte("not a tarball"), time.Minute, nil); err == nil {
+		t.Fatal("invalid tarballs should be rejected")
+	}
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+	"github.com/postui/postdb"
+	"github.com/postui/postdb/q"
+)
+
+const feedPageSize = 100
+
+// A feedRecord is a new build in the replication feed of the primary server, the signature
+// signs the ID, the esmeta and the paths with the digests of the artifacts.
+type feedRecord struct {
+	Seq       int64           `json:"seq"`
+	ID        string          `json:"id"`
+	ESMeta    json.RawMessage `json:"esmeta"`
+	CSS       bool            `json:"css"`
+	Files     []feedFile      `json:"files"`
+	Time      int64           `json:"time"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+// signedContent returns the content of the record that is signed, the esmeta is re-encoded
+// since its JSON is compacted and escaped differently by the encoders.
+func (r *feedRecord) signedContent() []byte {
+	var esmeta interface{}
+	json.Unmarshal(r.ESMeta, &esmeta)
+	type signedFile struct {
+		Path   string `json:"path"`
+		Digest string `json:"digest"`
+	}
+	files := make([]signedFile, len(r.Files))
+	for i, file := range r.Files {
+		files[i] = signedFile{file.Path, file.Digest}
+	}
+	data, _ := json.Marshal(struct {
+		ID     string       `json:"id"`
+		ESMeta interface{}  `json:"esmeta"`
+		CSS    bool         `json:"css"`
+		Files  []signedFile `json:"files"`
+	}{r.ID, esmeta, r.CSS, files})
+	return data
+}
+
+// A feedFile is an artifact of the build, the path is relative to the builds storage.
+type feedFile struct {
+	Path      string `json:"path"`
+	Digest    string `json:"digest"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// An artifactSet collects the artifacts written by a build, the assets are emitted in the
+// esbuild hooks concurrently.
+type artifactSet struct {
+	server *Server
+	lock   sync.Mutex
+	files  []string
+}
+
+func (s *artifactSet) Write(filename string, contents ...io.Reader) error {
+	err := s.server.writeArtifact(filename, contents...)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files = append(s.files, filename)
+	return nil
+}
+
+func (s *artifactSet) Files() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.files...)
+}
+
+// A buildFeed is the append-only log of the new builds, it's stored as JSON lines.
+type buildFeed struct {
+	config   *Config
+	lock     sync.Mutex
+	filename string
+	seq      int64
+}
+
+func openBuildFeed(config *Config, filename string) (f *buildFeed, err error) {
+	f = &buildFeed{config: config, filename: filename}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var r feedRecord
+		if json.Unmarshal(scanner.Bytes(), &r) == nil && r.Seq > f.seq {
+			f.seq = r.Seq
+		}
+	}
+	return f, scanner.Err()
+}
+
+// Publish appends the build to the feed, the digests of the artifacts are taken from the
+// `.sig` sidecars if the artifacts are signed, and the record is signed if the signing is
+// enabled.
+func (f *buildFeed) Publish(id string, esmeta *ESMeta, pkgCSS bool, files []string) error {
+	buildsDir := filepath.Join(f.config.storageDir, "builds")
+	r := feedRecord{
+		ID:     id,
+		ESMeta: utils.MustEncodeJSON(esmeta),
+		CSS:    pkgCSS,
+		Time:   time.Now().Unix(),
+	}
+	for _, filename := range files {
+		rel, err := filepath.Rel(buildsDir, filename)
+		if err != nil {
+			return err
+		}
+		file := feedFile{Path: filepath.ToSlash(rel)}
+		if sig, ok := readArtifactSignature(filename); ok {
+			file.Digest = sig.Digest
+			file.Signature = sig.Signature
+		} else {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			digest := sha256.Sum256(data)
+			file.Digest = "sha256-" + base64.StdEncoding.EncodeToString(digest[:])
+		}
+		r.Files = append(r.Files, file)
+	}
+	if f.config.signingKey != nil {
+		r.Signature = signContent(f.config.signingKey, r.signedContent()).Signature
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	r.Seq = f.seq + 1
+	file, err := os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(bytes.TrimSpace(utils.MustEncodeJSON(r)), '\n'))
+	if err != nil {
+		return err
+	}
+	f.seq = r.Seq
+	return nil
+}
+
+// Read returns the records after the seq.
+func (f *buildFeed) Read(since int64, limit int) (records []feedRecord, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	records = []feedRecord{}
+	file, err := os.Open(f.filename)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() && len(records) < limit {
+		var r feedRecord
+		if json.Unmarshal(scanner.Bytes(), &r) == nil && r.Seq > since {
+			records = append(records, r)
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// publishBuild publishes the build to the feed if the replication feed is enabled.
+func (s *Server) publishBuild(id string, esmeta *ESMeta, pkgCSS bool, files []string) {
+	if s.feed == nil {
+		return
+	}
+	err := s.feed.Publish(id, esmeta, pkgCSS, files)
+	if err != nil {
+		s.log.Warnf("publish %s to the feed: %v", id, err)
+	}
+}
+
+// readFeed handles the `/-/feed?since=0` requests.
+func (s *Server) readFeed(ctx *rex.Context) interface{} {
+	if s.feed == nil {
+		return rex.Status(404, "the replication feed is disabled")
+	}
+	since, _ := strconv.ParseInt(ctx.Form.Value("since"), 10, 64)
+	records, err := s.feed.Read(since, feedPageSize)
+	if err != nil {
+		return rex.Status(500, err.Error())
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	return map[string]interface{}{
+		"records": records,
+	}
+}
+
+// A mirror replicates the builds of the primary server by the feed, the artifacts are
+// verified with the public key of the primary.
+type mirror struct {
+	server  *Server
+	primary string
+	pubkey  ed25519.PublicKey
+	client  *http.Client
+}
+
+func mirrorSeqKey(primary string) string {
+	return fmt.Sprintf("mirror-seq:%s", primary)
+}
+
+// startMirror polls the feed of the primary server in the `mirror-interval` when the
+// `mirror-of` config is set.
+func (s *Server) startMirror() {
+	if s.config.mirrorOf == "" {
+		return
+	}
+
+	go func() {
+		m := &mirror{server: s, primary: strings.TrimSuffix(s.config.mirrorOf, "/"), pubkey: s.config.mirrorPubkey, client: s.httpClient}
+		if m.pubkey == nil {
+			pubkey, err := m.fetchPublicKey()
+			if err != nil {
+				s.log.Errorf("mirror: %v, the replication is stopped", err)
+				return
+			}
+			s.log.Warnf("mirror: the public key of %s is trusted on first use, pin it by the 'mirror-pubkey' option", m.primary)
+			m.pubkey = pubkey
+		}
+		for {
+			n, skipped, err := m.Sync()
+			if err != nil {
+				s.log.Errorf("mirror: %v", err)
+			}
+			if n > 0 || skipped > 0 {
+				s.log.Infof("mirror: %d builds replicated from %s, %d skipped", n, m.primary, skipped)
+			}
+			time.Sleep(s.config.mirrorInterval)
+		}
+	}()
+}
+
+func (m *mirror) fetchPublicKey() (pubkey ed25519.PublicKey, err error) {
+	var ret struct {
+		PublicKey string `json:"publicKey"`
+	}
+	err = m.getJSON("/-/pubkey", &ret)
+	if err != nil {
+		return
+	}
+	return parsePublicKey(ret.PublicKey)
+}
+
+// parsePublicKey parses the base64 encoded ed25519 public key.
+func parsePublicKey(s string) (pubkey ed25519.PublicKey, err error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key '%s'", s)
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+func (m *mirror) getJSON(pathname string, v interface{}) error {
+	resp, err := m.client.Get(m.primary + pathname)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %s%s: %s", m.primary, pathname, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// A replicateError is the error of a record that can't be replicated by retrying, like the
+// records with invalid signatures or the artifacts removed from the primary.
+type replicateError struct {
+	message string
+}
+
+func (e *replicateError) Error() string {
+	return e.message
+}
+
+// Sync replicates the new builds since the last sync, returns the number of the replicated
+// builds and the skipped ones. The records that can't be replicated are skipped to not stop
+// the replication, the other errors like the network errors stop the sync to retry later.
+func (m *mirror) Sync() (n int, skipped int, err error) {
+	var since int64
+	post, err := m.server.db.Get(q.Alias(mirrorSeqKey(m.primary)), q.K("seq"))
+	if err == nil {
+		since, _ = strconv.ParseInt(string(post.KV.Get("seq")), 10, 64)
+	} else if err != postdb.ErrNotFound {
+		return
+	}
+	for {
+		var page struct {
+			Records []feedRecord `json:"records"`
+		}
+		err = m.getJSON(fmt.Sprintf("/-/feed?since=%d", since), &page)
+		if err != nil || len(page.Records) == 0 {
+			return
+		}
+		for _, r := range page.Records {
+			err = m.replicate(r)
+			if e, ok := err.(*replicateError); ok {
+				m.server.log.Warnf("mirror: skip %s(seq %d): %v", r.ID, r.Seq, e)
+				skipped++
+			} else if err != nil {
+				return n, skipped, fmt.Errorf("replicate %s: %v", r.ID, err)
+			} else {
+				n++
+			}
+			since = r.Seq
+			err = m.saveSeq(since)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (m *mirror) saveSeq(seq int64) error {
+	key := mirrorSeqKey(m.primary)
+	kv := q.KV{"seq": []byte(strconv.FormatInt(seq, 10))}
+	_, err := m.server.db.Put(q.Alias(key), kv)
+	if err == postdb.ErrDuplicateAlias {
+		err = m.server.db.Update(q.Alias(key), kv)
+	}
+	return err
+}
+
+// replicate downloads the artifacts of the build and verifies them by the signature of the
+// record, the artifacts and the build record are stored after all the artifacts are verified.
+func (m *mirror) replicate(r feedRecord) error {
+	if r.Signature == "" {
+		return &replicateError{"the record is not signed"}
+	}
+	if !verifyContent(m.pubkey, r.signedContent(), artifactSignature{Signature: r.Signature}) {
+		return &replicateError{"the signature of the record is invalid"}
+	}
+	if !strings.HasPrefix(r.ID, fmt.Sprintf("v%d/", VERSION)) || strings.Contains(r.ID, "..") {
+		return &replicateError{fmt.Sprintf("invalid build ID %s", r.ID)}
+	}
+
+	artifacts := make([][]byte, len(r.Files))
+	for i, file := range r.Files {
+		if strings.Contains(file.Path, "..") {
+			return &replicateError{fmt.Sprintf("invalid path %s", file.Path)}
+		}
+		data, err := m.fetchArtifact(file.Path)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(data)
+		if file.Digest != "sha256-"+base64.StdEncoding.EncodeToString(digest[:]) {
+			return &replicateError{fmt.Sprintf("the digest of %s mismatched", file.Path)}
+		}
+		artifacts[i] = data
+	}
+	for i, file := range r.Files {
+		filename := filepath.Join(m.server.config.storageDir, "builds", filepath.FromSlash(file.Path))
+		err := writeFileAtomic(filename, bytes.NewReader(artifacts[i]))
+		if err != nil {
+			return err
+		}
+		// keep the signature of the artifact for the clients that verify it by the public key
+		if file.Signature != "" {
+			sig := artifactSignature{
+				Algorithm: "ed25519",
+				KeyID:     signingKeyID(m.pubkey),
+				Digest:    file.Digest,
+				Signature: file.Signature,
+			}
+			err = writeFileAtomic(filename+".sig", bytes.NewReader(utils.MustEncodeJSON(sig)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cssMark := []byte{0}
+	if r.CSS {
+		cssMark = []byte{1}
+	}
+	kv := q.KV{"esmeta": []byte(r.ESMeta), "css": cssMark}
+	_, err := m.server.db.Put(q.Alias(r.ID), kv)
+	if err == postdb.ErrDuplicateAlias {
+		err = m.server.db.Update(q.Alias(r.ID), kv)
+	}
+	return err
+}
+
+func (m *mirror) fetchArtifact(pathname string) (data []byte, err error) {
+	resp, err := m.client.Get(fmt.Sprintf("%s/%s?raw", m.primary, pathname))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	// the build is removed from the primary, like evicted or purged
+	if resp.StatusCode == 404 || resp.StatusCode == 410 {
+		return nil, &replicateError{fmt.Sprintf("GET %s/%s: %s", m.primary, pathname, resp.Status)}
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %s/%s: %s", m.primary, pathname, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
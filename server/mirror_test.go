@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	logx "github.com/ije/gox/log"
+	"github.com/ije/gox/utils"
+	"github.com/postui/postdb"
+)
+
+func TestMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-mirror-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := loadSigningKey(filepath.Join(dir, "signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryDir := filepath.Join(dir, "primary")
+	s := &Server{config: &Config{storageDir: primaryDir, signingKey: key}, log: &logx.Logger{}}
+	s.feed, err = openBuildFeed(s.config, filepath.Join(dir, "feed.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(id string, code string) string {
+		artifacts := &artifactSet{server: s}
+		filename := filepath.Join(primaryDir, "builds", id+".js")
+		if err := artifacts.Write(filename, strings.NewReader(code)); err != nil {
+			t.Fatal(err)
+		}
+		s.publishBuild(id, &ESMeta{NpmPackage: &NpmPackage{Name: "react", Version: "17.0.2"}}, false, artifacts.Files())
+		return filename
+	}
+	react := fmt.Sprintf("v%d/react@17.0.2/es2020/react", VERSION)
+	publish(react, "export default 1")
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/-/feed" {
+			since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+			records, _ := s.feed.Read(since, feedPageSize)
+			json.NewEncoder(w).Encode(map[string]interface{}{"records": records})
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(primaryDir, "builds", filepath.FromSlash(r.URL.Path)))
+	}))
+	defer primary.Close()
+
+	// the mirror server runs in the same process
+	ms := &Server{config: &Config{storageDir: filepath.Join(dir, "mirror")}, log: &logx.Logger{}}
+	ms.db, err = postdb.Open(filepath.Join(dir, "esm.db"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.db.Close()
+
+	m := &mirror{server: ms, primary: primary.URL, pubkey: key.Public().(ed25519.PublicKey), client: http.DefaultClient}
+	n, skipped, err := m.Sync()
+	if err != nil || n != 1 || skipped != 0 {
+		t.Fatalf("unexpected sync result: %d, %d, %v", n, skipped, err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(ms.config.storageDir, "builds", react+".js"))
+	if err != nil || string(data) != "export default 1" {
+		t.Fatalf("the build is not replicated: %s, %v", data, err)
+	}
+	if _, ok := readArtifactSignature(filepath.Join(ms.config.storageDir, "builds", react+".js")); !ok {
+		t.Fatal("the signature of the build is not replicated")
+	}
+	if esm, _, ok := ms.findESM(react); !ok || esm.Name != "react" {
+		t.Fatal("the build record is not replicated")
+	}
+	if n, _, err = m.Sync(); err != nil || n != 0 {
+		t.Fatalf("the replicated builds should not be replicated again: %d, %v", n, err)
+	}
+
+	// the tampered artifacts, the evicted artifacts and the tampered or unsigned records are
+	// skipped, the records after them are still replicated
+	tamperRecord := func(fn func(r *feedRecord)) {
+		data, _ := ioutil.ReadFile(s.feed.filename)
+		lines := bytes.Split(bytes.TrimSpace(data), []byte{'\n'})
+		var r feedRecord
+		json.Unmarshal(lines[len(lines)-1], &r)
+		fn(&r)
+		lines[len(lines)-1] = bytes.TrimSpace(utils.MustEncodeJSON(r))
+		ioutil.WriteFile(s.feed.filename, append(bytes.Join(lines, []byte{'\n'}), '\n'), 0644)
+	}
+	vue := fmt.Sprintf("v%d/vue@3.0.11/es2020/vue", VERSION)
+	ioutil.WriteFile(publish(vue, "export default 2"), bytes.Repeat([]byte{'x'}, 16), 0644)
+	preact := fmt.Sprintf("v%d/preact@10.5.13/es2020/preact", VERSION)
+	os.Remove(publish(preact, "export default 3"))
+	lit := fmt.Sprintf("v%d/lit@2.0.0/es2020/lit", VERSION)
+	publish(lit, "export default 4")
+	tamperRecord(func(r *feedRecord) { r.ESMeta = json.RawMessage(`{"name":"evil","version":"1.0.0"}`) })
+	solid := fmt.Sprintf("v%d/solid-js@1.0.0/es2020/solid-js", VERSION)
+	publish(solid, "export default 5")
+	tamperRecord(func(r *feedRecord) { r.Signature = "" })
+	svelte := fmt.Sprintf("v%d/svelte@3.38.2/es2020/svelte", VERSION)
+	publish(svelte, "export default 6")
+
+	n, skipped, err = m.Sync()
+	if err != nil || n != 1 || skipped != 4 {
+		t.Fatalf("unexpected sync result: %d, %d, %v", n, skipped, err)
+	}
+	for _, id := range []string{vue, preact, lit, solid} {
+		if fileExists(filepath.Join(ms.config.storageDir, "builds", id+".js")) {
+			t.Fatalf("the skipped build %s should not be stored", id)
+		}
+	}
+	if _, _, ok := ms.findESM(svelte); !ok {
+		t.Fatal("the records after the skipped ones should be replicated")
+	}
+	if n, skipped, err = m.Sync(); err != nil || n != 0 || skipped != 0 {
+		t.Fatalf("the skipped records should not be synced again: %d, %d, %v", n, skipped, err)
+	}
+}
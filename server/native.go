@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// A nativeAddonError is the error of the packages that are the node-gyp native addons, they
+// can't be built to the ES modules.
+type nativeAddonError struct {
+	name    string
+	version string
+	// the file that the native addon is detected by, like `binding.gyp`
+	reason string
+	// the substitute of the `native-substitutes` config
+	substitute string
+}
+
+func (e *nativeAddonError) Error() string {
+	msg := fmt.Sprintf("%s@%s is a native addon(%s) that can't be built to the ES modules", e.name, e.version, e.reason)
+	if e.substitute != "" {
+		msg += fmt.Sprintf(", try '%s' instead", e.substitute)
+	}
+	return msg
+}
+
+// parseNativeSubstitutes parses the `native-substitutes` config like
+// `bcrypt=bcryptjs,node-sass=sass`, the imports of the native addons are replaced by the
+// WASM or the pure JS equivalents.
+func parseNativeSubstitutes(s string) (substitutes map[string]string, err error) {
+	substitutes = map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to := utils.SplitByFirstByte(part, '=')
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !regPkgName.MatchString(from) || !regPkgName.MatchString(to) || from == to {
+			return nil, fmt.Errorf("invalid native substitute '%s', should be 'addon=equivalent'", part)
+		}
+		substitutes[from] = to
+	}
+	return
+}
+
+// detectNativeAddon reports whether the installed package is a native addon, by the `gypfile`
+// field that the registry adds for the `binding.gyp`, the `binding.gyp` file, or the `binary`
+// field of node-pre-gyp.
+func detectNativeAddon(pkgDir string) (reason string, ok bool) {
+	var p struct {
+		Gypfile bool `json:"gypfile"`
+		Binary  *struct {
+			ModuleName string `json:"module_name"`
+		} `json:"binary"`
+	}
+	if utils.ParseJSONFile(filepath.Join(pkgDir, "package.json"), &p) != nil {
+		return
+	}
+	if p.Gypfile || fileExists(filepath.Join(pkgDir, "binding.gyp")) {
+		return "node-gyp", true
+	}
+	if p.Binary != nil && p.Binary.ModuleName != "" {
+		return "node-pre-gyp", true
+	}
+	return
+}
+
+// nativeSubstitute returns the substitute of the import of a native addon, the submodules are
+// mapped as well, like `bcrypt/promises` -> `bcryptjs/promises`.
+func (s *Server) nativeSubstitute(specifier string) (string, bool) {
+	name, submodule := splitPkgPath(specifier)
+	to, ok := s.config.nativeSubstitutes[name]
+	if !ok {
+		return "", false
+	}
+	if submodule != "" {
+		to += "/" + submodule
+	}
+	return to, true
+}
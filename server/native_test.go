@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseNativeSubstitutes(t *testing.T) {
+	substitutes, err := parseNativeSubstitutes("bcrypt=bcryptjs, node-sass = sass,@corp/native=@corp/wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(substitutes) != 3 || substitutes["bcrypt"] != "bcryptjs" || substitutes["node-sass"] != "sass" || substitutes["@corp/native"] != "@corp/wasm" {
+		t.Fatalf("unexpected substitutes %v", substitutes)
+	}
+	for _, s := range []string{"bcrypt", "bcrypt=", "bcrypt=bcrypt", "a b=c"} {
+		if _, err := parseNativeSubstitutes(s); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+}
+
+func TestDetectNativeAddon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-native-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		packageJSON string
+		bindingGyp  bool
+		reason      string
+	}{
+		{`{"name":"a","gypfile":true}`, false, "node-gyp"},
+		{`{"name":"a"}`, true, "node-gyp"},
+		{`{"name":"a","binary":{"module_name":"a","module_path":"./lib/binding"}}`, false, "node-pre-gyp"},
+		{`{"name":"a"}`, false, ""},
+	} {
+		os.RemoveAll(filepath.Join(dir, "binding.gyp"))
+		ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(c.packageJSON), 0644)
+		if c.bindingGyp {
+			ioutil.WriteFile(filepath.Join(dir, "binding.gyp"), []byte("{}"), 0644)
+		}
+		reason, ok := detectNativeAddon(dir)
+		if reason != c.reason || ok != (c.reason != "") {
+			t.Fatalf("%s(binding.gyp: %v): unexpected detection %s, %v", c.packageJSON, c.bindingGyp, reason, ok)
+		}
+	}
+}
+
+func TestNativeAddonBuild(t *testing.T) {
+	s := setupTestEnv(t)
+	s.config.nativeSubstitutes = map[string]string{"esm-fixture-native": "esm-fixture-native-js"}
+
+	build := func(name string) (string, error) {
+		task := &buildTask{Server: s, pkg: fixturePkg(t, s, name), cjsExports: "auto", target: "es2020"}
+		_, _, err := task.buildESM(context.Background())
+		if err != nil {
+			return "", err
+		}
+		return readBuild(t, s, task.ID()+".js"), nil
+	}
+
+	_, err := build("esm-fixture-native@1.0.0")
+	if _, ok := err.(*nativeAddonError); !ok || err.Error() != "esm-fixture-native@1.0.0 is a native addon(node-gyp) that can't be built to the ES modules, try 'esm-fixture-native-js' instead" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !isCacheableBuildError(err) {
+		t.Fatal("the native addon error should be cached")
+	}
+
+	_, err = build("esm-fixture-prebuilt@1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "esm-fixture-prebuilt@1.0.0 is a native addon(addon.node)") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	code, err := build("esm-fixture-uses-native@1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(code, "/esm-fixture-native-js@1.0.0/es2020/esm-fixture-native-js.js") || strings.Contains(code, "/esm-fixture-native@") {
+		t.Fatalf("the native addon should be substituted:\n%s", code)
+	}
+}
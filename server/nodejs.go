@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
@@ -76,6 +78,44 @@ var builtInNodeModules = map[string]bool{
 	"zlib":                true,
 }
 
+// the submodules of the node builtin modules, like `fs/promises`
+var builtInNodeSubmodules = map[string]bool{
+	"assert/strict":     true,
+	"dns/promises":      true,
+	"fs/promises":       true,
+	"path/posix":        true,
+	"path/win32":        true,
+	"stream/promises":   true,
+	"timers/promises":   true,
+	"util/types":        true,
+	"readline/promises": true,
+}
+
+// isNodeBuiltInModule reports whether the import path is a node builtin module like `fs`,
+// `fs/promises` or `node:fs`.
+func isNodeBuiltInModule(importPath string) bool {
+	if strings.HasPrefix(importPath, "node:") {
+		return true
+	}
+	return builtInNodeModules[importPath] || builtInNodeSubmodules[importPath]
+}
+
+// isNodeRuntimeTarget reports whether the target runs in a server-side runtime that implements
+// the node builtin modules natively, like Node.js and Bun.
+func isNodeRuntimeTarget(target string) bool {
+	return target == "node" || target == "bun"
+}
+
+// isBunBuiltInModule reports whether the import path is a Bun native module like `bun:sqlite`.
+func isBunBuiltInModule(importPath string) bool {
+	return strings.HasPrefix(importPath, "bun:")
+}
+
+// nodeBuiltInModuleURL returns the `node:` URL of the node builtin module.
+func nodeBuiltInModuleURL(importPath string) string {
+	return "node:" + strings.TrimPrefix(importPath, "node:")
+}
+
 // status: https://deno.land/std/node
 var denoStdNodeModules = map[string]bool{
 	"fs": true,
@@ -132,6 +172,15 @@ type NpmPackage struct {
 	PeerDependencies map[string]string `json:"peerDependencies,omitempty"`
 	// https://nodejs.org/api/esm.html#esm_resolver_algorithm_specification
 	DefinedExports interface{} `json:"exports,omitempty"`
+	// the tarball is recorded in the build record to detect the republish of the same version
+	Dist *NpmPackageDist `json:"dist,omitempty"`
+}
+
+// NpmPackageDist defines the tarball of a npm package version
+type NpmPackageDist struct {
+	Tarball   string `json:"tarball,omitempty"`
+	Shasum    string `json:"shasum,omitempty"`
+	Integrity string `json:"integrity,omitempty"`
 }
 
 // NodeEnv defines the nodejs env
@@ -140,26 +189,26 @@ type NodeEnv struct {
 	npmRegistry string
 }
 
-func checkNodeEnv() (env *NodeEnv, err error) {
+func (s *Server) checkNodeEnv() (env *NodeEnv, err error) {
 	var installed bool
 CheckNodejs:
 	version, major, err := getNodejsVersion()
 	if err != nil || major < minNodejsVersion {
 		PATH := os.Getenv("PATH")
-		nodeBinDir := "/usr/local/nodejs/bin"
+		nodeBinDir := filepath.Join(s.config.nodejsDir, "bin")
 		if !strings.Contains(PATH, nodeBinDir) {
 			os.Setenv("PATH", fmt.Sprintf("%s%c%s", nodeBinDir, os.PathListSeparator, PATH))
 			goto CheckNodejs
 		} else if !installed {
-			err = os.RemoveAll("/usr/local/nodejs")
+			err = os.RemoveAll(s.config.nodejsDir)
 			if err != nil {
 				return
 			}
-			err = installNodejs("/usr/local/nodejs", nodejsLatestLTS)
+			err = s.installNodejs(s.config.nodejsDir, nodejsLatestLTS)
 			if err != nil {
 				return
 			}
-			log.Infof("nodejs %s installed", nodejsLatestLTS)
+			s.log.Infof("nodejs %s installed", nodejsLatestLTS)
 			installed = true
 			goto CheckNodejs
 		} else {
@@ -175,16 +224,16 @@ CheckNodejs:
 		npmRegistry: "https://registry.npmjs.org/",
 	}
 
-	output, err := exec.Command("npm", "config", "get", "registry").CombinedOutput()
+	_, output, err := s.runProc(context.Background(), procOptions{}, "npm", "config", "get", "registry")
 	if err == nil {
 		env.npmRegistry = strings.TrimRight(strings.TrimSpace(string(output)), "/") + "/"
 	}
 
 CheckYarn:
-	output, err = exec.Command("yarn", "-v").CombinedOutput()
+	_, output, err = s.runProc(context.Background(), procOptions{}, "yarn", "-v")
 	if err != nil {
 		if errors.Is(err, exec.ErrNotFound) {
-			output, err = exec.Command("npm", "install", "yarn", "-g").CombinedOutput()
+			_, output, err = s.runProc(context.Background(), procOptions{}, "npm", "install", "yarn", "-g")
 			if err != nil {
 				err = errors.New("install yarn: " + strings.TrimSpace(string(output)))
 				return
@@ -196,7 +245,7 @@ CheckYarn:
 	return
 }
 
-func (env *NodeEnv) getPackageInfo(name string, version string) (info NpmPackage, submodule string, err error) {
+func (s *Server) getPackageInfo(name string, version string) (info NpmPackage, submodule string, err error) {
 	slice := strings.Split(name, "/")
 	if l := len(slice); strings.HasPrefix(name, "@") && l > 1 {
 		name = strings.Join(slice[:2], "/")
@@ -209,29 +258,47 @@ func (env *NodeEnv) getPackageInfo(name string, version string) (info NpmPackage
 			submodule = strings.Join(slice[1:], "/")
 		}
 	}
-	if strings.HasPrefix(version, "^") {
-		version, _ = utils.SplitByFirstByte(version[1:], '.')
-	} else if strings.HasPrefix(version, "~") {
-		major, rest := utils.SplitByFirstByte(version[1:], '.')
-		minor, _ := utils.SplitByFirstByte(rest, '.')
-		version = major + "." + minor
+	if p, ok := links.Get(name, version); ok {
+		info = p.info
+		return
 	}
+	version = floatingVersion(version)
 	isFullVersion := regFullVersion.MatchString(version)
 	key := fmt.Sprintf("npm:%s@%s", name, version)
-	p, err := db.Get(q.Alias(key), q.K("package"))
+	p, err := s.db.Get(q.Alias(key), q.K("package", "expires"))
 	if err == nil {
-		if !isFullVersion && int64(p.Crtime)+refreshDuration < time.Now().Unix() {
-			_, err = db.Delete(q.Alias(key))
-		} else if json.Unmarshal(p.KV.Get("package"), &info) == nil {
-			return
+		if json.Unmarshal(p.KV.Get("package"), &info) == nil {
+			if isFullVersion || !s.packageInfoExpired(p, time.Now()) {
+				return
+			}
+			// serve the stale version and re-resolve it in the background
+			if s.revalidator != nil {
+				s.revalidator.Schedule(name, version, info.Version)
+				return
+			}
 		}
+		_, err = s.db.Delete(q.Alias(key))
 	}
 	if err != nil && err != postdb.ErrNotFound {
 		return
 	}
 
-	start := time.Now()
-	resp, err := httpClient.Get(env.npmRegistry + name)
+	info, err = s.fetchPackageInfo(name, version)
+	if err != nil {
+		return
+	}
+	err = s.cachePackageInfo(name, version, info)
+	return
+}
+
+// fetchPackageRecords fetches the metadata of all the versions of the package from the npm
+// registry.
+func (s *Server) fetchPackageRecords(name string) (h NpmPackageRecords, err error) {
+	if err = s.injectFault("registry"); err != nil {
+		err = fmt.Errorf("npm: can't get metadata of package '%s' (%v)", name, err)
+		return
+	}
+	resp, err := s.httpClient.Get(s.node.npmRegistry + name)
 	if err != nil {
 		return
 	}
@@ -254,26 +321,39 @@ func (env *NodeEnv) getPackageInfo(name string, version string) (info NpmPackage
 	if err != nil {
 		return
 	}
-
-	var h NpmPackageRecords
 	err = json.Unmarshal(data, &h)
+	return
+}
+
+// fetchPackageInfo resolves the version of the package from the npm registry.
+func (s *Server) fetchPackageInfo(name string, version string) (info NpmPackage, err error) {
+	start := time.Now()
+	h, err := s.fetchPackageRecords(name)
 	if err != nil {
 		return
 	}
 
-	if isFullVersion {
+	if regFullVersion.MatchString(version) {
 		info = h.Versions[version]
 	} else {
 		distVersion, ok := h.DistTags[version]
 		if ok {
 			info = h.Versions[distVersion]
 		} else {
-			var majorVerions versionSlice
+			// the prereleases are only matched if there is no stable version
+			var majorVerions, prereleases versionSlice
 			for key := range h.Versions {
 				if regFullVersion.MatchString(key) && strings.HasPrefix(key, version+".") {
-					majorVerions = append(majorVerions, key)
+					if strings.ContainsRune(key, '-') {
+						prereleases = append(prereleases, key)
+					} else {
+						majorVerions = append(majorVerions, key)
+					}
 				}
 			}
+			if len(majorVerions) == 0 {
+				majorVerions = prereleases
+			}
 			if l := len(majorVerions); l > 0 {
 				if l > 1 {
 					sort.Sort(majorVerions)
@@ -288,15 +368,60 @@ func (env *NodeEnv) getPackageInfo(name string, version string) (info NpmPackage
 		return
 	}
 
-	// cache
-	db.Put(q.Alias(key), q.KV{"package": utils.MustEncodeJSON(info)})
+	changed, e := s.recordTarballFingerprint(info.Name, info.Version, tarballFingerprint(&info), time.Now())
+	if e != nil {
+		s.log.Warnf("record the tarball of %s@%s: %v", info.Name, info.Version, e)
+	} else if changed {
+		s.log.Warnf("the tarball of %s@%s is changed by the registry", info.Name, info.Version)
+	}
 
-	log.Debugf("get npm package(%s@%s) info in %v", name, info.Version, time.Now().Sub(start))
+	s.log.Debugf("get npm package(%s@%s) info in %v", name, info.Version, time.Now().Sub(start))
 	return
 }
 
+// cachePackageInfo caches the resolved package info, the floating versions and the dist-tags
+// expire after the refresh interval.
+func (s *Server) cachePackageInfo(name string, version string, info NpmPackage) (err error) {
+	key := fmt.Sprintf("npm:%s@%s", name, version)
+	kv := q.KV{"package": utils.MustEncodeJSON(info)}
+	isFullVersion := regFullVersion.MatchString(version)
+	if !isFullVersion {
+		refreshInterval := s.versionRefreshInterval()
+		if regDistTag.MatchString(version) {
+			refreshInterval = s.distTagRefreshInterval()
+		}
+		kv["expires"] = []byte(strconv.FormatInt(time.Now().Unix()+refreshInterval, 10))
+	}
+	_, err = s.db.Put(q.Alias(key), kv)
+	if err == postdb.ErrDuplicateAlias {
+		err = s.db.Update(q.Alias(key), kv)
+	}
+	if err != nil {
+		s.log.Warnf("cache the package info of %s@%s: %v", name, version, err)
+		err = nil
+	}
+	if !isFullVersion {
+		err = s.recordVersionPin(name, version, info.Version, time.Now())
+		if err != nil {
+			s.log.Warnf("record the version pin of %s@%s: %v", name, version, err)
+			err = nil
+		}
+	}
+	return
+}
+
+// packageInfoExpired reports whether the cached package info of a floating version expired,
+// the records without the `expires` are expired after the refresh interval since created.
+func (s *Server) packageInfoExpired(p *q.Post, now time.Time) bool {
+	expires, err := strconv.ParseInt(string(p.KV.Get("expires")), 10, 64)
+	if err != nil {
+		expires = int64(p.Crtime) + s.versionRefreshInterval()
+	}
+	return expires < now.Unix()
+}
+
 func getNodejsVersion() (version string, major int, err error) {
-	output, err := exec.Command("node", "--version").CombinedOutput()
+	_, output, err := runProc(context.Background(), procOptions{}, "node", "--version")
 	if err != nil {
 		return
 	}
@@ -307,10 +432,15 @@ func getNodejsVersion() (version string, major int, err error) {
 	return
 }
 
-func installNodejs(dir string, version string) (err error) {
+func (s *Server) installNodejs(dir string, version string) (err error) {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("please install nodejs %d+ manually", minNodejsVersion)
+	}
+
 	dlURL := fmt.Sprintf("%sv%s/node-v%s-%s-x64.tar.xz", nodejsDistURL, version, version, runtime.GOOS)
-	log.Debugf("downloading %s", dlURL)
-	resp, err := http.Get(dlURL)
+	s.log.Debugf("downloading %s", dlURL)
+	client := &http.Client{Transport: &http.Transport{Proxy: s.config.requestProxy}}
+	resp, err := client.Get(dlURL)
 	if err != nil {
 		err = fmt.Errorf("download nodejs: %v", err)
 		return
@@ -325,9 +455,7 @@ func installNodejs(dir string, version string) (err error) {
 	io.Copy(f, resp.Body)
 	f.Close()
 
-	cmd := exec.Command("tar", "-xJf", path.Base(dlURL))
-	cmd.Dir = os.TempDir()
-	output, err := cmd.CombinedOutput()
+	_, output, err := s.runProc(context.Background(), procOptions{Dir: os.TempDir()}, "tar", "-xJf", path.Base(dlURL))
 	if err != nil {
 		if len(output) > 0 {
 			err = errors.New(string(output))
@@ -335,9 +463,7 @@ func installNodejs(dir string, version string) (err error) {
 		return
 	}
 
-	cmd = exec.Command("mv", "-f", strings.TrimSuffix(path.Base(dlURL), ".tar.xz"), dir)
-	cmd.Dir = os.TempDir()
-	output, err = cmd.CombinedOutput()
+	_, output, err = s.runProc(context.Background(), procOptions{Dir: os.TempDir()}, "mv", "-f", strings.TrimSuffix(path.Base(dlURL), ".tar.xz"), dir)
 	if err != nil {
 		if len(output) > 0 {
 			err = errors.New(string(output))
@@ -346,17 +472,71 @@ func installNodejs(dir string, version string) (err error) {
 	return
 }
 
-func yarnAdd(wd string, packages ...string) (err error) {
-	if len(packages) > 0 {
-		start := time.Now()
-		args := append([]string{"add", "--silent", "--no-progress", "--ignore-scripts"}, packages...)
-		cmd := exec.Command("yarn", args...)
-		cmd.Dir = wd
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("yarn add %s: %s", strings.Join(packages, " "), string(output))
+// installPackages installs the packages like `react@17.0.2` into the working directory by the
+// `installer` config, the native installer falls back to yarn for the dependencies that are
+// not in the registry.
+func (s *Server) installPackages(ctx context.Context, wd string, packages ...string) (err error) {
+	if len(packages) == 0 {
+		return
+	}
+	start := time.Now()
+	s.injectFault("slow-install")
+	// the later installs of the working directory change the tree, they are not cached
+	cacheKey := ""
+	if s.config.installCacheTTL > 0 && !dirExists(filepath.Join(wd, "node_modules")) {
+		cacheKey = s.installCacheKey(packages)
+		if s.restoreInstallCache(wd, cacheKey) {
+			buildLogs.Dir(wd).Printf("$ install %s (cached)", strings.Join(packages, " "))
+			s.log.Debug("install", strings.Join(packages, " "), "from the cache in", time.Now().Sub(start))
+			return s.checkInstallGuardrails(wd, packages)
+		}
+	}
+	if s.config.installTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.installTimeout)
+		defer cancel()
+	}
+	if s.config.installer == "yarn" {
+		err = s.yarnAdd(ctx, wd, packages...)
+	} else {
+		err = s.nativeInstall(ctx, wd, packages)
+		if e, ok := err.(*unsupportedSpecError); ok {
+			buildLogs.Dir(wd).Printf("%v, fallback to yarn", e)
+			err = s.yarnAdd(ctx, wd, packages...)
 		}
-		log.Debug("yarn add", strings.Join(packages, " "), "in", time.Now().Sub(start))
+	}
+	if err != nil {
+		if s.config.installTimeout > 0 && time.Since(start) >= s.config.installTimeout {
+			return &installError{packages, fmt.Sprintf("exceeds the limit(%v) of the 'install-timeout' config", s.config.installTimeout), true}
+		}
+		return
+	}
+	s.log.Debug("install", strings.Join(packages, " "), "in", time.Now().Sub(start))
+	err = s.checkInstallGuardrails(wd, packages)
+	if err == nil && cacheKey != "" {
+		if e := s.saveInstallCache(wd, cacheKey); e != nil {
+			s.log.Warnf("save install cache: %v", e)
+		}
+	}
+	return
+}
+
+func (s *Server) yarnAdd(ctx context.Context, wd string, packages ...string) (err error) {
+	args := []string{"add", "--silent", "--no-progress", "--ignore-scripts"}
+	if s.node != nil && s.node.npmRegistry != "" {
+		args = append(args, "--registry", s.node.npmRegistry)
+	}
+	for _, spec := range packages {
+		args = append(args, links.InstallSpec(spec))
+	}
+	opts := procOptions{Dir: wd}
+	if l := buildLogs.Dir(wd); l != nil {
+		l.Printf("$ yarn add %s", strings.Join(packages, " "))
+		opts.Output = l
+	}
+	_, output, err := s.runProc(ctx, opts, "yarn", args...)
+	if err != nil {
+		return fmt.Errorf("yarn add %s: %s", strings.Join(packages, " "), string(output))
 	}
 	return
 }
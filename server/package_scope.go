@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/gox/utils"
+)
+
+// the namespace of the modules that relay the files resolved by the plugin
+const relayNamespace = "esm-sh-relay"
+
+// A packageScope is the nearest package of the files in the `node_modules`, the `browser` and
+// `imports` fields of the package apply to the imports of the files.
+type packageScope struct {
+	dir     string
+	browser *browserField
+	imports *exportsObject
+}
+
+func readPackageScope(dir string) *packageScope {
+	scope := &packageScope{dir: dir}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return scope
+	}
+	var p struct {
+		Browser interface{}     `json:"browser"`
+		Imports json.RawMessage `json:"imports"`
+	}
+	if json.Unmarshal(data, &p) != nil {
+		return scope
+	}
+	if field, ok := parseBrowserField(p.Browser); ok {
+		scope.browser = &field
+	}
+	if len(p.Imports) > 0 {
+		if imports, err := parseExportsField(p.Imports); err == nil {
+			scope.imports, _ = imports.(*exportsObject)
+		}
+	}
+	return scope
+}
+
+// A packageScopeResolver resolves the imports of the files by their package scopes, esbuild
+// resolves the files of the packages but the bare imports are resolved by the plugin. The
+// scopes are cached and guarded by a lock since esbuild calls the plugin hooks concurrently.
+type packageScopeResolver struct {
+	wd     string
+	lock   sync.Mutex
+	scopes map[string]*packageScope
+}
+
+func newPackageScopeResolver(wd string) *packageScopeResolver {
+	return &packageScopeResolver{wd: wd, scopes: map[string]*packageScope{}}
+}
+
+// ResolveBrowser returns the replacement of the bare import of the importer file by the
+// `browser` field: an absolute file path, another module, or the empty string for the empty
+// module.
+func (r *packageScopeResolver) ResolveBrowser(importer string, specifier string) (replacement string, ok bool) {
+	scope := r.lookup(importer)
+	if scope == nil || scope.browser == nil || scope.browser.replace == nil {
+		return
+	}
+	v, ok := scope.browser.replace[specifier]
+	if !ok {
+		return
+	}
+	switch to := v.(type) {
+	case bool:
+		if to {
+			return "", false
+		}
+		return "", true
+	case string:
+		if isFileImportPath(to) {
+			file, ok := resolvePackageFile(scope.dir, to)
+			if !ok {
+				return "", false
+			}
+			return filepath.Join(scope.dir, file), true
+		}
+		return to, to != specifier
+	}
+	return "", false
+}
+
+// ResolveImports resolves the internal import like `#internal/utils` of the importer file by
+// the `imports` field with the conditions, returns an absolute file path or the bare specifier
+// of a dependency.
+func (r *packageScopeResolver) ResolveImports(importer string, specifier string, conditions []string) (to string, ok bool) {
+	scope := r.lookup(importer)
+	if scope == nil || scope.imports == nil {
+		return
+	}
+	value, match, rest, ok := matchSubpath(scope.imports, specifier)
+	if !ok {
+		return
+	}
+	to, ok = resolveSubpathTarget(value, match, conditions, true)
+	if !ok {
+		return "", false
+	}
+	if rest != "" {
+		if !strings.HasSuffix(to, "/") {
+			return "", false
+		}
+		to += rest
+	}
+	if strings.HasPrefix(to, "./") {
+		to = filepath.Join(scope.dir, to)
+		if !fileExists(to) {
+			return "", false
+		}
+	}
+	return to, true
+}
+
+// lookup returns the nearest package scope of the file in the `node_modules` of the build.
+func (r *packageScopeResolver) lookup(filename string) *packageScope {
+	nmDir := filepath.Join(r.wd, "node_modules")
+	if !filepath.IsAbs(filename) || !strings.HasPrefix(filename, nmDir+string(filepath.Separator)) {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for dir := filepath.Dir(filename); dir != nmDir && strings.HasPrefix(dir, nmDir); dir = filepath.Dir(dir) {
+		if scope, ok := r.scopes[dir]; ok {
+			return scope
+		}
+		if fileExists(filepath.Join(dir, "package.json")) {
+			scope := readPackageScope(dir)
+			r.scopes[dir] = scope
+			return scope
+		}
+	}
+	return nil
+}
+
+// importsConditions returns the conditions to resolve the `imports` field for the target,
+// like esbuild does for the `exports` field.
+func (task *buildTask) importsConditions(kind api.ResolveKind) []string {
+	conditions := []string{"module"}
+	if kind == api.ResolveJSRequireCall {
+		conditions = append(conditions, "require")
+	} else {
+		conditions = append(conditions, "import")
+	}
+	switch task.target {
+	case "node":
+		conditions = append(conditions, "node")
+	case "bun":
+		conditions = append(conditions, "bun", "node")
+	case "workers":
+		conditions = append(conditions, "workerd", "worker", "browser")
+	default:
+		conditions = append(conditions, "browser")
+	}
+	if task.isDev {
+		conditions = append(conditions, "development")
+	} else {
+		conditions = append(conditions, "production")
+	}
+	return conditions
+}
+
+// relayFile returns the resolve result of a package file that is resolved by the plugin, like
+// the targets of the `imports` and the `browser` fields. esbuild doesn't apply the `sideEffects`
+// field of the package to the paths returned by the plugins, so the file is imported by a relay
+// module that esbuild resolves by itself. The required files are not tree-shaken, they are
+// returned as they are.
+func relayFile(args api.OnResolveArgs, filename string) api.OnResolveResult {
+	if args.Kind == api.ResolveJSRequireCall {
+		return api.OnResolveResult{Path: filename}
+	}
+	return api.OnResolveResult{Path: filename, Namespace: relayNamespace}
+}
+
+// loadRelay loads the relay module of the file, it re-exports the file.
+func (task *buildTask) loadRelay(args api.OnLoadArgs) (api.OnLoadResult, error) {
+	specifier := strings.TrimSpace(string(utils.MustEncodeJSON(args.Path)))
+	code := fmt.Sprintf("export * from %s;\n", specifier)
+	// the commonjs modules are imported as the default export
+	exports, esm, _ := task.parseESModuleExports(context.Background(), task.wd, args.Path)
+	if !esm || includes(exports, "default") {
+		code += fmt.Sprintf("export { default } from %s;\n", specifier)
+	}
+	return api.OnLoadResult{Contents: &code, ResolveDir: filepath.Dir(args.Path), Loader: api.LoaderJS}, nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestResolveImports(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-package-scope-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	pkgDir := filepath.Join(wd, "node_modules", "pkg")
+	for name, content := range map[string]string{
+		"package.json": `{"imports": {
+			"#utils/*": "./src/utils/*.js",
+			"#env": { "node": "./env-node.js", "default": "./env.js" },
+			"#polyfill": { "browser": "./polyfill.js", "default": "node-polyfill" },
+			"#missing": "./missing.js"
+		}}`,
+		"src/utils/format.js": "",
+		"env-node.js":         "",
+		"env.js":              "",
+		"polyfill.js":         "",
+		"index.js":            "",
+	} {
+		ensureDir(filepath.Dir(filepath.Join(pkgDir, name)))
+		ioutil.WriteFile(filepath.Join(pkgDir, name), []byte(content), 0644)
+	}
+
+	r := newPackageScopeResolver(wd)
+	importer := filepath.Join(pkgDir, "index.js")
+	browser := (&buildTask{target: "es2020"}).importsConditions(api.ResolveJSImportStatement)
+	node := (&buildTask{target: "node"}).importsConditions(api.ResolveJSImportStatement)
+	for _, c := range []struct {
+		specifier  string
+		conditions []string
+		to         string
+	}{
+		{"#utils/format", browser, filepath.Join(pkgDir, "src/utils/format.js")},
+		{"#env", browser, filepath.Join(pkgDir, "env.js")},
+		{"#env", node, filepath.Join(pkgDir, "env-node.js")},
+		{"#polyfill", browser, filepath.Join(pkgDir, "polyfill.js")},
+		{"#polyfill", node, "node-polyfill"},
+		{"#missing", browser, ""},
+		{"#unknown", browser, ""},
+	} {
+		to, ok := r.ResolveImports(importer, c.specifier, c.conditions)
+		if to != c.to || ok != (c.to != "") {
+			t.Fatalf("resolve %s %v: unexpected result '%s'", c.specifier, c.conditions, to)
+		}
+	}
+}
+
+func TestImportsFieldBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	for target, env := range map[string]string{"es2020": "browser-env", "node": "node-env"} {
+		task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-imports@1.0.0"), cjsExports: "auto", target: target}
+		esm, _, err := task.buildESM(context.Background())
+		if err != nil {
+			t.Fatalf("build %s: %v", task.ID(), err)
+		}
+		if !includes(esm.Exports, "message") {
+			t.Fatalf("build %s: missing export message in %v", task.ID(), esm.Exports)
+		}
+		code := readBuild(t, s, task.ID()+".js")
+		if !strings.Contains(code, env) || !strings.Contains(code, "esm-fixture-dep@1.0.0") || strings.Contains(code, "#") {
+			t.Fatalf("build %s: the imports field is not applied:\n%s", task.ID(), code)
+		}
+	}
+}
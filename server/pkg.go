@@ -2,6 +2,9 @@ package server
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/ije/gox/utils"
@@ -13,7 +16,7 @@ type pkg struct {
 	submodule string
 }
 
-func parsePkg(pathname string) (*pkg, error) {
+func (s *Server) parsePkg(pathname string) (*pkg, error) {
 	a := strings.Split(strings.Trim(pathname, "/"), "/")
 	for i, s := range a {
 		a[i] = strings.TrimSpace(s)
@@ -30,11 +33,15 @@ func parsePkg(pathname string) (*pkg, error) {
 	if scope != "" {
 		name = scope + "/" + name
 	}
+	// the package names are URL-safe, so the names with the non-ASCII characters are never published
+	if escapePath(name) != name {
+		return nil, fmt.Errorf("invalid package name '%s'", name)
+	}
 	if name != "" {
 		if version == "" {
 			version = "latest"
 		}
-		info, _, err := node.getPackageInfo(name, version)
+		info, _, err := s.getPackageInfo(name, version)
 		if err != nil {
 			return nil, err
 		}
@@ -91,3 +98,65 @@ func (a pkgSlice) String() string {
 	}
 	return strings.Join(s, ",")
 }
+
+var regPkgName = regexp.MustCompile(`^(@[a-z0-9\-~][a-z0-9\-\._~]*/)?[a-z0-9\-~][a-z0-9\-\._~]*$`)
+
+// splitPkgPath splits the bare specifier into the package name and the submodule, like
+// `preact/compat` -> `preact`, `compat`.
+func splitPkgPath(specifier string) (name string, submodule string) {
+	a := strings.Split(specifier, "/")
+	if strings.HasPrefix(specifier, "@") && len(a) > 1 {
+		return strings.Join(a[:2], "/"), strings.Join(a[2:], "/")
+	}
+	return a[0], strings.Join(a[1:], "/")
+}
+
+// An importAlias rewrites the imports of the packages in a build, it's set by the `alias`
+// query like `?alias=react:preact/compat,react-dom:preact/compat`.
+type importAlias map[string]string
+
+func parseImportAlias(s string) (importAlias, error) {
+	alias := importAlias{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		from, to := utils.SplitByFirstByte(p, ':')
+		from = strings.TrimSpace(from)
+		to = strings.Trim(strings.TrimSpace(to), "/")
+		if !regPkgName.MatchString(from) {
+			return nil, fmt.Errorf("invalid alias '%s', bad package name '%s'", p, from)
+		}
+		if name, _ := splitPkgPath(to); !regPkgName.MatchString(name) {
+			return nil, fmt.Errorf("invalid alias '%s', bad target '%s'", p, to)
+		}
+		if name, _ := splitPkgPath(to); name != from {
+			alias[from] = to
+		}
+	}
+	return alias, nil
+}
+
+// Resolve returns the aliased specifier, the submodules of the aliased package are
+// rewritten as well, like `react/jsx-runtime` -> `preact/compat/jsx-runtime`.
+func (a importAlias) Resolve(specifier string) (string, bool) {
+	name, submodule := splitPkgPath(specifier)
+	to, ok := a[name]
+	if !ok {
+		return specifier, false
+	}
+	if submodule != "" {
+		to += "/" + submodule
+	}
+	return to, true
+}
+
+func (a importAlias) String() string {
+	s := make([]string, 0, len(a))
+	for from, to := range a {
+		s = append(s, from+":"+to)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
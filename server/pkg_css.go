@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// splitBuildCSSPath splits the path of the package css like
+// `/react-datepicker@4.1.1/es2020/react-datepicker.css` into the package path and the target,
+// the css of the submodules are like `/antd@4.16.0/es2020/dist/antd.css`.
+func splitBuildCSSPath(pathname string) (pkgPath string, target string, ok bool) {
+	a := strings.Split(strings.TrimPrefix(pathname, "/"), "/")
+	n := 1
+	if strings.HasPrefix(a[0], "@") {
+		n = 2
+	}
+	if len(a) < n+2 || !strings.Contains(a[n-1], "@") {
+		return
+	}
+	name, version := utils.SplitByLastByte(strings.Join(a[:n], "/"), '@')
+	target = a[n]
+	if _, ok := targets[target]; !ok || name == "" || version == "" {
+		return "", "", false
+	}
+	pkgPath = "/" + strings.Join(a[:n], "/")
+	if submodule := strings.TrimSuffix(strings.Join(a[n+1:], "/"), ".css"); submodule != path.Base(name) {
+		pkgPath += "/" + submodule
+	}
+	return pkgPath, target, true
+}
+
+// wantsStylesheet reports whether the request is made by a `<link rel="stylesheet">` or the
+// `@import` of css, the module imports get the module that injects the stylesheet instead.
+func wantsStylesheet(r *http.Request) bool {
+	if dest := r.Header.Get("Sec-Fetch-Dest"); dest != "" {
+		return dest == "style"
+	}
+	return strings.HasPrefix(r.Header.Get("Accept"), "text/css")
+}
+
+// cssLinkInjector returns the code that appends a `<link rel="stylesheet">` of the css URL to
+// the document once, the URL is resolved by the `import.meta.url`.
+func cssLinkInjector(cssURL string) string {
+	return fmt.Sprintf(`if (typeof document !== "undefined") {
+  const href = new URL(%s, import.meta.url).href;
+  if (!Array.from(document.querySelectorAll('link[rel="stylesheet"]')).some(link => link.href === href)) {
+    const link = document.createElement("link");
+    link.rel = "stylesheet";
+    link.href = href;
+    document.head.appendChild(link);
+  }
+}
+`, strings.TrimSpace(string(utils.MustEncodeJSON(cssURL))))
+}
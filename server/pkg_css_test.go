@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitBuildCSSPath(t *testing.T) {
+	for _, c := range []struct {
+		pathname string
+		pkgPath  string
+		target   string
+	}{
+		{"/react-datepicker@4.1.1/es2020/react-datepicker.css", "/react-datepicker@4.1.1", "es2020"},
+		{"/@fullcalendar/daygrid@5.8.0/deno/daygrid.css", "/@fullcalendar/daygrid@5.8.0", "deno"},
+		{"/antd@4.16.0/es2015/dist/antd.css", "/antd@4.16.0/dist/antd", "es2015"},
+		{"/normalize.css@8.0.1/normalize.css", "", ""},
+		{"/bootstrap@5.0.0/dist/css/bootstrap.css", "", ""},
+		{"/antd/es2015/antd.css", "", ""},
+	} {
+		pkgPath, target, ok := splitBuildCSSPath(c.pathname)
+		if pkgPath != c.pkgPath || target != c.target || ok != (c.target != "") {
+			t.Fatalf("split %s: unexpected '%s' '%s' %v", c.pathname, pkgPath, target, ok)
+		}
+	}
+}
+
+func TestPackageCSS(t *testing.T) {
+	_, s := newTestServer(t)
+	handler := s.Handler()
+
+	cssPath := fmt.Sprintf("/v%d/esm-fixture-assets@1.0.0/es2020/esm-fixture-assets.css", VERSION)
+	for _, c := range []struct {
+		url         string
+		header      map[string]string
+		status      int
+		contentType string
+		contains    []string
+	}{
+		{"/esm-fixture-assets@1.0.0/es2020/esm-fixture-assets.css", nil, 200, "text/css; charset=utf-8", []string{"@font-face"}},
+		{"/esm-fixture-assets@1.0.0?css&target=es2020", map[string]string{"Accept": "text/css,*/*;q=0.1"}, 307, "", nil},
+		{"/esm-fixture-assets@1.0.0?css&target=es2020", map[string]string{"Sec-Fetch-Dest": "style"}, 307, "", nil},
+		{"/esm-fixture-assets@1.0.0?css&target=es2020", map[string]string{"Sec-Fetch-Dest": "script"}, 200, "application/javascript; charset=utf-8", []string{
+			`new URL("` + cssPath + `", import.meta.url)`,
+			`link.rel = "stylesheet"`,
+			fmt.Sprintf(`export * from "/v%d/esm-fixture-assets@1.0.0/es2020/esm-fixture-assets.js"`, VERSION),
+		}},
+		{"/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm.css", nil, 404, "", nil},
+	} {
+		req := httptest.NewRequest("GET", c.url, nil)
+		for key, value := range c.header {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Fatalf("GET %s: unexpected status %d:\n%s", c.url, rec.Code, rec.Body.String())
+		}
+		if c.contentType != "" && rec.Header().Get("Content-Type") != c.contentType {
+			t.Fatalf("GET %s: unexpected content type '%s'", c.url, rec.Header().Get("Content-Type"))
+		}
+		if c.status == 307 && !strings.HasSuffix(rec.Header().Get("Location"), cssPath) {
+			t.Fatalf("GET %s: unexpected location '%s'", c.url, rec.Header().Get("Location"))
+		}
+		for _, s := range c.contains {
+			if !strings.Contains(rec.Body.String(), s) {
+				t.Fatalf("GET %s: missing '%s' in the body:\n%s", c.url, s, rec.Body.String())
+			}
+		}
+	}
+}
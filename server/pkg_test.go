@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestImportAlias(t *testing.T) {
+	alias, err := parseImportAlias("react:preact/compat, react-dom:preact/compat,preact:preact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias.String() != "react-dom:preact/compat,react:preact/compat" {
+		t.Fatalf("unexpected alias %s", alias)
+	}
+	for specifier, expect := range map[string]string{
+		"react":             "preact/compat",
+		"react/jsx-runtime": "preact/compat/jsx-runtime",
+		"react-dom/server":  "preact/compat/server",
+		"react-is":          "",
+	} {
+		to, ok := alias.Resolve(specifier)
+		if ok != (expect != "") || (ok && to != expect) {
+			t.Fatalf("unexpected alias of %s: %s", specifier, to)
+		}
+	}
+	for _, s := range []string{"react", "react:", "./react:preact", "react:../preact", "React:preact"} {
+		if _, err := parseImportAlias(s); err == nil {
+			t.Fatalf("alias '%s' should be invalid", s)
+		}
+	}
+
+	task := &buildTask{pkg: pkg{name: "swr", version: "0.5.6"}, alias: alias, target: "es2020"}
+	if id := task.ID(); id != fmt.Sprintf("v%d/swr@0.5.6/alias=react-dom:preact_compat,react:preact_compat/es2020/swr", VERSION) {
+		t.Fatalf("unexpected build id %s", id)
+	}
+}
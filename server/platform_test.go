@@ -0,0 +1,59 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestDefaultDirs(t *testing.T) {
+	programData := os.Getenv("ProgramData")
+	os.Setenv("ProgramData", filepath.Join("C:", "ProgramData"))
+	defer os.Setenv("ProgramData", programData)
+
+	for _, c := range []struct {
+		goos   string
+		etcDir string
+		logDir string
+	}{
+		{"linux", "/usr/local/etc/esmd", "/var/log/esmd"},
+		{"darwin", "/usr/local/etc/esmd", "/usr/local/var/log/esmd"},
+		{"windows", filepath.Join("C:", "ProgramData", "esmd"), filepath.Join("C:", "ProgramData", "esmd", "log")},
+	} {
+		if dir := defaultEtcDir(c.goos); dir != c.etcDir {
+			t.Fatalf("unexpected etc dir of %s: %s, should be %s", c.goos, dir, c.etcDir)
+		}
+		if dir := defaultLogDir(c.goos); dir != c.logDir {
+			t.Fatalf("unexpected log dir of %s: %s, should be %s", c.goos, dir, c.logDir)
+		}
+	}
+}
+
+func TestShutdownSignals(t *testing.T) {
+	if len(shutdownSignals) == 0 {
+		t.Fatal("missing the shutdown signals")
+	}
+	for _, sig := range shutdownSignals {
+		// the SIGKILL can't be caught
+		if sig == syscall.SIGKILL {
+			t.Fatal("the SIGKILL should not be notified")
+		}
+	}
+	if shutdownSignals[0] != os.Interrupt && shutdownSignals[0] != syscall.SIGTERM {
+		t.Fatalf("unexpected shutdown signals %v", shutdownSignals)
+	}
+}
+
+func TestLookupPackageFileOfOSPaths(t *testing.T) {
+	wd, err := filepath.Abs(filepath.Join("testdata", "wd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &buildTask{pkg: pkg{name: "a", version: "1.0.0"}, wd: wd}
+	// esbuild passes the paths with the OS separator
+	name, version, subpath, ok := task.lookupPackageFile(filepath.Join(wd, "node_modules", "a", "lib", "chunk.js"))
+	if !ok || name != "a" || version != "1.0.0" || subpath != "lib/chunk.js" {
+		t.Fatalf("unexpected package file %s@%s/%s", name, version, subpath)
+	}
+}
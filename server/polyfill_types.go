@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// polyfillTypes returns the declaration path of the embedded polyfill like
+// `/_node_buffer.js` -> `/v{VERSION}/_node_buffer.d.ts`, the declarations are copied from
+// `embed/types` to the types storage when the server starts.
+func (s *Server) polyfillTypes(pathname string) (dts string, ok bool) {
+	name := strings.TrimPrefix(pathname, "/")
+	if !strings.HasPrefix(name, "_") || !strings.HasSuffix(name, ".js") || strings.Contains(name, "/") {
+		return
+	}
+	filename := fmt.Sprintf("%s.d.ts", strings.TrimSuffix(name, ".js"))
+	if !fileExists(filepath.Join(s.config.storageDir, "types", fmt.Sprintf("v%d", VERSION), filename)) {
+		return
+	}
+	return fmt.Sprintf("/v%d/%s", VERSION, filename), true
+}
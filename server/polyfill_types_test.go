@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ije/esbuild-internal/js_parser"
+	"github.com/ije/esbuild-internal/logger"
+	"github.com/ije/esbuild-internal/test"
+)
+
+var regDtsExport = regexp.MustCompile(`export (?:declare (?:const|function|class) (\w+)|\{ \w+ as (\w+) \}|default )`)
+
+func TestPolyfillDeclarations(t *testing.T) {
+	polyfills, err := ioutil.ReadDir("../embed/polyfills")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range polyfills {
+		name := strings.TrimSuffix(fi.Name(), ".js")
+		code, err := ioutil.ReadFile(filepath.Join("../embed/polyfills", fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dts, err := ioutil.ReadFile(filepath.Join("../embed/types", name+".d.ts"))
+		if err != nil {
+			t.Fatalf("missing the declarations of the %s polyfill", name)
+		}
+		declared := map[string]bool{}
+		for _, m := range regDtsExport.FindAllStringSubmatch(string(dts), -1) {
+			switch {
+			case m[1] != "":
+				declared[m[1]] = true
+			case m[2] != "":
+				declared[m[2]] = true
+			default:
+				declared["default"] = true
+			}
+		}
+		ast, pass := js_parser.Parse(logger.NewDeferLog(), test.SourceForTest(string(code)), js_parser.Options{})
+		if !pass {
+			t.Fatalf("parse the %s polyfill", name)
+		}
+		for export := range ast.NamedExports {
+			if !declared[export] {
+				t.Fatalf("the export '%s' of the %s polyfill is not declared", export, name)
+			}
+		}
+	}
+}
+
+func TestPolyfillTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-polyfill-types-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &Server{config: &Config{storageDir: dir}}
+
+	typesDir := filepath.Join(dir, "types", fmt.Sprintf("v%d", VERSION))
+	ensureDir(typesDir)
+	ioutil.WriteFile(filepath.Join(typesDir, "_node_buffer.d.ts"), []byte("export {}"), 0644)
+
+	if dts, ok := s.polyfillTypes("/_node_buffer.js"); !ok || dts != fmt.Sprintf("/v%d/_node_buffer.d.ts", VERSION) {
+		t.Fatalf("unexpected types of _node_buffer.js: %s", dts)
+	}
+	for _, pathname := range []string{"/_node_process.js", "/react@17.0.2/es2020/react.js", "/_node_buffer.js.map"} {
+		if _, ok := s.polyfillTypes(pathname); ok {
+			t.Fatalf("%s should not have the polyfill types", pathname)
+		}
+	}
+}
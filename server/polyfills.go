@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// polyfillsHash returns the hash of the active polyfill set: the embedded polyfills and the
+// mappings of the node builtin modules to the polyfill packages and the deno std shims. The
+// builds record it to be rebuilt when the polyfills are changed.
+func (s *Server) polyfillsHash() string {
+	s.polyfills.Do(func() {
+		h := sha1.New()
+		if s.embedFS != nil {
+			entries, err := s.embedFS.ReadDir("embed/polyfills")
+			if err == nil {
+				for _, entry := range entries {
+					data, err := s.embedFS.ReadFile("embed/polyfills/" + entry.Name())
+					if err == nil {
+						fmt.Fprintf(h, "%s:%d\n", entry.Name(), len(data))
+						h.Write(data)
+					}
+				}
+			}
+		}
+		writeSortedMap(h, "deno-std", denoStdNodeModules)
+		writeSortedMap(h, "polyfills", polyfilledBuiltInNodeModules)
+		s.polyfills.hash = hex.EncodeToString(h.Sum(nil))[:16]
+	})
+	return s.polyfills.hash
+}
+
+func writeSortedMap(w io.Writer, name string, m interface{}) {
+	lines := []string{}
+	switch v := m.(type) {
+	case map[string]bool:
+		for key, value := range v {
+			lines = append(lines, fmt.Sprintf("%s=%v", key, value))
+		}
+	case map[string]string:
+		for key, value := range v {
+			lines = append(lines, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	sort.Strings(lines)
+	fmt.Fprintf(w, "%s:%d\n", name, len(lines))
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// polyfillsChanged reports whether the build is built with a different polyfill set, the
+// records before the hash is recorded are not checked.
+func (s *Server) polyfillsChanged(esm *ESMeta) bool {
+	return esm.PolyfillsHash != "" && esm.PolyfillsHash != s.polyfillsHash()
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ije/gox/utils"
+	"github.com/postui/postdb/q"
+)
+
+func TestPolyfillsHash(t *testing.T) {
+	s := setupTestEnv(t)
+
+	if h := s.polyfillsHash(); len(h) != 16 || h != s.polyfillsHash() {
+		t.Fatalf("unexpected polyfills hash '%s'", h)
+	}
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-esm@1.0.0"), cjsExports: "auto", target: "es2020"}
+	esm, _, err := task.buildESM(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esm.PolyfillsHash != s.polyfillsHash() {
+		t.Fatalf("unexpected polyfills hash '%s' of the build", esm.PolyfillsHash)
+	}
+	if _, _, ok := s.findESM(task.ID()); !ok {
+		t.Fatal("the build should be found")
+	}
+
+	// the build with a different polyfill set is stale
+	stale := *esm
+	stale.PolyfillsHash = "0000000000000000"
+	err = s.db.Update(q.Alias(task.ID()), q.KV{"esmeta": utils.MustEncodeJSON(stale)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.findESM(task.ID()); ok {
+		t.Fatal("the stale build should not be found")
+	}
+
+	// the builds before the hash is recorded are not rebuilt
+	legacy := *esm
+	legacy.PolyfillsHash = ""
+	err = s.db.Update(q.Alias(task.ID()), q.KV{"esmeta": utils.MustEncodeJSON(legacy)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := s.findESM(task.ID()); !ok {
+		t.Fatal("the legacy build should be found")
+	}
+
+	// the rebuild replaces the stale record
+	err = s.db.Update(q.Alias(task.ID()), q.KV{"esmeta": utils.MustEncodeJSON(stale)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = task.buildESM(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if esm, _, ok := s.findESM(task.ID()); !ok || esm.PolyfillsHash != s.polyfillsHash() {
+		t.Fatal("the rebuild should update the record")
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+const prebuildMaxDeps = 200
+
+// A PrebuildRequest specifies the packages to build like the `dependencies` of package.json.
+type PrebuildRequest struct {
+	Dependencies map[string]string `json:"dependencies"`
+	Target       string            `json:"target"`
+	Dev          bool              `json:"dev"`
+}
+
+// prebuild handles the `POST /-/build` requests, it resolves and builds all the dependencies,
+// then returns the URLs of the builds and an import map.
+func (s *Server) prebuild(ctx *rex.Context, queue *buildQueue) interface{} {
+	if ctx.R.Method != "POST" {
+		ctx.SetHeader("Allow", "POST")
+		return rex.Status(405, "method not allowed")
+	}
+
+	var req PrebuildRequest
+	err := json.NewDecoder(io.LimitReader(ctx.R.Body, 1<<20)).Decode(&req)
+	if err != nil {
+		return rex.Status(400, "invalid request body: "+err.Error())
+	}
+	if len(req.Dependencies) == 0 {
+		return rex.Status(400, "no dependencies")
+	}
+	if len(req.Dependencies) > prebuildMaxDeps {
+		return rex.Status(400, fmt.Sprintf("too many dependencies, the max is %d", prebuildMaxDeps))
+	}
+	if req.Target == "" {
+		req.Target = "es2020"
+	}
+	if _, ok := targets[req.Target]; !ok {
+		return rex.Status(400, fmt.Sprintf("invalid target '%s', available targets: %s", req.Target, strings.Join(targetNames(), ", ")))
+	}
+	// the byte budget of the gzip sizes of all the builds, like `?budget=150kb`
+	var budget int64
+	if v := ctx.R.URL.Query().Get("budget"); v != "" {
+		budget, err = parseByteSize(v)
+		if err != nil || budget <= 0 {
+			return rex.Status(400, fmt.Sprintf("invalid budget '%s', should be like '150kb'", v))
+		}
+	}
+
+	origin := fmt.Sprintf("https://%s", s.config.cdnDomain)
+	if s.config.cdnDomain == "" {
+		proto := "http"
+		if ctx.R.TLS != nil {
+			proto = "https"
+		}
+		origin = fmt.Sprintf("%s://%s", proto, ctx.R.Host)
+	}
+	client, quota := s.buildClient(ctx)
+
+	names := make([]string, 0, len(req.Dependencies))
+	for name := range req.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	urls := map[string]string{}
+	ids := map[string]string{}
+	scopes := map[string]string{}
+	errors := map[string]string{}
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string, version string) {
+			defer wg.Done()
+
+			url, prefix, err := s.prebuildPackage(queue, name, version, req.Target, req.Dev, client, quota)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errors[name] = err.Error()
+				return
+			}
+			urls[name] = origin + url
+			ids[name] = strings.TrimSuffix(strings.TrimPrefix(url, "/"), ".js")
+			scopes[name+"/"] = origin + prefix
+		}(name, strings.TrimSpace(req.Dependencies[name]))
+	}
+	wg.Wait()
+
+	imports := map[string]string{}
+	for specifier, url := range urls {
+		imports[specifier] = url
+	}
+	for specifier, url := range scopes {
+		imports[specifier] = url
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	ret := map[string]interface{}{
+		"target":    req.Target,
+		"urls":      urls,
+		"importMap": map[string]interface{}{"imports": imports},
+		"errors":    errors,
+	}
+	if budget > 0 {
+		items, total, err := s.measureBudget(ids, origin)
+		if err != nil {
+			return rex.Status(500, err.Error())
+		}
+		if total > budget {
+			return rex.Status(422, map[string]interface{}{
+				"error":    fmt.Sprintf("the gzip size %s exceeds the budget %s", formatByteSize(total), formatByteSize(budget)),
+				"budget":   budget,
+				"size":     total,
+				"packages": items,
+			})
+		}
+		ret["budget"] = budget
+		ret["size"] = total
+	}
+	return ret
+}
+
+// prebuildPackage builds the package, returns the path of the build and the path prefix of
+// its submodules.
+func (s *Server) prebuildPackage(queue *buildQueue, name string, version string, target string, isDev bool, client string, quota int) (url string, prefix string, err error) {
+	if name == "" || strings.ContainsAny(name, " ?#") || strings.Count(name, "/") > 1 || (strings.Contains(name, "/") && !strings.HasPrefix(name, "@")) {
+		return "", "", fmt.Errorf("invalid package name")
+	}
+	if version == "" {
+		version = "latest"
+	}
+	if strings.Contains(version, ":") || strings.Contains(version, "/") {
+		return "", "", fmt.Errorf("unsupported version '%s'", version)
+	}
+	info, _, err := s.getPackageInfo(name, version)
+	if err != nil {
+		return
+	}
+	if issue, ok := s.lookupKnownIssue(name, info.Version); ok {
+		return "", "", issue
+	}
+
+	task := &buildTask{
+		Server: s,
+		pkg:    pkg{name: name, version: info.Version},
+		target: target,
+		isDev:  isDev,
+	}
+	task.applyDefaultExternals()
+	if _, _, ok := s.findESM(task.ID()); !ok {
+		if !coldBuildQuota.TakeBuild(queue, task, client, quota, time.Now()) {
+			return "", "", fmt.Errorf("build quota exceeded")
+		}
+		output := <-queue.Add(task)
+		if output.err != nil {
+			return "", "", output.err
+		}
+	}
+	buildAccess.Touch(task.ID())
+	return fmt.Sprintf("/%s.js", task.ID()), fmt.Sprintf("/%s/", task.pkg.String()), nil
+}
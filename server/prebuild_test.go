@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/postui/postdb/q"
+)
+
+func TestPrebuildPackage(t *testing.T) {
+	s := setupTestEnv(t)
+	queue := newBuildQueue(1, 0)
+
+	// a cached build
+	id := fmt.Sprintf("v%d/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm", VERSION)
+	err := writeFileAtomic(filepath.Join(s.config.storageDir, "builds", id+".js"), bytes.NewReader([]byte("export default 1;\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.db.Put(q.Alias(id), q.KV{"esmeta": []byte(`{"module":"index.mjs"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, prefix, err := s.prebuildPackage(queue, "esm-fixture-esm", "^1.0.0", "es2020", false, "ip:127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "/"+id+".js" || prefix != "/esm-fixture-esm@1.0.0/" {
+		t.Fatalf("unexpected build url %s %s", url, prefix)
+	}
+
+	for name, version := range map[string]string{
+		"":                    "1.0.0",
+		"a/b":                 "1.0.0",
+		"@scope/a/b":          "1.0.0",
+		"esm-fixture-dep":     "github:foo/bar",
+		"esm-fixture-unknown": "1.0.0",
+	} {
+		if _, _, err := s.prebuildPackage(queue, name, version, "es2020", false, "ip:127.0.0.1", 0); err == nil {
+			t.Fatalf("%s@%s should not be built", name, version)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// parseProxyURL checks the proxy URL of the `http-proxy` and `https-proxy` configs, the scheme
+// is `http` by default.
+func parseProxyURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid proxy URL '%s'", s)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return "", fmt.Errorf("invalid proxy URL '%s': unsupported scheme '%s'", s, u.Scheme)
+	}
+	return u.String(), nil
+}
+
+// hasProxyConfig reports whether any of the `http-proxy`, `https-proxy` and `no-proxy`
+// configs is set, otherwise the proxy env vars of the server are used.
+func (c *Config) hasProxyConfig() bool {
+	return c != nil && (c.httpProxy != "" || c.httpsProxy != "" || c.noProxy != "")
+}
+
+// requestProxy returns the proxy of the registry requests by the proxy configs.
+func (c *Config) requestProxy(req *http.Request) (*url.URL, error) {
+	if !c.hasProxyConfig() {
+		return http.ProxyFromEnvironment(req)
+	}
+	return c.proxy(req.URL)
+}
+
+// proxyEnv returns the env vars of the proxy configs for the installers, both yarn and npm
+// read the `npm_config_*` vars.
+func (c *Config) proxyEnv() []string {
+	if !c.hasProxyConfig() {
+		return nil
+	}
+	env := []string{}
+	for _, e := range []struct {
+		names []string
+		value string
+	}{
+		{[]string{"HTTP_PROXY", "http_proxy", "npm_config_proxy"}, c.httpProxy},
+		{[]string{"HTTPS_PROXY", "https_proxy", "npm_config_https_proxy"}, c.httpsProxy},
+		{[]string{"NO_PROXY", "no_proxy", "npm_config_noproxy"}, c.noProxy},
+	} {
+		// the empty values override the proxy env vars of the server
+		for _, name := range e.names {
+			env = append(env, name+"="+e.value)
+		}
+	}
+	return env
+}
+
+func newProxyFunc(httpProxy string, httpsProxy string, noProxy string) func(*url.URL) (*url.URL, error) {
+	return (&httpproxy.Config{
+		HTTPProxy:  httpProxy,
+		HTTPSProxy: httpsProxy,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseProxyURL(t *testing.T) {
+	for s, expected := range map[string]string{
+		"":                       "",
+		"proxy.corp:3128":        "http://proxy.corp:3128",
+		"https://proxy.corp":     "https://proxy.corp",
+		"http://u:p@proxy:8080/": "http://u:p@proxy:8080/",
+	} {
+		u, err := parseProxyURL(s)
+		if err != nil || u != expected {
+			t.Fatalf("parse %q: expected %q, got %q, %v", s, expected, u, err)
+		}
+	}
+	for _, s := range []string{"ftp://proxy.corp", "http://"} {
+		if _, err := parseProxyURL(s); err == nil {
+			t.Fatalf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestRequestProxy(t *testing.T) {
+	s := &Server{config: &Config{httpsProxy: "http://proxy.corp:3128", noProxy: ".internal.corp"}}
+	s.config.proxy = newProxyFunc(s.config.httpProxy, s.config.httpsProxy, s.config.noProxy)
+
+	for url, expected := range map[string]string{
+		"https://registry.npmjs.org/react":       "http://proxy.corp:3128",
+		"https://npm.internal.corp/@corp/ui":     "",
+		"http://registry.npmjs.org/react":        "",
+		"https://registry.npmjs.org/@babel/core": "http://proxy.corp:3128",
+	} {
+		proxy, err := s.config.requestProxy(httptest.NewRequest("GET", url, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (proxy == nil && expected != "") || (proxy != nil && proxy.String() != expected) {
+			t.Fatalf("unexpected proxy %v of %s", proxy, url)
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	stdout, _, err := s.runProc(context.Background(), procOptions{}, "sh", "-c", "echo $npm_config_https_proxy,$npm_config_noproxy,$HTTP_PROXY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env := strings.TrimSpace(string(stdout)); env != "http://proxy.corp:3128,.internal.corp," {
+		t.Fatalf("the proxy configs should be passed to the subprocesses, got %q", env)
+	}
+}
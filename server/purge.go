@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ije/rex"
+	"github.com/postui/postdb/q"
+)
+
+// the artifacts of a build in the builds storage
+var buildArtifactExts = []string{".js", ".js.map", ".css", ".LEGAL.txt", ".js.sig", ".js.map.sig", ".css.sig", ".LEGAL.txt.sig"}
+
+// purgeBuilds removes the builds of the package version, returns the number of the purged builds.
+func (s *Server) purgeBuilds(name string, version string) (n int, err error) {
+	infix := fmt.Sprintf("/%s@%s/", name, version)
+	posts, err := s.db.List(q.Filter(func(post q.Post) bool {
+		return strings.Contains("/"+post.Alias, infix)
+	}))
+	if err != nil {
+		return
+	}
+	for _, post := range posts {
+		_, err = s.db.Delete(q.Alias(post.Alias))
+		if err != nil {
+			return
+		}
+		for _, ext := range buildArtifactExts {
+			os.Remove(filepath.Join(s.config.storageDir, "builds", post.Alias+ext))
+		}
+		n++
+	}
+	return
+}
+
+// purge handles the `POST /-/purge?pkg=react@17.0.2` requests, the builds of the package
+// version are removed and will be rebuilt by the next requests. The package patterns like
+// `react*` and `@babel/*`, and the filters like `target=es2015` and `older-than=180d` start
+// a batch purge in the background, the progress is reported at `/-/purge/{id}`.
+func (s *Server) purge(ctx *rex.Context) interface{} {
+	if s.config.adminToken == "" {
+		return rex.Err(404)
+	}
+	if !s.isAdminRequest(ctx, false) {
+		return rex.Err(401)
+	}
+	if ctx.R.Method != "POST" {
+		ctx.SetHeader("Allow", "POST")
+		return rex.Status(405, "method not allowed")
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	pkg := ctx.Form.Value("pkg")
+	target := ctx.Form.Value("target")
+	olderThan := ctx.Form.Value("older-than")
+	if strings.Contains(pkg, "*") || target != "" || olderThan != "" {
+		filter, err := parsePurgeFilter(pkg, target, olderThan, time.Now())
+		if err != nil {
+			return rex.Status(400, err.Error())
+		}
+		job, err := s.purgeJobs.Start(filter)
+		if err != nil {
+			return rex.Status(500, err.Error())
+		}
+		statusURL := purgeJobPrefix + job.ID
+		ctx.SetHeader("Location", statusURL)
+		return rex.Status(202, map[string]interface{}{
+			"id":        job.ID,
+			"filter":    job.Filter,
+			"status":    job.Status,
+			"statusURL": statusURL,
+		})
+	}
+
+	name, version := splitPkgVersion(pkg)
+	if !regPkgName.MatchString(name) || !regFullVersion.MatchString(version) {
+		return rex.Status(400, fmt.Sprintf("invalid package '%s', the exact version is required", pkg))
+	}
+	n, err := s.purgeBuilds(name, version)
+	if err != nil {
+		return rex.Status(500, err.Error())
+	}
+	s.log.Infof("purge: %d builds of %s@%s purged by admin", n, name, version)
+	return map[string]interface{}{
+		"pkg":    fmt.Sprintf("%s@%s", name, version),
+		"purged": n,
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/rex"
+	"github.com/postui/postdb/q"
+)
+
+// the prefix of the progress URLs of the purge jobs, like `/-/purge/3f2a9c1e`
+const purgeJobPrefix = "/-/purge/"
+
+// the time to keep the state of the finished purge jobs
+const purgeJobTTL = time.Hour
+
+var regBuildVersionPrefix = regexp.MustCompile(`^v\d+/`)
+
+// A purgeFilter selects the builds to purge by the package pattern like `react*` or
+// `@babel/*@7.*`, the target, and the age of the builds.
+type purgeFilter struct {
+	name    string
+	version string
+	target  string
+	before  time.Time
+}
+
+func parsePurgeFilter(pkg string, target string, olderThan string, now time.Time) (f purgeFilter, err error) {
+	f.name, f.version = splitPkgVersion(pkg)
+	if _, e := path.Match(f.name, ""); e != nil || !regPkgName.MatchString(strings.ReplaceAll(f.name, "*", "x")) {
+		return f, fmt.Errorf("invalid package pattern '%s'", pkg)
+	}
+	if _, e := path.Match(f.version, ""); e != nil || strings.Contains(f.version, "/") {
+		return f, fmt.Errorf("invalid package pattern '%s'", pkg)
+	}
+	if target != "" {
+		if _, ok := targets[target]; !ok {
+			return f, fmt.Errorf("unsupported target '%s'", target)
+		}
+		f.target = target
+	}
+	if olderThan != "" {
+		f.before, err = parseTimeAgo(olderThan+"-ago", now)
+		if err != nil {
+			return f, fmt.Errorf("invalid age '%s'", olderThan)
+		}
+	}
+	return
+}
+
+// Match reports whether the build is selected by the filter, the id of the build is like
+// `v36/react@17.0.2/es2020/react`.
+func (f purgeFilter) Match(id string, built time.Time) bool {
+	if !f.before.IsZero() && !built.Before(f.before) {
+		return false
+	}
+	segments := strings.Split(regBuildVersionPrefix.ReplaceAllString(id, ""), "/")
+	if strings.HasPrefix(segments[0], "@") && len(segments) > 1 {
+		segments = append([]string{segments[0] + "/" + segments[1]}, segments[2:]...)
+	}
+	name, version := splitPkgVersion(segments[0])
+	if ok, _ := path.Match(f.name, name); !ok || version == "" {
+		return false
+	}
+	if f.version != "" {
+		if ok, _ := path.Match(f.version, version); !ok {
+			return false
+		}
+	}
+	if f.target != "" {
+		// the first segment that is a target is the target of the build, the options like
+		// `deps=...` and `bundle` are before it
+		for _, s := range segments[1:] {
+			if _, ok := targets[s]; ok {
+				return s == f.target
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (f purgeFilter) String() string {
+	s := f.name
+	if f.version != "" {
+		s += "@" + f.version
+	}
+	if f.target != "" {
+		s += " target=" + f.target
+	}
+	if !f.before.IsZero() {
+		s += " before=" + f.before.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// A purgeJob is the state of a batch purge in the background.
+type purgeJob struct {
+	ID       string     `json:"id"`
+	Filter   string     `json:"filter"`
+	Status   string     `json:"status"`
+	Matched  int        `json:"matched"`
+	Purged   int        `json:"purged"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// A purgeJobTracker tracks the purge jobs until they expire.
+type purgeJobTracker struct {
+	server *Server
+	lock   sync.Mutex
+	jobs   map[string]*purgeJob
+}
+
+func newPurgeJobTracker(server *Server) *purgeJobTracker {
+	return &purgeJobTracker{server: server, jobs: map[string]*purgeJob{}}
+}
+
+// Start starts a purge job of the filter in the background.
+func (t *purgeJobTracker) Start(filter purgeFilter) (job purgeJob, err error) {
+	id := make([]byte, 4)
+	_, err = rand.Read(id)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	j := &purgeJob{
+		ID:      hex.EncodeToString(id),
+		Filter:  filter.String(),
+		Status:  "scanning",
+		Started: now,
+	}
+
+	t.lock.Lock()
+	for key, old := range t.jobs {
+		if old.Finished != nil && now.Sub(*old.Finished) > purgeJobTTL {
+			delete(t.jobs, key)
+		}
+	}
+	t.jobs[j.ID] = j
+	job = *j
+	t.lock.Unlock()
+
+	go t.run(j, filter)
+	return
+}
+
+func (t *purgeJobTracker) run(j *purgeJob, filter purgeFilter) {
+	err := t.server.purgeMatchedBuilds(filter, func(matched int, purged int) {
+		t.lock.Lock()
+		j.Status = "purging"
+		j.Matched = matched
+		j.Purged = purged
+		t.lock.Unlock()
+	})
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	j.Status = "done"
+	if err != nil {
+		j.Status = "error"
+		j.Error = err.Error()
+	}
+	finished := time.Now()
+	j.Finished = &finished
+	t.server.log.Infof("purge: %d builds of %s purged by admin (job %s)", j.Purged, j.Filter, j.ID)
+}
+
+// Get returns the state of the job.
+func (t *purgeJobTracker) Get(id string) (job purgeJob, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	j, ok := t.jobs[id]
+	if ok {
+		job = *j
+	}
+	return
+}
+
+// purgeMatchedBuilds removes the builds that are selected by the filter, the progress is
+// reported after every removed build.
+func (s *Server) purgeMatchedBuilds(filter purgeFilter, progress func(matched int, purged int)) (err error) {
+	posts, err := s.db.List(q.Filter(func(post q.Post) bool {
+		return regBuildVersionPrefix.MatchString(post.Alias) && filter.Match(post.Alias, time.Unix(int64(post.Crtime), 0))
+	}))
+	if err != nil {
+		return
+	}
+	progress(len(posts), 0)
+	for i, post := range posts {
+		_, err = s.db.Delete(q.Alias(post.Alias))
+		if err != nil {
+			return
+		}
+		for _, ext := range buildArtifactExts {
+			os.Remove(filepath.Join(s.config.storageDir, "builds", post.Alias+ext))
+		}
+		progress(len(posts), i+1)
+	}
+	return
+}
+
+// purgeJobStatus handles the `GET /-/purge/{id}` requests of the admin, it reports the progress
+// of the purge job.
+func (s *Server) purgeJobStatus(ctx *rex.Context, pathname string) interface{} {
+	if s.config.adminToken == "" {
+		return rex.Err(404)
+	}
+	if !s.isAdminRequest(ctx, false) {
+		return rex.Err(401)
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	job, ok := s.purgeJobs.Get(strings.TrimPrefix(pathname, purgeJobPrefix))
+	if !ok {
+		return rex.Status(404, "purge job not found")
+	}
+	if job.Finished == nil {
+		ctx.SetHeader("Retry-After", "1")
+	}
+	return job
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ije/rex"
+	"github.com/postui/postdb"
+	"github.com/postui/postdb/q"
+)
+
+func TestPurge(t *testing.T) {
+	s := setupTestEnv(t)
+	s.config.adminToken = "secret"
+
+	ids := []string{
+		fmt.Sprintf("v%d/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm", VERSION),
+		fmt.Sprintf("v%d/esm-fixture-esm@1.0.0/deno/esm-fixture-esm.development", VERSION),
+		// pinned by the `deps` query, not a build of the package
+		fmt.Sprintf("v%d/esm-fixture-cjs@1.0.0/deps=esm-fixture-esm@1.0.0/es2020/esm-fixture-cjs", VERSION),
+	}
+	for _, id := range ids {
+		if _, err := s.db.Put(q.Alias(id), q.KV{"esmeta": []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(s.config.storageDir, "builds", id+".js")
+		if err := ensureDir(filepath.Dir(filename)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte("export default 1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call := func(token string, pkg string) interface{} {
+		req := httptest.NewRequest("POST", "http://esm.sh/-/purge?pkg="+pkg, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return s.purge(&rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}})
+	}
+	if _, ok := call("wrong", "esm-fixture-esm@1.0.0").(map[string]interface{}); ok {
+		t.Fatal("the request without the admin token should be rejected")
+	}
+	if _, ok := call("secret", "esm-fixture-esm@1").(map[string]interface{}); ok {
+		t.Fatal("the purge of a version range should be rejected")
+	}
+	ret, ok := call("secret", "esm-fixture-esm@1.0.0").(map[string]interface{})
+	if !ok || ret["purged"] != 2 {
+		t.Fatalf("unexpected purge result %v", ret)
+	}
+	for i, id := range ids {
+		_, err := s.db.Get(q.Alias(id))
+		removed := !fileExists(filepath.Join(s.config.storageDir, "builds", id+".js"))
+		if i < 2 && (err != postdb.ErrNotFound || !removed) {
+			t.Fatalf("%s should be purged", id)
+		}
+		if i == 2 && (err != nil || removed) {
+			t.Fatalf("%s should be retained", id)
+		}
+	}
+}
+
+func TestPurgeFilter(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		pkg       string
+		target    string
+		olderThan string
+		id        string
+		age       time.Duration
+		match     bool
+	}{
+		{"react*", "", "", "v36/react-dom@17.0.2/es2020/react-dom", 0, true},
+		{"react*", "", "", "v36/preact@10.5.0/es2020/preact", 0, false},
+		{"@babel/*", "", "", "v35/@babel/core@7.16.0/deps=react@17.0.2/es2015/core", 0, true},
+		{"@babel/*@7.15.*", "", "", "v35/@babel/core@7.16.0/es2015/core", 0, false},
+		{"react", "es2015", "", "v36/react@17.0.2/bundle/es2015/react.development", 0, true},
+		{"react", "es2015", "", "v36/react@17.0.2/es2020/react", 0, false},
+		{"react", "", "180d", "v36/react@17.0.2/es2020/react", 181 * 24 * time.Hour, true},
+		{"react", "", "180d", "v36/react@17.0.2/es2020/react", 24 * time.Hour, false},
+	} {
+		f, err := parsePurgeFilter(c.pkg, c.target, c.olderThan, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Match(c.id, now.Add(-c.age)) != c.match {
+			t.Fatalf("filter %s: unexpected match of %s", f, c.id)
+		}
+	}
+	for _, c := range [][3]string{{"", "", ""}, {"react[", "", ""}, {"react*", "es1999", ""}, {"react*", "", "old"}} {
+		if _, err := parsePurgeFilter(c[0], c[1], c[2], now); err == nil {
+			t.Fatalf("the filter %v should be invalid", c)
+		}
+	}
+}
+
+func TestBatchPurge(t *testing.T) {
+	s := setupTestEnv(t)
+	s.config.adminToken = "secret"
+
+	ids := []string{
+		fmt.Sprintf("v%d/esm-fixture-esm@1.0.0/deno/esm-fixture-esm", VERSION),
+		fmt.Sprintf("v%d/esm-fixture-cjs@1.0.0/deno/esm-fixture-cjs", VERSION),
+		fmt.Sprintf("v%d/esm-fixture-cjs@1.0.0/es2020/esm-fixture-cjs", VERSION),
+	}
+	for _, id := range ids {
+		if _, err := s.db.Put(q.Alias(id), q.KV{"esmeta": []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "http://esm.sh/-/purge?pkg=esm-fixture-*&target=deno", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.purge(&rex.Context{W: w, R: req, Form: &rex.Form{R: req}})
+	statusURL := w.Header().Get("Location")
+	if !strings.HasPrefix(statusURL, purgeJobPrefix) {
+		t.Fatal("the batch purge should be started in the background")
+	}
+
+	var job purgeJob
+	for i := 0; i < 100; i++ {
+		req := httptest.NewRequest("GET", "http://esm.sh"+statusURL, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		ret, ok := s.purgeJobStatus(&rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}, statusURL).(purgeJob)
+		if !ok {
+			t.Fatal("the purge job should be found")
+		}
+		job = ret
+		if job.Finished != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Status != "done" || job.Matched != 2 || job.Purged != 2 {
+		t.Fatalf("unexpected purge job %+v", job)
+	}
+	for i, id := range ids {
+		_, err := s.db.Get(q.Alias(id))
+		if i < 2 && err != postdb.ErrNotFound {
+			t.Fatalf("%s should be purged", id)
+		}
+		if i == 2 && err != nil {
+			t.Fatalf("%s should be retained", id)
+		}
+	}
+}
@@ -7,47 +7,55 @@ import (
 	"net"
 	"net/http"
 	"path"
-	"runtime"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/evanw/esbuild/pkg/api"
 	"github.com/ije/gox/utils"
 	"github.com/ije/rex"
-	"github.com/mssola/user_agent"
 )
 
-var httpClient = &http.Client{
-	Transport: &http.Transport{
-		Dial: func(network, addr string) (conn net.Conn, err error) {
-			conn, err = net.DialTimeout(network, addr, 15*time.Second)
-			if err != nil {
-				return conn, err
-			}
+// newHTTPClient returns the client of the registry requests with the proxy configs.
+func newHTTPClient(config *Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: config.requestProxy,
+			Dial: func(network, addr string) (conn net.Conn, err error) {
+				conn, err = net.DialTimeout(network, addr, 15*time.Second)
+				if err != nil {
+					return conn, err
+				}
 
-			// Set a one-time deadline for potential SSL handshaking
-			conn.SetDeadline(time.Now().Add(60 * time.Second))
-			return conn, nil
+				// Set a one-time deadline for potential SSL handshaking
+				conn.SetDeadline(time.Now().Add(60 * time.Second))
+				return conn, nil
+			},
+			MaxIdleConnsPerHost:   6,
+			ResponseHeaderTimeout: 60 * time.Second,
 		},
-		MaxIdleConnsPerHost:   6,
-		ResponseHeaderTimeout: 60 * time.Second,
-	},
+	}
 }
 
 // esm query middleware for rex
-func query() rex.Handle {
+func (s *Server) query() rex.Handle {
 	startTime := time.Now()
-	queue := newBuildQueue(runtime.NumCPU())
+	queue := newBuildQueue(s.config.buildConcurrency, s.config.buildQueueSize)
+	queue.throttle = func() bool {
+		return s.config.buildMemThreshold > 0 && telemetry.Stats().MemPressure() >= s.config.buildMemThreshold
+	}
+	s.revalidator = newVersionRevalidator(s, queue, 4)
 
 	return func(ctx *rex.Context) interface{} {
 		pathname := ctx.Path.String()
 		switch pathname {
 		case "/":
-			indexHTML, err := embedFS.ReadFile("embed/index.html")
+			indexHTML, err := s.embedFS.ReadFile("embed/index.html")
 			if err != nil {
 				return err
 			}
-			readme, err := embedFS.ReadFile("README.md")
+			readme, err := s.embedFS.ReadFile("README.md")
 			if err != nil {
 				return err
 			}
@@ -56,8 +64,52 @@ func query() rex.Handle {
 			html = bytes.ReplaceAll(html, []byte("{VERSION}"), []byte(fmt.Sprintf("%d", VERSION)))
 			return rex.Content("index.html", startTime, bytes.NewReader(html))
 		case "/favicon.ico":
-			// todo: add esm.sh logo
-			return rex.Err(404)
+			data, err := s.embedFS.ReadFile("embed/assets/favicon.ico")
+			if err != nil {
+				return err
+			}
+			ctx.SetHeader("Cache-Control", "public, max-age=86400")
+			return rex.Content("favicon.ico", startTime, bytes.NewReader(data))
+		case "/robots.txt":
+			data, err := s.robotsTxt()
+			if err != nil {
+				return err
+			}
+			return rex.Content("robots.txt", startTime, bytes.NewReader(data))
+		case "/-/catalog":
+			return s.catalog(ctx)
+		case "/-/link":
+			return s.linkPackage(ctx)
+		case "/-/resolve":
+			return s.bulkResolve(ctx)
+		case "/-/build":
+			return s.prebuild(ctx, queue)
+		case "/-/version-history":
+			return s.versionHistory(ctx)
+		case "/-/targets":
+			return listTargets(ctx)
+		case "/-/resolve-version":
+			return s.resolveVersion(ctx)
+		case "/-/pubkey":
+			return s.publicKey(ctx)
+		case "/-/feed":
+			return s.readFeed(ctx)
+		case "/-/telemetry":
+			return s.usageTelemetry(ctx)
+		case "/-/unblock":
+			return s.unblock(ctx)
+		case "/-/purge":
+			return s.purge(ctx)
+		case "/-/admin/storage":
+			return s.storageUsageReport(ctx)
+		case "/-/status":
+			return status(ctx, queue, startTime)
+		case "/-/metrics":
+			return metrics(ctx, queue, startTime)
+		case "/-/dashboard":
+			return s.dashboard(ctx, queue, startTime, false)
+		case "/-/dashboard.json":
+			return s.dashboard(ctx, queue, startTime, true)
 		case "/_error.js":
 			switch ctx.Form.Value("type") {
 			case "resolve":
@@ -69,15 +121,76 @@ func query() rex.Handle {
 			}
 		}
 
+		if strings.HasPrefix(pathname, purgeJobPrefix) {
+			return s.purgeJobStatus(ctx, pathname)
+		}
+		if strings.HasPrefix(pathname, buildStatusPrefix) {
+			return s.buildStatus(ctx, queue, pathname)
+		}
+		if strings.HasPrefix(pathname, buildLogPrefix) {
+			return streamBuildLog(ctx, pathname)
+		}
+		if strings.HasPrefix(pathname, typesArchivePrefix) {
+			return s.typesArchive(ctx, pathname)
+		}
+
+		if to, status, ok := s.config.aliases.Match(pathname); ok {
+			if ctx.R.URL.RawQuery != "" {
+				to += "?" + ctx.R.URL.RawQuery
+			}
+			if status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect {
+				ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", refreshDuration))
+			}
+			return rex.Redirect(to, status)
+		}
+
+		// reject the paths of crawlers and scanners early
+		if isBotPath(pathname) {
+			return rex.Err(404)
+		}
+
+		// the dev routes always rebuild the modules, the builds are stored in the scratch area
+		// and never cached by the clients
+		scratch := false
+		if s.config.devRoutes && strings.HasPrefix(pathname, devRoutePrefix+"/") {
+			pathname = strings.TrimPrefix(pathname, devRoutePrefix)
+			scratch = true
+		}
+		buildsDir := filepath.Join(s.config.storageDir, "builds")
+		buildsCacheControl := "public, max-age=31536000, immutable"
+		if scratch {
+			buildsDir = s.scratchBuildsDir()
+			buildsCacheControl = "private, no-store"
+		}
+
 		// serve embed files
 		if strings.HasPrefix(pathname, "/embed/assets/") || strings.HasPrefix(pathname, "/embed/test/") {
-			data, err := embedFS.ReadFile(pathname[1:])
+			data, err := s.embedFS.ReadFile(pathname[1:])
 			if err != nil {
 				return err
 			}
 			return rex.Content(pathname, startTime, bytes.NewReader(data))
 		}
 
+		// the stable paths are served by the current build version, the `Content-Location`
+		// header reports the versioned path
+		var stable bool
+		pathname, stable = stablePath(pathname)
+		typesCacheControl := "public, max-age=31536000, immutable"
+		if stable {
+			if !scratch {
+				buildsCacheControl = stableCacheControl
+			}
+			typesCacheControl = stableCacheControl
+			if scratch {
+				ctx.SetHeader("Content-Location", devRoutePrefix+pathname)
+			} else {
+				ctx.SetHeader("Content-Location", pathname)
+			}
+		}
+
 		hasBuildVerPrefix := strings.HasPrefix(pathname, fmt.Sprintf("/v%d/", VERSION))
 		prevBuildVer := ""
 		if hasBuildVerPrefix {
@@ -89,7 +202,35 @@ func query() rex.Handle {
 			prevBuildVer = a[1]
 		}
 
+		// the assets referenced by `new URL("./asset", import.meta.url)`
+		if hasBuildVerPrefix && strings.Contains(pathname, "/_assets/") {
+			fp := filepath.Join(buildsDir, fmt.Sprintf("v%d", VERSION), pathname)
+			if prevBuildVer != "" {
+				fp = filepath.Join(s.config.storageDir, "builds", prevBuildVer, pathname)
+			}
+			if fileExists(fp) {
+				if sig, ok := readArtifactSignature(fp); ok {
+					ctx.SetHeader("X-Esm-Signature", sig.Signature)
+					ctx.SetHeader("X-Esm-Signature-Key", sig.KeyID)
+				}
+				// `WebAssembly.compileStreaming` requires the `application/wasm` type
+				if strings.HasSuffix(fp, ".wasm") {
+					ctx.SetHeader("Content-Type", "application/wasm")
+				}
+				ctx.SetHeader("Cache-Control", buildsCacheControl)
+				return rex.File(fp)
+			}
+			return rex.Err(404)
+		}
+
+		// the `?raw` query serves the files of the packages verbatim
+		if !hasBuildVerPrefix && !ctx.Form.IsNil("raw") {
+			return s.rawPackageFile(ctx, pathname)
+		}
+
 		var storageType string
+		// the target of the package css path like `/react-datepicker@4.1.1/es2020/react-datepicker.css`
+		var cssTarget, cssPath string
 		switch path.Ext(pathname) {
 		case ".js":
 			if hasBuildVerPrefix {
@@ -104,47 +245,73 @@ func query() rex.Handle {
 		case ".css":
 			if hasBuildVerPrefix {
 				storageType = "builds"
+			} else if pkgPath, target, ok := splitBuildCSSPath(pathname); ok {
+				cssPath = pathname
+				pathname = pkgPath
+				cssTarget = target
 			} else if len(strings.Split(pathname, "/")) > 2 {
 				storageType = "raw"
 			}
+		case ".txt":
+			if hasBuildVerPrefix && strings.HasSuffix(pathname, ".LEGAL.txt") {
+				storageType = "builds"
+			}
+		case ".map":
+			if hasBuildVerPrefix && strings.HasSuffix(pathname, ".js.map") {
+				storageType = "builds"
+			}
+		case ".sig":
+			if hasBuildVerPrefix {
+				storageType = "builds"
+			}
 		case ".json", ".jsx", ".tsx", ".less", ".sass", ".scss", ".stylus", ".styl", ".wasm", ".xml", ".yaml", ".svg":
 			if len(strings.Split(pathname, "/")) > 2 {
 				storageType = "raw"
 			}
 		}
 		if storageType == "raw" {
-			m, err := parsePkg(pathname)
+			m, err := s.parsePkg(pathname)
 			if err != nil {
 				return throwErrorJS(ctx, err)
 			}
 			if m.submodule != "" {
+				// the `?module` query of the wasm files returns the loader module of the raw file
+				if strings.HasSuffix(pathname, ".wasm") && !ctx.Form.IsNil("module") {
+					ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+					if regVersionPath.MatchString(pathname) {
+						ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+					} else {
+						ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", refreshDuration))
+					}
+					return wasmLoader("/" + escapePath(m.String()))
+				}
 				shouldRedirect := !regVersionPath.MatchString(pathname)
 				hostname := ctx.R.Host
 				proto := "http"
 				if ctx.R.TLS != nil {
 					proto = "https"
 				}
-				if hostname == config.domain {
-					if config.cdnDomain != "" {
+				if hostname == s.config.domain {
+					if s.config.cdnDomain != "" {
 						shouldRedirect = true
-						hostname = config.cdnDomain
+						hostname = s.config.cdnDomain
 						proto = "https"
 					}
-					if config.cdnDomainChina != "" {
+					if s.config.cdnDomainChina != "" {
 						var record Record
-						err = mmdbr.Lookup(net.ParseIP(ctx.RemoteIP()), &record)
+						err = s.mmdbr.Lookup(net.ParseIP(ctx.RemoteIP()), &record)
 						if err == nil && record.Country.ISOCode == "CN" {
 							shouldRedirect = true
-							hostname = config.cdnDomainChina
+							hostname = s.config.cdnDomainChina
 							proto = "https"
 						}
 					}
 				}
 				if shouldRedirect {
-					url := fmt.Sprintf("%s://%s/%s", proto, hostname, m.String())
+					url := fmt.Sprintf("%s://%s/%s", proto, hostname, escapePath(m.String()))
 					return rex.Redirect(url, http.StatusTemporaryRedirect)
 				}
-				cacheFile := path.Join(config.storageDir, "raw", m.String())
+				cacheFile := filepath.Join(s.config.storageDir, "raw", m.String())
 				if fileExists(cacheFile) {
 					if strings.HasSuffix(pathname, ".ts") {
 						ctx.SetHeader("Content-Type", "application/typescript")
@@ -153,10 +320,10 @@ func query() rex.Handle {
 					return rex.File(cacheFile)
 				}
 				unpkgDomain := "unpkg.com"
-				if config.unpkgDomain != "" {
-					unpkgDomain = config.unpkgDomain
+				if s.config.unpkgDomain != "" {
+					unpkgDomain = s.config.unpkgDomain
 				}
-				resp, err := httpClient.Get(fmt.Sprintf("https://%s/%s", unpkgDomain, m.String()))
+				resp, err := s.httpClient.Get(fmt.Sprintf("https://%s/%s", unpkgDomain, escapePath(m.String())))
 				if err != nil {
 					return err
 				}
@@ -187,64 +354,140 @@ func query() rex.Handle {
 			storageType = ""
 		}
 		if storageType != "" {
-			var filepath string
+			var fp string
 			if hasBuildVerPrefix && (storageType == "builds" || storageType == "types") {
 				if prevBuildVer != "" {
-					filepath = path.Join(config.storageDir, storageType, prevBuildVer, pathname)
+					fp = filepath.Join(s.config.storageDir, storageType, prevBuildVer, pathname)
+				} else if storageType == "builds" {
+					fp = filepath.Join(buildsDir, fmt.Sprintf("v%d", VERSION), pathname)
 				} else {
-					filepath = path.Join(config.storageDir, storageType, fmt.Sprintf("v%d", VERSION), pathname)
+					fp = filepath.Join(s.config.storageDir, storageType, fmt.Sprintf("v%d", VERSION), pathname)
 				}
 			} else {
-				filepath = path.Join(config.storageDir, storageType, pathname)
+				fp = filepath.Join(s.config.storageDir, storageType, pathname)
 			}
-			if fileExists(filepath) {
+			if fileExists(fp) {
+				// the mirrors fetch the builds by the `raw` query to verify the signatures
+				withFooter := storageType == "builds" && s.config.buildFooter != "" && strings.HasSuffix(pathname, ".js") && ctx.Form.IsNil("raw")
 				if storageType == "types" {
+					data, err := ioutil.ReadFile(fp)
+					if err != nil {
+						return err
+					}
+					target := "es2015"
+					if strings.HasPrefix(ctx.R.UserAgent(), "Deno/") || ctx.Form.Value("target") == "deno" {
+						target = "deno"
+					}
 					ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
+					ctx.SetHeader("Cache-Control", typesCacheControl)
+					ctx.SetHeader("Vary", "User-Agent")
+					return s.rewriteLibReferences(data, target)
 				}
-				ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
-				return rex.File(filepath)
+				if storageType == "builds" && prevBuildVer == "" && !scratch && strings.HasSuffix(pathname, ".js") {
+					buildAccess.Touch(fmt.Sprintf("v%d%s", VERSION, strings.TrimSuffix(pathname, ".js")))
+				}
+				if strings.HasSuffix(pathname, ".js.map") || strings.HasSuffix(pathname, ".sig") {
+					ctx.SetHeader("Content-Type", "application/json; charset=utf-8")
+				} else if sig, ok := readArtifactSignature(fp); ok && !withFooter {
+					ctx.SetHeader("X-Esm-Signature", sig.Signature)
+					ctx.SetHeader("X-Esm-Signature-Key", sig.KeyID)
+				}
+				if storageType == "builds" && prevBuildVer == "" {
+					if dts, ok := s.polyfillTypes(pathname); ok {
+						if s.config.cdnDomain != "" {
+							dts = fmt.Sprintf("https://%s%s", s.config.cdnDomain, dts)
+						}
+						ctx.SetHeader("X-TypeScript-Types", dts)
+					}
+				}
+				if storageType == "builds" {
+					ctx.SetHeader("Cache-Control", buildsCacheControl)
+				} else {
+					ctx.SetHeader("Cache-Control", typesCacheControl)
+				}
+				if withFooter {
+					data, err := s.appendBuildFooter(fp, pathname)
+					if err != nil {
+						return err
+					}
+					ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
+					return data
+				}
+				return rex.File(fp)
+			}
+			// synthesize the types for the packages without types
+			if storageType == "types" {
+				m, err := s.parsePkg(pathname)
+				if err != nil {
+					return rex.Err(404)
+				}
+				hasTypes, err := s.packageHasTypes(m.name, m.version)
+				if err != nil {
+					return err
+				}
+				if hasTypes {
+					return rex.Err(404)
+				}
+				ctx.SetHeader("Content-Type", "application/typescript; charset=utf-8")
+				ctx.SetHeader("Cache-Control", fmt.Sprintf("private, max-age=%d", refreshDuration))
+				ctx.SetHeader("X-Esm-Synthesized-Types", "true")
+				return synthesizeDTS(*m)
+			}
+			// the artifact is not signed
+			if strings.HasSuffix(pathname, ".sig") {
+				return rex.Err(404)
 			}
 		}
 
-		target := strings.ToLower(strings.TrimSpace(ctx.Form.Value("target")))
-		if _, ok := targets[target]; !ok {
-			ua := ctx.R.UserAgent()
-			if strings.HasPrefix(ua, "Deno/") {
-				target = "deno"
-			} else {
-				target = "es2015"
-				name, version := user_agent.New(ua).Browser()
-				if engine, ok := engines[strings.ToLower(name)]; ok {
-					a := strings.Split(version, ".")
-					if len(a) > 3 {
-						version = strings.Join(a[:3], ".")
-					}
-					unspportEngineFeatures := validateEngineFeatures(api.Engine{
-						Name:    engine,
-						Version: version,
-					})
-					for _, t := range []string{
-						"es2020",
-						"es2019",
-						"es2018",
-						"es2017",
-						"es2016",
-					} {
-						unspportESMAFeatures := validateESMAFeatures(targets[t])
-						if unspportEngineFeatures <= unspportESMAFeatures {
-							target = t
-							break
-						}
-					}
+		// redirect the equivalent URLs to the canonical one, like `/react/?target=ES2020&dev=`
+		// -> `/react?dev&target=es2020`, to avoid the duplicate builds and cache entries
+		if ctx.R.Method == "GET" || ctx.R.Method == "HEAD" {
+			query := s.canonicalQuery(ctx.R.URL.Query())
+			if query != ctx.R.URL.RawQuery || ctx.R.URL.Path != ctx.Path.String() {
+				to := escapePath(ctx.Path.String())
+				if query != "" {
+					to += "?" + query
 				}
+				ctx.SetHeader("Cache-Control", "public, max-age=86400")
+				return rex.Redirect(to, http.StatusMovedPermanently)
 			}
 		}
 
+		target := strings.ToLower(strings.TrimSpace(ctx.Form.Value("target")))
+		if cssTarget != "" {
+			target = cssTarget
+		}
+		if _, ok := targets[target]; !ok && target != "" {
+			return throwErrorJS(ctx, fmt.Errorf("unsupported target '%s', available targets: %s", target, strings.Join(targetNames(), ", ")))
+		}
+		if target == "" {
+			// the build path contains the target, so the caches of the detected targets
+			// don't mix up
+			target = detectTarget(ctx.R.UserAgent())
+			ctx.SetHeader("Vary", "User-Agent")
+		}
+		ctx.SetHeader("X-Esm-Target", target)
+
+		alias, err := parseImportAlias(ctx.Form.Value("alias"))
+		if err != nil {
+			return throwErrorJS(ctx, err)
+		}
+
+		define, err := parseQueryDefines(ctx.Form.Value("env"), ctx.Form.Value("define"))
+		if err != nil {
+			return throwErrorJS(ctx, err)
+		}
+
+		drop, err := parseDropQuery(ctx.Form.Value("drop"))
+		if err != nil {
+			return throwErrorJS(ctx, err)
+		}
+
 		deps := pkgSlice{}
 		for _, p := range strings.Split(ctx.Form.Value("deps"), ",") {
 			p = strings.TrimSpace(p)
 			if p != "" {
-				m, err := parsePkg(p)
+				m, err := s.parsePkg(p)
 				if err != nil {
 					if strings.HasSuffix(err.Error(), "not found") {
 						continue
@@ -257,11 +500,66 @@ func query() rex.Handle {
 			}
 		}
 
-		isPkgCSS := !ctx.Form.IsNil("css")
+		external, err := parseExternals(ctx.Form.Value("external"))
+		if err != nil {
+			return throwErrorJS(ctx, err)
+		}
+
+		cjsExports := strings.ToLower(strings.TrimSpace(ctx.Form.Value("cjs-exports")))
+		if cjsExports == "" {
+			cjsExports = "auto"
+		} else if !cjsExportsModes[cjsExports] {
+			return throwErrorJS(ctx, fmt.Errorf("invalid cjs-exports mode '%s', available modes: strict, auto, all", cjsExports))
+		} else if cjsExports == "all" && !s.config.evalCJSExports {
+			return throwErrorJS(ctx, fmt.Errorf("the cjs-exports mode 'all' is disabled by the server"))
+		}
+
+		// the default mode of the server is not in the build ID
+		legalComments := strings.ToLower(strings.TrimSpace(ctx.Form.Value("legal-comments")))
+		if legalComments != "" && !legalCommentsModes[legalComments] {
+			return throwErrorJS(ctx, fmt.Errorf("invalid legal-comments mode '%s', available modes: eof, none, linked", legalComments))
+		} else if legalComments == s.config.legalComments {
+			legalComments = ""
+		}
+
+		exports := newStringSet()
+		for _, name := range strings.Split(ctx.Form.Value("exports"), ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				if name != "default" && !regJSIdentifier.MatchString(name) {
+					return throwErrorJS(ctx, fmt.Errorf("invalid export name '%s'", name))
+				}
+				exports.Add(name)
+			}
+		}
+
+		isPkgCSS := cssTarget != "" || !ctx.Form.IsNil("css")
+		isMeta := !ctx.Form.IsNil("meta")
+		isSplit := !ctx.Form.IsNil("split")
+		isKeepNames := !ctx.Form.IsNil("keep-names")
+		isBundle := !ctx.Form.IsNil("bundle")
+		isStandalone := !ctx.Form.IsNil("standalone")
+		isWorker := !ctx.Form.IsNil("worker")
+		// serve the minimum viable target instead of the error of the unsupported syntax
+		targetFallback := s.config.targetFallback
+		if !ctx.Form.IsNil("fallback") {
+			v := ctx.Form.Value("fallback")
+			targetFallback = v != "0" && v != "false"
+		}
+		sourcemap := ""
+		if !ctx.Form.IsNil("sourcemap") {
+			sourcemap = "external"
+			if ctx.Form.Value("sourcemap") == "inline" {
+				sourcemap = "inline"
+			}
+		}
 		isDev := !ctx.Form.IsNil("dev")
 		noCheck := !ctx.Form.IsNil("no-check")
+		// respond `202 Accepted` instead of waiting for the build, the scratch builds are
+		// always built synchronously
+		isAsync := !ctx.Form.IsNil("async") && !scratch
 
-		reqPkg, err := parsePkg(pathname)
+		reqPkg, err := s.parsePkg(pathname)
 		if err != nil {
 			if strings.HasSuffix(err.Error(), "not found") {
 				return throwErrorJS(ctx, err)
@@ -269,9 +567,44 @@ func query() rex.Handle {
 			return throwErrorJS(ctx, err)
 		}
 
+		redirectToExactVersion := func(rest string, maxAge int64) interface{} {
+			to := fmt.Sprintf("/%s@%s%s", reqPkg.name, reqPkg.version, escapePath(rest))
+			if scratch {
+				to = devRoutePrefix + to
+			}
+			if ctx.R.URL.RawQuery != "" {
+				to += "?" + ctx.R.URL.RawQuery
+			}
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+			return rex.Redirect(to, http.StatusFound)
+		}
+		// the package css path keeps the target and the file name in the redirects
+		versionPath := pathname
+		if cssTarget != "" {
+			versionPath = cssPath
+		}
+		// redirect the weak versions and the ranges like `react@^16.8` to the current exact version
+		if s.config.redirectWeakVersions && !hasBuildVerPrefix {
+			if _, rest, ok := splitWeakVersionPath(versionPath, reqPkg.name); ok {
+				return redirectToExactVersion(rest, s.versionRefreshInterval())
+			}
+		}
+		// redirect the dist-tags like `react@next` to the version that the tag points to now
+		if !hasBuildVerPrefix {
+			if _, rest, ok := splitDistTagPath(versionPath, reqPkg.name); ok {
+				return redirectToExactVersion(rest, s.distTagRefreshInterval())
+			}
+		}
+
 		isBare := false
 		if hasBuildVerPrefix && endsWith(pathname, ".js") {
 			a := strings.Split(reqPkg.submodule, "/")
+			if len(a) > 1 && strings.HasPrefix(a[0], "alias=") {
+				if m, err := parseImportAlias(strings.ReplaceAll(strings.TrimPrefix(a[0], "alias="), "_", "/")); err == nil {
+					alias = m
+				}
+				a = a[1:]
+			}
 			if len(a) > 1 {
 				if strings.HasPrefix(a[0], "deps=") {
 					for _, p := range strings.Split(strings.TrimPrefix(a[0], "deps="), ",") {
@@ -281,7 +614,7 @@ func query() rex.Handle {
 								scope, name := utils.SplitByFirstByte(p, '_')
 								p = scope + "/" + name
 							}
-							m, err := parsePkg(p)
+							m, err := s.parsePkg(p)
 							if err != nil {
 								if strings.HasSuffix(err.Error(), "not found") {
 									continue
@@ -296,8 +629,70 @@ func query() rex.Handle {
 					a = a[1:]
 				}
 			}
+			if len(a) > 1 && strings.HasPrefix(a[0], "external=") {
+				external = parseExternalSegment(a[0])
+				a = a[1:]
+			}
+			if len(a) > 1 && strings.HasPrefix(a[0], "cjs-exports=") {
+				if mode := strings.TrimPrefix(a[0], "cjs-exports="); cjsExportsModes[mode] && (mode != "all" || s.config.evalCJSExports) {
+					cjsExports = mode
+				}
+				a = a[1:]
+			}
+			if len(a) > 1 && strings.HasPrefix(a[0], "exports=") {
+				for _, name := range strings.Split(strings.TrimPrefix(a[0], "exports="), ",") {
+					if name == "default" || regJSIdentifier.MatchString(name) {
+						exports.Add(name)
+					}
+				}
+				a = a[1:]
+			}
+			if len(a) > 1 && strings.HasPrefix(a[0], "define=") {
+				hash := strings.TrimPrefix(a[0], "define=")
+				m, ok := s.loadDefines(hash)
+				if !ok {
+					return rex.Status(404, fmt.Sprintf("unknown defines '%s'", hash))
+				}
+				define = m
+				a = a[1:]
+			}
+			if len(a) > 1 && strings.HasPrefix(a[0], "drop=") {
+				drop, err = parseDropQuery(strings.TrimPrefix(a[0], "drop="))
+				if err != nil {
+					return rex.Status(404, err.Error())
+				}
+				a = a[1:]
+			}
+			if len(a) > 1 && a[0] == "bundle" {
+				isBundle = true
+				a = a[1:]
+			} else if len(a) > 1 && a[0] == "standalone" {
+				isStandalone = true
+				a = a[1:]
+			}
+			if len(a) > 1 && a[0] == "split" {
+				isSplit = true
+				a = a[1:]
+			}
+			if len(a) > 1 && a[0] == "keep-names" {
+				isKeepNames = true
+				a = a[1:]
+			}
+			if len(a) > 1 && strings.HasPrefix(a[0], "legal-comments=") {
+				if mode := strings.TrimPrefix(a[0], "legal-comments="); legalCommentsModes[mode] {
+					legalComments = mode
+				}
+				a = a[1:]
+			}
+			if len(a) > 1 && (a[0] == "sourcemap" || a[0] == "sourcemap=inline") {
+				sourcemap = "external"
+				if a[0] == "sourcemap=inline" {
+					sourcemap = "inline"
+				}
+				a = a[1:]
+			}
 			if len(a) > 1 {
-				if _, ok := targets[a[0]]; ok || a[0] == "esnext" {
+				if _, ok := targets[a[0]]; ok {
 					submodule := strings.TrimSuffix(strings.Join(a[1:], "/"), ".js")
 					if endsWith(submodule, ".development") {
 						submodule = strings.TrimSuffix(submodule, ".development")
@@ -314,34 +709,167 @@ func query() rex.Handle {
 			}
 		}
 
+		// the development builds keep the `console` and `debugger` statements
+		if isDev {
+			drop = nil
+		}
+
 		// todo: wait 1 second then down to previous build version
 		task := &buildTask{
-			pkg:    *reqPkg,
-			deps:   deps,
-			target: target,
-			isDev:  isDev,
+			Server:        s,
+			pkg:           *reqPkg,
+			alias:         alias,
+			deps:          deps,
+			external:      external,
+			cjsExports:    cjsExports,
+			exports:       exports.Values(),
+			define:        define,
+			drop:          drop,
+			bundle:        isBundle && !isStandalone,
+			standalone:    isStandalone,
+			split:         isSplit,
+			keepNames:     isKeepNames,
+			legalComments: legalComments,
+			sourcemap:     sourcemap,
+			target:        target,
+			isDev:         isDev,
+			scratch:       scratch,
+		}
+
+		targetFallback = targetFallback && !isBare
+		if targetFallback {
+			if minTarget := s.findMinTarget(task.pkg); isLowerTarget(task.target, minTarget) {
+				task.target = minTarget
+				ctx.SetHeader("X-Esm-Target-Fallback", minTarget)
+			}
+		}
+		// the build paths have the external packages already
+		if !isBare {
+			task.applyDefaultExternals()
+		}
+
+		// fail fast for the package versions that are known to be broken
+		if issue, ok := s.lookupKnownIssue(task.pkg.name, task.pkg.version); ok {
+			return throwErrorJS(ctx, issue)
 		}
 
-		esm, pkgCSS, ok := findESM(task.ID())
+		var esm *ESMeta
+		var pkgCSS, ok bool
+		if !scratch {
+			esm, pkgCSS, ok = s.findESM(task.ID())
+			if ok {
+				atomic.AddUint64(&cacheHits, 1)
+			} else {
+				atomic.AddUint64(&cacheMisses, 1)
+			}
+		}
 		if !ok {
+			// shed the new builds fast when the queue is overloaded, the cache hits are not affected
+			if queue.Shed(task.queueKey(), s.config.loadShedDepth) {
+				return buildQueueOverloaded(ctx, errBuildQueueFull)
+			}
+			client, quota := s.buildClient(ctx)
+			if !coldBuildQuota.TakeBuild(queue, task, client, quota, time.Now()) {
+				return throwErrorJS(ctx, fmt.Errorf("Build quota exceeded: the daily quota (%d) of new builds is used up, please try again tomorrow(UTC) or use the builds that are already cached", quota))
+			}
+			if isAsync {
+				asyncBuilds.Start(queue, task)
+				return asyncBuildAccepted(ctx, task.ID())
+			}
 			output := <-queue.Add(task)
+			if e, ok := output.err.(*targetError); ok && targetFallback && e.minTarget != "" {
+				fallbackTask := *task
+				fallbackTask.id = ""
+				fallbackTask.target = e.minTarget
+				fallbackTask.external = external
+				fallbackTask.applyDefaultExternals()
+				task = &fallbackTask
+				ctx.SetHeader("X-Esm-Target-Fallback", e.minTarget)
+				output = <-queue.Add(task)
+			}
 			if output.err != nil {
+				if e, ok := output.err.(*targetError); ok && isMeta {
+					return rex.Status(422, map[string]interface{}{
+						"id":        task.ID(),
+						"error":     e.Error(),
+						"minTarget": e.minTarget,
+					})
+				}
+				ctx.SetHeader("X-Esm-Build-Log", buildLogPrefix+task.ID())
+				// the reproducible failure is served without rebuilding until it expires
+				if f, ok := output.err.(*buildFailure); ok {
+					ret := throwErrorJS(ctx, f)
+					ctx.SetHeader("Retry-After", strconv.Itoa(int(time.Until(f.expires).Seconds())+1))
+					return rex.Status(http.StatusUnprocessableEntity, ret)
+				}
+				if _, ok := output.err.(*buildTimeoutError); ok {
+					return rex.Status(http.StatusGatewayTimeout, throwErrorJS(ctx, output.err))
+				}
+				if output.err == errBuildQueueFull {
+					return buildQueueOverloaded(ctx, output.err)
+				}
 				return throwErrorJS(ctx, output.err)
 			}
 			esm = output.esm
 			pkgCSS = output.pkgCSS
 		} else {
-			log.Debugf("esm %s,%s found", reqPkg, target)
+			s.log.Debugf("esm %s,%s found", reqPkg, target)
+		}
+		if !scratch {
+			buildAccess.Touch(task.ID())
+		}
+		// the builds of the floating versions are pre-built when the versions move
+		if !scratch && !hasBuildVerPrefix && !isExactVersionPath(pathname, reqPkg.name) {
+			version, _ := splitVersionPath(pathname, reqPkg.name)
+			if version == "" {
+				version = "latest"
+			}
+			s.revalidator.Track(reqPkg.name, floatingVersion(version), task)
+		}
+		// the registry serves a different tarball for the version since the build
+		if fingerprint, changed := s.tarballChanged(esm); changed {
+			ctx.SetHeader("X-Esm-Tarball-Changed", fingerprint)
+		}
+
+		if isMeta {
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("private, max-age=%d", refreshDuration))
+			meta := map[string]interface{}{
+				"id":   task.ID(),
+				"meta": esm,
+			}
+			if minTarget := s.findMinTarget(task.pkg); minTarget != "" {
+				meta["minTarget"] = minTarget
+			}
+			return meta
 		}
 
+		// the package css path serves the css of the build directly
+		if cssTarget != "" {
+			fp := filepath.Join(buildsDir, task.ID()+".css")
+			if !pkgCSS || !fileExists(fp) {
+				return rex.Status(404, "css not found")
+			}
+			ctx.SetHeader("Content-Type", "text/css; charset=utf-8")
+			if regVersionPath.MatchString(cssPath) {
+				ctx.SetHeader("Cache-Control", stableCacheControl)
+			} else {
+				ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", refreshDuration))
+			}
+			return rex.File(fp)
+		}
+		// the `?css` query of the stylesheet requests redirects to the css of the build, and the
+		// module imports get the module that injects the stylesheet
 		if isPkgCSS {
+			ctx.AddHeader("Vary", "Accept, Sec-Fetch-Dest")
+		}
+		if isPkgCSS && (wantsStylesheet(ctx.R) || !pkgCSS) {
 			if pkgCSS {
 				hostname := ctx.R.Host
 				proto := "http"
 				if ctx.R.TLS != nil {
 					proto = "https"
 				}
-				url := fmt.Sprintf("%s://%s/%s.css", proto, hostname, task.ID())
+				url := fmt.Sprintf("%s://%s%s/%s.css", proto, hostname, task.routePrefix(), escapePath(task.ID()))
 				code := http.StatusTemporaryRedirect
 				if regVersionPath.MatchString(pathname) {
 					code = http.StatusPermanentRedirect
@@ -353,76 +881,117 @@ func query() rex.Handle {
 
 		if isBare {
 			fp := path.Join(
-				config.storageDir,
-				"builds",
+				buildsDir,
 				fmt.Sprintf("v%d", VERSION),
 				pathname,
 			)
 			if fileExists(fp) {
-				ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+				ctx.SetHeader("Cache-Control", buildsCacheControl)
 				return rex.File(fp)
 			}
 			return rex.Err(404)
 		}
 
+		if unknown := unknownExports(esm, task.exports); len(unknown) > 0 {
+			return throwErrorJS(ctx, fmt.Errorf("unknown exports '%s' of %s, check the '?meta' query for the available exports", strings.Join(unknown, ","), reqPkg))
+		}
+
 		buf := bytes.NewBuffer(nil)
 		importPrefix := "/"
 		importSuffix := ".js"
-		if config.cdnDomain != "" {
-			importPrefix = fmt.Sprintf("https://%s/", config.cdnDomain)
+		if s.config.cdnDomain != "" {
+			importPrefix = fmt.Sprintf("https://%s/", s.config.cdnDomain)
 		}
-		if config.cdnDomainChina != "" {
+		if s.config.cdnDomainChina != "" {
 			var record Record
-			err = mmdbr.Lookup(net.ParseIP(ctx.RemoteIP()), &record)
+			err = s.mmdbr.Lookup(net.ParseIP(ctx.RemoteIP()), &record)
 			if err == nil && record.Country.ISOCode == "CN" {
-				importPrefix = fmt.Sprintf("https://%s/", config.cdnDomainChina)
+				importPrefix = fmt.Sprintf("https://%s/", s.config.cdnDomainChina)
 			}
 		}
+		// the scratch builds are only stored in this server
+		if scratch {
+			importPrefix = devRoutePrefix + "/"
+		}
 
-		fmt.Fprintf(buf, `/* esm.sh - %v */%s`, reqPkg, "\n")
-		fmt.Fprintf(buf, `export * from "%s%s%s";%s`, importPrefix, task.ID(), importSuffix, "\n")
-
-		if esm.Module != "" {
-			for _, name := range esm.Exports {
-				if name == "default" {
-					fmt.Fprintf(
-						buf,
-						`export { default } from "%s%s%s";%s`,
-						importPrefix,
-						task.ID(),
-						importSuffix,
-						"\n",
-					)
-					break
+		if isWorker {
+			// the worker imports the build by the absolute URL
+			origin := ""
+			if strings.HasPrefix(importPrefix, "/") {
+				proto := "http"
+				if ctx.R.TLS != nil {
+					proto = "https"
 				}
+				origin = fmt.Sprintf("%s://%s", proto, ctx.R.Host)
 			}
+			buf.WriteString(workerFactory(*reqPkg, fmt.Sprintf("%s%s%s%s", origin, importPrefix, escapePath(task.ID()), importSuffix)))
 		} else {
+			fmt.Fprintf(buf, `/* esm.sh - %v */%s`, reqPkg, "\n")
+			if isPkgCSS {
+				buf.WriteString(cssLinkInjector(fmt.Sprintf("%s%s.css", importPrefix, escapePath(task.ID()))))
+			}
+			fmt.Fprintf(buf, `export * from "%s%s%s";%s`, importPrefix, escapePath(task.ID()), importSuffix, "\n")
+		}
+
+		hasDefaultExport := esm.Module == "" || includes(esm.Exports, "default")
+		if len(task.exports) > 0 && !includes(task.exports, "default") {
+			hasDefaultExport = false
+		}
+		if hasDefaultExport && !isWorker {
 			fmt.Fprintf(
 				buf,
 				`export { default } from "%s%s%s";%s`,
 				importPrefix,
-				task.ID(),
+				escapePath(task.ID()),
 				importSuffix,
 				"\n",
 			)
 		}
-		if esm.Dts != "" && !noCheck {
+		if s.config.usageReport && !ctx.Form.IsNil("report") {
+			buf.WriteString(usageBeacon(reqPkg.String(), task.exports))
+		}
+		if esm.Dts != "" && !noCheck && !isWorker {
 			value := fmt.Sprintf(
 				"%s%s",
 				importPrefix,
-				strings.TrimPrefix(
+				escapePath(strings.TrimPrefix(
 					path.Join("/", fmt.Sprintf("v%d", VERSION), esm.Dts),
 					"/",
-				),
+				)),
 			)
 			ctx.SetHeader("X-TypeScript-Types", value)
+			if esm.TypesSource != "" {
+				ctx.SetHeader("X-Esm-Types-Source", esm.TypesSource)
+			}
+		}
+		if r := esm.Treeshake; r != nil {
+			ctx.SetHeader("X-Esm-Treeshake", fmt.Sprintf("size=%d, full=%d, saving=%.1f%%, dropped=%d", r.Size, r.FullSize, r.Saving(), len(r.Dropped)))
+		}
+		if len(esm.TopLevelSideEffects) > 0 {
+			ctx.SetHeader("X-Esm-Side-Effects", strings.Join(esm.TopLevelSideEffects, ","))
+		}
+		if scratch {
+			ctx.SetHeader("Cache-Control", buildsCacheControl)
+		} else if isExactVersionPath(pathname, reqPkg.name) && ctx.Form.IsNil("deps") && ctx.Form.IsNil("alias") {
+			// the module of an exact version never changes unless the deps or aliases float,
+			// the target depends on the `User-Agent`, so it's cached by the browser only
+			ctx.SetHeader("Cache-Control", "private, max-age=31536000, immutable")
+		} else {
+			ctx.SetHeader("Cache-Control", fmt.Sprintf("private, max-age=%d", refreshDuration))
 		}
-		ctx.SetHeader("Cache-Control", fmt.Sprintf("private, max-age=%d", refreshDuration))
 		ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
 		return buf
 	}
 }
 
+// buildQueueOverloaded responds 503 with the `Retry-After` header for the build requests that
+// are rejected by the overloaded queue.
+func buildQueueOverloaded(ctx *rex.Context, err error) interface{} {
+	ret := throwErrorJS(ctx, err)
+	ctx.SetHeader("Retry-After", strconv.Itoa(loadShedRetryAfter))
+	return rex.Status(http.StatusServiceUnavailable, ret)
+}
+
 func throwErrorJS(ctx *rex.Context, err error) interface{} {
 	buf := bytes.NewBuffer(nil)
 	fmt.Fprintf(buf, "/* esm.sh - error */\n")
@@ -435,5 +1004,5 @@ func throwErrorJS(ctx *rex.Context, err error) interface{} {
 	fmt.Fprintf(buf, "export default null;\n")
 	ctx.SetHeader("Cache-Control", "private, no-store, no-cache, must-revalidate")
 	ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8")
-	return buf
+	return errorModule{buf}
 }
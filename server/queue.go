@@ -2,10 +2,24 @@ package server
 
 import (
 	"container/list"
+	"errors"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var errBuildQueueFull = errors.New("the build queue is full, please try again later")
+
+// the seconds that the clients should wait before retrying the shed requests
+const loadShedRetryAfter = 10
+
+// the number of the build requests that are rejected by the load shedding
+var shedBuilds uint64
+
+// the number of the build requests that wait on a queued build instead of building again
+var coalescedBuilds uint64
+
 // A Queue for esbuild
 type buildQueue struct {
 	lock         sync.Mutex
@@ -13,6 +27,11 @@ type buildQueue struct {
 	current      []*task
 	tasks        map[string]*task
 	maxProcesses int
+	// the max number of the waiting tasks, 0 means unlimited
+	maxQueued int
+	// returns true to pause starting new tasks
+	throttle  func() bool
+	throttled bool
 }
 
 type buildOutput struct {
@@ -30,11 +49,15 @@ type task struct {
 	consumers  []chan *buildOutput
 }
 
-func newBuildQueue(maxProcesses int) *buildQueue {
+func newBuildQueue(maxProcesses int, maxQueued int) *buildQueue {
+	if maxProcesses < 1 {
+		maxProcesses = 1
+	}
 	q := &buildQueue{
 		queue:        list.New(),
 		tasks:        map[string]*task{},
 		maxProcesses: maxProcesses,
+		maxQueued:    maxQueued,
 	}
 	return q
 }
@@ -47,15 +70,98 @@ func (q *buildQueue) Len() int {
 	return q.queue.Len()
 }
 
-// Add adds a new build task.
+// Stats returns the number of the waiting and processing tasks.
+func (q *buildQueue) Stats() (queued int, processing int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.queue.Len() - len(q.current), len(q.current)
+}
+
+// Throttled reports whether the queue pauses starting new tasks.
+func (q *buildQueue) Throttled() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.throttled
+}
+
+// An activeBuild is the build task in process.
+type activeBuild struct {
+	ID      string `json:"id"`
+	Target  string `json:"target"`
+	Waiters int    `json:"waiters"`
+	// the seconds since the build started
+	Elapsed float64 `json:"elapsed"`
+}
+
+// Active returns the builds in process, the longest running first.
+func (q *buildQueue) Active(now time.Time) []activeBuild {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	builds := make([]activeBuild, 0, len(q.current))
+	for _, t := range q.current {
+		builds = append(builds, activeBuild{
+			ID:      t.ID(),
+			Target:  t.target,
+			Waiters: len(t.consumers),
+			Elapsed: now.Sub(t.startTime).Seconds(),
+		})
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].Elapsed > builds[j].Elapsed
+	})
+	return builds
+}
+
+// State reports whether the task of the key is queued and whether it's in process.
+func (q *buildQueue) State(key string) (inProcess bool, queued bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	t, ok := q.tasks[key]
+	if !ok {
+		return false, false
+	}
+	return t.inProcess, true
+}
+
+// Shed reports whether the new build of the key should be rejected since the number of the
+// waiting tasks reaches the depth, the requests of the queued builds are never rejected as
+// they don't add load. 0 depth means never.
+func (q *buildQueue) Shed(key string, depth int) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if depth <= 0 {
+		return false
+	}
+	if _, ok := q.tasks[key]; ok {
+		return false
+	}
+	if q.queue.Len()-len(q.current) < depth {
+		return false
+	}
+	atomic.AddUint64(&shedBuilds, 1)
+	return true
+}
+
+// Add adds a new build task, the requests of a queued build share its output.
 func (q *buildQueue) Add(build *buildTask) chan *buildOutput {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
 	c := make(chan *buildOutput, 1)
-	t, ok := q.tasks[build.ID()]
+	t, ok := q.tasks[build.queueKey()]
 	if ok {
 		t.consumers = append(t.consumers, c)
+		atomic.AddUint64(&coalescedBuilds, 1)
+		return c
+	}
+
+	if q.maxQueued > 0 && q.queue.Len()-len(q.current) >= q.maxQueued {
+		c <- &buildOutput{err: errBuildQueueFull}
 		return c
 	}
 
@@ -65,7 +171,7 @@ func (q *buildQueue) Add(build *buildTask) chan *buildOutput {
 		consumers:  []chan *buildOutput{c},
 	}
 	t.el = q.queue.PushBack(t)
-	q.tasks[build.ID()] = t
+	q.tasks[build.queueKey()] = t
 	q.next()
 
 	return c
@@ -83,10 +189,22 @@ func (q *buildQueue) next() {
 		}
 	}
 	if nextTask == nil {
+		q.throttled = false
+		return
+	}
+
+	// always allow one task in process to avoid the starvation
+	if len(q.current) > 0 && q.throttle != nil && q.throttle() {
+		if !q.throttled {
+			q.throttled = true
+			time.AfterFunc(time.Second, q.retry)
+		}
 		return
 	}
+	q.throttled = false
 
 	nextTask.inProcess = true
+	nextTask.startTime = time.Now()
 	q.current = append(q.current, nextTask)
 
 	q.lock.Unlock()
@@ -94,10 +212,19 @@ func (q *buildQueue) next() {
 	q.lock.Lock()
 }
 
+func (q *buildQueue) retry() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.throttled {
+		q.throttled = false
+		q.next()
+	}
+}
+
 func (q *buildQueue) wait(t *task) {
-	t.startTime = time.Now()
-	esm, pkgCSS, err := t.buildESM()
-	log.Debugf(
+	esm, pkgCSS, err := t.build()
+	t.log.Debugf(
 		"queue(%s,%s) done in %s",
 		t.pkg.String(),
 		t.target,
@@ -123,7 +250,7 @@ func (q *buildQueue) wait(t *task) {
 	}
 	q.current = p
 	q.queue.Remove(t.el)
-	delete(q.tasks, t.ID())
+	delete(q.tasks, t.queueKey())
 
 	q.next()
 }
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestBuildQueue(t *testing.T) {
+	q := newBuildQueue(1, 2)
+
+	// occupy the only process
+	p := &task{buildTask: &buildTask{id: "p"}, inProcess: true}
+	p.el = q.queue.PushBack(p)
+	q.current = []*task{p}
+
+	coalesced := atomic.LoadUint64(&coalescedBuilds)
+	q.Add(&buildTask{id: "a"})
+	q.Add(&buildTask{id: "a"})
+	if n := len(q.tasks["a"].consumers); n != 2 {
+		t.Fatalf("the identical builds should be coalesced, got %d consumers", n)
+	}
+	if n := atomic.LoadUint64(&coalescedBuilds) - coalesced; n != 1 {
+		t.Fatalf("the coalesced build should be counted once, got %d", n)
+	}
+	q.Add(&buildTask{id: "b"})
+	select {
+	case output := <-q.Add(&buildTask{id: "c"}):
+		if output.err != errBuildQueueFull {
+			t.Fatalf("unexpected error %v", output.err)
+		}
+	default:
+		t.Fatal("the build should be rejected when the queue is full")
+	}
+	if queued, processing := q.Stats(); queued != 2 || processing != 1 {
+		t.Fatalf("unexpected stats %d/%d", queued, processing)
+	}
+	if _, ok := q.tasks["c"]; ok {
+		t.Fatal("the rejected build should not be queued")
+	}
+}
+
+func TestBuildQueueShed(t *testing.T) {
+	q := newBuildQueue(1, 0)
+
+	// occupy the only process
+	p := &task{buildTask: &buildTask{id: "p"}, inProcess: true}
+	p.el = q.queue.PushBack(p)
+	q.current = []*task{p}
+
+	if q.Shed("a", 0) {
+		t.Fatal("the 0 depth should never shed")
+	}
+	q.Add(&buildTask{id: "a"})
+	shed := shedBuilds
+	if !q.Shed("b", 1) {
+		t.Fatal("the new build should be shed when the queue reaches the depth")
+	}
+	if q.Shed("a", 1) {
+		t.Fatal("the queued build should not be shed")
+	}
+	if q.Shed("b", 2) {
+		t.Fatal("the new build should not be shed under the depth")
+	}
+	if shedBuilds != shed+1 {
+		t.Fatalf("unexpected shed counter %d", shedBuilds-shed)
+	}
+}
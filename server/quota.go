@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+// A buildQuota counts the cold builds of the clients in the current day(UTC).
+type buildQuota struct {
+	lock   sync.Mutex
+	day    string
+	counts map[string]int
+}
+
+var coldBuildQuota = &buildQuota{counts: map[string]int{}}
+
+// Take takes one build from the daily quota of the client, returns false if the quota is
+// used up.
+func (q *buildQuota) Take(client string, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	day := now.UTC().Format("2006-01-02")
+	if day != q.day {
+		q.day = day
+		q.counts = map[string]int{}
+	}
+	if q.counts[client] >= limit {
+		return false
+	}
+	q.counts[client]++
+	return true
+}
+
+// TakeBuild takes one build from the daily quota of the client if the task is a new build, the
+// requests that join the queued build of the task don't use the quota.
+func (q *buildQuota) TakeBuild(queue *buildQueue, task *buildTask, client string, limit int, now time.Time) bool {
+	if _, queued := queue.State(task.queueKey()); queued {
+		return true
+	}
+	return q.Take(client, limit, now)
+}
+
+// parseQuotaTokens parses the `build-quota-tokens` config like `token1=5000,token2=0`, the
+// value is the daily quota of the token, 0 means unlimited.
+func parseQuotaTokens(s string) (tokens map[string]int, err error) {
+	tokens = map[string]int{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		a := strings.SplitN(part, "=", 2)
+		if len(a) != 2 || a[0] == "" {
+			return nil, fmt.Errorf("invalid quota token '%s', should be 'token=quota'", part)
+		}
+		quota, err := strconv.Atoi(a[1])
+		if err != nil || quota < 0 {
+			return nil, fmt.Errorf("invalid quota of the token '%s'", a[0])
+		}
+		tokens[a[0]] = quota
+	}
+	return
+}
+
+// buildClient returns the client ID and the daily quota of the request, the clients with a
+// known token (`Authorization: Bearer TOKEN`) share the quota of the token, others are
+// identified by the IP of `clientIP`.
+func (s *Server) buildClient(ctx *rex.Context) (client string, quota int) {
+	if auth := ctx.R.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		if quota, ok := s.config.buildQuotaTokens[token]; ok {
+			return "token:" + token, quota
+		}
+	}
+	return "ip:" + s.clientIP(ctx.R), s.config.buildQuota
+}
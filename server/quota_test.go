@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+func TestBuildQuota(t *testing.T) {
+	q := &buildQuota{counts: map[string]int{}}
+	now := time.Date(2021, 4, 1, 23, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if !q.Take("ip:1.1.1.1", 3, now) {
+			t.Fatalf("build %d should be in the quota", i+1)
+		}
+	}
+	if q.Take("ip:1.1.1.1", 3, now) {
+		t.Fatal("the quota should be used up")
+	}
+	if !q.Take("ip:2.2.2.2", 3, now) {
+		t.Fatal("the quota should be per client")
+	}
+	if !q.Take("ip:1.1.1.1", 0, now) {
+		t.Fatal("0 means unlimited")
+	}
+	if !q.Take("ip:1.1.1.1", 3, now.Add(2*time.Hour)) {
+		t.Fatal("the quota should be reset in the next day")
+	}
+}
+
+func TestBuildQuotaOfQueuedBuilds(t *testing.T) {
+	q := &buildQuota{counts: map[string]int{}}
+	queue := newBuildQueue(1, 0)
+	now := time.Date(2021, 4, 1, 23, 0, 0, 0, time.UTC)
+
+	// the queued build is in process
+	p := &task{buildTask: &buildTask{id: "a"}, inProcess: true}
+	p.el = queue.queue.PushBack(p)
+	queue.current = []*task{p}
+	queue.tasks["a"] = p
+
+	for i := 0; i < 3; i++ {
+		if !q.TakeBuild(queue, &buildTask{id: "a"}, "ip:1.1.1.1", 1, now) {
+			t.Fatal("joining the queued build should not use the quota")
+		}
+	}
+	if !q.TakeBuild(queue, &buildTask{id: "b"}, "ip:1.1.1.1", 1, now) {
+		t.Fatal("the new build should be in the quota")
+	}
+	if q.TakeBuild(queue, &buildTask{id: "c"}, "ip:1.1.1.1", 1, now) {
+		t.Fatal("the quota should be used up by the new build")
+	}
+}
+
+func TestParseQuotaTokens(t *testing.T) {
+	tokens, err := parseQuotaTokens("abc=5000, def=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens["abc"] != 5000 || tokens["def"] != 0 {
+		t.Fatalf("unexpected tokens %v", tokens)
+	}
+	for _, s := range []string{"abc", "=1", "abc=-1", "abc=x"} {
+		if _, err := parseQuotaTokens(s); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+}
+
+func TestBuildClient(t *testing.T) {
+	s := &Server{config: &Config{buildQuota: 3, buildQuotaTokens: map[string]int{"secret": 100}}}
+
+	req := httptest.NewRequest("GET", "/react", nil)
+	req.RemoteAddr = "1.1.1.1:80"
+	req.Header.Set("X-Forwarded-For", "2.2.2.2")
+	ctx := &rex.Context{R: req}
+	if client, quota := s.buildClient(ctx); client != "ip:1.1.1.1" || quota != 3 {
+		t.Fatalf("the forwarded IP of the untrusted peer should be ignored: %s %d", client, quota)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	if client, quota := s.buildClient(ctx); client != "token:secret" || quota != 100 {
+		t.Fatalf("unexpected client %s %d", client, quota)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// the content types of the package files that are not in the mime table of go, or are
+// registered differently by the systems
+var rawContentTypes = map[string]string{
+	".js":   "application/javascript; charset=utf-8",
+	".mjs":  "application/javascript; charset=utf-8",
+	".cjs":  "application/javascript; charset=utf-8",
+	".jsx":  "text/jsx; charset=utf-8",
+	".ts":   "application/typescript; charset=utf-8",
+	".mts":  "application/typescript; charset=utf-8",
+	".cts":  "application/typescript; charset=utf-8",
+	".tsx":  "text/tsx; charset=utf-8",
+	".json": "application/json; charset=utf-8",
+	".map":  "application/json; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".wasm": "application/wasm",
+}
+
+// rawContentType returns the content type of the package file, the files without the known
+// extension like `LICENSE` are served as plain text.
+func rawContentType(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if t, ok := rawContentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" && ext != "" {
+		return t
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// rawPackageDir returns the directory of the package files extracted from the tarball of the
+// registry, the tarball is extracted once for all the files.
+func (s *Server) rawPackageDir(ctx context.Context, info NpmPackage) (string, error) {
+	dir := filepath.Join(s.config.storageDir, "packages", info.Name+"@"+info.Version)
+	if dirExists(dir) {
+		return dir, nil
+	}
+	tarball, _, err := s.fetchTarball(ctx, info)
+	if err != nil {
+		return "", err
+	}
+	err = ensureDir(filepath.Dir(dir))
+	if err != nil {
+		return "", err
+	}
+	// extract into a temporary directory, so the concurrent requests never see a partial package
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dir), ".extract-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	err = extractTarball(tarball, tmpDir)
+	if err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmpDir, dir); err != nil && !dirExists(dir) {
+		return "", err
+	}
+	return dir, nil
+}
+
+// rawPackageFile serves the file of the package verbatim for the `?raw` query, like
+// `/react@17.0.2/umd/react.production.min.js?raw`, the files are extracted from the tarball of
+// the registry instead of being fetched from unpkg.
+func (s *Server) rawPackageFile(ctx *rex.Context, pathname string) interface{} {
+	name, subpath := splitPkgPath(strings.TrimPrefix(pathname, "/"))
+	subpath = path.Clean("/" + subpath)
+	if subpath == "/" {
+		return rex.Status(http.StatusBadRequest, "missing the file path of the package")
+	}
+	m, err := s.parsePkg(name)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			return rex.Status(404, err.Error())
+		}
+		return rex.Status(500, err.Error())
+	}
+	info, _, err := s.getPackageInfo(m.name, m.version)
+	if err != nil {
+		return rex.Status(500, err.Error())
+	}
+	dir, err := s.rawPackageDir(ctx.R.Context(), info)
+	if err != nil {
+		s.log.Errorf("rawPackageDir(%s@%s): %v", info.Name, info.Version, err)
+		return rex.Status(http.StatusBadGateway, fmt.Sprintf("could not fetch the package %s@%s", info.Name, info.Version))
+	}
+	filename := filepath.Join(dir, filepath.FromSlash(subpath))
+	if !fileExists(filename) {
+		return rex.Status(404, fmt.Sprintf("file '%s' not found in %s@%s", strings.TrimPrefix(subpath, "/"), info.Name, info.Version))
+	}
+	ctx.SetHeader("Content-Type", rawContentType(filename))
+	if regVersionPath.MatchString(pathname) {
+		ctx.SetHeader("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", refreshDuration))
+	}
+	return rex.File(filename)
+}
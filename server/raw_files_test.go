@@ -0,0 +1,48 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRawPackageFile(t *testing.T) {
+	_, s := newTestServer(t)
+	handler := s.Handler()
+
+	index, err := ioutil.ReadFile(filepath.Join("testdata", "registry", "esm-fixture-cjs@1.0.0", "index.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		url          string
+		status       int
+		contentType  string
+		cacheControl string
+		body         string
+	}{
+		{"/esm-fixture-cjs@1.0.0/index.js?raw", 200, "application/javascript; charset=utf-8", "public, max-age=31536000, immutable", string(index)},
+		{"/esm-fixture-cjs@1/index.js?raw", 200, "application/javascript; charset=utf-8", "public, max-age=600", string(index)},
+		{"/esm-fixture-cjs@1.0.0/package.json?raw", 200, "application/json; charset=utf-8", "", ""},
+		{"/esm-fixture-i18n@1.0.0/locales/%E6%97%A5%E6%9C%AC%E8%AA%9E.d.ts?raw", 200, "application/typescript; charset=utf-8", "", ""},
+		{"/esm-fixture-cjs@1.0.0/missing.js?raw", 404, "", "", ""},
+		{"/esm-fixture-cjs@1.0.0?raw", 400, "", "", ""},
+		{"/esm-fixture-unknown@1.0.0/index.js?raw", 404, "", "", ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", c.url, nil))
+		if rec.Code != c.status {
+			t.Fatalf("GET %s: unexpected status %d:\n%s", c.url, rec.Code, rec.Body.String())
+		}
+		if c.contentType != "" && rec.Header().Get("Content-Type") != c.contentType {
+			t.Fatalf("GET %s: unexpected content type '%s'", c.url, rec.Header().Get("Content-Type"))
+		}
+		if c.cacheControl != "" && rec.Header().Get("Cache-Control") != c.cacheControl {
+			t.Fatalf("GET %s: unexpected cache control '%s'", c.url, rec.Header().Get("Cache-Control"))
+		}
+		if c.body != "" && rec.Body.String() != c.body {
+			t.Fatalf("GET %s: the file is not served verbatim:\n%s", c.url, rec.Body.String())
+		}
+	}
+}
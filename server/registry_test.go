@@ -0,0 +1,242 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test ./server -run TestRecordFixtures -record=react@17.0.2,lodash@4.17.21
+var recordFixtures = flag.String("record", "", "record the packages from the npm registry as the test fixtures")
+
+const fixturesDir = "testdata/registry"
+
+// A fixtureRegistry serves the packages in `testdata/registry/{name}@{version}` as a npm
+// registry, the metadata is generated by the `package.json` of the fixtures and the
+// tarballs are packed on demand.
+type fixtureRegistry struct {
+	*httptest.Server
+	packages map[string]map[string]string // name -> version -> dir
+}
+
+func newFixtureRegistry(t *testing.T) *fixtureRegistry {
+	r := &fixtureRegistry{packages: map[string]map[string]string{}}
+	dirs, err := filepath.Glob(filepath.Join(fixturesDir, "*@*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopedDirs, err := filepath.Glob(filepath.Join(fixturesDir, "@*", "*@*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range append(dirs, scopedDirs...) {
+		rel := filepath.ToSlash(strings.TrimPrefix(dir, fixturesDir+string(filepath.Separator)))
+		i := strings.LastIndexByte(rel, '@')
+		if i <= 0 {
+			// the scope directory
+			continue
+		}
+		name, version := rel[:i], rel[i+1:]
+		if r.packages[name] == nil {
+			r.packages[name] = map[string]string{}
+		}
+		r.packages[name][version] = dir
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *fixtureRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	pathname := strings.ReplaceAll(req.URL.Path, "%2f", "/")
+	pathname = strings.ReplaceAll(pathname, "%2F", "/")
+	name, tarball := strings.TrimPrefix(pathname, "/"), ""
+	if i := strings.Index(name, "/-/"); i > 0 {
+		name, tarball = name[:i], name[i+3:]
+	}
+	versions, ok := r.packages[name]
+	if !ok {
+		http.Error(w, `{"error":"Not found"}`, 404)
+		return
+	}
+
+	if tarball != "" {
+		version := strings.TrimSuffix(strings.TrimPrefix(tarball, path.Base(name)+"-"), ".tgz")
+		dir, ok := versions[version]
+		if !ok {
+			http.Error(w, `{"error":"Not found"}`, 404)
+			return
+		}
+		data, err := packFixture(dir)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+		return
+	}
+
+	var sorted versionSlice
+	metas := map[string]interface{}{}
+	for version, dir := range versions {
+		var meta map[string]interface{}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+		if err == nil {
+			err = json.Unmarshal(data, &meta)
+		}
+		if err == nil {
+			data, err = packFixture(dir)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		sum := sha1.Sum(data)
+		meta["dist"] = map[string]string{
+			"tarball": fmt.Sprintf("%s/%s/-/%s-%s.tgz", r.URL, name, path.Base(name), version),
+			"shasum":  hex.EncodeToString(sum[:]),
+		}
+		metas[version] = meta
+		sorted = append(sorted, version)
+	}
+	sort.Sort(sorted)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":      name,
+		"dist-tags": map[string]string{"latest": sorted[0]},
+		"versions":  metas,
+	})
+}
+
+// packFixture packs the fixture directory as a npm tarball, the output is stable for the
+// shasum of the metadata.
+func packFixture(dir string) ([]byte, error) {
+	var files []string
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, filename)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		rel, _ := filepath.Rel(dir, filename)
+		err = tw.WriteHeader(&tar.Header{
+			Name:    "package/" + filepath.ToSlash(rel),
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: time.Unix(499162500, 0),
+		})
+		if err != nil {
+			return nil, err
+		}
+		tw.Write(data)
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TestRecordFixtures downloads the packages of the `-record` flag from the npm registry into
+// the fixtures directory.
+func TestRecordFixtures(t *testing.T) {
+	if *recordFixtures == "" {
+		t.Skip("no -record flag")
+	}
+	for _, spec := range strings.Split(*recordFixtures, ",") {
+		i := strings.LastIndexByte(spec, '@')
+		if i <= 0 {
+			t.Fatalf("invalid package %s, should be name@version", spec)
+		}
+		name, version := spec[:i], spec[i+1:]
+		resp, err := http.Get(fmt.Sprintf("https://registry.npmjs.org/%s/%s", name, version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var meta struct {
+			Dist struct {
+				Tarball string `json:"tarball"`
+			} `json:"dist"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&meta)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err = http.Get(meta.Dist.Tarball)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = extractFixture(resp.Body, filepath.Join(fixturesDir, name+"@"+version))
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%s recorded", spec)
+	}
+}
+
+func extractFixture(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		// the root directory of npm tarballs is not always `package/`
+		a := strings.SplitN(h.Name, "/", 2)
+		if len(a) != 2 || strings.Contains(a[1], "..") {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(filepath.Join(dir, filepath.FromSlash(a[1])), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+	}
+}
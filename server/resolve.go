@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ije/rex"
+)
+
+const (
+	resolveMaxSpecifiers = 1000
+	resolveConcurrency   = 8
+)
+
+// A ResolveResult is a line of the `/-/resolve` response.
+type ResolveResult struct {
+	Specifier string `json:"specifier"`
+	Name      string `json:"name,omitempty"`
+	Version   string `json:"version,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Dts       string `json:"dts,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// bulkResolve handles the `POST /-/resolve?target=es2020&deps=react@17` requests, the body is
+// a newline-delimited list of bare specifiers like `react-dom@17/server`, the results are
+// streamed as ndjson in the order of the specifiers. The `dts` is only reported for the
+// cached builds.
+func (s *Server) bulkResolve(ctx *rex.Context) interface{} {
+	if ctx.R.Method != "POST" {
+		ctx.SetHeader("Allow", "POST")
+		return rex.Status(405, "method not allowed")
+	}
+
+	// don't use `ctx.Form` that may consume the body
+	query := ctx.R.URL.Query()
+	target := strings.ToLower(strings.TrimSpace(query.Get("target")))
+	if target != "" {
+		if _, ok := targets[target]; !ok {
+			return rex.Status(400, fmt.Sprintf("invalid target '%s', available targets: %s", target, strings.Join(targetNames(), ", ")))
+		}
+	}
+	deps := pkgSlice{}
+	for _, p := range strings.Split(query.Get("deps"), ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			m, err := s.parsePkg(p)
+			if err != nil {
+				return rex.Status(400, fmt.Sprintf("invalid deps '%s': %v", p, err))
+			}
+			if !deps.Has(m.name) {
+				deps = append(deps, *m)
+			}
+		}
+	}
+
+	var specifiers []string
+	scanner := bufio.NewScanner(io.LimitReader(ctx.R.Body, 1<<20))
+	for scanner.Scan() {
+		specifier := strings.TrimSpace(scanner.Text())
+		if specifier == "" {
+			continue
+		}
+		if len(specifiers) == resolveMaxSpecifiers {
+			return rex.Status(400, fmt.Sprintf("too many specifiers, the max is %d", resolveMaxSpecifiers))
+		}
+		specifiers = append(specifiers, specifier)
+	}
+	if err := scanner.Err(); err != nil {
+		return rex.Status(400, err.Error())
+	}
+
+	origin := fmt.Sprintf("https://%s", s.config.cdnDomain)
+	if s.config.cdnDomain == "" {
+		proto := "http"
+		if ctx.R.TLS != nil {
+			proto = "https"
+		}
+		origin = fmt.Sprintf("%s://%s", proto, ctx.R.Host)
+	}
+	search := url.Values{}
+	if target != "" {
+		search.Set("target", target)
+	}
+	if len(deps) > 0 {
+		search.Set("deps", deps.String())
+	}
+	if target == "" {
+		target = "es2020"
+	}
+
+	results := make([]chan *ResolveResult, len(specifiers))
+	for i := range results {
+		results[i] = make(chan *ResolveResult, 1)
+	}
+	go func() {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, resolveConcurrency)
+		for i, specifier := range specifiers {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, specifier string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[i] <- s.resolveSpecifier(specifier, target, deps, origin, search)
+			}(i, specifier)
+		}
+		wg.Wait()
+	}()
+
+	r, w := io.Pipe()
+	go func() {
+		// stop encoding when the request is done or the client is gone
+		<-ctx.R.Context().Done()
+		r.Close()
+	}()
+	go func() {
+		enc := json.NewEncoder(w)
+		for _, c := range results {
+			if err := enc.Encode(<-c); err != nil {
+				break
+			}
+		}
+		w.Close()
+	}()
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	ctx.SetHeader("Content-Type", "application/x-ndjson; charset=utf-8")
+	return r
+}
+
+func (s *Server) resolveSpecifier(specifier string, target string, deps pkgSlice, origin string, search url.Values) *ResolveResult {
+	ret := &ResolveResult{Specifier: specifier}
+	if isFileImportPath(specifier) || strings.Contains(specifier, ":") {
+		ret.Error = "not a bare specifier"
+		return ret
+	}
+	m, err := s.parsePkg(specifier)
+	if err != nil {
+		ret.Error = err.Error()
+		return ret
+	}
+	ret.Name = m.name
+	ret.Version = m.version
+	ret.URL = fmt.Sprintf("%s/%s", origin, m.String())
+	if len(search) > 0 {
+		ret.URL += "?" + search.Encode()
+	}
+
+	task := &buildTask{
+		Server:     s,
+		pkg:        *m,
+		deps:       append(pkgSlice{}, deps...),
+		cjsExports: "auto",
+		target:     target,
+	}
+	task.applyDefaultExternals()
+	if esm, _, ok := s.findESM(task.ID()); ok && esm.Dts != "" {
+		ret.Dts = fmt.Sprintf("%s/v%d%s", origin, VERSION, esm.Dts)
+	}
+	return ret
+}
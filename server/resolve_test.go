@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ije/rex"
+)
+
+func TestBulkResolve(t *testing.T) {
+	s := setupTestEnv(t)
+
+	body := "esm-fixture-esm\n\nesm-fixture-cjs@1/lib/index\n./local.js\nesm-fixture-unknown\n"
+	req := httptest.NewRequest("POST", "http://esm.sh/-/resolve?target=es2020&deps=esm-fixture-dep@1", strings.NewReader(body))
+	ctx := &rex.Context{W: httptest.NewRecorder(), R: req}
+	ret, ok := s.bulkResolve(ctx).(io.Reader)
+	if !ok {
+		t.Fatal("the response should be a stream")
+	}
+
+	var results []ResolveResult
+	dec := json.NewDecoder(ret)
+	for dec.More() {
+		var r ResolveResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	if r := results[0]; r.Specifier != "esm-fixture-esm" || r.Version != "1.0.0" || r.URL != "http://esm.sh/esm-fixture-esm@1.0.0?deps=esm-fixture-dep%401.0.0&target=es2020" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	if r := results[1]; r.Name != "esm-fixture-cjs" || r.URL != "http://esm.sh/esm-fixture-cjs@1.0.0/lib/index?deps=esm-fixture-dep%401.0.0&target=es2020" {
+		t.Fatalf("unexpected result %+v", r)
+	}
+	for _, r := range results[2:] {
+		if r.Error == "" || r.URL != "" {
+			t.Fatalf("%s should not be resolved", r.Specifier)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// the max number of the builds of a floating version that are pre-built when it moves
+const revalidateMaxTracked = 16
+
+// A versionRevalidator re-resolves the expired floating versions(like `latest`) in the
+// background, the stale versions are served meanwhile. When a floating version moves, the
+// recent builds of it are pre-built with the new version before the cache is updated, so the
+// clients are switched to the new version that is ready.
+type versionRevalidator struct {
+	server  *Server
+	lock    sync.Mutex
+	queue   *buildQueue
+	pending map[string]bool
+	tracked map[string][]buildTask
+	// limits the concurrent re-resolutions
+	sem chan struct{}
+}
+
+func newVersionRevalidator(server *Server, queue *buildQueue, concurrency int) *versionRevalidator {
+	return &versionRevalidator{
+		server:  server,
+		queue:   queue,
+		pending: map[string]bool{},
+		tracked: map[string][]buildTask{},
+		sem:     make(chan struct{}, concurrency),
+	}
+}
+
+// Track records the build that is served from the floating version.
+func (r *versionRevalidator) Track(name string, version string, task *buildTask) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := fmt.Sprintf("%s@%s", name, version)
+	tasks := r.tracked[key]
+	for _, t := range tasks {
+		if t.ID() == task.ID() {
+			return
+		}
+	}
+	t := *task
+	t.artifacts = nil
+	tasks = append(tasks, t)
+	if len(tasks) > revalidateMaxTracked {
+		tasks = tasks[len(tasks)-revalidateMaxTracked:]
+	}
+	r.tracked[key] = tasks
+}
+
+// Schedule re-resolves the floating version in the background, the duplicate schedules are
+// ignored until it's done.
+func (r *versionRevalidator) Schedule(name string, version string, staleVersion string) {
+	key := fmt.Sprintf("%s@%s", name, version)
+	r.lock.Lock()
+	if r.pending[key] {
+		r.lock.Unlock()
+		return
+	}
+	r.pending[key] = true
+	r.lock.Unlock()
+
+	go func() {
+		r.sem <- struct{}{}
+		defer func() {
+			<-r.sem
+			r.lock.Lock()
+			delete(r.pending, key)
+			r.lock.Unlock()
+		}()
+
+		err := r.revalidate(name, version, staleVersion)
+		if err != nil {
+			r.server.log.Warnf("revalidate %s: %v", key, err)
+		}
+	}()
+}
+
+func (r *versionRevalidator) revalidate(name string, version string, staleVersion string) error {
+	info, err := r.server.fetchPackageInfo(name, version)
+	if err != nil {
+		return err
+	}
+	if info.Version != staleVersion {
+		r.lock.Lock()
+		tasks := append([]buildTask{}, r.tracked[fmt.Sprintf("%s@%s", name, version)]...)
+		r.lock.Unlock()
+		prebuilt := 0
+		for _, t := range tasks {
+			t.id = ""
+			t.pkg.version = info.Version
+			if _, _, ok := r.server.findESM(t.ID()); ok {
+				continue
+			}
+			output := <-r.queue.Add(&t)
+			if output.err != nil {
+				r.server.log.Warnf("pre-build %s: %v", t.ID(), output.err)
+				continue
+			}
+			prebuilt++
+		}
+		r.server.log.Infof("%s@%s moved from %s to %s, %d builds pre-built", name, version, staleVersion, info.Version, prebuilt)
+	}
+	return r.server.cachePackageInfo(name, version, info)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/postui/postdb/q"
+)
+
+func TestVersionRevalidator(t *testing.T) {
+	s := setupTestEnv(t)
+	s.revalidator = newVersionRevalidator(s, nil, 1)
+
+	// the stale record of `latest` that points to an old version
+	stale := NpmPackage{Name: "esm-fixture-esm", Version: "0.9.0"}
+	_, err := s.db.Put(q.Alias("npm:esm-fixture-esm@latest"), q.KV{"package": utils.MustEncodeJSON(stale), "expires": []byte("0")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := s.getPackageInfo("esm-fixture-esm", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "0.9.0" {
+		t.Fatalf("the stale version should be served while revalidating, but got %s", info.Version)
+	}
+
+	for i := 0; i < 100; i++ {
+		s.revalidator.lock.Lock()
+		pending := len(s.revalidator.pending)
+		s.revalidator.lock.Unlock()
+		if pending == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	info, _, err = s.getPackageInfo("esm-fixture-esm", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.0.0" {
+		t.Fatalf("the version should be re-resolved, but got %s", info.Version)
+	}
+	history, _ := s.getVersionHistory("esm-fixture-esm", "latest")
+	if l := len(history); l == 0 || history[l-1].Version != "1.0.0" {
+		t.Fatalf("the move should be recorded: %v", history)
+	}
+}
+
+func TestVersionRevalidatorTrack(t *testing.T) {
+	r := newVersionRevalidator(nil, nil, 1)
+	for i := 0; i < revalidateMaxTracked+4; i++ {
+		task := &buildTask{pkg: pkg{name: "react", version: "17.0.2"}, target: targetNames()[i%len(targetNames())], isDev: i >= len(targetNames())}
+		r.Track("react", "latest", task)
+		r.Track("react", "latest", task)
+	}
+	if n := len(r.tracked["react@latest"]); n != revalidateMaxTracked {
+		t.Fatalf("the tracked builds should be deduplicated and limited, but got %d", n)
+	}
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var regRangeOperator = regexp.MustCompile(`(>=|<=|>|<|=|~|\^)\s+`)
+
+// A semver is a parsed version like `1.2.3-beta.1`, the build metadata is dropped.
+type semver struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+// parseSemver parses the full version, the `v` prefix is allowed as npm does.
+func parseSemver(s string) (v semver, ok bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		v.prerelease = s[i+1:]
+		s = s[:i]
+	}
+	a := strings.Split(s, ".")
+	if len(a) != 3 {
+		return
+	}
+	var n [3]int
+	for i, p := range a {
+		x, err := strconv.Atoi(p)
+		if err != nil || x < 0 {
+			return
+		}
+		n[i] = x
+	}
+	v.major, v.minor, v.patch = n[0], n[1], n[2]
+	return v, true
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if v.prerelease != "" {
+		s += "-" + v.prerelease
+	}
+	return s
+}
+
+// compare returns -1, 0 or 1, the prerelease versions are lower than the release.
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	if v.prerelease == o.prerelease {
+		return 0
+	}
+	if v.prerelease == "" {
+		return 1
+	}
+	if o.prerelease == "" {
+		return -1
+	}
+	a := strings.Split(v.prerelease, ".")
+	b := strings.Split(o.prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		x, xerr := strconv.Atoi(a[i])
+		y, yerr := strconv.Atoi(b[i])
+		switch {
+		case xerr == nil && yerr == nil:
+			if x < y {
+				return -1
+			}
+			return 1
+		case xerr == nil:
+			// the numeric identifiers are lower than the alphanumeric ones
+			return -1
+		case yerr == nil:
+			return 1
+		case a[i] < b[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if len(a) < len(b) {
+		return -1
+	}
+	if len(a) > len(b) {
+		return 1
+	}
+	return 0
+}
+
+type comparator struct {
+	op string
+	v  semver
+}
+
+func (c comparator) match(v semver) bool {
+	n := v.compare(c.v)
+	switch c.op {
+	case ">=":
+		return n >= 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case "<":
+		return n < 0
+	default:
+		return n == 0
+	}
+}
+
+// A versionRange is a npm version range like `^1.2.0 || >=2.1.0 <3`, the comparator sets
+// are joined by `||`.
+type versionRange [][]comparator
+
+// parseVersionRange parses the npm version range, the `*`, `x` and empty range match any
+// release version.
+func parseVersionRange(s string) (r versionRange, err error) {
+	for _, set := range strings.Split(s, "||") {
+		set = regRangeOperator.ReplaceAllString(strings.TrimSpace(set), "$1")
+		var comparators []comparator
+		fields := strings.Fields(set)
+		if len(fields) == 3 && fields[1] == "-" {
+			// the hyphen range like `1.2 - 2.3`
+			var lower, upper []comparator
+			lower, err = parseComparator(">=" + fields[0])
+			if err == nil {
+				upper, err = parseComparator("<=" + fields[2])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid version range '%s'", s)
+			}
+			comparators = append(lower, upper...)
+		} else {
+			for _, field := range fields {
+				var a []comparator
+				a, err = parseComparator(field)
+				if err != nil {
+					return nil, fmt.Errorf("invalid version range '%s'", s)
+				}
+				comparators = append(comparators, a...)
+			}
+		}
+		r = append(r, comparators)
+	}
+	return
+}
+
+// parsePartialVersion parses the version like `1`, `1.2`, `1.x` or `1.2.3-beta`, returns the
+// number of the specified parts.
+func parsePartialVersion(s string) (v semver, n int, err error) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		v.prerelease = s[i+1:]
+		s = s[:i]
+	}
+	a := strings.Split(s, ".")
+	if len(a) > 3 {
+		return v, 0, fmt.Errorf("invalid version '%s'", s)
+	}
+	parts := [3]*int{&v.major, &v.minor, &v.patch}
+	for i, p := range a {
+		if p == "x" || p == "X" || p == "*" || p == "" {
+			break
+		}
+		x, e := strconv.Atoi(p)
+		if e != nil || x < 0 {
+			return v, 0, fmt.Errorf("invalid version '%s'", s)
+		}
+		*parts[i] = x
+		n++
+	}
+	if n < 3 {
+		v.prerelease = ""
+	}
+	return
+}
+
+// parseComparator expands the comparator like `^1.2`, `~1.2.3` or `>1.2` to the primitive
+// comparators.
+func parseComparator(s string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+	v, n, err := parsePartialVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		if op == "<" || op == ">" {
+			// `<*` and `>*` match nothing
+			return []comparator{{"<", semver{}}}, nil
+		}
+		return nil, nil
+	}
+
+	// the exclusive upper bound of the partial version, like `1.3.0` of `1.2`
+	next := v
+	next.prerelease = ""
+	switch n {
+	case 1:
+		next = semver{major: v.major + 1}
+	case 2:
+		next = semver{major: v.major, minor: v.minor + 1}
+	}
+
+	switch op {
+	case "^":
+		upper := semver{major: v.major + 1}
+		if v.major == 0 && n > 1 {
+			upper = semver{minor: v.minor + 1}
+			if v.minor == 0 && n > 2 {
+				upper = semver{patch: v.patch + 1}
+			}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case "~":
+		upper := semver{major: v.major, minor: v.minor + 1}
+		if n == 1 {
+			upper = semver{major: v.major + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case ">":
+		if n < 3 {
+			return []comparator{{">=", next}}, nil
+		}
+		return []comparator{{">", v}}, nil
+	case "<=":
+		if n < 3 {
+			return []comparator{{"<", next}}, nil
+		}
+		return []comparator{{"<=", v}}, nil
+	case ">=", "<":
+		return []comparator{{op, v}}, nil
+	default:
+		if n < 3 {
+			return []comparator{{">=", v}, {"<", next}}, nil
+		}
+		return []comparator{{"=", v}}, nil
+	}
+}
+
+// Match reports whether the version satisfies the range, the prerelease versions only
+// match the comparator sets that have a prerelease of the same `major.minor.patch`.
+func (r versionRange) Match(v semver) bool {
+	for _, set := range r {
+		ok := true
+		for _, c := range set {
+			if !c.match(v) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if v.prerelease == "" {
+			return true
+		}
+		for _, c := range set {
+			if c.v.prerelease != "" && c.v.major == v.major && c.v.minor == v.minor && c.v.patch == v.patch {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maxSatisfying returns the highest version that satisfies the range.
+func maxSatisfying(versions []string, r versionRange) (version string, ok bool) {
+	var max semver
+	for _, s := range versions {
+		v, valid := parseSemver(s)
+		if valid && r.Match(v) && (!ok || v.compare(max) > 0) {
+			max, version, ok = v, s, true
+		}
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestVersionRange(t *testing.T) {
+	for _, c := range []struct {
+		r       string
+		version string
+		match   bool
+	}{
+		{"", "1.2.3", true},
+		{"*", "1.2.3", true},
+		{"*", "1.2.3-beta", false},
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{"1.2", "1.2.9", true},
+		{"1.2.x", "1.3.0", false},
+		{"1", "1.9.0", true},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "1.2.2", false},
+		{"^1.2.3", "2.0.0", false},
+		{"^0.2.3", "0.2.9", true},
+		{"^0.2.3", "0.3.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.x", "0.9.0", true},
+		{"^1.2.3-beta.2", "1.2.3-beta.10", true},
+		{"^1.2.3-beta.2", "1.2.3-beta.1", false},
+		{"^1.2.3-beta.2", "1.2.4-beta.3", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{">=1.2.0 <2", "1.9.9", true},
+		{">= 1.2.0 < 2", "2.0.0", false},
+		{">1.2", "1.2.9", false},
+		{">1.2", "1.3.0", true},
+		{"<=1.2", "1.2.9", true},
+		{"1.2 - 2.3", "2.3.5", true},
+		{"1.2 - 2.3.4", "2.3.5", false},
+		{"^1.0.0 || ^2.0.0", "2.1.0", true},
+		{"^1.0.0 || ^2.0.0", "3.0.0", false},
+		{"v1.2.3", "1.2.3", true},
+	} {
+		r, err := parseVersionRange(c.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok := parseSemver(c.version)
+		if !ok {
+			t.Fatalf("invalid version %s", c.version)
+		}
+		if r.Match(v) != c.match {
+			t.Fatalf("'%s' should match %s: %v", c.r, c.version, c.match)
+		}
+	}
+
+	for _, s := range []string{"^1.2.3.4", "~a.b", ">=1.2.3 - 2"} {
+		if _, err := parseVersionRange(s); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+
+	r, _ := parseVersionRange("^1.0.0")
+	if v, ok := maxSatisfying([]string{"0.9.0", "1.0.0", "1.10.0", "1.9.0", "2.0.0", "1.11.0-beta"}, r); !ok || v != "1.10.0" {
+		t.Fatalf("unexpected max satisfying version %s", v)
+	}
+}
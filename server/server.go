@@ -1,165 +1,519 @@
 package server
 
 import (
+	"crypto/ed25519"
 	"embed"
 	"flag"
 	"fmt"
-	"io"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
-	"syscall"
+	"runtime"
+	"strings"
+	"time"
 
 	logx "github.com/ije/gox/log"
 	"github.com/ije/rex"
-	"github.com/oschwald/maxminddb-golang"
-	"github.com/postui/postdb"
-)
-
-var (
-	config  *Config
-	node    *NodeEnv
-	mmdbr   *maxminddb.Reader
-	db      *postdb.DB
-	log     *logx.Logger
-	embedFS *embed.FS
+	"golang.org/x/net/idna"
 )
 
 // Server Config
 type Config struct {
 	storageDir     string
+	nodejsDir      string
 	domain         string
 	cdnDomain      string
 	cdnDomainChina string
 	unpkgDomain    string
+	legalComments  string
+	devLineWidth   int
+	robotsTxt      string
+	buildTTL       time.Duration
+	warmThreshold  int
+	procNice       int
+	procCPULimit   int
+	// the number of the long-lived nodejs workers that parse the exports of the commonjs modules
+	cjsLexerWorkers int
+	// the fault rates of the chaos mode
+	chaos      map[string]float64
+	chaosDelay time.Duration
+	// cache the reproducible errors of the failed builds for the duration, 0 means disabled
+	buildFailureTTL time.Duration
+	// kill the build(and its subprocesses) after the duration, 0 means unlimited
+	buildTimeout time.Duration
+	// the daily quota of new builds per client, 0 means unlimited
+	buildQuota       int
+	buildQuotaTokens map[string]int
+	// the friendly URLs redirect to the package paths
+	aliases aliasRoutes
+	// block the clients that send more invalid requests than the threshold in a minute
+	abuseThreshold int
+	abuseBlock     time.Duration
+	// the proxies whose `X-Forwarded-For` and `X-Real-IP` headers are trusted
+	trustedProxies []*net.IPNet
+	// the token of the admin APIs, empty means the admin APIs are disabled
+	adminToken string
+	// the TTL of the linked packages, 0 means `PUT /-/link` is disabled
+	linkTTL time.Duration
+	// the max number of the builds in process and waiting in the queue
+	buildConcurrency int
+	buildQueueSize   int
+	// reject the new builds with 503 when the waiting builds reach the depth, 0 means never
+	loadShedDepth int
+	// pause starting new builds when the memory usage ratio exceeds it
+	buildMemThreshold float64
+	// disallow all build-triggering paths in the robots.txt
+	robotsDisallowBuilds bool
+	// analyze the top-level side effects of builds
+	analyzeSideEffects bool
+	// verify the emitted modules after the builds
+	verifyBuilds bool
+	// allow the `cjs-exports=all` mode that evaluates the package code in nodejs
+	evalCJSExports bool
+	// serve the minimum viable target when the package can't be built for the requested target
+	targetFallback bool
+	// the global names defined for the production builds, like `__DEV__=false`
+	define map[string]string
+	// the external packages added to the builds by the target or the package pattern
+	defaultExternals defaultExternals
+	// re-resolve the floating versions like `react@16` in the interval
+	versionRefresh time.Duration
+	// re-resolve the dist-tags like `react@next` in the interval, 0 means the `versionRefresh`
+	distTagRefresh time.Duration
+	// redirect the weak versions like `react@16` and the ranges like `react@^16.8` to the
+	// exact versions
+	redirectWeakVersions bool
+	// sign the build artifacts with the ed25519 key, nil means the artifacts are not signed
+	signingKey ed25519.PrivateKey
+	// replicate the builds of the primary server, the artifacts are verified by its public key
+	mirrorOf       string
+	mirrorPubkey   ed25519.PublicKey
+	mirrorInterval time.Duration
+	// the guardrails of the installs: the max number of the installed packages, the max
+	// duration of an install, and the denied transitive dependencies
+	installMaxDeps int
+	installTimeout time.Duration
+	installDeny    map[string]bool
+	// the substitutes of the native addons that are imported by the packages, like
+	// `bcrypt` -> `bcryptjs`
+	nativeSubstitutes map[string]string
+	// install the packages by the tarballs of the registry(`native`) or by `yarn`
+	installer string
+	// reuse the installed trees of the same install list in the duration
+	installCacheTTL time.Duration
+	// the proxies of the registry requests and the installers, the proxy env vars of the
+	// server are used if none of them is set
+	httpProxy  string
+	httpsProxy string
+	noProxy    string
+	proxy      func(*url.URL) (*url.URL, error)
+	// serve the `/dev/` routes that always rebuild and store the builds in the scratch area
+	devRoutes    bool
+	devRoutesTTL time.Duration
+	// accept the usage reports of the exports at `/-/telemetry` and the `?report` query
+	usageReport bool
+	// the snippet appended to the served builds, with the `{{buildID}}`, `{{package}}` and
+	// `{{version}}` placeholders
+	buildFooter string
+	// the package versions known to be broken, they take precedence over the curated table that
+	// is updated from the `knownIssuesURL` in the interval
+	knownIssues        []knownIssue
+	knownIssuesURL     string
+	knownIssuesRefresh time.Duration
 }
 
 // Serve serves esmd server
 func Serve(fs *embed.FS) {
+	serve(fs, os.Args[1:], false)
+}
+
+// Doctor runs the self-test with the live config of the server, it's the
+// `esmd doctor [options] [pkg]` command, the options are the same as the server's.
+func Doctor(fs *embed.FS, args []string) {
+	serve(fs, args, true)
+}
+
+func serve(fs *embed.FS, args []string, doctor bool) {
 	var port int
 	var httpsPort int
 	var etcDir string
+	var logDir string
+	var dataDir string
+	var configFile string
 	var domain string
 	var cdnDomain string
 	var cdnDomainChina string
 	var unpkgDomain string
+	var legalComments string
+	var devLineWidth int
+	var analyzeSideEffects bool
+	var verifyBuilds bool
+	var evalCJSExports bool
+	var targetFallback bool
+	var robotsTxt string
+	var buildTTL time.Duration
+	var buildFailureTTL time.Duration
+	var buildTimeout time.Duration
+	var warmThreshold int
+	var procNice int
+	var buildMemThreshold float64
+	var buildConcurrency int
+	var buildQueueSize int
+	var loadShedDepth int
+	var buildQuota int
+	var linkTTL time.Duration
+	var abuseThreshold int
+	var abuseBlock time.Duration
+	var trustedProxies string
+	var adminToken string
+	aliases := aliasRoutes{}
+	var buildQuotaTokens string
+	var procCPULimit int
+	var cjsLexerWorkers int
+	var chaos string
+	var define string
+	var defaultExternal string
+	var buildFooter string
+	var versionRefresh time.Duration
+	var distTagRefresh time.Duration
+	var redirectWeakVersions bool
+	var signingKey string
+	var replicationFeed bool
+	var mirrorOf string
+	var mirrorPubkey string
+	var mirrorInterval time.Duration
+	var usageReport bool
+	var installMaxDeps int
+	var installTimeout time.Duration
+	var installer string
+	var installCacheTTL time.Duration
+	var installDeny string
+	var nativeSubstitutes string
+	var knownIssues string
+	var knownIssuesURL string
+	var knownIssuesRefresh time.Duration
+	var httpProxy string
+	var httpsProxy string
+	var noProxy string
+	var devRoutes bool
+	var devRoutesTTL time.Duration
+	var chaosDelay time.Duration
+	var robotsDisallowBuilds bool
 	var logLevel string
 	var isDev bool
 
 	flag.IntVar(&port, "port", 80, "http server port")
-	flag.IntVar(&httpsPort, "https-port", 443, "https server port")
-	flag.StringVar(&etcDir, "etc-dir", "/usr/local/etc/esmd", "etc dir")
+	flag.IntVar(&httpsPort, "https-port", 443, "https server port, 0 means disabled")
+	flag.DurationVar(&buildTimeout, "build-timeout", 10*time.Minute, "fail the build and kill its subprocesses if it takes longer than the duration, 0 means unlimited")
+	flag.StringVar(&etcDir, "etc-dir", defaultEtcDir(runtime.GOOS), "etc dir")
+	flag.StringVar(&logDir, "log-dir", defaultLogDir(runtime.GOOS), "log dir")
+	flag.StringVar(&dataDir, "data-dir", "", "keep all the writable data in the dir for running in containers, the ports default to 8080(http only)")
+	flag.StringVar(&configFile, "config", "", "config file, default is '{etc-dir}/config.json' if it exists")
 	flag.StringVar(&domain, "domain", "esm.sh", "main domain")
 	flag.StringVar(&cdnDomain, "cdn-domain", "", "cdn domain")
 	flag.StringVar(&cdnDomainChina, "cdn-domain-china", "", "cdn domain for china")
 	flag.StringVar(&unpkgDomain, "unpkg-domain", "", "proxy domain for unpkg.com")
+	flag.StringVar(&legalComments, "legal-comments", "eof", "how to handle legal comments of builds: eof, none or linked(.LEGAL.txt)")
+	flag.IntVar(&devLineWidth, "dev-line-width", 0, "max line width of the header of development builds, 0 means one statement per line")
+	flag.StringVar(&buildFooter, "build-footer", "", "append the snippet to the served builds without changing the stored ones, the placeholders {{buildID}}, {{package}} and {{version}} are replaced with the string literals")
+	flag.StringVar(&defaultExternal, "default-external", "", "keep the packages as the bare imports in the builds by default, like 'react,react-dom' or the rules by the target or the package pattern like 'deno=preact;@corp/*=react,vue'")
+	flag.StringVar(&define, "define", "", "define the global names for the production builds, the presets(angular, dev, node-debug) or pairs like '__DEV__=false'")
+	flag.BoolVar(&analyzeSideEffects, "analyze-side-effects", false, "analyze the top-level side effects of builds")
+	flag.BoolVar(&verifyBuilds, "verify-builds", false, "verify the syntax, the exports and the import URLs of the emitted modules after the builds, the builds that fail the verification are not served")
+	flag.BoolVar(&evalCJSExports, "eval-cjs-exports", false, "allow the cjs-exports=all mode, it runs the code of the requested packages in nodejs with the access of the server")
+	flag.BoolVar(&targetFallback, "target-fallback", false, "serve the minimum viable target when the package can't be built for the requested target, can be overridden by the 'fallback' query")
+	flag.StringVar(&robotsTxt, "robots-txt", "", "custom robots.txt file")
+	flag.BoolVar(&robotsDisallowBuilds, "robots-disallow-builds", false, "disallow crawlers to visit the paths that trigger builds")
+	flag.DurationVar(&versionRefresh, "version-refresh", refreshDuration*time.Second, "re-resolve the floating versions like 'react@16' in the interval")
+	flag.DurationVar(&distTagRefresh, "dist-tag-refresh", 0, "re-resolve the dist-tags like 'react@next' in the interval, 0 means the same as 'version-refresh'")
+	flag.BoolVar(&redirectWeakVersions, "redirect-weak-versions", true, "redirect the major-only and major.minor versions like 'react@16' and the ranges like 'react@^16.8' to the current exact versions")
+	flag.StringVar(&signingKey, "signing-key", "", "sign the build artifacts with the ed25519 key file(base64 encoded seed), it's generated if it doesn't exist, empty means disabled")
+	flag.BoolVar(&replicationFeed, "replication-feed", false, "publish the new builds at '/-/feed' for the mirrors, the artifacts should be signed by the 'signing-key'")
+	flag.StringVar(&mirrorOf, "mirror-of", "", "replicate the builds of the primary server by its feed, like 'https://esm.sh'")
+	flag.StringVar(&mirrorPubkey, "mirror-pubkey", "", "the public key(base64) of the primary server to verify the artifacts, default is fetched from its '/-/pubkey'")
+	flag.DurationVar(&mirrorInterval, "mirror-interval", time.Minute, "the interval to poll the feed of the primary server")
+	flag.BoolVar(&usageReport, "usage-report", false, "accept the usage reports of the package exports at '/-/telemetry', the '?report' query adds a beacon to the modules")
+	flag.IntVar(&installMaxDeps, "install-max-deps", 0, "fail the build if it installs more packages than the limit, 0 means unlimited")
+	flag.StringVar(&installer, "installer", "native", "how to install the packages: native(download the tarballs from the registry) or yarn")
+	flag.DurationVar(&installCacheTTL, "install-cache-ttl", 24*time.Hour, "reuse the installed node_modules of the same install list(like the builds of other targets), remove the cached trees that are not used in the duration, 0 means disabled")
+	flag.DurationVar(&installTimeout, "install-timeout", 0, "fail the build if an install takes longer than the duration, 0 means unlimited")
+	flag.StringVar(&nativeSubstitutes, "native-substitutes", "bcrypt=bcryptjs,node-sass=sass", "replace the imports of the native addons(node-gyp) with the WASM or the pure JS equivalents, like 'bcrypt=bcryptjs'")
+	flag.StringVar(&knownIssues, "known-issues", "", "the package versions known to be broken in JSON, like '[{\"name\":\"node-sass\",\"reason\":\"native bindings\",\"suggest\":\"sass\"}]', the requests of them fail fast with the suggestions")
+	flag.StringVar(&knownIssuesURL, "known-issues-url", "", "update the curated table of the known issues from the URL, empty means the table embedded in the server is used")
+	flag.DurationVar(&knownIssuesRefresh, "known-issues-refresh", time.Hour, "the interval to update the known issues from the 'known-issues-url'")
+	flag.StringVar(&installDeny, "install-deny", "", "fail the build if it installs the denied packages, like 'left-pad,@corp/legacy'")
+	flag.StringVar(&httpProxy, "http-proxy", "", "the proxy of the http requests to the registry and of the installers, like 'http://proxy.corp:3128'")
+	flag.StringVar(&httpsProxy, "https-proxy", "", "the proxy of the https requests to the registry and of the installers")
+	flag.StringVar(&noProxy, "no-proxy", "", "the hosts that bypass the proxy, like 'localhost,.corp.example.com'")
+	flag.BoolVar(&devRoutes, "dev-routes", false, "serve the '/dev/' routes that always rebuild without the cache and store the builds in the scratch area")
+	flag.DurationVar(&devRoutesTTL, "dev-routes-ttl", 10*time.Minute, "remove the scratch builds of the '/dev/' routes after the duration")
+	flag.DurationVar(&buildTTL, "build-ttl", 0, "evict the builds that are not refreshed in the duration, 0 means never")
+	flag.DurationVar(&buildFailureTTL, "build-failure-ttl", 10*time.Minute, "cache the errors of the failed builds(esbuild and install errors) for the duration instead of rebuilding on every request, 0 means disabled")
+	flag.IntVar(&warmThreshold, "warm-threshold", 100, "retain the expiring builds that are accessed more than the times in the last TTL")
+	flag.Float64Var(&buildMemThreshold, "build-mem-threshold", 0.9, "pause starting new builds when the memory usage ratio of the host exceeds it, 0 means never")
+	flag.IntVar(&buildConcurrency, "build-concurrency", runtime.NumCPU(), "max number of the builds in process")
+	flag.IntVar(&buildQueueSize, "build-queue-size", 1000, "max number of the builds waiting in the queue, 0 means unlimited")
+	flag.IntVar(&loadShedDepth, "load-shed-depth", 0, "respond 503 to the requests of the new builds instead of queuing them when the waiting builds reach the depth, 0 means never")
+	flag.IntVar(&buildQuota, "build-quota", 0, "max new builds per client(IP) per day, 0 means unlimited")
+	flag.StringVar(&buildQuotaTokens, "build-quota-tokens", "", "the tokens with their own daily quota of new builds, like 'token1=5000,token2=0'(0 means unlimited)")
+	flag.Var(aliases, "aliases", "redirect the friendly URLs to the package paths, like '/jquery=/jquery@3/dist/jquery.module.js,/ui=/@corp/ui@2'")
+	flag.IntVar(&abuseThreshold, "abuse-threshold", 120, "block the client that sends more invalid requests(404s and errors) than the threshold in a minute, 0 means never")
+	flag.DurationVar(&abuseBlock, "abuse-block", 10*time.Minute, "the duration to block the abusive clients")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "the IPs or the CIDRs of the proxies(like the CDN) whose 'X-Forwarded-For' and 'X-Real-IP' headers are trusted to identify the clients, like '10.0.0.0/8'")
+	flag.StringVar(&adminToken, "admin-token", "", "the bearer token of the admin APIs like '/-/unblock' and '/-/purge', empty means disabled")
+	flag.DurationVar(&linkTTL, "link-ttl", 0, "allow to link unpublished packages by 'PUT /-/link' for the duration, default is 1h in the development mode, 0 means disabled")
+	flag.IntVar(&procNice, "proc-nice", 0, "niceness of the subprocesses like yarn and nodejs (unix only)")
+	flag.IntVar(&procCPULimit, "proc-cpu-limit", 0, "max cpu time in seconds of the subprocesses, 0 means unlimited (unix only)")
+	flag.IntVar(&cjsLexerWorkers, "cjs-lexer-workers", 4, "the number of the long-lived nodejs workers that parse the exports of the commonjs modules")
+	flag.StringVar(&chaos, "chaos", "", "inject faults for testing, like 'registry=0.1,slow-install=0.2,esbuild=0.05,disk-full=0.01'")
+	flag.DurationVar(&chaosDelay, "chaos-delay", 10*time.Second, "the delay of the 'slow-install' fault")
 	flag.StringVar(&logLevel, "log", "info", "log level")
 	flag.BoolVar(&isDev, "dev", false, "run server in development mode")
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+
+	nodejsDir := "/usr/local/nodejs"
+	if dataDir != "" {
+		dataDir, _ = filepath.Abs(dataDir)
+		etcDir = dataDir
+		logDir = filepath.Join(dataDir, "log")
+		nodejsDir = filepath.Join(dataDir, "nodejs")
+		err := useDataDir(dataDir)
+		if err != nil {
+			fmt.Printf("init data dir: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if configFile == "" && fileExists(filepath.Join(etcDir, "config.json")) {
+		configFile = filepath.Join(etcDir, "config.json")
+	}
+	if configFile != "" {
+		err := applyConfigFile(flag.CommandLine, configFile)
+		if err != nil {
+			fmt.Printf("load config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if dataDir != "" {
+		// bind unprivileged ports by default
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		if !set["port"] {
+			port = 8080
+		}
+		if !set["https-port"] {
+			httpsPort = 0
+		}
+	}
 
-	logDir := "/var/log/esmd"
 	if isDev {
 		etcDir, _ = filepath.Abs(".dev")
 		domain = "localhost"
 		cdnDomain = ""
 		cdnDomainChina = ""
-		logDir = path.Join(etcDir, "log")
+		logDir = filepath.Join(etcDir, "log")
 		logLevel = "debug"
+		if linkTTL == 0 {
+			linkTTL = time.Hour
+		}
 	}
 
-	config = &Config{
-		storageDir:     path.Join(etcDir, "storage"),
-		domain:         domain,
-		cdnDomain:      cdnDomain,
-		cdnDomainChina: cdnDomainChina,
-		unpkgDomain:    unpkgDomain,
+	// the internationalized domains are written in the URLs by the punycode form
+	for _, d := range []*string{&domain, &cdnDomain, &cdnDomainChina, &unpkgDomain} {
+		if *d != "" {
+			ascii, err := idna.Lookup.ToASCII(*d)
+			if err != nil {
+				fmt.Printf("invalid domain '%s': %v\n", *d, err)
+				os.Exit(1)
+			}
+			*d = ascii
+		}
 	}
-	embedFS = fs
 
-	var err error
-	log, err = logx.New(fmt.Sprintf("file:%s?buffer=32k", path.Join(logDir, "main.log")))
+	config := &Config{
+		storageDir:      filepath.Join(etcDir, "storage"),
+		nodejsDir:       nodejsDir,
+		domain:          domain,
+		cdnDomain:       cdnDomain,
+		cdnDomainChina:  cdnDomainChina,
+		unpkgDomain:     unpkgDomain,
+		legalComments:   legalComments,
+		devLineWidth:    devLineWidth,
+		robotsTxt:       robotsTxt,
+		buildTTL:        buildTTL,
+		warmThreshold:   warmThreshold,
+		procNice:        procNice,
+		procCPULimit:    procCPULimit,
+		cjsLexerWorkers: cjsLexerWorkers,
+		chaosDelay:      chaosDelay,
+
+		buildConcurrency:  buildConcurrency,
+		buildQueueSize:    buildQueueSize,
+		loadShedDepth:     loadShedDepth,
+		buildQuota:        buildQuota,
+		buildFailureTTL:   buildFailureTTL,
+		buildTimeout:      buildTimeout,
+		linkTTL:           linkTTL,
+		abuseThreshold:    abuseThreshold,
+		abuseBlock:        abuseBlock,
+		adminToken:        adminToken,
+		aliases:           aliases,
+		buildMemThreshold: buildMemThreshold,
+
+		robotsDisallowBuilds: robotsDisallowBuilds,
+		analyzeSideEffects:   analyzeSideEffects,
+		verifyBuilds:         verifyBuilds,
+		evalCJSExports:       evalCJSExports,
+		targetFallback:       targetFallback,
+		versionRefresh:       versionRefresh,
+		distTagRefresh:       distTagRefresh,
+		redirectWeakVersions: redirectWeakVersions,
+		mirrorOf:             mirrorOf,
+		mirrorInterval:       mirrorInterval,
+		usageReport:          usageReport,
+		installMaxDeps:       installMaxDeps,
+		installTimeout:       installTimeout,
+		installCacheTTL:      installCacheTTL,
+		installer:            installer,
+		devRoutes:            devRoutes,
+		devRoutesTTL:         devRoutesTTL,
+		knownIssuesURL:       knownIssuesURL,
+		knownIssuesRefresh:   knownIssuesRefresh,
+	}
+	log, err := logx.New(fmt.Sprintf("file:%s?buffer=32k", filepath.Join(logDir, "main.log")))
 	if err != nil {
 		fmt.Printf("initiate logger: %v\n", err)
 		os.Exit(1)
 	}
 	log.SetLevelByName(logLevel)
 
-	node, err = checkNodeEnv()
+	config.buildQuotaTokens, err = parseQuotaTokens(buildQuotaTokens)
 	if err != nil {
-		log.Fatalf("check nodejs env: %v", err)
+		log.Fatal(err)
 	}
-	log.Debugf("nodejs v%s installed", node.version)
 
-	ensureDir(path.Join(config.storageDir, fmt.Sprintf("builds/v%d", VERSION)))
-	ensureDir(path.Join(config.storageDir, fmt.Sprintf("types/v%d", VERSION)))
-	ensureDir(path.Join(config.storageDir, "raw"))
+	config.define, err = parseDefineConfig(define)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	db, err = postdb.Open(path.Join(etcDir, "esm.db"), 0666)
+	config.defaultExternals, err = parseDefaultExternals(defaultExternal)
 	if err != nil {
-		log.Fatalf("initiate esm.db: %v", err)
+		log.Fatal(err)
 	}
 
-	polyfills, err := embedFS.ReadDir("embed/polyfills")
+	config.buildFooter, err = parseBuildFooter(buildFooter)
 	if err != nil {
 		log.Fatal(err)
 	}
-	for _, entry := range polyfills {
-		name := entry.Name()
-		filename := path.Join(config.storageDir, fmt.Sprintf("builds/v%d/_%s", VERSION, name))
-		if !fileExists(filename) {
-			file, err := embedFS.Open(fmt.Sprintf("embed/polyfills/%s", name))
-			if err != nil {
-				log.Fatal(err)
-			}
-			f, err := os.Create(filename)
-			if err != nil {
-				log.Fatal(err)
-			}
-			_, err = io.Copy(f, file)
-			f.Close()
+
+	config.installDeny, err = parseInstallDenyList(installDeny)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.nativeSubstitutes, err = parseNativeSubstitutes(nativeSubstitutes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.knownIssues, err = parseKnownIssues([]byte(knownIssues))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fs != nil {
+		if data, e := fs.ReadFile("embed/known-issues.json"); e == nil {
+			curated, err := parseKnownIssues(data)
 			if err != nil {
-				log.Fatal(err)
+				log.Fatalf("embed/known-issues.json: %v", err)
 			}
-			log.Debugf("%s added", name)
+			setCuratedKnownIssues(curated)
 		}
 	}
+	if !legalCommentsModes[legalComments] {
+		log.Fatalf("invalid legal-comments '%s', available modes: eof, none, linked", legalComments)
+	}
+	if installer != "native" && installer != "yarn" {
+		log.Fatalf("invalid installer '%s', available installers: native, yarn", installer)
+	}
 
-	types, err := embedFS.ReadDir("embed/types")
+	config.trustedProxies, err = parseTrustedProxies(trustedProxies)
 	if err != nil {
 		log.Fatal(err)
 	}
-	for _, entry := range types {
-		name := entry.Name()
-		filename := path.Join(config.storageDir, fmt.Sprintf("types/v%d/_%s", VERSION, name))
-		if !fileExists(filename) {
-			file, err := embedFS.Open(fmt.Sprintf("embed/types/%s", name))
-			if err != nil {
-				log.Fatal(err)
-			}
-			f, err := os.Create(filename)
-			if err != nil {
-				log.Fatal(err)
-			}
-			_, err = io.Copy(f, file)
-			f.Close()
-			if err != nil {
-				log.Fatal(err)
-			}
-			log.Debugf("%s added", name)
+
+	config.httpProxy, err = parseProxyURL(httpProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.httpsProxy, err = parseProxyURL(httpsProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.noProxy = strings.TrimSpace(noProxy)
+	config.proxy = newProxyFunc(config.httpProxy, config.httpsProxy, config.noProxy)
+
+	if signingKey != "" {
+		config.signingKey, err = loadSigningKey(signingKey)
+		if err != nil {
+			log.Fatalf("load signing key: %v", err)
 		}
+		log.Infof("artifact signing enabled, key id: %s", signingKeyID(config.signingKey.Public().(ed25519.PublicKey)))
 	}
 
-	mmdata, err := embedFS.ReadFile("embed/china_ip_list.mmdb")
-	if err == nil {
-		mmdbr, err = maxminddb.FromBytes(mmdata)
+	if mirrorPubkey != "" {
+		config.mirrorPubkey, err = parsePublicKey(mirrorPubkey)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Debugf("china_ip_list.mmdb applied: %+v", mmdbr.Metadata)
 	}
 
-	accessLogger, err := logx.New(fmt.Sprintf("file:%s?buffer=32k&fileDateFormat=20060102", path.Join(logDir, "access.log")))
+	config.chaos, err = parseChaosConfig(chaos)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(config.chaos) > 0 {
+		log.Warnf("chaos mode enabled: %v", config.chaos)
+	}
+
+	srv, err := newServer(config, log, fs, etcDir)
+	if err != nil {
+		if doctor && strings.HasPrefix(err.Error(), "open esm.db") {
+			fmt.Printf("✗ %v\n  hint: the db is locked by the running server, stop it before running the doctor\n", err)
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
+	if doctor {
+		ok := runDoctor(os.Stdout, srv.doctorSteps(flag.Arg(0), filepath.Join(logDir, "main.log")))
+		srv.Close()
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	srv.startBuildGC()
+	srv.startScratchGC()
+	srv.startInstallCacheGC()
+	if replicationFeed {
+		if config.signingKey == nil {
+			log.Warn("the replication feed is enabled without the 'signing-key', the mirrors can't verify the artifacts")
+		}
+		srv.feed, err = openBuildFeed(config, filepath.Join(etcDir, "feed.jsonl"))
+		if err != nil {
+			log.Fatalf("open the replication feed: %v", err)
+		}
+	}
+	srv.startMirror()
+	srv.startKnownIssuesUpdater()
+	startTelemetry()
+
+	accessLogger, err := logx.New(fmt.Sprintf("file:%s?buffer=32k&fileDateFormat=20060102", filepath.Join(logDir, "access.log")))
 	if err != nil {
 		log.Fatalf("initiate access logger: %v", err)
 	}
@@ -171,11 +525,11 @@ func Serve(fs *embed.FS) {
 		rex.Header("Server", domain),
 		rex.Cors(rex.CORS{
 			AllowAllOrigins: true,
-			AllowMethods:    []string{"GET"},
-			AllowHeaders:    []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding"},
+			AllowMethods:    []string{"GET", "POST", "PUT"},
+			AllowHeaders:    []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization"},
 			MaxAge:          3600,
 		}),
-		query(),
+		srv.handle(),
 	)
 
 	C := rex.Serve(rex.ServerConfig{
@@ -183,15 +537,15 @@ func Serve(fs *embed.FS) {
 		TLS: rex.TLSConfig{
 			Port: uint16(httpsPort),
 			AutoTLS: rex.AutoTLSConfig{
-				AcceptTOS: !isDev,
+				AcceptTOS: !isDev && httpsPort > 0,
 				Hosts:     []string{"www." + domain, domain},
-				CacheDir:  path.Join(etcDir, "autotls"),
+				CacheDir:  filepath.Join(etcDir, "autotls"),
 			},
 		},
 	})
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGKILL, syscall.SIGHUP)
+	signal.Notify(c, shutdownSignals...)
 
 	if isDev {
 		log.Debugf("Server ready on http://localhost:%d", port)
@@ -204,12 +558,51 @@ func Serve(fs *embed.FS) {
 	}
 
 	// release resource
-	log.FlushBuffer()
 	accessLogger.FlushBuffer()
-	db.Close()
+	srv.Close()
 }
 
-func init() {
-	log = &logx.Logger{}
-	embedFS = &embed.FS{}
+// defaultEtcDir returns the platform-appropriate etc dir.
+func defaultEtcDir(goos string) string {
+	switch goos {
+	case "windows":
+		return filepath.Join(os.Getenv("ProgramData"), "esmd")
+	default:
+		return "/usr/local/etc/esmd"
+	}
+}
+
+// useDataDir redirects the temporary files and the caches of yarn and npm into the data dir.
+func useDataDir(dataDir string) error {
+	tmpDir := filepath.Join(dataDir, "tmp")
+	err := ensureDir(tmpDir)
+	if err != nil {
+		return err
+	}
+	env := map[string]string{
+		"TMPDIR":            tmpDir,
+		"TMP":               tmpDir,
+		"TEMP":              tmpDir,
+		"YARN_CACHE_FOLDER": filepath.Join(dataDir, "cache", "yarn"),
+		"npm_config_cache":  filepath.Join(dataDir, "cache", "npm"),
+	}
+	for key, value := range env {
+		err = os.Setenv(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultLogDir returns the platform-appropriate log dir.
+func defaultLogDir(goos string) string {
+	switch goos {
+	case "windows":
+		return filepath.Join(os.Getenv("ProgramData"), "esmd", "log")
+	case "darwin":
+		return "/usr/local/var/log/esmd"
+	default:
+		return "/var/log/esmd"
+	}
 }
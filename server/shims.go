@@ -0,0 +1,61 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/ije/esbuild-internal/js_ast"
+	"github.com/ije/esbuild-internal/js_parser"
+	"github.com/ije/esbuild-internal/logger"
+	"github.com/ije/esbuild-internal/test"
+)
+
+// the node shims that don't import any module are injected by esbuild, the define
+// config maps the node globals to the exported names of these files, unused shims
+// are tree-shaken.
+var injectableNodeShims = map[string]string{
+	"global.js":         "var g = window;\nexport { g as __global$ };\n",
+	"setImmediate.js":   "var s = (cb, ...args) => setTimeout(cb, 0, ...args);\nexport { s as __setImmediate$ };\n",
+	"requireResolve.js": "var r = (p) => p;\nexport { r as __rResolve$ };\n",
+}
+
+// writeInjectableNodeShims writes the injectable shims to the build directory and
+// returns the file paths for the `Inject` option of esbuild, the workers have no `window`.
+func writeInjectableNodeShims(wd string, target string) (files []string, err error) {
+	dir := filepath.Join(wd, "esm_sh_shims")
+	err = ensureDir(dir)
+	if err != nil {
+		return
+	}
+	for name, code := range injectableNodeShims {
+		if name == "global.js" && target == "workers" {
+			code = "var g = globalThis;\nexport { g as __global$ };\n"
+		}
+		filename := filepath.Join(dir, name)
+		err = ioutil.WriteFile(filename, []byte(code), 0644)
+		if err != nil {
+			return
+		}
+		files = append(files, filename)
+	}
+	sort.Strings(files)
+	return
+}
+
+// unboundIdentifiers returns the global identifiers that are referenced by the code,
+// unlike scanning the bytes it ignores the names in string literals and comments.
+func unboundIdentifiers(code []byte) (set *stringSet, ok bool) {
+	log := logger.NewDeferLog()
+	ast, pass := js_parser.Parse(log, test.SourceForTest(string(code)), js_parser.Options{})
+	if !pass {
+		return
+	}
+	set = newStringSet()
+	for _, symbol := range ast.Symbols {
+		if symbol.Kind == js_ast.SymbolUnbound {
+			set.Add(symbol.OriginalName)
+		}
+	}
+	return set, true
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestInjectableNodeShims(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-shims-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	shims, err := writeInjectableNodeShims(wd, "es2020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := api.Build(api.BuildOptions{
+		Stdin: &api.StdinOptions{
+			Contents:   `export const s = "global.setImmediate __global$"; global.setImmediate(() => {}); export const d = global.document;`,
+			ResolveDir: wd,
+			Sourcefile: "export.js",
+		},
+		Outdir: "/esbuild",
+		Bundle: true,
+		Format: api.FormatESModule,
+		Define: map[string]string{
+			"global":              "__global$",
+			"global.setImmediate": "__setImmediate$",
+			"require.resolve":     "__rResolve$",
+		},
+		Inject:        shims,
+		AbsWorkingDir: wd,
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors[0].Text)
+	}
+	code := result.OutputFiles[0].Contents
+	globals, ok := unboundIdentifiers(code)
+	if !ok {
+		t.Fatalf("invalid output:\n%s", code)
+	}
+	for _, name := range []string{"__global$", "__setImmediate$", "__rResolve$"} {
+		if globals.Has(name) {
+			t.Fatalf("unexpected unbound %s:\n%s", name, code)
+		}
+	}
+	if !globals.Has("window") || !globals.Has("setTimeout") {
+		t.Fatalf("missing shims:\n%s", code)
+	}
+}
+
+func TestInjectableNodeShimsForWorkers(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-shims-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	shims, err := writeInjectableNodeShims(wd, "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := api.Build(api.BuildOptions{
+		Stdin:         &api.StdinOptions{Contents: `export const d = global.document;`, ResolveDir: wd, Sourcefile: "export.js"},
+		Outdir:        "/esbuild",
+		Bundle:        true,
+		Format:        api.FormatESModule,
+		Define:        map[string]string{"global": "__global$"},
+		Inject:        shims,
+		AbsWorkingDir: wd,
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors[0].Text)
+	}
+	code := result.OutputFiles[0].Contents
+	globals, ok := unboundIdentifiers(code)
+	if !ok || globals.Has("window") || !globals.Has("globalThis") {
+		t.Fatalf("the workers should use globalThis:\n%s", code)
+	}
+}
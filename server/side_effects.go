@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+var regBareImportStmt = regexp.MustCompile(`import\s*("[^"]*"|'[^']*')\s*;?`)
+
+// the heuristics to categorize the top-level side effects by the retained code
+var sideEffectPatterns = []struct {
+	kind string
+	reg  *regexp.Regexp
+}{
+	{"network", regexp.MustCompile(`\b(fetch|XMLHttpRequest|WebSocket|EventSource|sendBeacon)\b`)},
+	{"dom", regexp.MustCompile(`\b(document|HTMLElement|customElements|MutationObserver)\b`)},
+	{"storage", regexp.MustCompile(`\b(localStorage|sessionStorage|indexedDB|cookie)\b`)},
+	{"timer", regexp.MustCompile(`\b(setTimeout|setInterval|requestAnimationFrame|__setImmediate\$)\b`)},
+	{"listener", regexp.MustCompile(`\b(addEventListener|onerror|onload|onmessage)\b|\.on\(`)},
+	{"global", regexp.MustCompile(`\b(window|globalThis|self|__global\$)\s*\.\s*[a-zA-Z_$][\w$]*\s*=[^=]`)},
+	{"console", regexp.MustCompile(`\bconsole\s*\.`)},
+}
+
+// analyzeSideEffects imports the module for side effects only and lets esbuild tree-shake
+// it, any retained code means the module has top-level side effects, then the retained code
+// is categorized by some simple heuristics.
+func analyzeSideEffects(wd string, importPath string, plugin api.Plugin, define map[string]string) (effects []string, err error) {
+	result := api.Build(api.BuildOptions{
+		Stdin: &api.StdinOptions{
+			Contents:   `import "` + importPath + `";`,
+			ResolveDir: wd,
+			Sourcefile: "side_effects.js",
+		},
+		Outdir:            "/esbuild",
+		Write:             false,
+		Bundle:            true,
+		Target:            api.ESNext,
+		Format:            api.FormatESModule,
+		Platform:          api.PlatformBrowser,
+		MinifyWhitespace:  true,
+		MinifySyntax:      true,
+		MinifyIdentifiers: true,
+		Define:            define,
+		Plugins:           []api.Plugin{plugin},
+		AbsWorkingDir:     wd,
+	})
+	if len(result.Errors) > 0 {
+		err = errors.New("esbuild: " + result.Errors[0].Text)
+		return
+	}
+
+	for _, file := range result.OutputFiles {
+		if strings.HasSuffix(file.Path, ".js") {
+			effects = categorizeSideEffects(file.Contents)
+		}
+	}
+	return
+}
+
+func categorizeSideEffects(code []byte) []string {
+	code = bytes.TrimSpace(regBareImportStmt.ReplaceAll(code, nil))
+	if len(code) == 0 {
+		return nil
+	}
+
+	effects := []string{}
+	for _, p := range sideEffectPatterns {
+		if p.reg.Match(code) {
+			effects = append(effects, p.kind)
+		}
+	}
+	if len(effects) == 0 {
+		effects = append(effects, "unknown")
+	}
+	sort.Strings(effects)
+	return effects
+}
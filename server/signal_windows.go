@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"os"
+)
+
+var shutdownSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// An artifactSignature is the `.sig` sidecar of a build artifact, the signature signs the
+// sha-256 digest of the artifact.
+type artifactSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+	Digest    string `json:"digest"`
+	Signature string `json:"signature"`
+}
+
+// loadSigningKey loads the ed25519 key from the file that stores the base64 encoded seed, a
+// new key is generated if the file doesn't exist.
+func loadSigningKey(filename string) (key ed25519.PrivateKey, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return
+		}
+		err = ioutil.WriteFile(filename, []byte(base64.StdEncoding.EncodeToString(key.Seed())+"\n"), 0600)
+		return
+	}
+	if err != nil {
+		return
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key %s, should be a base64 encoded ed25519 seed", filename)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// signingKeyID returns the short id of the public key, the first 8 bytes of its sha-256 digest.
+func signingKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// currentSigningKeyID returns the id of the signing key, empty if the signing is disabled.
+func (s *Server) currentSigningKeyID() string {
+	if s.config == nil || s.config.signingKey == nil {
+		return ""
+	}
+	return signingKeyID(s.config.signingKey.Public().(ed25519.PublicKey))
+}
+
+func signContent(key ed25519.PrivateKey, content []byte) artifactSignature {
+	digest := sha256.Sum256(content)
+	return artifactSignature{
+		Algorithm: "ed25519",
+		KeyID:     signingKeyID(key.Public().(ed25519.PublicKey)),
+		Digest:    "sha256-" + base64.StdEncoding.EncodeToString(digest[:]),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest[:])),
+	}
+}
+
+// verifyContent reports whether the signature signs the sha-256 digest of the content with the
+// public key.
+func verifyContent(pub ed25519.PublicKey, content []byte, sig artifactSignature) bool {
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(content)
+	return ed25519.Verify(pub, digest[:], signature)
+}
+
+// signArtifact writes the `.sig` sidecar of the artifact if the signing is enabled.
+func (s *Server) signArtifact(filename string) error {
+	if s.config == nil || s.config.signingKey == nil {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	sig := signContent(s.config.signingKey, content)
+	return s.writeStorageFile(filename+".sig", bytes.NewReader(utils.MustEncodeJSON(sig)))
+}
+
+// writeArtifact writes the build artifact to the storage and signs it.
+func (s *Server) writeArtifact(filename string, contents ...io.Reader) error {
+	err := s.writeStorageFile(filename, contents...)
+	if err != nil {
+		return err
+	}
+	return s.signArtifact(filename)
+}
+
+// readArtifactSignature reads the `.sig` sidecar of the artifact.
+func readArtifactSignature(filename string) (sig artifactSignature, ok bool) {
+	data, err := ioutil.ReadFile(filename + ".sig")
+	if err != nil {
+		return
+	}
+	ok = json.Unmarshal(data, &sig) == nil && sig.Signature != ""
+	return
+}
+
+// publicKey handles the `/-/pubkey` requests.
+func (s *Server) publicKey(ctx *rex.Context) interface{} {
+	if s.config.signingKey == nil {
+		return rex.Status(404, "artifact signing is disabled")
+	}
+	pub := s.config.signingKey.Public().(ed25519.PublicKey)
+	ctx.SetHeader("Cache-Control", "public, max-age=3600")
+	return map[string]interface{}{
+		"algorithm": "ed25519",
+		"keyId":     s.currentSigningKeyID(),
+		"publicKey": base64.StdEncoding.EncodeToString(pub),
+	}
+}
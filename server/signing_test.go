@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ije/rex"
+)
+
+func TestArtifactSigning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-signing-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "signing.key")
+	key, err := loadSigningKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := loadSigningKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, reloaded) {
+		t.Fatal("the generated signing key should be reloaded")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "bad.key"), []byte("not a key"), 0600)
+	if _, err = loadSigningKey(filepath.Join(dir, "bad.key")); err == nil {
+		t.Fatal("the invalid signing key should be rejected")
+	}
+
+	s := &Server{config: &Config{signingKey: key}}
+
+	filename := filepath.Join(dir, "builds", "react.js")
+	err = s.writeArtifact(filename, bytes.NewReader([]byte("export default 1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, ok := readArtifactSignature(filename)
+	if !ok {
+		t.Fatal("missing the signature sidecar")
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	if sig.KeyID != signingKeyID(pub) || sig.Algorithm != "ed25519" {
+		t.Fatalf("unexpected signature %+v", sig)
+	}
+	if !verifyContent(pub, []byte("export default 1"), sig) {
+		t.Fatal("the signature should be verified")
+	}
+	if verifyContent(pub, []byte("export default 2"), sig) {
+		t.Fatal("the tampered content should not be verified")
+	}
+
+	req := httptest.NewRequest("GET", "http://esm.sh/-/pubkey", nil)
+	ctx := &rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}
+	ret, ok := s.publicKey(ctx).(map[string]interface{})
+	if !ok || ret["keyId"] != sig.KeyID || ret["publicKey"] != base64.StdEncoding.EncodeToString(pub) {
+		t.Fatalf("unexpected public key %v", ret)
+	}
+
+	// the signing is disabled
+	s.config = &Config{}
+	err = s.writeArtifact(filepath.Join(dir, "builds", "vue.js"), bytes.NewReader([]byte("export default 1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join(dir, "builds", "vue.js.sig")) {
+		t.Fatal("the artifact should not be signed")
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// the `//# sourceMappingURL` comment of the files published with the source maps
+var regSourceMappingURL = regexp.MustCompile(`(?m)^//[#@] sourceMappingURL=(\S+)[ \t]*$`)
+
+// the modes of the `?sourcemap` query
+var sourcemapModes = map[string]bool{
+	"external": true, // the `.js.map` file is stored alongside the build
+	"inline":   true, // the source map is inlined as a data URL
+}
+
+// fixSourceMap shifts the mappings of the source map for the header lines that are inserted
+// before the esbuild output, and rewrites the absolute source paths of the build working
+// directory to the paths relative to it, like `node_modules/react/index.js`.
+func fixSourceMap(data []byte, headerLines int, outdir string, wd string) ([]byte, error) {
+	var sm map[string]interface{}
+	err := json.Unmarshal(data, &sm)
+	if err != nil {
+		return nil, err
+	}
+	if mappings, ok := sm["mappings"].(string); ok {
+		sm["mappings"] = strings.Repeat(";", headerLines) + mappings
+	}
+	if sources, ok := sm["sources"].([]interface{}); ok {
+		for i, v := range sources {
+			source, ok := v.(string)
+			if !ok || strings.Contains(source, ":") {
+				continue
+			}
+			abs := filepath.Join(outdir, filepath.FromSlash(source))
+			if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				sources[i] = filepath.ToSlash(rel)
+			}
+		}
+	}
+	return json.Marshal(sm)
+}
+
+// sourceMappingURL returns the `//# sourceMappingURL` comment of the build.
+func sourceMappingURL(mode string, mapFileName string, data []byte) string {
+	if mode == "inline" {
+		return fmt.Sprintf("//# sourceMappingURL=data:application/json;charset=utf-8;base64,%s\n", base64.StdEncoding.EncodeToString(data))
+	}
+	return fmt.Sprintf("//# sourceMappingURL=%s\n", mapFileName)
+}
+
+// inlineSourceMap inlines the source map that is published with the file of the package, so
+// esbuild chains it into the source map of the build. The `sources` are resolved by the
+// `sourceRoot` to the paths relative to the file, and the missing `sourcesContent` are read
+// from the package files in the build working directory.
+func inlineSourceMap(filename string, data []byte, wd string) ([]byte, bool) {
+	matches := regSourceMappingURL.FindAllSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return data, false
+	}
+	m := matches[len(matches)-1]
+	mapURL := string(data[m[2]:m[3]])
+	if strings.HasPrefix(mapURL, "data:") || strings.Contains(mapURL, "://") {
+		return data, false
+	}
+	if p, err := url.PathUnescape(mapURL); err == nil {
+		mapURL = p
+	}
+	dir := filepath.Dir(filename)
+	mapFile := filepath.Join(dir, filepath.FromSlash(mapURL))
+	if !isInDir(mapFile, wd) {
+		return data, false
+	}
+	mapData, err := ioutil.ReadFile(mapFile)
+	if err != nil {
+		return data, false
+	}
+	var sm map[string]interface{}
+	if json.Unmarshal(mapData, &sm) != nil {
+		return data, false
+	}
+	sources, ok := sm["sources"].([]interface{})
+	if !ok {
+		return data, false
+	}
+	sourceRoot, _ := sm["sourceRoot"].(string)
+	delete(sm, "sourceRoot")
+	sourcesContent, _ := sm["sourcesContent"].([]interface{})
+	for len(sourcesContent) < len(sources) {
+		sourcesContent = append(sourcesContent, nil)
+	}
+	mapDir := filepath.Dir(mapFile)
+	for i, v := range sources {
+		source, ok := v.(string)
+		if !ok || strings.Contains(source, ":") {
+			continue
+		}
+		if sourceRoot != "" && !strings.Contains(sourceRoot, ":") {
+			source = path.Join(sourceRoot, source)
+		}
+		abs := filepath.Join(mapDir, filepath.FromSlash(source))
+		// the sources are resolved by the dir of the file instead of the map by esbuild
+		if rel, err := filepath.Rel(dir, abs); err == nil {
+			sources[i] = filepath.ToSlash(rel)
+		}
+		// never read the files out of the build working directory
+		if _, ok := sourcesContent[i].(string); !ok && isInDir(abs, wd) {
+			if content, err := ioutil.ReadFile(abs); err == nil {
+				sourcesContent[i] = string(content)
+			}
+		}
+	}
+	sm["sourcesContent"] = sourcesContent
+	mapData, err = json.Marshal(sm)
+	if err != nil {
+		return data, false
+	}
+	comment := sourceMappingURL("inline", "", mapData)
+	ret := make([]byte, 0, len(data)+len(comment))
+	ret = append(ret, data[:m[0]]...)
+	ret = append(ret, strings.TrimSuffix(comment, "\n")...)
+	ret = append(ret, data[m[1]:]...)
+	return ret, true
+}
+
+// isInDir reports whether the file is in the dir.
+func isInDir(filename string, dir string) bool {
+	rel, err := filepath.Rel(dir, filename)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
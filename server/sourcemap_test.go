@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestFixSourceMap(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-sourcemap-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	ensureDir(filepath.Join(wd, "node_modules", "a"))
+	ioutil.WriteFile(filepath.Join(wd, "node_modules", "a", "index.js"), []byte("export function hello(name) {\n  return `hello ${name}`\n}\n"), 0644)
+	result := api.Build(api.BuildOptions{
+		Stdin:         &api.StdinOptions{Contents: `export * from "a"`, ResolveDir: wd, Sourcefile: "export.js"},
+		Outdir:        "/esbuild",
+		Bundle:        true,
+		Format:        api.FormatESModule,
+		Sourcemap:     api.SourceMapExternal,
+		AbsWorkingDir: wd,
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors[0].Text)
+	}
+	var data []byte
+	for _, file := range result.OutputFiles {
+		if strings.HasSuffix(file.Path, ".js.map") {
+			data = file.Contents
+		}
+	}
+	if data == nil {
+		t.Fatal("missing source map")
+	}
+
+	fixed, err := fixSourceMap(data, 2, "/esbuild", wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sm, raw struct {
+		Sources  []string `json:"sources"`
+		Mappings string   `json:"mappings"`
+	}
+	json.Unmarshal(data, &raw)
+	json.Unmarshal(fixed, &sm)
+	if sm.Mappings != ";;"+raw.Mappings {
+		t.Fatalf("the mappings should be shifted by the header lines: %s", sm.Mappings)
+	}
+	found := false
+	for _, source := range sm.Sources {
+		if source == "node_modules/a/index.js" {
+			found = true
+		}
+		if strings.Contains(source, wd) || strings.HasPrefix(source, "..") {
+			t.Fatalf("the source path should be relative to the working directory: %s", source)
+		}
+	}
+	if !found {
+		t.Fatalf("unexpected sources %v", sm.Sources)
+	}
+
+	if c := sourceMappingURL("external", "a.js.map", fixed); c != "//# sourceMappingURL=a.js.map\n" {
+		t.Fatalf("unexpected comment %s", c)
+	}
+	if c := sourceMappingURL("inline", "a.js.map", fixed); !strings.HasPrefix(c, "//# sourceMappingURL=data:application/json;charset=utf-8;base64,") {
+		t.Fatalf("unexpected comment %s", c)
+	}
+}
+
+func TestInlineSourceMap(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-sourcemap-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	pkgDir := filepath.Join(wd, "node_modules", "a")
+	ensureDir(filepath.Join(pkgDir, "dist"))
+	ensureDir(filepath.Join(pkgDir, "src"))
+	ioutil.WriteFile(filepath.Join(pkgDir, "src", "index.ts"), []byte("export const a: number = 1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(pkgDir, "dist", "index.js.map"), []byte(`{"version":3,"sourceRoot":"../src","sources":["index.ts","../../../../secret.txt"],"names":[],"mappings":"AAAA"}`), 0644)
+	ioutil.WriteFile(filepath.Join(filepath.Dir(wd), "secret.txt"), []byte("secret"), 0644)
+	defer os.Remove(filepath.Join(filepath.Dir(wd), "secret.txt"))
+
+	data, ok := inlineSourceMap(filepath.Join(pkgDir, "dist", "index.js"), []byte("export const a = 1;\n//# sourceMappingURL=index.js.map\n"), wd)
+	if !ok {
+		t.Fatal("the source map should be inlined")
+	}
+	m := regSourceMappingURL.FindSubmatch(data)
+	prefix := "data:application/json;charset=utf-8;base64,"
+	if m == nil || !strings.HasPrefix(string(m[1]), prefix) {
+		t.Fatalf("unexpected output:\n%s", data)
+	}
+	mapData, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(m[1]), prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sm struct {
+		SourceRoot     *string   `json:"sourceRoot"`
+		Sources        []string  `json:"sources"`
+		SourcesContent []*string `json:"sourcesContent"`
+	}
+	json.Unmarshal(mapData, &sm)
+	if sm.SourceRoot != nil || len(sm.Sources) != 2 || sm.Sources[0] != "../src/index.ts" {
+		t.Fatalf("the sources should be resolved by the source root: %s", mapData)
+	}
+	if len(sm.SourcesContent) != 2 || sm.SourcesContent[0] == nil || *sm.SourcesContent[0] != "export const a: number = 1\n" {
+		t.Fatalf("the missing sources content should be read from the package: %s", mapData)
+	}
+	if sm.SourcesContent[1] != nil {
+		t.Fatalf("the files out of the working directory should never be read: %s", mapData)
+	}
+
+	if _, ok := inlineSourceMap(filepath.Join(pkgDir, "dist", "index.js"), []byte("export const a = 1;\n"), wd); ok {
+		t.Fatal("the file without source map should be kept as it is")
+	}
+	if _, ok := inlineSourceMap(filepath.Join(pkgDir, "dist", "index.js"), []byte("export const a = 1;\n//# sourceMappingURL=../../../../secret.txt\n"), wd); ok {
+		t.Fatal("the source map out of the working directory should never be read")
+	}
+}
+
+func TestPublishedSourceMap(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-sourcemap@1.0.0"), cjsExports: "auto", target: "es2020", sourcemap: "external"}
+	buildFixture(t, task)
+	data := readBuild(t, s, task.ID()+".js.map")
+	var sm struct {
+		Sources        []string `json:"sources"`
+		SourcesContent []string `json:"sourcesContent"`
+	}
+	json.Unmarshal([]byte(data), &sm)
+	for i, source := range sm.Sources {
+		if source == "node_modules/esm-fixture-sourcemap/src/index.ts" && i < len(sm.SourcesContent) && strings.Contains(sm.SourcesContent[i], "name: string") {
+			return
+		}
+	}
+	t.Fatalf("the published source map should be chained into the build:\n%s", data)
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the prefix of the stable paths that hide the build version, like
+// `/stable/react@17.0.2/es2020/react.js`
+const stableRoutePrefix = "/stable"
+
+// the stable paths are served by the current build version, so they are cached for a day
+// instead of forever
+const stableCacheControl = "public, max-age=86400"
+
+// stablePath maps the stable path to the path of the current build version, like
+// `/stable/react@17.0.2/es2020/react.js` to `/v36/react@17.0.2/es2020/react.js`.
+func stablePath(pathname string) (string, bool) {
+	if !strings.HasPrefix(pathname, stableRoutePrefix+"/") {
+		return pathname, false
+	}
+	return fmt.Sprintf("/v%d%s", VERSION, strings.TrimPrefix(pathname, stableRoutePrefix)), true
+}
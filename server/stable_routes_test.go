@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStablePath(t *testing.T) {
+	for pathname, expected := range map[string]string{
+		"/stable/react@17.0.2/es2020/react.js":                    fmt.Sprintf("/v%d/react@17.0.2/es2020/react.js", VERSION),
+		"/stable/@types/react@17.0.3/index.d.ts":                  fmt.Sprintf("/v%d/@types/react@17.0.3/index.d.ts", VERSION),
+		"/stable/react-dom@17.0.2/es2020/server.js.map":           fmt.Sprintf("/v%d/react-dom@17.0.2/es2020/server.js.map", VERSION),
+		"/stable-react@1.0.0":                                     "/stable-react@1.0.0",
+		"/react@17.0.2":                                           "/react@17.0.2",
+		fmt.Sprintf("/v%d/react@17.0.2/es2020/react.js", VERSION): fmt.Sprintf("/v%d/react@17.0.2/es2020/react.js", VERSION),
+	} {
+		p, ok := stablePath(pathname)
+		if p != expected || ok != (p != pathname) {
+			t.Fatalf("unexpected stable path of %s: %s, %v", pathname, p, ok)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/gox/utils"
+)
+
+// the shims of the node globals for the `?standalone` builds, they import the embedded
+// polyfills instead of `/v{VERSION}/_node_*.js`.
+var standaloneNodeShims = map[string]string{
+	"process.js": "import p from \"./node_process.js\";\np.env.NODE_ENV = \"%s\";\nexport { p as __process$ };\n",
+	"buffer.js":  "import { Buffer as b } from \"./node_buffer.js\";\nexport { b as __Buffer$ };\n",
+}
+
+// writeStandaloneNodeShims writes the standalone shims and the embedded polyfills to the
+// build directory and returns the shim files for the `Inject` option of esbuild. The files
+// are marked as side-effect free, so the unused shims are tree-shaken.
+func (s *Server) writeStandaloneNodeShims(wd string, env string) (files []string, err error) {
+	dir := filepath.Join(wd, "esm_sh_shims", "standalone")
+	err = ensureDir(dir)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"sideEffects": false}`), 0644)
+	if err != nil {
+		return
+	}
+	for _, name := range []string{"node_process.js", "node_buffer.js"} {
+		var data []byte
+		data, err = s.embedFS.ReadFile("embed/polyfills/" + name)
+		if err != nil {
+			return
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+		if err != nil {
+			return
+		}
+	}
+	for _, name := range []string{"buffer.js", "process.js"} {
+		code := standaloneNodeShims[name]
+		if name == "process.js" {
+			code = fmt.Sprintf(code, env)
+		}
+		filename := filepath.Join(dir, name)
+		err = ioutil.WriteFile(filename, []byte(code), 0644)
+		if err != nil {
+			return
+		}
+		files = append(files, filename)
+	}
+	return
+}
+
+// embeddedPolyfillPath returns the path of the embedded polyfill of the node builtin module.
+func embeddedPolyfillPath(name string) string {
+	return fmt.Sprintf("embed/polyfills/node_%s.js", name)
+}
+
+func (s *Server) hasEmbeddedPolyfill(name string) bool {
+	f, err := s.embedFS.Open(embeddedPolyfillPath(name))
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// A nodePolyfillLoader loads the node builtin modules of the `?standalone` builds in the
+// `onLoad` hook of esbuild: the embedded polyfills are inlined, and the polyfill packages
+// like `path-browserify` are installed to be bundled.
+type nodePolyfillLoader struct {
+	ctx       context.Context
+	task      *buildTask
+	lock      sync.Mutex
+	installed map[string]bool
+}
+
+func newNodePolyfillLoader(ctx context.Context, task *buildTask) *nodePolyfillLoader {
+	return &nodePolyfillLoader{
+		ctx:       ctx,
+		task:      task,
+		installed: map[string]bool{},
+	}
+}
+
+func (l *nodePolyfillLoader) Load(args api.OnLoadArgs) (api.OnLoadResult, error) {
+	name := args.Path
+	var code string
+	if data, err := l.task.embedFS.ReadFile(embeddedPolyfillPath(name)); err == nil {
+		code = string(data)
+	} else if polyfill, ok := polyfilledBuiltInNodeModules[name]; ok {
+		err := l.install(polyfill)
+		if err != nil {
+			return api.OnLoadResult{}, err
+		}
+		// require the installed path since the polyfill may have the same name as the builtin
+		// module, like `events`
+		filename := filepath.Join(l.task.wd, "node_modules", polyfill)
+		code = fmt.Sprintf("module.exports = require(%s);\n", strings.TrimSpace(string(utils.MustEncodeJSON(filename))))
+	} else if l.task.target == "workers" {
+		return api.OnLoadResult{}, fmt.Errorf("unsupported nodejs builtin module \"%s\" in the workers target", name)
+	} else {
+		code = fmt.Sprintf("throw new Error(\"[esm.sh] Unsupported nodejs builtin module \\\"%s\\\"\");\n", name)
+	}
+	return api.OnLoadResult{
+		Contents:   &code,
+		ResolveDir: l.task.wd,
+		Loader:     api.LoaderJS,
+	}, nil
+}
+
+// install installs the polyfill package, the esbuild calls the hooks concurrently but yarn
+// can't run in the same directory concurrently.
+func (l *nodePolyfillLoader) install(polyfill string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	name, _ := splitPkgPath(polyfill)
+	if l.installed[name] || fileExists(filepath.Join(l.task.wd, "node_modules", name, "package.json")) {
+		return nil
+	}
+	info, _, err := l.task.getPackageInfo(name, "latest")
+	if err != nil {
+		return err
+	}
+	err = l.task.installPackages(l.ctx, l.task.wd, fmt.Sprintf("%s@%s", info.Name, info.Version))
+	if err != nil {
+		return err
+	}
+	l.installed[name] = true
+	return nil
+}
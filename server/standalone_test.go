@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"embed"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestNodePolyfillLoader(t *testing.T) {
+	wd, err := ioutil.TempDir("", "esm-standalone-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	// the installed polyfill package
+	ensureDir(filepath.Join(wd, "node_modules", "path-browserify"))
+	ioutil.WriteFile(filepath.Join(wd, "node_modules", "path-browserify", "package.json"), []byte(`{"name": "path-browserify", "main": "index.js"}`), 0644)
+	ioutil.WriteFile(filepath.Join(wd, "node_modules", "path-browserify", "index.js"), []byte(`exports.join = function browserifyJoin() {}`), 0644)
+
+	polyfills := newNodePolyfillLoader(context.Background(), &buildTask{Server: &Server{embedFS: &embed.FS{}}, wd: wd})
+	plugin := api.Plugin{
+		Name: "node-polyfill",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{Filter: ".*"}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				if builtInNodeModules[args.Path] {
+					return api.OnResolveResult{Path: args.Path, Namespace: "esm-sh-node-polyfill"}, nil
+				}
+				return api.OnResolveResult{}, nil
+			})
+			build.OnLoad(api.OnLoadOptions{Filter: ".*", Namespace: "esm-sh-node-polyfill"}, polyfills.Load)
+		},
+	}
+	result := api.Build(api.BuildOptions{
+		Stdin:         &api.StdinOptions{Contents: "import { join } from 'path'\nimport fs from 'fs'\nexport { join, fs }\n", ResolveDir: wd, Sourcefile: "export.js"},
+		Outdir:        "/esbuild",
+		Bundle:        true,
+		Format:        api.FormatESModule,
+		Plugins:       []api.Plugin{plugin},
+		AbsWorkingDir: wd,
+	})
+	if len(result.Errors) > 0 {
+		t.Fatal(result.Errors[0].Text)
+	}
+	code := string(result.OutputFiles[0].Contents)
+	for _, s := range []string{"browserifyJoin", `Unsupported nodejs builtin module "fs"`} {
+		if !strings.Contains(code, s) {
+			t.Fatalf("missing %s in:\n%s", s, code)
+		}
+	}
+	if strings.Contains(code, "import ") {
+		t.Fatalf("the standalone build should not import any module:\n%s", code)
+	}
+}
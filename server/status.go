@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+// status handles the `/-/status` requests.
+func status(ctx *rex.Context, queue *buildQueue, startTime time.Time) interface{} {
+	queued, processing := queue.Stats()
+	host := telemetry.Stats()
+	tarpitted, blockedTotal := abuse.Stats()
+	usagePackages, usageReports := usage.Stats()
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	return map[string]interface{}{
+		"version": VERSION,
+		"uptime":  time.Now().Sub(startTime).Round(time.Second).String(),
+		"queue": map[string]interface{}{
+			"queued":     queued,
+			"processing": processing,
+			"throttled":  queue.Throttled(),
+			"coalesced":  atomic.LoadUint64(&coalescedBuilds),
+			"shed":       atomic.LoadUint64(&shedBuilds),
+		},
+		"cache": map[string]interface{}{
+			"hits":   atomic.LoadUint64(&cacheHits),
+			"misses": atomic.LoadUint64(&cacheMisses),
+		},
+		"host": host,
+		"abuse": map[string]interface{}{
+			"tarpitted": tarpitted,
+			"blocked":   blockedTotal,
+		},
+		"usage": map[string]interface{}{
+			"packages": usagePackages,
+			"reports":  usageReports,
+		},
+		"tarballChanges": atomic.LoadUint64(&tarballChanges),
+	}
+}
+
+// metrics handles the `/-/metrics` requests in the prometheus text format.
+func metrics(ctx *rex.Context, queue *buildQueue, startTime time.Time) interface{} {
+	queued, processing := queue.Stats()
+	host := telemetry.Stats()
+	tarpitted, blockedTotal := abuse.Stats()
+	usagePackages, usageReports := usage.Stats()
+	throttled := 0
+	if queue.Throttled() {
+		throttled = 1
+	}
+
+	buf := bytes.NewBuffer(nil)
+	metric := func(typ string, name string, help string, value interface{}) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+	}
+	gauge := func(name string, help string, value interface{}) {
+		metric("gauge", name, help, value)
+	}
+	gauge("esmd_uptime_seconds", "Seconds since the server started.", int64(time.Now().Sub(startTime).Seconds()))
+	gauge("esmd_build_queue_queued", "Build tasks waiting in the queue.", queued)
+	gauge("esmd_build_queue_processing", "Build tasks in process.", processing)
+	gauge("esmd_build_queue_throttled", "Whether the queue is throttled by the memory pressure.", throttled)
+	metric("counter", "esmd_build_coalesced_total", "Build requests that wait on an in-flight build of the same ID.", atomic.LoadUint64(&coalescedBuilds))
+	metric("counter", "esmd_build_shed_total", "Build requests that are rejected by the load shedding.", atomic.LoadUint64(&shedBuilds))
+	metric("counter", "esmd_build_cache_hits_total", "Build requests that are served from the cached builds.", atomic.LoadUint64(&cacheHits))
+	metric("counter", "esmd_build_cache_misses_total", "Build requests that need a new build.", atomic.LoadUint64(&cacheMisses))
+	gauge("esmd_host_cpus", "Number of CPUs.", host.CPUs)
+	fmt.Fprintf(buf, "# HELP esmd_host_load Load average of the host.\n# TYPE esmd_host_load gauge\n")
+	for i, period := range []string{"1m", "5m", "15m"} {
+		fmt.Fprintf(buf, "esmd_host_load{period=\"%s\"} %v\n", period, host.Load[i])
+	}
+	gauge("esmd_host_memory_total_bytes", "Total memory of the host.", host.MemTotal)
+	gauge("esmd_host_memory_available_bytes", "Available memory of the host.", host.MemAvailable)
+	gauge("esmd_tmp_dir_usage_bytes", "Disk usage of the build working directories.", host.TmpDirUsage)
+	gauge("esmd_yarn_cache_bytes", "Disk usage of the yarn cache.", host.YarnCacheSize)
+	metric("counter", "esmd_abuse_tarpitted_total", "Invalid requests that are tarpitted.", tarpitted)
+	metric("counter", "esmd_abuse_blocked_total", "Clients that are blocked for sending floods of invalid requests.", blockedTotal)
+	gauge("esmd_usage_packages", "Packages that have the usage reports.", usagePackages)
+	metric("counter", "esmd_usage_reports_total", "Usage reports of the package exports.", usageReports)
+	metric("counter", "esmd_tarball_changes_total", "Package versions that the registry serves a changed tarball.", atomic.LoadUint64(&tarballChanges))
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	ctx.SetHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	return buf.String()
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ije/rex"
+)
+
+// the max number of the items in the storage usage report by default
+const defaultStorageUsageLimit = 100
+
+// A storageUsage is the bytes used by a package(or a package version) in the storages.
+type storageUsage struct {
+	Name   string `json:"name"`
+	Builds int64  `json:"builds"`
+	Types  int64  `json:"types"`
+	Raw    int64  `json:"raw"`
+	Total  int64  `json:"total"`
+}
+
+func (u *storageUsage) add(storageType string, size int64) {
+	switch storageType {
+	case "builds":
+		u.Builds += size
+	case "types":
+		u.Types += size
+	case "raw":
+		u.Raw += size
+	}
+	u.Total += size
+}
+
+// storagePackageDirs returns the directories of the packages like `react@17.0.2` in the
+// storage of the type, the builds and the types are grouped by the build versions like `v36`.
+func (s *Server) storagePackageDirs(storageType string) (dirs []string) {
+	root := filepath.Join(s.config.storageDir, storageType)
+	roots := []string{root}
+	if storageType != "raw" {
+		roots = nil
+		entries, _ := ioutil.ReadDir(root)
+		for _, entry := range entries {
+			if entry.IsDir() && strings.HasPrefix(entry.Name(), "v") {
+				roots = append(roots, filepath.Join(root, entry.Name()))
+			}
+		}
+	}
+	for _, dir := range roots {
+		entries, _ := ioutil.ReadDir(dir)
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, "@") && entry.IsDir() {
+				scoped, _ := ioutil.ReadDir(filepath.Join(dir, name))
+				for _, e := range scoped {
+					dirs = append(dirs, filepath.Join(dir, name, e.Name()))
+				}
+			} else {
+				dirs = append(dirs, filepath.Join(dir, name))
+			}
+		}
+	}
+	return
+}
+
+// measureStorageUsage returns the bytes used by the packages in descending order, grouped by
+// the package name(`package`) or the package version(`version`). the files that don't belong
+// to a package, like the polyfills, are only counted in the total.
+func (s *Server) measureStorageUsage(group string) (items []storageUsage, total storageUsage) {
+	usages := map[string]*storageUsage{}
+	for _, storageType := range []string{"builds", "types", "raw"} {
+		for _, dir := range s.storagePackageDirs(storageType) {
+			size := dirSize(dir)
+			total.add(storageType, size)
+
+			base := filepath.Base(dir)
+			if scope := filepath.Base(filepath.Dir(dir)); strings.HasPrefix(scope, "@") {
+				base = scope + "/" + base
+			}
+			name, version := splitPkgVersion(base)
+			if !regPkgName.MatchString(name) || version == "" {
+				continue
+			}
+			key := name
+			if group == "version" {
+				key = base
+			}
+			u, ok := usages[key]
+			if !ok {
+				u = &storageUsage{Name: key}
+				usages[key] = u
+			}
+			u.add(storageType, size)
+		}
+	}
+	items = make([]storageUsage, 0, len(usages))
+	for _, u := range usages {
+		items = append(items, *u)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Total == items[j].Total {
+			return items[i].Name < items[j].Name
+		}
+		return items[i].Total > items[j].Total
+	})
+	total.Name = "*"
+	return
+}
+
+// storageUsageReport handles the `GET /-/admin/storage?group=package` requests of the admin,
+// it reports the bytes used by the packages in the builds, types and raw storages.
+func (s *Server) storageUsageReport(ctx *rex.Context) interface{} {
+	if s.config.adminToken == "" {
+		return rex.Err(404)
+	}
+	if !s.isAdminRequest(ctx, false) {
+		return rex.Err(401)
+	}
+	if ctx.R.Method != "GET" {
+		ctx.SetHeader("Allow", "GET")
+		return rex.Status(405, "method not allowed")
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	group := ctx.Form.Value("group")
+	if group == "" {
+		group = "package"
+	}
+	if group != "package" && group != "version" {
+		return rex.Status(400, fmt.Sprintf("invalid group '%s', available groups: package, version", group))
+	}
+	limit := defaultStorageUsageLimit
+	if v := ctx.Form.Value("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return rex.Status(400, fmt.Sprintf("invalid limit '%s'", v))
+		}
+		limit = n
+	}
+
+	items, total := s.measureStorageUsage(group)
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return map[string]interface{}{
+		"group": group,
+		"total": total,
+		"items": items,
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ije/rex"
+)
+
+func TestStorageUsage(t *testing.T) {
+	s := setupTestEnv(t)
+	s.config.adminToken = "secret"
+
+	for filename, size := range map[string]int{
+		fmt.Sprintf("builds/v%d/react@17.0.2/es2020/react.js", VERSION):   100,
+		fmt.Sprintf("builds/v%d/react@16.14.0/es2020/react.js", VERSION):  50,
+		"builds/v35/react@16.14.0/es2020/react.js":                        10,
+		fmt.Sprintf("types/v%d/react@17.0.2/index.d.ts", VERSION):         20,
+		"raw/react@17.0.2/index.js":                                       5,
+		fmt.Sprintf("builds/v%d/@scope/pkg@1.0.0/es2020/pkg.js", VERSION): 120,
+		fmt.Sprintf("builds/v%d/_node_buffer.js", VERSION):                7,
+	} {
+		filename = filepath.Join(s.config.storageDir, filename)
+		if err := ensureDir(filepath.Dir(filename)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, total := s.measureStorageUsage("package")
+	if len(items) != 2 || total.Total != 312 || total.Builds != 287 {
+		t.Fatalf("unexpected usage %v, %v", items, total)
+	}
+	if items[0] != (storageUsage{Name: "react", Builds: 160, Types: 20, Raw: 5, Total: 185}) {
+		t.Fatalf("unexpected usage %v", items[0])
+	}
+	if items[1] != (storageUsage{Name: "@scope/pkg", Builds: 120, Total: 120}) {
+		t.Fatalf("unexpected usage %v", items[1])
+	}
+	items, _ = s.measureStorageUsage("version")
+	if len(items) != 3 || items[0].Name != "react@17.0.2" || items[0].Total != 125 || items[2].Name != "react@16.14.0" {
+		t.Fatalf("unexpected usage %v", items)
+	}
+
+	call := func(token string, query string) interface{} {
+		req := httptest.NewRequest("GET", "http://esm.sh/-/admin/storage"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return s.storageUsageReport(&rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}})
+	}
+	if _, ok := call("wrong", "").(map[string]interface{}); ok {
+		t.Fatal("the request without the admin token should be rejected")
+	}
+	if _, ok := call("secret", "?group=file").(map[string]interface{}); ok {
+		t.Fatal("the invalid group should be rejected")
+	}
+	ret, ok := call("secret", "?group=package&limit=1").(map[string]interface{})
+	if !ok || ret["group"] != "package" || len(ret["items"].([]storageUsage)) != 1 {
+		t.Fatalf("unexpected report %v", ret)
+	}
+}
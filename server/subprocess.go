@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	logx "github.com/ije/gox/log"
+)
+
+// the max length of the subprocess output that is written to the log
+const maxLoggedOutput = 4 * 1024
+
+type procOptions struct {
+	Dir   string
+	Stdin io.Reader
+	// kill the process(and the processes it creates) after the timeout
+	Timeout time.Duration
+	// the writer that the combined output is also written to, like the build log
+	Output io.Writer
+	// the config of the proc limits and the proxy env, and the logger of the output, they are
+	// set by `Server.runProc`
+	config *Config
+	log    *logx.Logger
+}
+
+// a lockedBuffer is written by the stdout and stderr pipes concurrently
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// runProc runs the command with the proc limits and the proxy env of the server, the output is
+// written to the log of the server. the subprocesses run with the `proc-nice` config, and are
+// limited by the `proc-cpu-limit` config on unix.
+func (s *Server) runProc(ctx context.Context, opts procOptions, name string, args ...string) (stdout []byte, output []byte, err error) {
+	opts.config = s.config
+	opts.log = s.log
+	return runProc(ctx, opts, name, args...)
+}
+
+// runProc runs the command and returns the stdout and the combined output. the process
+// group is killed when the context is canceled or timed out, then the process is always
+// waited to avoid zombies.
+func runProc(ctx context.Context, opts procOptions, name string, args ...string) (stdout []byte, output []byte, err error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	var stdoutBuf bytes.Buffer
+	var combined lockedBuffer
+	cmd := newProcCommand(opts.config, name, args...)
+	cmd.Dir = opts.Dir
+	cmd.Stdin = opts.Stdin
+	if env := opts.config.proxyEnv(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var combinedWriter io.Writer = &combined
+	if opts.Output != nil {
+		combinedWriter = io.MultiWriter(&combined, opts.Output)
+	}
+	cmd.Stdout = io.MultiWriter(&stdoutBuf, combinedWriter)
+	cmd.Stderr = combinedWriter
+
+	start := time.Now()
+	err = cmd.Start()
+	if err != nil {
+		return
+	}
+	setProcPriority(opts.config, cmd)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProc(cmd)
+		<-done
+		err = fmt.Errorf("%s: %v", name, ctx.Err())
+	}
+
+	stdout = stdoutBuf.Bytes()
+	output = combined.buf.Bytes()
+	if opts.log != nil {
+		logged := output
+		if len(logged) > maxLoggedOutput {
+			logged = logged[len(logged)-maxLoggedOutput:]
+		}
+		opts.log.Debugf("%s %s in %v (%v)\n%s", name, strings.Join(args, " "), time.Now().Sub(start), err, bytes.TrimSpace(logged))
+	}
+	return
+}
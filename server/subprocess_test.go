@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunProc(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	stdout, output, err := runProc(context.Background(), procOptions{Stdin: strings.NewReader("esm")}, "sh", "-c", "cat; echo ' warn' >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the order of the stdout and stderr in the combined output is not guaranteed
+	if string(stdout) != "esm" || len(output) != 9 || !strings.Contains(string(output), "esm") || !strings.Contains(string(output), " warn\n") {
+		t.Fatalf("unexpected output: %q, %q", stdout, output)
+	}
+
+	start := time.Now()
+	// the subprocess created by the shell should be killed too
+	_, _, err = runProc(context.Background(), procOptions{Timeout: 100 * time.Millisecond}, "sh", "-c", "sleep 10 | cat")
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("should be timed out: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("the process is not killed")
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+func newProcCommand(config *Config, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	if config != nil && config.procCPULimit > 0 {
+		// keep the `exec.ErrNotFound` error if the command is not found
+		if filename, err := exec.LookPath(name); err == nil {
+			script := fmt.Sprintf(`ulimit -t %d && exec "$@"`, config.procCPULimit)
+			cmd = exec.Command("sh", append([]string{"-c", script, "sh", filename}, args...)...)
+		}
+	}
+	// run the process in a new process group to kill the processes it creates
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+func setProcPriority(config *Config, cmd *exec.Cmd) {
+	if config != nil && config.procNice > 0 {
+		syscall.Setpriority(syscall.PRIO_PGRP, cmd.Process.Pid, config.procNice)
+	}
+}
+
+func killProc(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
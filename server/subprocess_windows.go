@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"os/exec"
+)
+
+func newProcCommand(config *Config, name string, args ...string) *exec.Cmd {
+	return exec.Command(name, args...)
+}
+
+func setProcPriority(config *Config, cmd *exec.Cmd) {}
+
+func killProc(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/rex"
+	"github.com/mssola/user_agent"
+	"github.com/postui/postdb/q"
+)
+
+// the ES targets in ascending order
+var esTargets = []string{"es2015", "es2016", "es2017", "es2018", "es2019", "es2020", "es2021", "es2022", "esnext"}
+
+// A targetError is the build error of the syntax that esbuild can't transform to the target,
+// like the async generators for es2015.
+type targetError struct {
+	pkg       pkg
+	target    string
+	minTarget string // empty if the package can't be built for any target
+	message   string
+}
+
+func (e *targetError) Error() string {
+	if e.minTarget == "" {
+		return fmt.Sprintf("esbuild: %s", e.message)
+	}
+	return fmt.Sprintf("esbuild: %s; %s can't be built for '%s', please use the '?target=%s' query or higher", e.message, e.pkg, e.target, e.minTarget)
+}
+
+// targetNames returns the names of the supported build targets.
+func targetNames() []string {
+	return append(append([]string{}, esTargets...), "deno", "node", "bun", "workers")
+}
+
+// listTargets handles the `/-/targets` requests.
+func listTargets(ctx *rex.Context) interface{} {
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	return map[string]interface{}{
+		"targets": targetNames(),
+	}
+}
+
+// detectTarget returns the build target for the `User-Agent`, the runtimes like Deno, Bun and
+// Node.js have their own targets, the browsers get the highest ES target that they support
+// and the unknown clients get es2015.
+func detectTarget(ua string) string {
+	if strings.HasPrefix(ua, "Deno/") {
+		return "deno"
+	}
+	if strings.HasPrefix(ua, "Bun/") {
+		return "bun"
+	}
+	if ua == "node" || startsWith(ua, "Node/", "Node.js/", "node-fetch/", "undici") {
+		return "node"
+	}
+	name, version := user_agent.New(ua).Browser()
+	engine, ok := engines[strings.ToLower(name)]
+	if !ok {
+		return "es2015"
+	}
+	a := strings.Split(version, ".")
+	if len(a) > 3 {
+		version = strings.Join(a[:3], ".")
+	}
+	e := api.Engine{Name: engine, Version: version}
+	// nothing is lowered for es2022, and es2021 is skipped since it's lowered like es2020
+	if supportsES2020Lowered(e) {
+		return "es2022"
+	}
+	unsupportedEngineFeatures := validateEngineFeatures(e)
+	for _, t := range []string{"es2020", "es2019", "es2018", "es2017", "es2016"} {
+		if unsupportedEngineFeatures <= validateESMAFeatures(targets[t]) {
+			return t
+		}
+	}
+	return "es2015"
+}
+
+func isUnsupportedSyntaxError(text string) bool {
+	return strings.Contains(text, "the configured target environment")
+}
+
+// higherTargets returns the ES targets that are higher than the target.
+func higherTargets(target string) []string {
+	for i, t := range esTargets {
+		if t == target {
+			return esTargets[i+1:]
+		}
+	}
+	return nil
+}
+
+// isLowerTarget reports whether the ES target is lower than the other one.
+func isLowerTarget(target string, other string) bool {
+	return includes(higherTargets(target), other)
+}
+
+// findMinViableTarget builds the entry with the higher targets to find the minimum target that
+// the package can be built for.
+func findMinViableTarget(options api.BuildOptions, target string) string {
+	for _, t := range higherTargets(target) {
+		options.Target = targets[t]
+		result := api.Build(options)
+		if len(result.Errors) == 0 || !isUnsupportedSyntaxError(result.Errors[0].Text) {
+			return t
+		}
+	}
+	return ""
+}
+
+func minTargetKey(m pkg) string {
+	return fmt.Sprintf("min-target:%s", m)
+}
+
+// recordMinTarget stores the minimum viable target of the package for the meta API.
+func (s *Server) recordMinTarget(m pkg, target string) {
+	_, err := s.db.Put(q.Alias(minTargetKey(m)), q.KV{"target": []byte(target)})
+	if err != nil {
+		s.log.Warnf("record the min target of %s: %v", m, err)
+	}
+}
+
+// findMinTarget returns the recorded minimum viable target of the package.
+func (s *Server) findMinTarget(m pkg) string {
+	post, err := s.db.Get(q.Alias(minTargetKey(m)), q.K("target"))
+	if err != nil {
+		return ""
+	}
+	return string(post.KV.Get("target"))
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+func TestFindMinViableTarget(t *testing.T) {
+	s := setupTestEnv(t)
+
+	options := api.BuildOptions{
+		Stdin:  &api.StdinOptions{Contents: "export async function* ticks() { yield 1 }\n", Sourcefile: "export.js"},
+		Outdir: "/esbuild",
+		Bundle: true,
+		Format: api.FormatESModule,
+		Target: api.ES2015,
+	}
+	result := api.Build(options)
+	if len(result.Errors) == 0 || !isUnsupportedSyntaxError(result.Errors[0].Text) {
+		t.Fatalf("the async generators should not be supported for es2015: %v", result.Errors)
+	}
+	minTarget := findMinViableTarget(options, "es2015")
+	if minTarget != "es2018" {
+		t.Fatalf("unexpected min target %s", minTarget)
+	}
+	if findMinViableTarget(options, "esnext") != "" {
+		t.Fatal("there is no target higher than esnext")
+	}
+
+	m := pkg{name: "ticks", version: "1.0.0"}
+	err := &targetError{m, "es2015", minTarget, result.Errors[0].Text}
+	if !strings.HasSuffix(err.Error(), "ticks@1.0.0 can't be built for 'es2015', please use the '?target=es2018' query or higher") {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if s.findMinTarget(m) != "" {
+		t.Fatal("the min target should not be recorded")
+	}
+	s.recordMinTarget(m, minTarget)
+	if s.findMinTarget(m) != "es2018" {
+		t.Fatal("the min target should be recorded")
+	}
+}
+
+func TestIsLowerTarget(t *testing.T) {
+	for _, c := range []struct {
+		target string
+		other  string
+		expect bool
+	}{
+		{"es2015", "es2018", true},
+		{"es2019", "esnext", true},
+		{"es2018", "es2018", false},
+		{"es2020", "es2017", false},
+		{"deno", "esnext", false},
+		{"es2015", "", false},
+	} {
+		if isLowerTarget(c.target, c.other) != c.expect {
+			t.Fatalf("isLowerTarget(%s, %s) should be %v", c.target, c.other, c.expect)
+		}
+	}
+}
+
+func TestTargetNames(t *testing.T) {
+	names := targetNames()
+	if len(names) != len(targets) {
+		t.Fatalf("the target names %v don't match the targets", names)
+	}
+	for _, name := range names {
+		if _, ok := targets[name]; !ok {
+			t.Fatalf("unknown target %s", name)
+		}
+	}
+	if !isLowerTarget("es2020", "es2021") || !isLowerTarget("es2022", "esnext") {
+		t.Fatal("the es2021 and es2022 targets should be ordered")
+	}
+}
+
+func TestDetectTarget(t *testing.T) {
+	for ua, expected := range map[string]string{
+		"Deno/1.9.2":     "deno",
+		"Bun/1.0.0":      "bun",
+		"Node.js/16.0.0": "node",
+		"node-fetch/1.0 (+https://github.com/bitinn/node-fetch)": "node",
+		"undici":      "node",
+		"curl/7.64.1": "es2015",
+		"":            "es2015",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/61.0.3163.100 Safari/537.36":  "es2017",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.100 Safari/537.36":  "es2020",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36": "es2022",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:88.0) Gecko/20100101 Firefox/88.0":                                       "es2020",
+	} {
+		if target := detectTarget(ua); target != expected {
+			t.Fatalf("the target of '%s' should be %s, but got %s", ua, expected, target)
+		}
+	}
+}
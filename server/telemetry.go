@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostStats is the resource usage of the build host, the load and memory stats are only
+// available on linux.
+type HostStats struct {
+	CPUs          int        `json:"cpus"`
+	Load          [3]float64 `json:"load"`
+	MemTotal      uint64     `json:"memTotal"`
+	MemAvailable  uint64     `json:"memAvailable"`
+	TmpDirUsage   int64      `json:"tmpDirUsage"`
+	YarnCacheSize int64      `json:"yarnCacheSize"`
+}
+
+// MemPressure returns the ratio of the used memory, 0 if unknown.
+func (s HostStats) MemPressure() float64 {
+	if s.MemTotal == 0 || s.MemAvailable > s.MemTotal {
+		return 0
+	}
+	return 1 - float64(s.MemAvailable)/float64(s.MemTotal)
+}
+
+type hostTelemetry struct {
+	lock  sync.RWMutex
+	stats HostStats
+}
+
+var telemetry = &hostTelemetry{}
+
+// Stats returns the last sampled stats.
+func (t *hostTelemetry) Stats() HostStats {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.stats
+}
+
+// startTelemetry samples the load and memory every 2 seconds, and the disk usage every 5
+// minutes that walks the directories.
+func startTelemetry() {
+	telemetry.sampleSys()
+	go telemetry.sampleDisk()
+	go func() {
+		tick := time.NewTicker(2 * time.Second)
+		defer tick.Stop()
+		n := 0
+		for range tick.C {
+			telemetry.sampleSys()
+			n++
+			if n%150 == 0 {
+				telemetry.sampleDisk()
+			}
+		}
+	}()
+}
+
+func (t *hostTelemetry) sampleSys() {
+	load, _ := readLoadAvg("/proc/loadavg")
+	memTotal, memAvailable, _ := readMemInfo("/proc/meminfo")
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.stats.CPUs = runtime.NumCPU()
+	t.stats.Load = load
+	t.stats.MemTotal = memTotal
+	t.stats.MemAvailable = memAvailable
+}
+
+func (t *hostTelemetry) sampleDisk() {
+	var tmpDirUsage int64
+	entries, err := ioutil.ReadDir(os.TempDir())
+	if err == nil {
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() && (strings.HasPrefix(name, "esm-build-") || strings.HasPrefix(name, "esmd-")) {
+				tmpDirUsage += dirSize(filepath.Join(os.TempDir(), name))
+			}
+		}
+	}
+	var yarnCacheSize int64
+	if dir := yarnCacheDir(); dir != "" {
+		yarnCacheSize = dirSize(dir)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.stats.TmpDirUsage = tmpDirUsage
+	t.stats.YarnCacheSize = yarnCacheSize
+}
+
+func readLoadAvg(filename string) (load [3]float64, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(data))
+	for i := 0; i < 3 && i < len(fields); i++ {
+		load[i], err = strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readMemInfo returns the `MemTotal` and `MemAvailable` of the `/proc/meminfo` in bytes.
+func readMemInfo(filename string) (total uint64, available uint64, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		var v *uint64
+		switch fields[0] {
+		case "MemTotal:":
+			v = &total
+		case "MemAvailable:":
+			v = &available
+		default:
+			continue
+		}
+		kb, e := strconv.ParseUint(fields[1], 10, 64)
+		if e != nil {
+			return 0, 0, e
+		}
+		*v = kb * 1024
+	}
+	return
+}
+
+var yarnCacheDirOnce struct {
+	sync.Once
+	dir string
+}
+
+func yarnCacheDir() string {
+	yarnCacheDirOnce.Do(func() {
+		if dir := os.Getenv("YARN_CACHE_FOLDER"); dir != "" {
+			yarnCacheDirOnce.dir = dir
+			return
+		}
+		stdout, _, err := runProc(context.Background(), procOptions{Timeout: 30 * time.Second}, "yarn", "cache", "dir")
+		if err == nil {
+			yarnCacheDirOnce.dir = strings.TrimSpace(string(stdout))
+		}
+	})
+	return yarnCacheDirOnce.dir
+}
+
+func dirSize(dir string) (size int64) {
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadHostStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-telemetry-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	loadavg := filepath.Join(dir, "loadavg")
+	meminfo := filepath.Join(dir, "meminfo")
+	ioutil.WriteFile(loadavg, []byte("0.52 1.25 2.00 3/512 12345\n"), 0644)
+	ioutil.WriteFile(meminfo, []byte("MemTotal:        8000000 kB\nMemFree:          500000 kB\nMemAvailable:     2000000 kB\nBuffers:          100000 kB\n"), 0644)
+
+	load, err := readLoadAvg(loadavg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if load != [3]float64{0.52, 1.25, 2} {
+		t.Fatalf("unexpected load %v", load)
+	}
+
+	total, available, err := readMemInfo(meminfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 8000000*1024 || available != 2000000*1024 {
+		t.Fatalf("unexpected meminfo %d/%d", available, total)
+	}
+	stats := HostStats{MemTotal: total, MemAvailable: available}
+	if p := stats.MemPressure(); p != 0.75 {
+		t.Fatalf("unexpected memory pressure %v", p)
+	}
+	if p := (HostStats{}).MemPressure(); p != 0 {
+		t.Fatalf("memory pressure should be 0 if unknown, got %v", p)
+	}
+}
@@ -0,0 +1,6 @@
+/* esm.sh - esbuild bundle(test@1.0.0) es2020 development */
+import __react$ from "/v1/react@17.0.2/es2020/react.js";
+import __process$ from "/v{VERSION}/_node_process.js";
+__process$.env.NODE_ENV="development";
+import { Buffer as __Buffer$ } from "/v{VERSION}/_node_buffer.js";
+var e=__process$.env.DEBUG,b=__Buffer$.from("esm");export{e,b};
\ No newline at end of file
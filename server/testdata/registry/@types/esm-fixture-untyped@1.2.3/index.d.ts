@@ -0,0 +1 @@
+export declare const greet: (name: string) => string;
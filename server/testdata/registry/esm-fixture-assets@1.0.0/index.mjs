@@ -0,0 +1,4 @@
+import logo from "./logo.png";
+import "./style.css";
+
+export { logo };
@@ -0,0 +1,6 @@
+import fs from "fs";
+import { dep } from "esm-fixture-cjs";
+import { env } from "./env.mjs";
+
+export const platform = "browser:" + env + ":" + dep;
+export const exists = typeof fs.existsSync === "function" ? fs.existsSync : () => false;
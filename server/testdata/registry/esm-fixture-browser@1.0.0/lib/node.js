@@ -0,0 +1,4 @@
+const fs = require("fs");
+
+exports.platform = "node";
+exports.exists = fs.existsSync;
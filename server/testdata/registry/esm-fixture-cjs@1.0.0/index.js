@@ -0,0 +1,5 @@
+const { dep } = require("esm-fixture-dep");
+
+exports.cjs = "cjs:" + dep;
+exports.env = process.env.NODE_ENV;
+exports.text = 'require("esm-fixture-dep")';
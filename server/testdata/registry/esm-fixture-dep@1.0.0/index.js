@@ -0,0 +1 @@
+exports.dep = "dep";
@@ -0,0 +1 @@
+export const dep = "dep";
@@ -0,0 +1,6 @@
+export function sum(a, b) {
+  console.log("sum", a, b);
+  debugger;
+  return a + b;
+}
+export const keyword = { debugger: "kept" }.debugger;
@@ -0,0 +1 @@
+export const loadLocale = () => import("./locale/en.mjs");
@@ -0,0 +1,3 @@
+import { dep } from "esm-fixture-dep";
+
+export default "en:" + dep;
@@ -0,0 +1,2 @@
+export const apiURL = process.env.API_URL || "https://default.example.com";
+export const debug = typeof __DEBUG__ !== "undefined" && __DEBUG__;
@@ -0,0 +1,7 @@
+import { dep } from "esm-fixture-dep";
+
+export const esm = "esm:" + dep;
+
+export default function hello() {
+  return "hello";
+}
@@ -0,0 +1 @@
+export const feature = true;
@@ -0,0 +1 @@
+export function format(s) { return `[${s}]`; }
@@ -0,0 +1 @@
+exports.feature = true;
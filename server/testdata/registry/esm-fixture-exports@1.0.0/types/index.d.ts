@@ -0,0 +1 @@
+export declare const name: string;
@@ -0,0 +1 @@
+export { default as greeting } from "./locales/日本語.js";
@@ -0,0 +1,2 @@
+declare const greeting: string;
+export default greeting;
@@ -0,0 +1,5 @@
+import { format } from "#internal/format";
+import { env } from "#env";
+import { dep } from "#dep";
+
+export const message = format(env, dep);
@@ -0,0 +1,3 @@
+export function format(...args) {
+  return args.join(":");
+}
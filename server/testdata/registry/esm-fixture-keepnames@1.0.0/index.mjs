@@ -0,0 +1,7 @@
+class Widget {}
+
+function createWidget() {
+  return new Widget();
+}
+
+export const widgetName = createWidget().constructor.name;
@@ -0,0 +1,2 @@
+/*! esm-fixture-legal v1.0.0 | MIT License */
+export const legal = "legal";
@@ -0,0 +1 @@
+export { baseURL, iconURL } from "./lib/util.mjs";
@@ -0,0 +1,2 @@
+export const baseURL = import.meta.url;
+export const iconURL = new URL("../icon.svg", import.meta.url).href;
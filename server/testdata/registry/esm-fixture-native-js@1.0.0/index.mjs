@@ -0,0 +1,3 @@
+export function hash(s) {
+  return "js:" + s;
+}
@@ -0,0 +1,3 @@
+{
+  "targets": [{ "target_name": "native", "sources": ["native.cc"] }]
+}
@@ -0,0 +1 @@
+module.exports = require("./build/Release/native.node");
@@ -0,0 +1 @@
+module.exports = require("./prebuilds/addon.node");
@@ -0,0 +1,3 @@
+import { shared } from "./shared.mjs";
+
+export const a = shared + ":a";
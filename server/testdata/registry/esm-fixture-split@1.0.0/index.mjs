@@ -0,0 +1,2 @@
+export const loadA = () => import("./a.mjs");
+export const loadB = () => import("./b.mjs");
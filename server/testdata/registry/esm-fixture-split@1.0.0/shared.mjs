@@ -0,0 +1 @@
+export const shared = "shared-module";
@@ -0,0 +1,11 @@
+import { used } from "#internal/used";
+import { unused } from "#internal/unused";
+import { shim } from "node-only";
+
+export function getUsed() {
+  return used;
+}
+
+export function getAll() {
+  return [used, unused, shim];
+}
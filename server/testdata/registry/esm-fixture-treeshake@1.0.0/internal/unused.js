@@ -0,0 +1,2 @@
+console.log("unused-side-effect");
+export const unused = "unused-value";
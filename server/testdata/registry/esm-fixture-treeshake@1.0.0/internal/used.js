@@ -0,0 +1 @@
+export const used = "used-value";
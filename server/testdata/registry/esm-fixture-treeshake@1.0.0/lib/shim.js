@@ -0,0 +1,2 @@
+console.log("shim-side-effect");
+export const shim = "shim-value";
@@ -0,0 +1,3 @@
+import { hash } from "esm-fixture-native";
+
+export const hashed = hash("a");
@@ -0,0 +1,4 @@
+import init, { url } from "./add.wasm";
+
+export { url };
+export default init;
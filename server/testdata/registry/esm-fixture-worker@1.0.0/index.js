@@ -0,0 +1,10 @@
+export function startWorker() {
+  return new Worker(new URL("./worker.js", import.meta.url), { type: "module" });
+}
+
+export function startRemoteWorker() {
+  return new Worker(new URL("https://cdn.example.com/worker.js", import.meta.url));
+}
+
+export const iconURL = new URL("./icon.svg", import.meta.url);
+export const remoteIconURL = new URL("https://cdn.example.com/icon.svg", import.meta.url);
@@ -0,0 +1 @@
+self.onmessage = (e) => self.postMessage(e.data);
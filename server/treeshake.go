@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// TreeshakeReport defines the size saving of a `?exports=` build versus the full build
+type TreeshakeReport struct {
+	Size     int      `json:"size"`
+	FullSize int      `json:"fullSize"`
+	Dropped  []string `json:"dropped"`
+}
+
+// Saving returns the saving percentage of the size
+func (r *TreeshakeReport) Saving() float64 {
+	if r.FullSize == 0 {
+		return 0
+	}
+	return float64(r.FullSize-r.Size) * 100 / float64(r.FullSize)
+}
+
+// buildEntryStub creates the entry module that re-exports the package, only the
+// names in the `filter` are exported if it's not empty.
+func buildEntryStub(importPath string, esmeta *ESMeta, filter []string) string {
+	buf := bytes.NewBuffer(nil)
+	exports := newStringSet()
+	hasDefaultExport := false
+	for _, name := range esmeta.Exports {
+		if name == "default" {
+			hasDefaultExport = true
+		} else if name != "import" {
+			exports.Add(name)
+		}
+	}
+	if esmeta.Module == "" {
+		// commonjs modules always have the default export
+		hasDefaultExport = true
+	}
+	if len(filter) > 0 {
+		names := newStringSet()
+		for _, name := range filter {
+			if name != "default" && exports.Has(name) {
+				names.Add(name)
+			}
+		}
+		hasDefaultExport = hasDefaultExport && includes(filter, "default")
+		exports = names
+	}
+	values := exports.Values()
+	sort.Strings(values)
+	if hasDefaultExport {
+		values = append(values, "default")
+	}
+	if len(values) > 0 {
+		fmt.Fprintf(buf, `export {%s} from "%s";%s`, strings.Join(values, ","), importPath, "\n")
+	}
+	return buf.String()
+}
+
+// unknownExports returns the names in the `?exports=` query that are not exported by the
+// module, the commonjs modules always have the default export.
+func unknownExports(esmeta *ESMeta, names []string) []string {
+	unknown := []string{}
+	for _, name := range names {
+		if name == "default" && esmeta.Module == "" {
+			continue
+		}
+		if !includes(esmeta.Exports, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// reportTreeshake builds the full entry with the same options and compares the outputs
+func reportTreeshake(options api.BuildOptions, fullStub string, result api.BuildResult) (report *TreeshakeReport, err error) {
+	stdin := *options.Stdin
+	stdin.Contents = fullStub
+	options.Stdin = &stdin
+	options.Metafile = true
+	fullResult := api.Build(options)
+	if len(fullResult.Errors) > 0 {
+		err = errors.New("esbuild: " + fullResult.Errors[0].Text)
+		return
+	}
+
+	var meta, fullMeta esbuildMetafile
+	err = json.Unmarshal([]byte(result.Metafile), &meta)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(fullResult.Metafile), &fullMeta)
+	if err != nil {
+		return
+	}
+
+	report = &TreeshakeReport{Dropped: []string{}}
+	for _, file := range result.OutputFiles {
+		if !strings.HasSuffix(file.Path, ".map") {
+			report.Size += len(file.Contents)
+		}
+	}
+	for _, file := range fullResult.OutputFiles {
+		if !strings.HasSuffix(file.Path, ".map") {
+			report.FullSize += len(file.Contents)
+		}
+	}
+	for input := range fullMeta.Inputs {
+		if _, ok := meta.Inputs[input]; !ok {
+			report.Dropped = append(report.Dropped, input)
+		}
+	}
+	sort.Strings(report.Dropped)
+	return
+}
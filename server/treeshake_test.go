@@ -0,0 +1,58 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildEntryStub(t *testing.T) {
+	esm := &ESMeta{NpmPackage: &NpmPackage{Module: "index.js"}, Exports: []string{"useState", "Component", "default"}}
+	cjs := &ESMeta{NpmPackage: &NpmPackage{}, Exports: []string{"debounce", "throttle"}}
+
+	for _, c := range []struct {
+		esmeta *ESMeta
+		filter []string
+		expect string
+	}{
+		{esm, nil, "export {Component,useState,default} from \"react\";\n"},
+		{esm, []string{"useState", "nope"}, "export {useState} from \"react\";\n"},
+		{esm, []string{"default"}, "export {default} from \"react\";\n"},
+		{cjs, nil, "export {debounce,throttle,default} from \"react\";\n"},
+		{cjs, []string{"throttle"}, "export {throttle} from \"react\";\n"},
+	} {
+		if stub := buildEntryStub("react", c.esmeta, c.filter); stub != c.expect {
+			t.Fatalf("unexpected stub of %v: %s", c.filter, stub)
+		}
+	}
+}
+
+func TestTreeshakeSideEffects(t *testing.T) {
+	s := setupTestEnv(t)
+
+	// the `#internal/*` and the `browser` imports are resolved by the esm-resolver plugin
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-treeshake@1.0.0"), cjsExports: "auto", target: "es2020", exports: []string{"getUsed"}}
+	code := buildFixture(t, task)
+	if !strings.Contains(code, "used-value") {
+		t.Fatalf("the used export should be kept:\n%s", code)
+	}
+	for _, s := range []string{"unused-side-effect", "shim-side-effect"} {
+		if strings.Contains(code, s) {
+			t.Fatalf("the unused modules of the side-effect free package should be dropped, but got %s:\n%s", s, code)
+		}
+	}
+}
+
+func TestUnknownExports(t *testing.T) {
+	esm := &ESMeta{NpmPackage: &NpmPackage{Module: "index.js"}, Exports: []string{"debounce", "throttle"}}
+	cjs := &ESMeta{NpmPackage: &NpmPackage{}, Exports: []string{"debounce"}}
+
+	if unknown := unknownExports(esm, []string{"debounce", "throttle"}); len(unknown) != 0 {
+		t.Fatalf("unexpected unknown exports %v", unknown)
+	}
+	if unknown := unknownExports(esm, []string{"debounce", "default", "nope"}); strings.Join(unknown, ",") != "default,nope" {
+		t.Fatalf("unexpected unknown exports %v", unknown)
+	}
+	if unknown := unknownExports(cjs, []string{"debounce", "default"}); len(unknown) != 0 {
+		t.Fatalf("unexpected unknown exports %v", unknown)
+	}
+}
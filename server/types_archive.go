@@ -0,0 +1,214 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// the prefix of the types archives, like `/-/types-archive/react@17.0.2.tgz`
+const typesArchivePrefix = "/-/types-archive/"
+
+// the max size of the declaration files in an archive
+const typesArchiveMaxSize = 32 * 1024 * 1024
+
+// matches the rewritten imports of the other packages, like `/v36/@types/prop-types@15.7.3/index.d.ts`
+var regTypesImport = regexp.MustCompile(fmt.Sprintf(`["']/v%d/((?:@[^/"']+/)?[^/@"']+@[^/"']+)/`, VERSION))
+
+// A typesManifest describes the declaration files of a types archive, the paths are relative to
+// the server root, like `v36/@types/react@17.0.3/index.d.ts`.
+type typesManifest struct {
+	Name     string              `json:"name"`
+	Version  string              `json:"version"`
+	Types    string              `json:"types"`
+	Packages []string            `json:"packages"`
+	Files    []typesManifestFile `json:"files"`
+}
+
+type typesManifestFile struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
+// findTypesRoot returns the entry of the types of the package version like
+// `@types/react@17.0.3/index.d.ts`, the types are copied by the builds.
+func (s *Server) findTypesRoot(name string, version string) (entry string, ok bool) {
+	task := &buildTask{
+		Server:     s,
+		pkg:        pkg{name: name, version: version},
+		cjsExports: "auto",
+		target:     "es2020",
+	}
+	if esm, _, found := s.findESM(task.ID()); found && esm.Dts != "" {
+		return strings.TrimPrefix(esm.Dts, "/"), true
+	}
+	entry = fmt.Sprintf("%s@%s/index.d.ts", name, version)
+	if fileExists(filepath.Join(s.config.storageDir, "types", fmt.Sprintf("v%d", VERSION), entry)) {
+		return entry, true
+	}
+	return "", false
+}
+
+// collectTypes reads the declaration files of the package and the packages they import, the
+// `lib` references are rewritten for the target like the `/v{VERSION}/*.d.ts` requests.
+func (s *Server) collectTypes(entry string, target string) (manifest *typesManifest, files map[string][]byte, err error) {
+	typesDir := filepath.Join(s.config.storageDir, "types", fmt.Sprintf("v%d", VERSION))
+	root, _ := splitPackageDir(entry)
+	name, version := splitPkgVersion(root)
+	manifest = &typesManifest{
+		Name:     name,
+		Version:  version,
+		Types:    fmt.Sprintf("v%d/%s", VERSION, entry),
+		Packages: []string{},
+		Files:    []typesManifestFile{},
+	}
+	files = map[string][]byte{}
+
+	size := 0
+	seen := map[string]bool{root: true}
+	queue := []string{root}
+	for len(queue) > 0 {
+		pkgDir := queue[0]
+		queue = queue[1:]
+		manifest.Packages = append(manifest.Packages, pkgDir)
+		err = filepath.Walk(filepath.Join(typesDir, pkgDir), func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() || !strings.HasSuffix(filename, ".d.ts") {
+				return nil
+			}
+			size += int(info.Size())
+			if size > typesArchiveMaxSize {
+				return fmt.Errorf("the types are too large, the max is %s", formatByteSize(typesArchiveMaxSize))
+			}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			for _, m := range regTypesImport.FindAllSubmatch(data, -1) {
+				if dep := string(m[1]); !seen[dep] {
+					seen[dep] = true
+					queue = append(queue, dep)
+				}
+			}
+			rel, _ := filepath.Rel(typesDir, filename)
+			files[path.Join(fmt.Sprintf("v%d", VERSION), filepath.ToSlash(rel))] = s.rewriteLibReferences(data, target)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	for name, data := range files {
+		manifest.Files = append(manifest.Files, typesManifestFile{name, len(data)})
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	sort.Strings(manifest.Packages[1:])
+	return
+}
+
+// splitPackageDir splits the path like `@types/react@17.0.3/index.d.ts` to the package
+// directory and the sub-path.
+func splitPackageDir(s string) (pkgDir string, subpath string) {
+	a := strings.SplitN(s, "/", 3)
+	if strings.HasPrefix(s, "@") && len(a) > 1 {
+		if len(a) == 3 {
+			return a[0] + "/" + a[1], a[2]
+		}
+		return a[0] + "/" + a[1], ""
+	}
+	pkgDir, subpath = utils.SplitByFirstByte(s, '/')
+	return
+}
+
+// packTypes packs the files and the `manifest.json` as a tarball.
+func packTypes(manifest *typesManifest, files map[string][]byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	mtime := time.Now()
+	write := func(name string, data []byte) error {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: mtime})
+		if err == nil {
+			_, err = tw.Write(data)
+		}
+		return err
+	}
+	err := write("manifest.json", utils.MustEncodeJSON(manifest))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range manifest.Files {
+		err = write(f.Path, files[f.Path])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// typesArchive handles the `/-/types-archive/{pkg}@{version}.tgz` requests, the archive bundles
+// the declaration files of the package and the packages they import with a `manifest.json`.
+// The `.json` extension returns the manifest only.
+func (s *Server) typesArchive(ctx *rex.Context, pathname string) interface{} {
+	spec := strings.TrimPrefix(pathname, typesArchivePrefix)
+	manifestOnly := strings.HasSuffix(spec, ".json")
+	if !manifestOnly && !strings.HasSuffix(spec, ".tgz") {
+		return rex.Status(404, "not found")
+	}
+	spec = strings.TrimSuffix(strings.TrimSuffix(spec, ".tgz"), ".json")
+	name, version := splitPkgVersion(spec)
+	if !regPkgName.MatchString(name) || !regFullVersion.MatchString(version) {
+		return rex.Status(400, fmt.Sprintf("invalid package '%s', the exact version is required", spec))
+	}
+
+	entry, ok := s.findTypesRoot(name, version)
+	if !ok {
+		return rex.Status(404, fmt.Sprintf("types of %s@%s not found, import the package to build the types", name, version))
+	}
+	target := "es2015"
+	if strings.HasPrefix(ctx.R.UserAgent(), "Deno/") || ctx.Form.Value("target") == "deno" {
+		target = "deno"
+	}
+	manifest, files, err := s.collectTypes(entry, target)
+	if err != nil {
+		return rex.Status(500, err.Error())
+	}
+
+	ctx.SetHeader("Cache-Control", "public, max-age=86400")
+	ctx.SetHeader("Vary", "User-Agent")
+	if manifestOnly {
+		return manifest
+	}
+	data, err := packTypes(manifest, files)
+	if err != nil {
+		return rex.Status(500, err.Error())
+	}
+	ctx.SetHeader("Content-Type", "application/gzip")
+	ctx.SetHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, path.Base(pathname)))
+	return data
+}
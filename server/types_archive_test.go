@@ -0,0 +1,103 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ije/rex"
+	"github.com/postui/postdb/q"
+)
+
+func TestTypesArchive(t *testing.T) {
+	s := setupTestEnv(t)
+
+	typesDir := filepath.Join(s.config.storageDir, "types", fmt.Sprintf("v%d", VERSION))
+	for name, content := range map[string]string{
+		"@types/esm-fixture-esm@1.0.0/index.d.ts": fmt.Sprintf("import \"./util.d.ts\";\nexport * from \"/v%d/esm-fixture-dep@1.0.0/index.d.ts\";\n", VERSION),
+		"@types/esm-fixture-esm@1.0.0/util.d.ts":  "export declare const util: number;\n",
+		"esm-fixture-dep@1.0.0/index.d.ts":        "export declare const dep: string;\n",
+		"esm-fixture-cjs@1.0.0/index.d.ts":        "export declare const cjs: string;\n",
+	} {
+		filename := filepath.Join(typesDir, name)
+		if err := ensureDir(filepath.Dir(filename)); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := fmt.Sprintf("v%d/esm-fixture-esm@1.0.0/es2020/esm-fixture-esm", VERSION)
+	if _, err := s.db.Put(q.Alias(id), q.KV{"esmeta": []byte(`{"dts":"/@types/esm-fixture-esm@1.0.0/index.d.ts"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(s.config.storageDir, "builds", id+".js")
+	if err := ensureDir(filepath.Dir(filename)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("export default 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(pathname string) interface{} {
+		req := httptest.NewRequest("GET", "http://esm.sh"+pathname, nil)
+		return s.typesArchive(&rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}, pathname)
+	}
+
+	data, ok := call(typesArchivePrefix + "esm-fixture-esm@1.0.0.tgz").([]byte)
+	if !ok {
+		t.Fatal("the archive should be returned")
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	entries := []string{}
+	var manifest typesManifest
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, h.Name)
+		if h.Name == "manifest.json" {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expected := []string{
+		"manifest.json",
+		fmt.Sprintf("v%d/@types/esm-fixture-esm@1.0.0/index.d.ts", VERSION),
+		fmt.Sprintf("v%d/@types/esm-fixture-esm@1.0.0/util.d.ts", VERSION),
+		fmt.Sprintf("v%d/esm-fixture-dep@1.0.0/index.d.ts", VERSION),
+	}
+	if strings.Join(entries, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected archive entries %v", entries)
+	}
+	if manifest.Name != "@types/esm-fixture-esm" || manifest.Types != expected[1] || strings.Join(manifest.Packages, ",") != "@types/esm-fixture-esm@1.0.0,esm-fixture-dep@1.0.0" || len(manifest.Files) != 3 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	// the manifest only
+	if m, ok := call(typesArchivePrefix + "esm-fixture-cjs@1.0.0.json").(*typesManifest); !ok || len(m.Files) != 1 || m.Types != fmt.Sprintf("v%d/esm-fixture-cjs@1.0.0/index.d.ts", VERSION) {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	for _, pathname := range []string{"esm-fixture-esm@1.tgz", "esm-fixture-dep@2.0.0.tgz", "esm-fixture-esm@1.0.0.zip"} {
+		if _, ok := call(typesArchivePrefix + pathname).([]byte); ok {
+			t.Fatalf("%s should not be archived", pathname)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+)
+
+// the limits of the usage stats in memory, the reports of the new packages or exports are
+// dropped when they are reached
+const (
+	usageMaxPackages = 10000
+	usageMaxExports  = 1000
+)
+
+// A usageReport reports the exports of a package(or submodule) that are used by an app, `*` means the
+// whole module is imported.
+type usageReport struct {
+	Pkg     string   `json:"pkg"`
+	Exports []string `json:"exports"`
+}
+
+// A usageCounter aggregates the usage reports by the package and the export.
+type usageCounter struct {
+	lock     sync.Mutex
+	packages map[string]map[string]uint64
+	reports  uint64
+}
+
+var usage = &usageCounter{packages: map[string]map[string]uint64{}}
+
+// Add counts the report, returns false if the report is invalid or dropped.
+func (c *usageCounter) Add(r usageReport) bool {
+	// the package may be a submodule like `react-dom@17.0.2/server`
+	name, version := splitPkgVersion(r.Pkg)
+	version, _ = utils.SplitByFirstByte(version, '/')
+	if !regPkgName.MatchString(name) || version == "" || len(r.Exports) == 0 {
+		return false
+	}
+	for _, name := range r.Exports {
+		if name != "*" && name != "default" && !regJSIdentifier.MatchString(name) {
+			return false
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	counts, ok := c.packages[r.Pkg]
+	if !ok {
+		if len(c.packages) >= usageMaxPackages {
+			return false
+		}
+		counts = map[string]uint64{}
+		c.packages[r.Pkg] = counts
+	}
+	for _, name := range r.Exports {
+		if _, ok := counts[name]; ok || len(counts) < usageMaxExports {
+			counts[name]++
+		}
+	}
+	c.reports++
+	return true
+}
+
+// Package returns the usage counts of the exports of the package.
+func (c *usageCounter) Package(pkg string) map[string]uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	counts := map[string]uint64{}
+	for name, n := range c.packages[pkg] {
+		counts[name] = n
+	}
+	return counts
+}
+
+// Stats returns the number of the reported packages and the total reports.
+func (c *usageCounter) Stats() (packages int, reports uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.packages), c.reports
+}
+
+// splitPkgVersion splits the package like `@babel/core@7.13.0` to the name and version.
+func splitPkgVersion(s string) (name string, version string) {
+	if i := strings.LastIndexByte(s, '@'); i > 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// usageBeacon returns the code that reports the imported exports of the package when the
+// module is loaded, the errors are ignored since it's only the analytics.
+func usageBeacon(pkg string, exports []string) string {
+	exports = append([]string{}, exports...)
+	if len(exports) == 0 {
+		exports = []string{"*"}
+	}
+	sort.Strings(exports)
+	report := strings.TrimSpace(string(utils.MustEncodeJSON(usageReport{pkg, exports})))
+	return fmt.Sprintf(
+		"try { navigator.sendBeacon(new URL(\"/-/telemetry\", import.meta.url), %s) } catch (e) {}\n",
+		strings.TrimSpace(string(utils.MustEncodeJSON(report))),
+	)
+}
+
+// usageTelemetry handles the `/-/telemetry` requests: `POST` reports the used exports of a
+// package, `GET /-/telemetry?pkg=react@17.0.2` returns the usage counts.
+func (s *Server) usageTelemetry(ctx *rex.Context) interface{} {
+	if !s.config.usageReport {
+		return rex.Err(404)
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	switch ctx.R.Method {
+	case "GET":
+		pkg := ctx.Form.Value("pkg")
+		if pkg == "" {
+			packages, reports := usage.Stats()
+			return map[string]interface{}{
+				"packages": packages,
+				"reports":  reports,
+			}
+		}
+		return map[string]interface{}{
+			"pkg":     pkg,
+			"exports": usage.Package(pkg),
+		}
+	case "POST":
+		var r usageReport
+		// the beacons are sent as `text/plain`
+		err := json.NewDecoder(io.LimitReader(ctx.R.Body, 64*1024)).Decode(&r)
+		if err != nil || !usage.Add(r) {
+			return rex.Status(400, "invalid report")
+		}
+		return rex.Status(204, "")
+	default:
+		ctx.SetHeader("Allow", "GET, POST")
+		return rex.Status(405, "method not allowed")
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ije/rex"
+)
+
+func TestUsageCounter(t *testing.T) {
+	c := &usageCounter{packages: map[string]map[string]uint64{}}
+	for _, r := range []usageReport{
+		{"react@17.0.2", []string{"useState", "useEffect"}},
+		{"react@17.0.2", []string{"useState"}},
+		{"react-dom@17.0.2/server", []string{"renderToString"}},
+		{"@babel/core@7.13.0", []string{"*"}},
+	} {
+		if !c.Add(r) {
+			t.Fatalf("the report %v should be counted", r)
+		}
+	}
+	for _, r := range []usageReport{
+		{"react", []string{"useState"}},
+		{"react@17.0.2", nil},
+		{"react@17.0.2", []string{"use-state"}},
+		{"<script>@1.0.0", []string{"x"}},
+	} {
+		if c.Add(r) {
+			t.Fatalf("the report %v should be rejected", r)
+		}
+	}
+	if counts := c.Package("react@17.0.2"); counts["useState"] != 2 || counts["useEffect"] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+	if packages, reports := c.Stats(); packages != 3 || reports != 4 {
+		t.Fatalf("unexpected stats %d, %d", packages, reports)
+	}
+}
+
+func TestUsageBeacon(t *testing.T) {
+	code := usageBeacon("react@17.0.2", []string{"useState", "memo"})
+	if !strings.Contains(code, `new URL("/-/telemetry", import.meta.url), "{\"pkg\":\"react@17.0.2\",\"exports\":[\"memo\",\"useState\"]}")`) {
+		t.Fatalf("unexpected beacon: %s", code)
+	}
+	if code := usageBeacon("react@17.0.2", nil); !strings.Contains(code, `\"exports\":[\"*\"]`) {
+		t.Fatalf("the whole module should be reported: %s", code)
+	}
+}
+
+func TestUsageTelemetry(t *testing.T) {
+	s := &Server{config: &Config{usageReport: true}}
+	usage = &usageCounter{packages: map[string]map[string]uint64{}}
+
+	req := httptest.NewRequest("POST", "http://esm.sh/-/telemetry", strings.NewReader(`{"pkg":"preact@10.5.13","exports":["h"]}`))
+	ctx := &rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}
+	if _, ok := s.usageTelemetry(ctx).(map[string]interface{}); ok {
+		t.Fatal("the report should not return the stats")
+	}
+	req = httptest.NewRequest("GET", "http://esm.sh/-/telemetry?pkg=preact@10.5.13", nil)
+	ctx = &rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}
+	ret, ok := s.usageTelemetry(ctx).(map[string]interface{})
+	if !ok || ret["exports"].(map[string]uint64)["h"] != 1 {
+		t.Fatalf("unexpected usage %v", ret)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapePath(t *testing.T) {
+	for _, c := range []struct {
+		path    string
+		escaped string
+	}{
+		{"/react@17.0.2/index.js", "/react@17.0.2/index.js"},
+		{"/@scope/pkg@1.0.0/a+b,c:d=e&f.json", "/@scope/pkg@1.0.0/a+b,c:d=e&f.json"},
+		{"/pkg@1.0.0/locales/日本語.json", "/pkg@1.0.0/locales/%E6%97%A5%E6%9C%AC%E8%AA%9E.json"},
+		{"/pkg@1.0.0/docs/100% ready?.md", "/pkg@1.0.0/docs/100%25%20ready%3F.md"},
+		{"/pkg@1.0.0/#private/ä.js", "/pkg@1.0.0/%23private/%C3%A4.js"},
+	} {
+		escaped := escapePath(c.path)
+		if escaped != c.escaped {
+			t.Fatalf("escape %s: unexpected '%s'", c.path, escaped)
+		}
+		// the server decodes the URL back to the same path
+		u, err := url.Parse("https://esm.sh" + escaped + "?target=es2020")
+		if err != nil || u.Path != c.path {
+			t.Fatalf("round-trip %s: got '%s' %v", c.path, u.Path, err)
+		}
+	}
+}
+
+func TestUTF8FilePaths(t *testing.T) {
+	s := setupTestEnv(t)
+
+	u, err := url.Parse("/esm-fixture-i18n@1.0.0/locales/%E6%97%A5%E6%9C%AC%E8%AA%9E")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := s.parsePkg(u.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.submodule != "locales/日本語" {
+		t.Fatalf("unexpected submodule '%s'", p.submodule)
+	}
+	// the build is read by the UTF-8 file name
+	buildFixture(t, &buildTask{Server: s, pkg: *p, cjsExports: "auto", target: "es2020"})
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-i18n@1.0.0"), cjsExports: "auto", target: "es2020"}
+	esm, _, err := task.buildESM(context.Background())
+	if err != nil {
+		t.Fatalf("build %s: %v", task.ID(), err)
+	}
+	if !includes(esm.Exports, "greeting") {
+		t.Fatalf("build %s: unexpected exports %v", task.ID(), esm.Exports)
+	}
+	// the import paths of the types are percent-encoded
+	typesDir := filepath.Join(s.config.storageDir, "types", fmt.Sprintf("v%d", VERSION), "esm-fixture-i18n@1.0.0")
+	data, err := ioutil.ReadFile(filepath.Join(typesDir, "index.d.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"./locales/%E6%97%A5%E6%9C%AC%E8%AA%9E.d.ts"`) {
+		t.Fatalf("the UTF-8 import path of the types is not encoded:\n%s", data)
+	}
+	if !fileExists(filepath.Join(typesDir, "locales", "日本語.d.ts")) {
+		t.Fatal("the types of the UTF-8 file name are not copied")
+	}
+
+	if _, err = s.parsePkg("/réact@1.0.0"); err == nil || !strings.Contains(err.Error(), "invalid package name") {
+		t.Fatalf("the non-ASCII package names should be rejected, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"fmt"
 	"os"
 	"regexp"
 	"strconv"
@@ -48,6 +49,14 @@ func (s *stringSet) Add(key string) {
 	s.m[key] = struct{}{}
 }
 
+func (s *stringSet) Has(key string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.m[key]
+	return ok
+}
+
 func (s *stringSet) Values() []string {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -96,7 +105,7 @@ func identify(importPath string) string {
 	p := []byte(importPath)
 	for i, c := range p {
 		switch c {
-		case '/', '-', '@', '.':
+		case '/', '-', '@', '.', ':':
 			p[i] = '_'
 		default:
 			p[i] = c
@@ -105,6 +114,15 @@ func identify(importPath string) string {
 	return string(p)
 }
 
+func includes(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func isFileImportPath(importPath string) bool {
 	return strings.HasPrefix(importPath, "/") || strings.HasPrefix(importPath, "./") || strings.HasPrefix(importPath, "../") || importPath == "." || importPath == ".."
 }
@@ -134,11 +152,56 @@ func ensureSuffix(path string, ext string) string {
 	return path
 }
 
+// escapePath percent-encodes the bytes of the decoded path that can't be used in the URL path
+// as they are, like the non-ASCII characters of the file names, `%`, `?` and `#`, so the server
+// decodes the URL back to the same path.
+func escapePath(p string) string {
+	var buf *strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c <= 0x20 || c >= 0x7f || strings.IndexByte("\"#%<>?\\^`{|}", c) >= 0 {
+			if buf == nil {
+				buf = &strings.Builder{}
+				buf.WriteString(p[:i])
+			}
+			fmt.Fprintf(buf, "%%%02X", c)
+		} else if buf != nil {
+			buf.WriteByte(c)
+		}
+	}
+	if buf == nil {
+		return p
+	}
+	return buf.String()
+}
+
+// resolveJSFile resolves the file path like nodejs without the package.json `main` lookup
+func resolveJSFile(filename string) string {
+	for _, p := range []string{
+		filename,
+		filename + ".js",
+		filename + ".mjs",
+		filename + "/index.js",
+		filename + "/index.mjs",
+	} {
+		if fileExists(p) {
+			return p
+		}
+	}
+	return ""
+}
+
 func fileExists(filepath string) bool {
 	fi, err := os.Lstat(filepath)
 	return err == nil && !fi.IsDir()
 }
 
+// targetExists reports whether the file exists, unlike `fileExists` the symlinks are followed.
+func targetExists(filepath string) bool {
+	fi, err := os.Stat(filepath)
+	return err == nil && !fi.IsDir()
+}
+
 func dirExists(filepath string) bool {
 	fi, err := os.Lstat(filepath)
 	return err == nil && fi.IsDir()
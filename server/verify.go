@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/esbuild-internal/js_parser"
+	"github.com/ije/esbuild-internal/logger"
+	"github.com/ije/esbuild-internal/test"
+)
+
+// A buildVerifyError is the error of a build that fails the verification, the build is not
+// stored so the broken module is never served.
+type buildVerifyError struct {
+	id     string
+	reason string
+}
+
+func (e *buildVerifyError) Error() string {
+	return fmt.Sprintf("verify: build %s: %s", e.id, e.reason)
+}
+
+// verifyBuild checks the emitted javascript artifacts of the build when the `verify-builds`
+// config is enabled: the syntax is checked for the target by esbuild and by node, the entry
+// must export the declared names, and the import URLs must be well-formed.
+func (task *buildTask) verifyBuild(ctx context.Context, esmeta *ESMeta, entry string) error {
+	for _, filename := range task.artifacts.Files() {
+		if !strings.HasSuffix(filename, ".js") {
+			continue
+		}
+		code, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		if reason := checkTargetSyntax(code, task.target); reason != "" {
+			return &buildVerifyError{task.ID(), reason}
+		}
+		if reason := task.checkNodeSyntax(ctx, code); reason != "" {
+			return &buildVerifyError{task.ID(), reason}
+		}
+		var expected []string
+		if filename == entry {
+			expected = verifiedExports(esmeta, task.exports)
+		}
+		if reason := task.checkModule(code, expected); reason != "" {
+			return &buildVerifyError{task.ID(), reason}
+		}
+	}
+	return nil
+}
+
+// checkTargetSyntax parses the code for the target, the syntax that is not supported by the
+// target is reported.
+func checkTargetSyntax(code []byte, target string) string {
+	ret := api.Transform(string(code), api.TransformOptions{
+		Loader: api.LoaderJS,
+		Format: api.FormatESModule,
+		Target: targets[target],
+	})
+	if len(ret.Errors) > 0 {
+		return "invalid syntax for " + target + ": " + ret.Errors[0].Text
+	}
+	return ""
+}
+
+// checkNodeSyntax checks the syntax of the code by node without executing it, the check is
+// skipped if node is not installed.
+func (s *Server) checkNodeSyntax(ctx context.Context, code []byte) string {
+	_, output, err := s.runProc(ctx, procOptions{Stdin: bytes.NewReader(code), Timeout: 30 * time.Second}, "node", "--input-type=module", "--check")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return ""
+		}
+		if i := bytes.Index(output, []byte("SyntaxError")); i >= 0 {
+			output = output[i:]
+		}
+		if i := bytes.IndexByte(output, '\n'); i > 0 {
+			output = output[:i]
+		}
+		return "node check: " + strings.TrimSpace(string(output))
+	}
+	return ""
+}
+
+// verifiedExports returns the names that the entry of the build must export.
+func verifiedExports(esmeta *ESMeta, filter []string) []string {
+	names := []string{}
+	for _, name := range esmeta.Exports {
+		if name != "import" && name != "__esModule" && (len(filter) == 0 || includes(filter, name)) {
+			names = append(names, name)
+		}
+	}
+	if esmeta.Module == "" && (len(filter) == 0 || includes(filter, "default")) && !includes(names, "default") {
+		names = append(names, "default")
+	}
+	return names
+}
+
+// checkModule checks the exported names and the import URLs of the module.
+func (task *buildTask) checkModule(code []byte, exports []string) string {
+	log := logger.NewDeferLog()
+	ast, pass := js_parser.Parse(log, test.SourceForTest(string(code)), js_parser.Options{})
+	if !pass {
+		return "invalid module"
+	}
+	// the names of `export * from` can't be checked statically
+	if len(ast.ExportStarImportRecords) == 0 {
+		for _, name := range exports {
+			if _, ok := ast.NamedExports[name]; !ok {
+				return fmt.Sprintf("missing export '%s'", name)
+			}
+		}
+	}
+	for _, record := range ast.ImportRecords {
+		if specifier := record.Path.Text; !task.isValidImportURL(specifier) {
+			return fmt.Sprintf("malformed import URL '%s'", specifier)
+		}
+	}
+	return ""
+}
+
+// isValidImportURL reports whether the import of the build is well-formed: the paths of the
+// server, the chunks of the split builds, the URLs, the builtin modules of the node runtimes,
+// or the bare imports of the external packages.
+func (task *buildTask) isValidImportURL(specifier string) bool {
+	if specifier == "" || specifier == "undefined" || strings.ContainsAny(specifier, " \t\n\"'\\") || strings.Contains(specifier, "@undefined") {
+		return false
+	}
+	if strings.HasPrefix(specifier, "./") {
+		return task.split
+	}
+	if strings.HasPrefix(specifier, "/") {
+		u, err := url.Parse(specifier)
+		return err == nil && !strings.HasPrefix(specifier, "//") && !strings.Contains(u.Path, "@/")
+	}
+	if strings.HasPrefix(specifier, "node:") {
+		return isNodeRuntimeTarget(task.target)
+	}
+	if strings.HasPrefix(specifier, "bun:") {
+		return task.target == "bun"
+	}
+	if strings.HasPrefix(specifier, "http:") || strings.HasPrefix(specifier, "https:") {
+		u, err := url.Parse(specifier)
+		return err == nil && u.Host != ""
+	}
+	return task.isExternal(specifier)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckModule(t *testing.T) {
+	s := &Server{config: &Config{}}
+	task := &buildTask{Server: s, pkg: pkg{name: "app", version: "1.0.0"}, target: "es2020", external: []string{"react"}}
+	for _, c := range []struct {
+		code    string
+		exports []string
+		reason  string
+	}{
+		{`import a from "/v36/dep@1.0.0/es2020/dep.js";import "react";export const b = a;export default a;`, []string{"b", "default"}, ""},
+		{`export * from "/v36/dep@1.0.0/es2020/dep.js";`, []string{"b"}, ""},
+		{`export const b = 1;`, []string{"b", "c"}, "missing export 'c'"},
+		{`import "react-dom";`, nil, "malformed import URL 'react-dom'"},
+		{`import "/v36/dep@undefined/es2020/dep.js";`, nil, "malformed import URL"},
+		{`import "node:fs";`, nil, "malformed import URL 'node:fs'"},
+		{`import("./chunk.js");`, nil, "malformed import URL './chunk.js'"},
+	} {
+		reason := task.checkModule([]byte(c.code), c.exports)
+		if (c.reason == "" && reason != "") || !strings.HasPrefix(reason, c.reason) {
+			t.Fatalf("check %s: unexpected reason '%s'", c.code, reason)
+		}
+	}
+
+	if reason := checkTargetSyntax([]byte("export const n = 1n;"), "es2015"); reason == "" {
+		t.Fatal("the bigint literals should be rejected for es2015")
+	}
+	if reason := checkTargetSyntax([]byte("export const n = 1n;"), "es2020"); reason != "" {
+		t.Fatalf("unexpected reason '%s'", reason)
+	}
+	if reason := s.checkNodeSyntax(context.Background(), []byte("export const = 1;")); reason == "" {
+		t.Fatal("the invalid syntax should be rejected by node")
+	}
+	if !isCacheableBuildError(&buildVerifyError{"v36/app@1.0.0/es2020/app", "invalid module"}) {
+		t.Fatal("the verification errors should be cached")
+	}
+}
+
+func TestVerifyBuild(t *testing.T) {
+	s := setupTestEnv(t)
+	s.config.verifyBuilds = true
+
+	for _, c := range []struct {
+		pkg    string
+		target string
+	}{
+		{"esm-fixture-esm@1.0.0", "es2020"},
+		{"esm-fixture-cjs@1.0.0", "es2015"},
+		{"esm-fixture-cjs@1.0.0", "node"},
+		{"esm-fixture-exports@1.0.0/feature", "deno"},
+	} {
+		buildFixture(t, &buildTask{Server: s, pkg: fixturePkg(t, s, c.pkg), cjsExports: "auto", target: c.target})
+	}
+}
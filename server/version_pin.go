@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ije/gox/utils"
+	"github.com/ije/rex"
+	"github.com/postui/postdb"
+	"github.com/postui/postdb/q"
+)
+
+const versionHistoryMaxLength = 100
+
+// the major-only and major.minor versions like `react@16` and `react@16.14`, and the caret
+// and tilde ranges like `react@^16.8` and `react@~16.14.0`
+var regWeakVersion = regexp.MustCompile(`^(\d+(\.\d+)?|[\^~]\d+(\.\d+){0,2})$`)
+
+// the dist-tags like `react@next` and `vue@beta`
+var regDistTag = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9._-]*$`)
+
+// A versionPin records that the floating version resolves to the exact version since the time.
+type versionPin struct {
+	Version string `json:"version"`
+	Since   int64  `json:"since"`
+}
+
+var versionHistoryLock sync.Mutex
+
+func versionHistoryKey(name string, version string) string {
+	return fmt.Sprintf("version-history:%s@%s", name, version)
+}
+
+// floatingVersion normalizes the semver range to the version that is resolved, like `^17.0.2`
+// -> `17`, `~16.14.0` -> `16.14`. The caret ranges of the `0.x` versions only float the
+// patch version, like `^0.6.2` -> `0.6`.
+func floatingVersion(version string) string {
+	if strings.HasPrefix(version, "^") {
+		a := strings.Split(version[1:], ".")
+		switch {
+		case a[0] != "0" || len(a) == 1:
+			version = a[0]
+		case a[1] != "0" || len(a) == 2:
+			version = a[0] + "." + a[1]
+		default:
+			version = version[1:]
+		}
+	} else if strings.HasPrefix(version, "~") {
+		major, rest := utils.SplitByFirstByte(version[1:], '.')
+		minor, _ := utils.SplitByFirstByte(rest, '.')
+		version = major
+		if minor != "" {
+			version += "." + minor
+		}
+	}
+	return version
+}
+
+// versionRefreshInterval returns the interval in seconds to re-resolve the floating versions.
+func (s *Server) versionRefreshInterval() int64 {
+	if s.config != nil && s.config.versionRefresh > 0 {
+		return int64(s.config.versionRefresh.Seconds())
+	}
+	return refreshDuration
+}
+
+// distTagRefreshInterval returns the interval in seconds to re-resolve the dist-tags, default
+// is the same as the floating versions.
+func (s *Server) distTagRefreshInterval() int64 {
+	if s.config != nil && s.config.distTagRefresh > 0 {
+		return int64(s.config.distTagRefresh.Seconds())
+	}
+	return s.versionRefreshInterval()
+}
+
+// getVersionHistory returns the exact versions that the floating version resolved to, the
+// latest is the last.
+func (s *Server) getVersionHistory(name string, version string) (history []versionPin, err error) {
+	post, err := s.db.Get(q.Alias(versionHistoryKey(name, version)), q.K("history"))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(post.KV.Get("history"), &history)
+	return
+}
+
+// recordVersionPin appends the exact version to the history of the floating version if it
+// moved.
+func (s *Server) recordVersionPin(name string, version string, exact string, now time.Time) error {
+	versionHistoryLock.Lock()
+	defer versionHistoryLock.Unlock()
+
+	history, err := s.getVersionHistory(name, version)
+	if err != nil && err != postdb.ErrNotFound {
+		return err
+	}
+	if l := len(history); l > 0 && history[l-1].Version == exact {
+		return nil
+	}
+	history = append(history, versionPin{Version: exact, Since: now.Unix()})
+	if l := len(history); l > versionHistoryMaxLength {
+		history = history[l-versionHistoryMaxLength:]
+	}
+
+	key := versionHistoryKey(name, version)
+	if len(history) == 1 {
+		_, err = s.db.Put(q.Alias(key), q.KV{"history": utils.MustEncodeJSON(history)})
+	} else {
+		err = s.db.Update(q.Alias(key), q.KV{"history": utils.MustEncodeJSON(history)})
+	}
+	return err
+}
+
+// splitVersionPath splits the pathname like `/react@16/jsx-runtime`, returns the version and
+// the rest of the path, the version is empty if the pathname has no version.
+func splitVersionPath(pathname string, name string) (version string, rest string) {
+	prefix := "/" + name + "@"
+	if !strings.HasPrefix(pathname, prefix) {
+		return
+	}
+	version, rest = utils.SplitByFirstByte(pathname[len(prefix):], '/')
+	if rest != "" {
+		rest = "/" + rest
+	}
+	return
+}
+
+// splitWeakVersionPath splits the pathname with a weak version or a range like `/react@^16.8`,
+// returns the weak version and the rest of the path.
+func splitWeakVersionPath(pathname string, name string) (version string, rest string, ok bool) {
+	version, rest = splitVersionPath(pathname, name)
+	ok = regWeakVersion.MatchString(version)
+	return
+}
+
+// splitDistTagPath splits the pathname with a dist-tag like `/react@next/jsx-runtime`, returns
+// the tag and the rest of the path.
+func splitDistTagPath(pathname string, name string) (tag string, rest string, ok bool) {
+	tag, rest = splitVersionPath(pathname, name)
+	ok = regDistTag.MatchString(tag)
+	return
+}
+
+// isExactVersionPath reports whether the pathname is pinned to an exact version like
+// `/react@17.0.2`, the module of the exact version never changes.
+func isExactVersionPath(pathname string, name string) bool {
+	version, _ := splitVersionPath(pathname, name)
+	return regFullVersion.MatchString(version)
+}
+
+// versionHistory handles the `/-/version-history?pkg=react@16` requests.
+func (s *Server) versionHistory(ctx *rex.Context) interface{} {
+	name, version := utils.SplitByLastByte(ctx.Form.Value("pkg"), '@')
+	if name == "" || version == "" {
+		return rex.Status(400, "invalid pkg, should be like 'react@16'")
+	}
+	version = floatingVersion(version)
+	history, err := s.getVersionHistory(name, version)
+	if err != nil && err != postdb.ErrNotFound {
+		return rex.Status(500, err.Error())
+	}
+	if history == nil {
+		history = []versionPin{}
+	}
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	return map[string]interface{}{
+		"name":    name,
+		"version": version,
+		"history": history,
+	}
+}
+
+// parseTimeAgo parses the `at` query of `/-/resolve-version`, like `21d-ago`, `3h-ago`, a
+// RFC3339 time or a unix timestamp.
+func parseTimeAgo(s string, now time.Time) (t time.Time, err error) {
+	if strings.HasSuffix(s, "-ago") {
+		s = strings.TrimSuffix(s, "-ago")
+		var d time.Duration
+		if strings.HasSuffix(s, "d") {
+			var days int
+			days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+			d = time.Duration(days) * 24 * time.Hour
+		} else {
+			d, err = time.ParseDuration(s)
+		}
+		if err != nil || d < 0 {
+			return t, fmt.Errorf("invalid time '%s-ago'", s)
+		}
+		return now.Add(-d), nil
+	}
+	if unix, e := strconv.ParseInt(s, 10, 64); e == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// pinnedVersionAt returns the exact version that the floating version resolved to at the time.
+func pinnedVersionAt(history []versionPin, at time.Time) (version string, ok bool) {
+	for _, pin := range history {
+		if pin.Since > at.Unix() {
+			break
+		}
+		version = pin.Version
+		ok = true
+	}
+	return
+}
+
+// resolveVersion handles the `/-/resolve-version?pkg=react&range=^17&at=21d-ago` requests,
+// the `at` query looks up the recorded history of the floating version.
+func (s *Server) resolveVersion(ctx *rex.Context) interface{} {
+	name := strings.TrimSpace(ctx.Form.Value("pkg"))
+	versionRange := strings.TrimSpace(ctx.Form.Value("range"))
+	if name == "" {
+		return rex.Status(400, "missing pkg")
+	}
+	if versionRange == "" {
+		versionRange = "latest"
+	}
+
+	info, _, err := s.getPackageInfo(name, versionRange)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "not found") {
+			return rex.Status(404, err.Error())
+		}
+		return rex.Status(500, err.Error())
+	}
+	ret := map[string]interface{}{
+		"name":    info.Name,
+		"range":   versionRange,
+		"version": info.Version,
+	}
+
+	if value := ctx.Form.Value("at"); value != "" {
+		at, err := parseTimeAgo(value, time.Now())
+		if err != nil {
+			return rex.Status(400, fmt.Sprintf("invalid at: %v", err))
+		}
+		ret["at"] = at.UTC().Format(time.RFC3339)
+		if regFullVersion.MatchString(versionRange) {
+			ret["versionAt"] = versionRange
+		} else {
+			history, err := s.getVersionHistory(name, floatingVersion(versionRange))
+			if err != nil && err != postdb.ErrNotFound {
+				return rex.Status(500, err.Error())
+			}
+			if version, ok := pinnedVersionAt(history, at); ok {
+				ret["versionAt"] = version
+			} else {
+				// the time is before the first record
+				ret["versionAt"] = nil
+			}
+		}
+	}
+
+	ctx.SetHeader("Cache-Control", "private, no-store")
+	return ret
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ije/rex"
+)
+
+func TestVersionHistory(t *testing.T) {
+	s := setupTestEnv(t)
+
+	_, _, err := s.getPackageInfo("esm-fixture-esm", "^1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := s.getVersionHistory("esm-fixture-esm", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Version != "1.0.0" {
+		t.Fatalf("unexpected history %v", history)
+	}
+
+	now := time.Now()
+	s.recordVersionPin("esm-fixture-esm", "1", "1.0.0", now)
+	s.recordVersionPin("esm-fixture-esm", "1", "1.1.0", now.Add(time.Hour))
+	history, _ = s.getVersionHistory("esm-fixture-esm", "1")
+	if len(history) != 2 || history[1].Version != "1.1.0" || history[1].Since != now.Add(time.Hour).Unix() {
+		t.Fatalf("unexpected history %v", history)
+	}
+}
+
+func TestSplitWeakVersionPath(t *testing.T) {
+	for _, c := range []struct {
+		pathname string
+		name     string
+		version  string
+		rest     string
+		ok       bool
+	}{
+		{"/react@16", "react", "16", "", true},
+		{"/react@16.14/jsx-runtime", "react", "16.14", "/jsx-runtime", true},
+		{"/@emotion/react@11/types", "@emotion/react", "11", "/types", true},
+		{"/react@^17", "react", "^17", "", true},
+		{"/react@~16.8/jsx-runtime", "react", "~16.8", "/jsx-runtime", true},
+		{"/react@^16.14.0", "react", "^16.14.0", "", true},
+		{"/react@>=16", "react", ">=16", "", false},
+		{"/react@16.14.0", "react", "16.14.0", "", false},
+		{"/react@next", "react", "next", "", false},
+		{"/react", "react", "", "", false},
+	} {
+		version, rest, ok := splitWeakVersionPath(c.pathname, c.name)
+		if ok != c.ok || (ok && (version != c.version || rest != c.rest)) {
+			t.Fatalf("unexpected split of %s: %s %s %v", c.pathname, version, rest, ok)
+		}
+	}
+
+	for _, c := range []struct {
+		pathname string
+		tag      string
+		rest     string
+		ok       bool
+	}{
+		{"/react@next", "next", "", true},
+		{"/react@latest/jsx-runtime", "latest", "/jsx-runtime", true},
+		{"/react@experimental-0.0.0-2f9a4c", "experimental-0.0.0-2f9a4c", "", true},
+		{"/react@17.0.2", "", "", false},
+		{"/react@^17", "", "", false},
+		{"/react", "", "", false},
+	} {
+		tag, rest, ok := splitDistTagPath(c.pathname, "react")
+		if ok != c.ok || (ok && (tag != c.tag || rest != c.rest)) {
+			t.Fatalf("unexpected split of %s: %s %s %v", c.pathname, tag, rest, ok)
+		}
+	}
+
+	if !isExactVersionPath("/react@17.0.2/jsx-runtime", "react") || isExactVersionPath("/react@17", "react") || isExactVersionPath("/react", "react") {
+		t.Fatal("only the exact versions should be immutable")
+	}
+
+	for v, expect := range map[string]string{"^17.0.2": "17", "~16.14.0": "16.14", "~16": "16", "^0.6.2": "0.6", "^0.0.3": "0.0.3", "16": "16", "latest": "latest"} {
+		if floatingVersion(v) != expect {
+			t.Fatalf("unexpected floating version of %s: %s", v, floatingVersion(v))
+		}
+	}
+}
+
+func TestDistTagRefreshInterval(t *testing.T) {
+
+	s := &Server{config: &Config{versionRefresh: 10 * time.Minute}}
+	if s.distTagRefreshInterval() != 600 {
+		t.Fatalf("the dist-tags should be re-resolved like the floating versions by default: %d", s.distTagRefreshInterval())
+	}
+	s.config.distTagRefresh = time.Minute
+	if s.distTagRefreshInterval() != 60 || s.versionRefreshInterval() != 600 {
+		t.Fatal("the dist-tags should have their own interval")
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	s := setupTestEnv(t)
+
+	now := time.Now()
+	s.recordVersionPin("esm-fixture-esm", "1", "0.9.0", now.Add(-30*24*time.Hour))
+	req := httptest.NewRequest("GET", "http://esm.sh/-/resolve-version?pkg=esm-fixture-esm&range=^1&at=21d-ago", nil)
+	ctx := &rex.Context{W: httptest.NewRecorder(), R: req, Form: &rex.Form{R: req}}
+	ret, ok := s.resolveVersion(ctx).(map[string]interface{})
+	if !ok {
+		t.Fatalf("unexpected response %v", ret)
+	}
+	if ret["version"] != "1.0.0" || ret["versionAt"] != "0.9.0" {
+		t.Fatalf("unexpected response %v", ret)
+	}
+
+	history, _ := s.getVersionHistory("esm-fixture-esm", "1")
+	if v, ok := pinnedVersionAt(history, now.Add(-31*24*time.Hour)); ok {
+		t.Fatalf("no version should be pinned before the first record: %s", v)
+	}
+	if v, _ := pinnedVersionAt(history, now.Add(time.Minute)); v != "1.0.0" {
+		t.Fatalf("unexpected pinned version %s", v)
+	}
+
+	for s, expect := range map[string]time.Time{
+		"21d-ago":              now.Add(-21 * 24 * time.Hour),
+		"90m-ago":              now.Add(-90 * time.Minute),
+		"1600000000":           time.Unix(1600000000, 0),
+		"2021-04-01T00:00:00Z": time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		at, err := parseTimeAgo(s, now)
+		if err != nil || !at.Equal(expect) {
+			t.Fatalf("unexpected time of %s: %v %v", s, at, err)
+		}
+	}
+	for _, s := range []string{"xd-ago", "-3h-ago", "yesterday"} {
+		if _, err := parseTimeAgo(s, now); err == nil {
+			t.Fatalf("'%s' should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/ije/gox/utils"
+)
+
+const wasmNamespace = "esm-sh-wasm"
+
+// loadWasm copies the wasm file into the storage and returns the loader module of it.
+func (task *buildTask) loadWasm(args api.OnLoadArgs) (api.OnLoadResult, error) {
+	url, err := task.emitAsset(args.Path)
+	if err != nil {
+		return api.OnLoadResult{}, fmt.Errorf("could not load \"%s\": %v", args.Path, err)
+	}
+	code := wasmLoader(url)
+	return api.OnLoadResult{Contents: &code, Loader: api.LoaderJS}, nil
+}
+
+// wasmLoader returns the module that loads the wasm file of the URL, it exports the URL, the
+// `compile` function that compiles the wasm module, and the default `init` function that
+// instantiates the wasm module with the imports and returns its exports. The URL is resolved
+// by the `import.meta.url` so the loader works on any host of the server.
+func wasmLoader(url string) string {
+	return fmt.Sprintf(`/* esm.sh - wasm loader */
+const url = new URL(%s, import.meta.url);
+function load() {
+  return fetch(url).then(res => {
+    if (!res.ok) throw new Error("[esm.sh] could not load " + url + ": " + res.status);
+    return res;
+  });
+}
+export function compile() {
+  if (typeof WebAssembly.compileStreaming === "function") {
+    return WebAssembly.compileStreaming(load());
+  }
+  return load().then(res => res.arrayBuffer()).then(buf => WebAssembly.compile(buf));
+}
+export default function init(imports = {}) {
+  return compile().then(mod => WebAssembly.instantiate(mod, imports)).then(instance => instance.exports);
+}
+export { url };
+`, strings.TrimSpace(string(utils.MustEncodeJSON(url))))
+}
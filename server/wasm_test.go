@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWasmLoader(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("nodejs is not installed")
+	}
+	dir, err := ioutil.TempDir("", "esm-wasm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// an empty wasm module
+	code := wasmLoader("data:application/wasm;base64,AGFzbQEAAAA=")
+	err = ioutil.WriteFile(filepath.Join(dir, "loader.mjs"), []byte(code), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := `import init, { compile } from "./loader.mjs";
+const mod = await compile();
+const exports = await init();
+if (!(mod instanceof WebAssembly.Module) || typeof exports !== "object") throw new Error("bad wasm");`
+	err = ioutil.WriteFile(filepath.Join(dir, "main.mjs"), []byte(script), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("node", filepath.Join(dir, "main.mjs")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("the wasm loader failed: %s", output)
+	}
+}
+
+func TestWasmBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-wasm@1.0.0"), cjsExports: "auto", target: "es2020"}
+	code := buildFixture(t, task)
+	assetURL := fmt.Sprintf("/v%d/esm-fixture-wasm@1.0.0/_assets/add.wasm", VERSION)
+	if !fileExists(filepath.Join(s.config.storageDir, "builds", assetURL)) {
+		t.Fatal("the wasm file should be copied into the storage")
+	}
+	if !strings.Contains(code, fmt.Sprintf(`new URL("%s",import.meta.url)`, assetURL)) || !strings.Contains(code, "WebAssembly.compileStreaming") {
+		t.Fatalf("build %s: the wasm import is not rewritten:\n%s", task.ID(), code)
+	}
+}
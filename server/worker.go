@@ -0,0 +1,26 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ije/gox/utils"
+)
+
+// workerFactory returns the module of the `?worker` query, it exports a factory that starts a
+// module worker of the build. The blob URL has no base to resolve the imports of the build,
+// so the blob imports the build by the absolute URL instead of inlining the code.
+func workerFactory(pkg pkg, moduleURL string) string {
+	return fmt.Sprintf(`/* esm.sh - worker %v */
+export default function workerFactory(options) {
+  return new Worker(%s, { type: "module", ...options });
+}
+`, pkg, workerBlobURL(moduleURL))
+}
+
+// workerBlobURL returns the expression of a same-origin blob URL that imports the module, the
+// browsers don't start the workers of the cross-origin URLs.
+func workerBlobURL(moduleURL string) string {
+	code := strings.TrimSpace(string(utils.MustEncodeJSON(fmt.Sprintf(`import "%s";`, moduleURL))))
+	return fmt.Sprintf(`URL.createObjectURL(new Blob([%s], { type: "application/javascript" }))`, code)
+}
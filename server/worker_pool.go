@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// the script of the cjs-module-lexer worker, it reads the requests like
+// `{"buildDir": "...", "importPath": "react"}` from the stdin line by line and writes the
+// results as the JSON lines to the stdout.
+const cjsModuleLexerWorkerScript = `
+const fs = require('fs')
+const { dirname } = require('path')
+const readline = require('readline')
+const { promisify } = require('util')
+const moduleLexer = require('cjs-module-lexer')
+const enhancedResolve = require('enhanced-resolve')
+
+const resolve = promisify(enhancedResolve.create({
+	mainFields: ['browser', 'module', 'main']
+}))
+
+// the function 'getExports' is copied from https://github.com/evanw/esbuild/issues/442#issuecomment-739340295
+async function getExports ({ buildDir, importPath }) {
+	await moduleLexer.init()
+
+	const exports = []
+	const paths = []
+
+	try {
+		paths.push(await resolve(buildDir, importPath))
+		while (paths.length > 0) {
+			const currentPath = paths.pop()
+			const code = fs.readFileSync(currentPath).toString()
+			const results = moduleLexer.parse(code)
+			exports.push(...results.exports)
+			for (const reexport of results.reexports) {
+				paths.push(await resolve(dirname(currentPath), reexport))
+			}
+		}
+		return { exports }
+	} catch(e) {
+		return { error: e.message }
+	}
+}
+
+const rl = readline.createInterface({ input: process.stdin })
+rl.on('line', async line => {
+	const ret = await getExports(JSON.parse(line))
+	process.stdout.write(JSON.stringify(ret) + '\n')
+})
+// exit with the server
+rl.on('close', () => process.exit(0))
+`
+
+var errWorkerExited = errors.New("the worker exited")
+
+// A nodeWorker is a long-lived process that handles the JSON lines requests one by one.
+type nodeWorker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// A nodeWorkerPool keeps the workers alive between the calls instead of starting a process
+// per call, the workers are started on demand up to the size of the pool. the worker is killed
+// if a call is canceled or timed out, and replaced by the next call.
+type nodeWorkerPool struct {
+	// the config of the proc limits of the workers
+	config  *Config
+	name    string
+	args    []string
+	dir     string
+	timeout time.Duration
+	slots   chan struct{}
+	lock    sync.Mutex
+	idle    []*nodeWorker
+}
+
+func newNodeWorkerPool(config *Config, size int, dir string, timeout time.Duration, name string, args ...string) *nodeWorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &nodeWorkerPool{
+		config:  config,
+		name:    name,
+		args:    args,
+		dir:     dir,
+		timeout: timeout,
+		slots:   make(chan struct{}, size),
+	}
+}
+
+func (p *nodeWorkerPool) start() (w *nodeWorker, err error) {
+	cmd := newProcCommand(p.config, p.name, p.args...)
+	cmd.Dir = p.dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	err = cmd.Start()
+	if err != nil {
+		return
+	}
+	setProcPriority(p.config, cmd)
+	return &nodeWorker{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+func (p *nodeWorkerPool) kill(w *nodeWorker) {
+	killProc(w.cmd)
+	w.stdin.Close()
+	go w.cmd.Wait()
+}
+
+// call sends the request to an idle worker and decodes the result.
+func (p *nodeWorkerPool) call(ctx context.Context, req interface{}, ret interface{}) (err error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	for {
+		var w *nodeWorker
+		p.lock.Lock()
+		if n := len(p.idle); n > 0 {
+			w = p.idle[n-1]
+			p.idle = p.idle[:n-1]
+		}
+		p.lock.Unlock()
+		reused := w != nil
+		if w == nil {
+			w, err = p.start()
+			if err != nil {
+				return
+			}
+		}
+		err = p.send(ctx, w, data, ret)
+		// the idle worker may exit, like killed by the cpu limit, retry with a new one
+		if err == errWorkerExited && reused {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.name, err)
+		}
+		return
+	}
+}
+
+// send sends the request to the worker, the worker is killed if the call fails since it may
+// be hung or in an unknown state, otherwise it's put back to the idle list.
+func (p *nodeWorkerPool) send(ctx context.Context, w *nodeWorker, data []byte, ret interface{}) (err error) {
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.stdin.Write(append(data, '\n'))
+		if err != nil {
+			done <- errWorkerExited
+			return
+		}
+		line, err := w.stdout.ReadBytes('\n')
+		if err != nil {
+			done <- errWorkerExited
+			return
+		}
+		done <- json.Unmarshal(line, ret)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		p.kill(w)
+		return
+	}
+	p.lock.Lock()
+	p.idle = append(p.idle, w)
+	p.lock.Unlock()
+	return
+}
+
+// close kills the idle workers.
+func (p *nodeWorkerPool) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, w := range p.idle {
+		p.kill(w)
+	}
+	p.idle = nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNodeWorkerPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esm-worker-pool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the worker answers the requests with its pid, and hangs on the `hang` request
+	script := `while read line; do case "$line" in *hang*) sleep 10;; *) echo "{\"exports\":[\"$$\"]}";; esac; done`
+	pool := newNodeWorkerPool(&Config{}, 2, dir, 0, "sh", "-c", script)
+	defer pool.close()
+
+	var ret cjsModuleLexerResult
+	err = pool.call(context.Background(), map[string]string{"importPath": "a"}, &ret)
+	if err != nil || len(ret.Exports) != 1 {
+		t.Fatalf("unexpected result %v, %v", ret, err)
+	}
+	pid := ret.Exports[0]
+	// the worker is reused
+	err = pool.call(context.Background(), map[string]string{"importPath": "b"}, &ret)
+	if err != nil || ret.Exports[0] != pid {
+		t.Fatalf("the worker should be reused: %v, %v", ret, err)
+	}
+
+	// the hung worker is killed and replaced
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = pool.call(ctx, map[string]string{"importPath": "hang"}, &ret)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = pool.call(context.Background(), map[string]string{"importPath": "c"}, &ret)
+	if err != nil || ret.Exports[0] == pid {
+		t.Fatalf("the worker should be replaced: %v, %v", ret, err)
+	}
+
+	// the exited idle worker is replaced
+	pid = ret.Exports[0]
+	pool.lock.Lock()
+	killProc(pool.idle[0].cmd)
+	pool.lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	err = pool.call(context.Background(), map[string]string{"importPath": "d"}, &ret)
+	if err != nil || ret.Exports[0] == pid {
+		t.Fatalf("the exited worker should be replaced: %v, %v", ret, err)
+	}
+}
+
+func TestCJSModuleLexerWorkerScript(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("nodejs is not installed")
+	}
+	dir, err := ioutil.TempDir("", "esm-worker-pool-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "worker.js")
+	err = ioutil.WriteFile(filename, []byte(cjsModuleLexerWorkerScript), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("node", "--check", filename).CombinedOutput()
+	if err != nil {
+		t.Fatalf("invalid worker script: %s", output)
+	}
+}
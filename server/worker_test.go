@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkerFactory(t *testing.T) {
+	code := workerFactory(pkg{name: "comlink", version: "4.3.1"}, "https://esm.sh/v36/comlink@4.3.1/es2020/comlink.js")
+	if !strings.Contains(code, `new Blob(["import \"https://esm.sh/v36/comlink@4.3.1/es2020/comlink.js\";"]`) {
+		t.Fatalf("the worker should import the build by the absolute URL:\n%s", code)
+	}
+	if !strings.Contains(code, "export default function workerFactory(options)") {
+		t.Fatalf("missing the worker factory:\n%s", code)
+	}
+
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("nodejs is not installed")
+	}
+	dir, err := ioutil.TempDir("", "esm-worker-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "worker.mjs")
+	err = ioutil.WriteFile(filename, []byte(code), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("node", "--check", filename).CombinedOutput()
+	if err != nil {
+		t.Fatalf("invalid worker factory: %s", output)
+	}
+}
+
+func TestWorkerURLBuild(t *testing.T) {
+	s := setupTestEnv(t)
+
+	task := &buildTask{Server: s, pkg: fixturePkg(t, s, "esm-fixture-worker@1.0.0"), cjsExports: "auto", target: "es2020"}
+	code := buildFixture(t, task)
+	workerURL := fmt.Sprintf("%s/v%d/esm-fixture-worker@1.0.0/es2020/worker.js", task.assetOrigin(), VERSION)
+	if !strings.Contains(code, "new Worker(URL.createObjectURL(new Blob([") || !strings.Contains(code, `import "`+workerURL+`";`) {
+		t.Fatalf("the worker should be started by a same-origin blob that imports %s:\n%s", workerURL, code)
+	}
+	assetURL := fmt.Sprintf(`new URL("%s/v%d/esm-fixture-worker@1.0.0/_assets/icon.svg")`, task.assetOrigin(), VERSION)
+	if !strings.Contains(code, assetURL) {
+		t.Fatalf("the asset URL should be rewritten to %s:\n%s", assetURL, code)
+	}
+
+	// the non-relative URLs are left as they are, only the `import.meta.url` is rewritten
+	fileURL := fmt.Sprintf(`"%s/esm-fixture-worker@1.0.0/index.js"`, task.assetOrigin())
+	for _, expr := range []string{
+		`new Worker(new URL("https://cdn.example.com/worker.js",` + fileURL + `))`,
+		`new URL("https://cdn.example.com/icon.svg",` + fileURL + `)`,
+	} {
+		if !strings.Contains(code, expr) {
+			t.Fatalf("the non-relative URL should be kept as '%s':\n%s", expr, code)
+		}
+	}
+	if strings.Count(code, "new Blob([") != 1 {
+		t.Fatalf("only the relative worker should be started by a blob:\n%s", code)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// A jsWriter writes the header statements of a build output with a consistent
+// newline policy: production builds put all statements in one line, development
+// builds put every statement in a new line (or pack them by the `dev-line-width` config).
+type jsWriter struct {
+	buf       bytes.Buffer
+	isDev     bool
+	lineWidth int
+	col       int
+}
+
+func newJSWriter(isDev bool, lineWidth int) *jsWriter {
+	return &jsWriter{isDev: isDev, lineWidth: lineWidth}
+}
+
+// Line writes a whole line, like a comment.
+func (w *jsWriter) Line(format string, a ...interface{}) {
+	if w.col > 0 {
+		w.buf.WriteByte('\n')
+		w.col = 0
+	}
+	fmt.Fprintf(&w.buf, format, a...)
+	w.buf.WriteByte('\n')
+}
+
+// Stmt writes a statement.
+func (w *jsWriter) Stmt(format string, a ...interface{}) {
+	s := fmt.Sprintf(format, a...)
+	if w.isDev && w.col > 0 && (w.lineWidth <= 0 || w.col+len(s) > w.lineWidth) {
+		w.buf.WriteByte('\n')
+		w.col = 0
+	}
+	w.buf.WriteString(s)
+	w.col += len(s)
+}
+
+// Bytes returns the header, development builds always end with a newline.
+func (w *jsWriter) Bytes() []byte {
+	if w.isDev && w.col > 0 {
+		w.buf.WriteByte('\n')
+		w.col = 0
+	}
+	return w.buf.Bytes()
+}
+
+// normalizeEOL converts the CRLF line breaks into LF. this is safe for javascript since
+// a string literal can't contain a raw line break, and template literals treat CRLF as LF.
+func normalizeEOL(data []byte) []byte {
+	if bytes.IndexByte(data, '\r') < 0 {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte{'\n'})
+}
+
+// writeFileAtomic writes the file via a temporary file and renames it when all the
+// contents are written, an interrupted build never leaves a partial artifact.
+func writeFileAtomic(filename string, contents ...io.Reader) (err error) {
+	err = ensureDir(filepath.Dir(filename))
+	if err != nil {
+		return
+	}
+
+	tmpFile := filename + ".tmp" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return
+	}
+	for _, r := range contents {
+		_, err = io.Copy(file, r)
+		if err != nil {
+			break
+		}
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, filename)
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+	}
+	return
+}
+
+// writeNodeShims adds the imports of the nodejs compatibility shims that are referenced by
+// the global identifiers of the code, the other shims are injected by esbuild(see `injectableNodeShims`).
+func writeNodeShims(w *jsWriter, globals *stringSet, env string) {
+	if globals.Has("__process$") {
+		w.Stmt(`import __process$ from "/v%d/_node_process.js";`, VERSION)
+		w.Stmt(`__process$.env.NODE_ENV="%s";`, env)
+	}
+	if globals.Has("__Buffer$") {
+		w.Stmt(`import { Buffer as __Buffer$ } from "/v%d/_node_buffer.js";`, VERSION)
+	}
+}
+
+// writeStorageFile writes the file of the storage atomically, the writing fails by the
+// `disk-full` fault in the chaos mode.
+func (s *Server) writeStorageFile(filename string, contents ...io.Reader) error {
+	if err := s.injectFault("disk-full"); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, contents...)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of testdata")
+
+func TestJSWriterGolden(t *testing.T) {
+	code := []byte(`var e=__process$.env.DEBUG,b=__Buffer$.from("esm");export{e,b};`)
+	for _, env := range []string{"production", "development"} {
+		w := newJSWriter(env == "development", 0)
+		w.Line("/* esm.sh - esbuild bundle(%s) %s %s */", "test@1.0.0", "es2020", env)
+		w.Stmt(`import __react$ from "%s";`, "/v1/react@17.0.2/es2020/react.js")
+		globals, _ := unboundIdentifiers(code)
+		writeNodeShims(w, globals, env)
+		output := append(w.Bytes(), normalizeEOL(code)...)
+		// keep the golden files stable across build versions
+		output = bytes.ReplaceAll(output, []byte(fmt.Sprintf("/v%d/", VERSION)), []byte("/v{VERSION}/"))
+
+		goldenFile := path.Join("testdata", "golden", "header."+env+".js")
+		if *updateGolden {
+			if err := ioutil.WriteFile(goldenFile, output, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := ioutil.ReadFile(goldenFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(output, golden) {
+			t.Fatalf("unexpected %s output:\n%s", env, output)
+		}
+	}
+}
+
+func TestNodeShimsInStringLiterals(t *testing.T) {
+	code := []byte(`var s="__process$.env",t='__Buffer$';/* __process$ */export{s,t};`)
+	globals, ok := unboundIdentifiers(code)
+	if !ok {
+		t.Fatal("invalid code")
+	}
+	w := newJSWriter(false, 0)
+	writeNodeShims(w, globals, "production")
+	if b := w.Bytes(); len(b) > 0 {
+		t.Fatalf("unexpected shims: %s", b)
+	}
+
+	code = []byte(`var s="__process$",e=__process$.env;export{s,e};`)
+	globals, _ = unboundIdentifiers(code)
+	w = newJSWriter(false, 0)
+	writeNodeShims(w, globals, "production")
+	if b := w.Bytes(); !bytes.Contains(b, []byte("import __process$ from")) || bytes.Contains(b, []byte("__Buffer$")) {
+		t.Fatalf("unexpected shims: %s", b)
+	}
+}
+
+func TestJSWriterLineWidth(t *testing.T) {
+	w := newJSWriter(true, 40)
+	w.Stmt(`import a from "/a.js";`)
+	w.Stmt(`import b from "/b.js";`)
+	w.Stmt(`var c = 1;`)
+	if s := string(w.Bytes()); s != "import a from \"/a.js\";\nimport b from \"/b.js\";var c = 1;\n" {
+		t.Fatalf("unexpected output: %q", s)
+	}
+}
+
+func TestNormalizeEOL(t *testing.T) {
+	if s := string(normalizeEOL([]byte("a\r\nb\nc\r\n"))); s != "a\nb\nc\n" {
+		t.Fatalf("unexpected output: %q", s)
+	}
+}